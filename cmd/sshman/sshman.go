@@ -4,6 +4,7 @@ import (
 	"andrew/sshman/internal/buildInfo"
 	"andrew/sshman/internal/config"
 	"andrew/sshman/internal/flags"
+	"andrew/sshman/internal/lint"
 	"andrew/sshman/internal/sqlite"
 	"andrew/sshman/internal/sshParser"
 	"andrew/sshman/internal/sshUtils"
@@ -12,6 +13,7 @@ import (
 	"andrew/sshman/internal/utils"
 	"bufio"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -19,13 +21,17 @@ import (
 	"log/slog"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	"github.com/adrg/xdg"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/huh"
 )
 
 type optionFlags []string
@@ -59,11 +65,46 @@ func main() {
 	quickEdit := flag.Bool("qe", false, "quick edit")
 	quickConnect := flag.Bool("qc", false, "quick connect")
 	quickSync := flag.Bool("qs", false, "quick sync")
+	// forward profile commands, these rely on user setting host alias flag
+	forwardAdd := flags.NewStringSettableFlag("fp-add", "", "create a named forward profile for host from the LocalForward, RemoteForward and DynamicForward options given with -o")
+	forwardRemove := flags.NewStringSettableFlag("fp-rm", "", "remove the named forward profile of host")
+	forwardStart := flags.NewStringSettableFlag("fp-start", "", "start the named forward profile of host with ssh -N, runs until interrupted")
+	forwardList := flag.Bool("fp-ls", false, "list forward profiles, limited to host if set")
+	// connect hook commands, these rely on user setting host alias flag
+	hookAdd := flags.NewStringSettableFlag("hook-add", "", "add a connect hook to host, written as \"<pre|post> <wol|wait|run> [arg]\", ie \"pre wol aa:bb:cc:dd:ee:ff\"")
+	hookRemove := flags.NewUintSettableFlag("hook-rm", 0, "remove the connect hook with the given id from host")
+	hookList := flag.Bool("hook-ls", false, "list connect hooks with their ids, limited to host if set")
+	keyList := flag.Bool("keys", false, "list the key inventory with fingerprints, the hosts using each key and any problems found")
+	signCert := flag.Bool("sign-cert", false, "sign a user certificate for the key of host with the configured ca, give the key with -i if host has several")
+	certPrincipals := flag.String("principals", "", "comma separated principals of the certificate, defaults to the user of host, used with sign-cert")
+	certList := flag.Bool("certs", false, "list tracked certificates with their principals and time left")
+	renewCerts := flag.Bool("renew-certs", false, "renew certificates expiring within ssh.certificates.renew_before, narrowed by host, tag and match")
+	agentList := flag.Bool("agent", false, "list the keys loaded in the ssh agent and the hosts each unlocks")
+	agentAdd := flag.Bool("agent-add", false, "load the key given with -i or the keys of host into the ssh agent")
+	agentRemove := flag.Bool("agent-rm", false, "remove the key given with -i or the keys of host from the ssh agent")
+	agentLifetime := flag.String("lifetime", "", "how long keys stay loaded, ie 8h, defaults to ssh.agent.lifetime, used with agent-add")
+	auditKeys := flag.Bool("audit-keys", false, "fetch ~/.ssh/authorized_keys of host or the hosts selected by tag and match and flag unknown, duplicate and stale keys")
+	auditRemove := flag.Bool("audit-rm", false, "pick flagged entries to remove from authorized_keys once the audit is printed, used with audit-keys")
+	rotateDue := flag.Bool("rotate-due", false, "walk through the rotate flow for every key breaking the key policy, then exit")
+	// bulk rotation selects hosts with the tag, match and old-key flags
+	rotateBulk := flag.Bool("rotate-bulk", false, "rotate the key of every selected host, the old key is only removed once login with the new key works")
+	bulkTag := flag.String("tag", "", "only select hosts with this tag, used with rotate-bulk, certs, renew-certs, audit-keys and check")
+	bulkMatch := flag.String("match", "", "only select hosts whose alias matches this glob, used with rotate-bulk, certs, renew-certs, audit-keys and check")
+	bulkOldKey := flag.String("old-key", "", "key to replace on every host using it, defaults to the single key each host has in the key store, used with rotate-bulk")
+	bulkAlgorithm := flag.String("algorithm", config.ED25519, "algorithm of the new keys, [RSA, ECDSA, ED25519], used with rotate-bulk")
+	bulkPerHost := flag.Bool("per-host", false, "generate a key per host instead of one shared key, used with rotate-bulk")
+	bulkConcurrency := flag.Int("concurrency", sshUtils.BulkRotateConcurrency, "number of hosts handled at once, used with rotate-bulk, audit-keys and check")
 
 	// debug flags
 	// get host relies on user setting host alias flag
 	getHost := flag.Bool("gh", false, "get a host config definition and print it")
 	createConfigFlag := flag.Bool("cc", false, "create ssh config using sqlite database")
+	// effective config relies on user setting host alias flag
+	effectiveConfig := flag.Bool("ec", false, "print the effective ssh config ssh resolves for a host, stored options are marked with *")
+	lintFlag := flag.Bool("lint", false, "check stored hosts for problems, exits with 1 if any error severity finding is reported")
+	healthCheck := flag.Bool("check", false, "probe host or the hosts selected by tag and match the way ssh reaches them, exits with 1 if any host is down")
+	checkTimeout := flag.Duration("timeout", sshUtils.DefaultCheckTimeout, "how long each hop of a host may take to answer, used with check")
+	outputFormat := flag.String("format", "text", "output format, [text, json] for lint and [text, json, junit] for check")
 	updateCheck := flag.Bool("update", false, "checks for an available update, on unix may prompt for auto update")
 	dryRun := flag.Bool("dry-run", false, "dry run update, runs update procedure but does not modify os")
 	// validate config flag
@@ -77,6 +118,8 @@ func main() {
 	host := flags.NewStringSettableFlag("host", "", "host alias")
 	// used for quickadd and quickedit
 	hostname := flags.NewStringSettableFlag("hostname", "", "new hostname for given host alias")
+	// used for quickedit, renames host and updates ProxyJump references to it
+	rename := flags.NewStringSettableFlag("rename", "", "new alias for the given host, used with quick edit")
 	// these are only used for quick connect
 	port := flags.NewUintSettableFlag("p", 22, "ssh port")
 	identityFile := flags.NewStringSettableFlag("i", "", "identity file")
@@ -202,6 +245,18 @@ func main() {
 		}
 	}
 
+	// the algorithm is checked before the config and database are loaded so no host is ever touched
+	if *rotateBulk {
+		if _, ok := config.KeyGenTypeSet[strings.ToUpper(*bulkAlgorithm)]; !ok {
+			_, _ = fmt.Fprintf(os.Stderr, "Unknown key algorithm %s\n", *bulkAlgorithm)
+			os.Exit(1)
+		}
+		if config.IsSecurityKeyType(*bulkAlgorithm) {
+			_, _ = fmt.Fprintf(os.Stderr, "Can not bulk rotate to %s keys: %v\n", *bulkAlgorithm, sshUtils.ErrBulkSecurityKey)
+			os.Exit(1)
+		}
+	}
+
 	/*
 		Load Config here
 	*/
@@ -224,6 +279,8 @@ func main() {
 		LoadDatabase here
 	*/
 	var dbAO *sqlite.HostDao // get database access object
+	var forwardAO *sqlite.ForwardDao
+	var keyAO *sqlite.KeyDao
 	if cfg.StorageConf.StoragePath != "" {
 		conn, err := sqlite.CreateAndLoadDB(cfg.StorageConf.StoragePath)
 		if err != nil {
@@ -232,6 +289,8 @@ func main() {
 			os.Exit(1)
 		}
 		dbAO = sqlite.NewHostDao(conn)
+		forwardAO = sqlite.NewForwardDao(conn)
+		keyAO = sqlite.NewKeyDao(conn)
 		closeResource = func() {
 			conn.Close()
 		}
@@ -245,6 +304,8 @@ func main() {
 			os.Exit(1)
 		}
 		dbAO = sqlite.NewHostDao(conn)
+		forwardAO = sqlite.NewForwardDao(conn)
+		keyAO = sqlite.NewKeyDao(conn)
 		closeResource = func() {
 			conn.Close()
 		}
@@ -276,6 +337,603 @@ func main() {
 		return
 	}
 
+	if *effectiveConfig {
+		if !host.SetByUser {
+			slog.Error("host must be set in order to print effective config")
+			_, _ = fmt.Fprintf(os.Stderr, "You must set host when getting effective config\n")
+			closeResource()
+			os.Exit(1)
+		}
+		storedHost, err := dbAO.Get(host.Value)
+		if err != nil {
+			slog.Error("error getting host from database", "host", host.Value, "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Error getting host from database\n")
+			closeResource()
+			os.Exit(1)
+		}
+		stored := make(map[string][]string)
+		for _, opt := range storedHost.Options {
+			stored[opt.Key] = append(stored[opt.Key], opt.Value)
+		}
+		opts, err := sshUtils.GetEffectiveConfig(storedHost.Host, stored, cfg)
+		if err != nil {
+			slog.Error("failed to get effective config", "host", host.Value, "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to get effective config: %v\n", err)
+			closeResource()
+			os.Exit(1)
+		}
+		for _, opt := range opts {
+			switch {
+			case opt.Overridden():
+				fmt.Printf("! %s %s (stored: %s)\n", opt.Key, opt.Value, strings.Join(opt.StoredValue, ", "))
+			case opt.Stored():
+				fmt.Printf("* %s %s\n", opt.Key, opt.Value)
+			default:
+				fmt.Printf("  %s %s\n", opt.Key, opt.Value)
+			}
+		}
+		return
+	}
+
+	if forwardAdd.SetByUser {
+		if !host.SetByUser {
+			slog.Error("host must be set in order to add a forward profile")
+			_, _ = fmt.Fprintf(os.Stderr, "You must set host when adding a forward profile\n")
+			closeResource()
+			os.Exit(1)
+		}
+		profile := sqlite.ForwardProfile{
+			Host:      host.Value,
+			Name:      forwardAdd.Value,
+			CreatedAt: time.Now(),
+		}
+		for _, opt := range sshConfigOptions {
+			key, value, found := strings.Cut(opt, "=")
+			if !found || !sshUtils.IsForwardOption(key) {
+				slog.Warn("Skipping option as it is not a forward", "option", opt)
+				continue
+			}
+			fwd := sqlite.Forward{Type: key, Spec: value}
+			if err := sshUtils.ValidateForward(fwd); err != nil {
+				slog.Error("invalid forward", "error", err)
+				_, _ = fmt.Fprintf(os.Stderr, "Invalid forward: %v\n", err)
+				closeResource()
+				os.Exit(1)
+			}
+			profile.Forwards = append(profile.Forwards, fwd)
+		}
+		if err := forwardAO.Insert(profile); err != nil {
+			slog.Error("failed to add forward profile", "host", host.Value, "profile", forwardAdd.Value, "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to add forward profile: %v\n", err)
+			closeResource()
+			os.Exit(1)
+		}
+		return
+	}
+
+	if forwardRemove.SetByUser {
+		if !host.SetByUser {
+			slog.Error("host must be set in order to remove a forward profile")
+			_, _ = fmt.Fprintf(os.Stderr, "You must set host when removing a forward profile\n")
+			closeResource()
+			os.Exit(1)
+		}
+		if err := forwardAO.Delete(host.Value, forwardRemove.Value); err != nil {
+			slog.Error("failed to remove forward profile", "host", host.Value, "profile", forwardRemove.Value, "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to remove forward profile: %v\n", err)
+			closeResource()
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *forwardList {
+		var profiles []sqlite.ForwardProfile
+		if host.SetByUser {
+			profiles, err = forwardAO.GetForHost(host.Value)
+		} else {
+			profiles, err = forwardAO.GetAll()
+		}
+		if err != nil {
+			slog.Error("failed to get forward profiles", "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to get forward profiles: %v\n", err)
+			closeResource()
+			os.Exit(1)
+		}
+		for _, profile := range profiles {
+			fmt.Printf("%s/%s\n", profile.Host, profile.Name)
+			for _, fwd := range profile.Forwards {
+				fmt.Printf("  %s %s\n", fwd.Type, fwd.Spec)
+			}
+		}
+		return
+	}
+
+	if forwardStart.SetByUser {
+		if !host.SetByUser {
+			slog.Error("host must be set in order to start a forward profile")
+			_, _ = fmt.Fprintf(os.Stderr, "You must set host when starting a forward profile\n")
+			closeResource()
+			os.Exit(1)
+		}
+		profile, err := forwardAO.Get(host.Value, forwardStart.Value)
+		if err != nil {
+			slog.Error("failed to get forward profile", "host", host.Value, "profile", forwardStart.Value, "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to get forward profile: %v\n", err)
+			closeResource()
+			os.Exit(1)
+		}
+		if err = sshUtils.CheckLocalPorts(profile); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Can not start forward profile: %v\n", err)
+			closeResource()
+			os.Exit(1)
+		}
+		c := sshUtils.TunnelCommand(profile, cfg)
+		c.Stdin = os.Stdin
+		c.Stdout = os.Stdout
+		c.Stderr = os.Stderr
+		fmt.Printf("Forwarding %s, press ctrl+c to stop\n", strings.Join(sshUtils.LocalListenAddrs(profile), ", "))
+		if err = c.Run(); err != nil {
+			slog.Error("forward profile exited", "error", err)
+			closeResource()
+			os.Exit(1)
+		}
+		return
+	}
+
+	if hookAdd.SetByUser {
+		if !host.SetByUser {
+			slog.Error("host must be set in order to add a hook")
+			_, _ = fmt.Fprintf(os.Stderr, "You must set host when adding a hook\n")
+			closeResource()
+			os.Exit(1)
+		}
+		hook, err := sshUtils.ParseHook(host.Value, hookAdd.Value)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Invalid hook: %v\n", err)
+			closeResource()
+			os.Exit(1)
+		}
+		id, err := dbAO.InsertHook(hook)
+		if err != nil {
+			slog.Error("failed to add hook", "host", host.Value, "hook", hookAdd.Value, "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to add hook: %v\n", err)
+			closeResource()
+			os.Exit(1)
+		}
+		fmt.Printf("Added hook %d to %s\n", id, host.Value)
+		return
+	}
+
+	if hookRemove.SetByUser {
+		if !host.SetByUser {
+			slog.Error("host must be set in order to remove a hook")
+			_, _ = fmt.Fprintf(os.Stderr, "You must set host when removing a hook\n")
+			closeResource()
+			os.Exit(1)
+		}
+		if err := dbAO.DeleteHook(host.Value, int64(hookRemove.Value)); err != nil {
+			slog.Error("failed to remove hook", "host", host.Value, "hook", hookRemove.Value, "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to remove hook: %v\n", err)
+			closeResource()
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *hookList {
+		var hooks []sqlite.Hook
+		if host.SetByUser {
+			hooks, err = dbAO.GetHooks(host.Value)
+		} else {
+			hooks, err = dbAO.GetAllHooks()
+		}
+		if err != nil {
+			slog.Error("failed to get hooks", "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to get hooks: %v\n", err)
+			closeResource()
+			os.Exit(1)
+		}
+		for _, hook := range hooks {
+			fmt.Printf("%d\t%s\t%s\n", hook.ID, hook.Host, sshUtils.HookString(hook))
+		}
+		return
+	}
+
+	if *keyList {
+		allHosts, err := dbAO.GetAll()
+		if err != nil {
+			slog.Error("failed to get hosts", "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to get hosts from database\n")
+			closeResource()
+			os.Exit(1)
+		}
+		entries, err := sshUtils.RefreshKeyInventory(keyAO, allHosts, cfg.GetKeyStorePath())
+		if err != nil {
+			slog.Error("failed to refresh key inventory", "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to refresh key inventory: %v\n", err)
+			closeResource()
+			os.Exit(1)
+		}
+		printKeyInventory(entries)
+		return
+	}
+
+	if *agentList || *agentAdd || *agentRemove {
+		ag, err := sshUtils.DialAgent()
+		if err != nil {
+			slog.Error("failed to connect to ssh agent", "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
+			closeResource()
+			os.Exit(1)
+		}
+		defer ag.Close()
+		allHosts, err := dbAO.GetAll()
+		if err != nil {
+			slog.Error("failed to get hosts", "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to get hosts from database\n")
+			closeResource()
+			os.Exit(1)
+		}
+		if *agentList {
+			keys, err := sshUtils.ListAgentKeys(ag, allHosts)
+			if err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
+				closeResource()
+				os.Exit(1)
+			}
+			printAgentKeys(keys)
+			return
+		}
+		var paths []string
+		switch {
+		case identityFile.SetByUser:
+			paths = []string{identityFile.Value}
+		case host.SetByUser:
+			storedHost, err := dbAO.Get(host.Value)
+			if err != nil {
+				slog.Error("error getting host from database", "host", host.Value, "error", err)
+				_, _ = fmt.Fprintf(os.Stderr, "Error getting host from database\n")
+				closeResource()
+				os.Exit(1)
+			}
+			paths = sshUtils.HostIdentityFiles(storedHost)
+		default:
+			_, _ = fmt.Fprintf(os.Stderr, "Give the key with -i or a host whose keys should be used\n")
+			closeResource()
+			os.Exit(1)
+		}
+		lifetime := cfg.Ssh.Agent.GetLifetime()
+		if *agentLifetime != "" {
+			if lifetime, err = config.ParseKeyAge(*agentLifetime); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
+				closeResource()
+				os.Exit(1)
+			}
+		}
+		failed := false
+		for _, path := range paths {
+			if *agentRemove {
+				err = sshUtils.RemoveKeyFromAgent(ag, path)
+			} else {
+				err = addKeyToAgent(ag, path, lifetime)
+			}
+			if err != nil {
+				failed = true
+				slog.Error("agent operation failed", "key", path, "error", err)
+				_, _ = fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+				continue
+			}
+			if *agentRemove {
+				fmt.Printf("Removed %s from the agent\n", path)
+			} else {
+				fmt.Printf("Loaded %s into the agent\n", path)
+			}
+		}
+		if failed {
+			closeResource()
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *signCert {
+		if !host.SetByUser {
+			_, _ = fmt.Fprintf(os.Stderr, "You must set host to sign a certificate\n")
+			closeResource()
+			os.Exit(1)
+		}
+		storedHost, err := dbAO.Get(host.Value)
+		if err != nil {
+			slog.Error("error getting host from database", "host", host.Value, "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Error getting host from database\n")
+			closeResource()
+			os.Exit(1)
+		}
+		principals := make([]string, 0)
+		for _, principal := range strings.Split(*certPrincipals, ",") {
+			if principal = strings.TrimSpace(principal); principal != "" {
+				principals = append(principals, principal)
+			}
+		}
+		cert, err := sshUtils.CertifyHost(dbAO, keyAO, storedHost, identityFile.Value, principals, cfg, time.Now())
+		if err != nil {
+			slog.Error("failed to sign certificate", "host", host.Value, "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to sign certificate: %v\n", err)
+			closeResource()
+			os.Exit(1)
+		}
+		if createSSHConfigFile(dbAO, cfg.GetSshConfigFilePath()) != nil {
+			slog.Error("could not write ssh config file out")
+			_, _ = fmt.Fprint(os.Stderr, "Failed to write ssh config file out\n")
+		}
+		fmt.Printf("Signed %s for %s, serial %d, valid until %s\n", cert.Path, strings.Join(cert.Principals, ","), cert.Serial,
+			cert.ValidBefore.Format("2006-01-02 15:04"))
+		return
+	}
+
+	if *certList || *renewCerts {
+		allHosts, err := dbAO.GetAll()
+		if err != nil {
+			slog.Error("failed to get hosts", "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to get hosts from database\n")
+			closeResource()
+			os.Exit(1)
+		}
+		certs, err := keyAO.GetCertificates()
+		if err != nil {
+			slog.Error("failed to get certificates", "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to get certificates from database\n")
+			closeResource()
+			os.Exit(1)
+		}
+		match := *bulkMatch
+		if host.SetByUser {
+			match = host.Value
+		}
+		certs = sshUtils.SelectCertificates(certs, allHosts, *bulkTag, match)
+		if *certList {
+			printCertificates(certs, time.Now())
+			return
+		}
+		due := sshUtils.ExpiringCertificates(certs, cfg.Ssh.Certificates.GetRenewBefore(), time.Now())
+		if len(due) == 0 {
+			fmt.Println("No certificates are due for renewal")
+			return
+		}
+		ca, err := sshUtils.LoadCA(cfg)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to load ca: %v\n", err)
+			closeResource()
+			os.Exit(1)
+		}
+		failed := false
+		for _, r := range sshUtils.RenewCertificates(keyAO, due, ca, cfg.Ssh.Certificates.GetValidity(), time.Now()) {
+			if r.Err != nil {
+				failed = true
+				slog.Error("failed to renew certificate", "path", r.Old.Path, "error", r.Err)
+				fmt.Printf("%s: failed to renew %s: %v\n", r.Old.Host, r.Old.Path, r.Err)
+				continue
+			}
+			fmt.Printf("%s: renewed %s, valid until %s\n", r.New.Host, r.New.Path, r.New.ValidBefore.Format("2006-01-02 15:04"))
+		}
+		if failed {
+			closeResource()
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *auditKeys {
+		allHosts, err := dbAO.GetAll()
+		if err != nil {
+			slog.Error("failed to get hosts", "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to get hosts from database\n")
+			closeResource()
+			os.Exit(1)
+		}
+		match := *bulkMatch
+		if host.SetByUser {
+			match = host.Value
+		}
+		selected := sshUtils.SelectAuditHosts(allHosts, *bulkTag, match)
+		if len(selected) == 0 {
+			_, _ = fmt.Fprintf(os.Stderr, "No hosts selected for the audit\n")
+			closeResource()
+			os.Exit(1)
+		}
+		inventory, err := keyAO.GetAll()
+		if err != nil {
+			slog.Error("failed to get key inventory", "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to get key inventory from database\n")
+			closeResource()
+			os.Exit(1)
+		}
+		sshOpts := make([]string, 0)
+		for _, opt := range sshConfigOptions {
+			sshOpts = append(sshOpts, "-o", opt)
+		}
+		local := sshUtils.LocalPublicKeys(cfg.GetKeyStorePath(), inventory)
+		audits := sshUtils.AuditHosts(context.Background(), selected, local, cfg, *bulkConcurrency, sshOpts...)
+		clean := printAuthorizedKeysAudit(audits)
+		if *auditRemove {
+			if err = removeAuditedKeys(audits, cfg, sshOpts); err != nil {
+				slog.Error("failed to remove authorized keys", "error", err)
+				_, _ = fmt.Fprintf(os.Stderr, "Failed to remove authorized keys: %v\n", err)
+				closeResource()
+				os.Exit(1)
+			}
+			return
+		}
+		if !clean {
+			closeResource()
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *rotateBulk {
+		if *bulkTag == "" && *bulkMatch == "" && *bulkOldKey == "" {
+			_, _ = fmt.Fprintf(os.Stderr, "Bulk rotation needs at least one of tag, match or old-key to select hosts\n")
+			closeResource()
+			os.Exit(1)
+		}
+		allHosts, err := dbAO.GetAll()
+		if err != nil {
+			slog.Error("failed to get hosts", "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to get hosts from database\n")
+			closeResource()
+			os.Exit(1)
+		}
+		targets, skipped := sshUtils.SelectBulkTargets(allHosts, *bulkTag, *bulkMatch, *bulkOldKey, cfg.GetKeyStorePath())
+		for _, reason := range skipped {
+			_, _ = fmt.Fprintf(os.Stderr, "Skipping %s\n", reason)
+		}
+		if len(targets) == 0 {
+			_, _ = fmt.Fprintf(os.Stderr, "No hosts selected for rotation\n")
+			closeResource()
+			os.Exit(1)
+		}
+		sharedName := *bulkTag
+		if sharedName == "" {
+			sharedName = "bulk"
+		}
+		opts := sshUtils.BulkRotateOptions{
+			Algorithm:   strings.ToUpper(*bulkAlgorithm),
+			PerHost:     *bulkPerHost,
+			SharedName:  sharedName,
+			Concurrency: *bulkConcurrency,
+		}
+		for _, opt := range sshConfigOptions {
+			opts.SSHOptions = append(opts.SSHOptions, "-o", opt)
+		}
+		updates := make(chan sshUtils.BulkRotateStatus)
+		printed := make(chan struct{})
+		// every host is recorded in the ledger as it goes so a killed rotation can still be resumed
+		ledger := sshUtils.NewBulkLedgerRecorder(keyAO, time.Now())
+		go func() {
+			for update := range updates {
+				fmt.Printf("%s: %s\n", update.Host, update.Stage)
+				if err := ledger.Record(update); err != nil {
+					slog.Error("failed to record bulk rotation progress", "error", err)
+				}
+			}
+			close(printed)
+		}()
+		// an interrupt stops the rotation, the hosts already rotated are still recorded below
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		results := sshUtils.BulkRotate(ctx, targets, opts, cfg, updates)
+		stop()
+		close(updates)
+		<-printed
+		if err = sshUtils.ApplyBulkRotation(dbAO, keyAO, ledger, results, cfg); err != nil {
+			slog.Error("failed to apply bulk rotation", "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to record rotation: %v\n", err)
+		}
+		if createSSHConfigFile(dbAO, cfg.GetSshConfigFilePath()) != nil {
+			slog.Error("could not write ssh config file out")
+			_, _ = fmt.Fprint(os.Stderr, "Failed to write ssh config file out\n")
+		}
+		if !printBulkRotateReport(results) || err != nil {
+			closeResource()
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *lintFlag {
+		linter, err := lint.New(cfg.Lint)
+		if err != nil {
+			slog.Error("failed to create linter", "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Invalid lint config: %v\n", err)
+			closeResource()
+			os.Exit(1)
+		}
+		allHosts, err := dbAO.GetAll()
+		if err != nil {
+			slog.Error("failed to get hosts", "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to get hosts from database\n")
+			closeResource()
+			os.Exit(1)
+		}
+		profiles, err := forwardAO.GetAll()
+		if err != nil {
+			slog.Error("failed to get forward profiles", "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to get forward profiles from database\n")
+			closeResource()
+			os.Exit(1)
+		}
+		findings := linter.Run(lint.Context{Hosts: allHosts, Forwards: profiles, Config: cfg})
+		switch strings.ToLower(*outputFormat) {
+		case "json":
+			err = lint.WriteJSON(os.Stdout, findings)
+		case "text":
+			err = lint.WriteText(os.Stdout, findings)
+		default:
+			err = fmt.Errorf("unknown output format %s", *outputFormat)
+		}
+		if err != nil {
+			slog.Error("failed to write lint findings", "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to write lint findings: %v\n", err)
+			closeResource()
+			os.Exit(1)
+		}
+		if lint.HasErrors(findings) {
+			closeResource()
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *healthCheck {
+		allHosts, err := dbAO.GetAll()
+		if err != nil {
+			slog.Error("failed to get hosts", "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to get hosts from database\n")
+			closeResource()
+			os.Exit(1)
+		}
+		match := *bulkMatch
+		if host.SetByUser {
+			match = host.Value
+		}
+		names := sshUtils.SelectAuditHosts(allHosts, *bulkTag, match)
+		selected := slices.DeleteFunc(slices.Clone(allHosts), func(h sqlite.Host) bool { return !slices.Contains(names, h.Host) })
+		if len(selected) == 0 {
+			_, _ = fmt.Fprintf(os.Stderr, "No hosts selected for the check\n")
+			closeResource()
+			os.Exit(1)
+		}
+		format := strings.ToLower(*outputFormat)
+		if format != "text" && format != "json" && format != "junit" {
+			_, _ = fmt.Fprintf(os.Stderr, "Unknown output format %s, expected text, json or junit\n", *outputFormat)
+			closeResource()
+			os.Exit(1)
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		checks := sshUtils.CheckHosts(ctx, selected, allHosts, *checkTimeout, *bulkConcurrency, cfg)
+		stop()
+		switch format {
+		case "json":
+			err = sshUtils.WriteHealthJSON(os.Stdout, checks)
+		case "junit":
+			err = sshUtils.WriteHealthJUnit(os.Stdout, checks)
+		default:
+			err = sshUtils.WriteHealthTable(os.Stdout, checks)
+		}
+		if err != nil {
+			slog.Error("failed to write health check report", "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to write health check report: %v\n", err)
+			closeResource()
+			os.Exit(1)
+		}
+		if sshUtils.HealthChecksDown(checks) {
+			closeResource()
+			os.Exit(1)
+		}
+		return
+	}
+
 	if *createConfigFlag {
 		if createSSHConfigFile(dbAO, cfg.GetSshConfigFilePath()) != nil {
 			slog.Error("could not write ssh config file out")
@@ -304,12 +962,34 @@ func main() {
 			os.Exit(1)
 		}
 
-		_, err := dbAO.Get(host.Value)
+		storedHost, err := dbAO.Get(host.Value)
 		if !sshConfigFile.SetByUser && err != nil {
 			slog.Error("Host does not exist in table exiting", "host", host.Value)
 			closeResource()
 			os.Exit(1)
 		}
+		// hosts only in the given config file have no hooks
+		var hooks []sqlite.Hook
+		if err == nil {
+			if hooks, err = dbAO.GetHooks(host.Value); err != nil {
+				slog.Warn("failed to get hooks of host, connecting without them", "host", host.Value, "error", err)
+			}
+		}
+		// wait hooks resolve the route through the jump hosts, without them they would probe the wrong address
+		var allHosts []sqlite.Host
+		if len(hooks) > 0 {
+			if allHosts, err = dbAO.GetAll(); err != nil {
+				slog.Error("failed to get hosts for the hooks of host", "host", host.Value, "error", err)
+				_, _ = fmt.Fprintf(os.Stderr, "Not connecting, failed to read the hosts the hooks need: %v\n", err)
+				closeResource()
+				os.Exit(1)
+			}
+		}
+		if err = runConnectHooks(hooks, sqlite.HookPreConnect, storedHost, allHosts, cfg); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "Not connecting: %v\n", err)
+			closeResource()
+			os.Exit(1)
+		}
 		var configPath string
 		if sshConfigFile.SetByUser {
 			configPath = sshConfigFile.Value
@@ -341,6 +1021,9 @@ func main() {
 		cmd.Stdin = os.Stdin
 		cmd.Stdout = os.Stdout
 		cmd.Run()
+		if err = runConnectHooks(hooks, sqlite.HookPostDisconnect, storedHost, allHosts, cfg); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
 		return
 	}
 
@@ -433,6 +1116,13 @@ func main() {
 			_, _ = fmt.Fprintf(os.Stderr, "You must set host when using quick Edit\n")
 			return
 		}
+		allHosts, err := dbAO.GetAll()
+		if err != nil {
+			slog.Error("Error getting hosts", "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to get hosts from database\n")
+			closeResource()
+			os.Exit(1)
+		}
 		// check for host existence in database
 		dbHost, err := dbAO.Get(host.Value)
 		if err != nil {
@@ -468,6 +1158,10 @@ func main() {
 			if sshUtils.IsOptionYesNo(key) {
 				value = strings.ToLower(value)
 			}
+			if key == "ProxyJump" && !sshUtils.IsProxyJumpValid(value) {
+				slog.Warn("Skipping ProxyJump option as it is not in the form of [user@]host[:port][,...]", "value", value)
+				continue
+			}
 			if _, ok := optMap[key]; ok {
 				if sshUtils.OptionIsOfMutiType(key) {
 					optMap[key] = append(optMap[key], sqlite.HostOptions{
@@ -499,8 +1193,40 @@ func main() {
 		dbHost.UpdatedAt = new(time.Time)
 		*dbHost.UpdatedAt = time.Now()
 		dbHost.Options = replacementList
-		err = dbAO.Update(dbHost)
-		if err != nil {
+		// the edited host is validated with its new alias and the rewritten ProxyJump of its dependents before
+		// anything is written
+		renaming := rename.SetByUser && rename.Value != host.Value
+		var dependents []sqlite.Host
+		finalHosts := allHosts
+		if renaming {
+			dbHost.Host = rename.Value
+			dependents = slices.DeleteFunc(sshUtils.RenameJumpHost(host.Value, rename.Value, allHosts), func(h sqlite.Host) bool {
+				return h.Host == host.Value
+			})
+			finalHosts = slices.DeleteFunc(slices.Clone(allHosts), func(h sqlite.Host) bool {
+				return h.Host == host.Value || slices.ContainsFunc(dependents, func(d sqlite.Host) bool { return d.Host == h.Host })
+			})
+			finalHosts = append(finalHosts, dependents...)
+		}
+		if err = sshUtils.ValidateJumpChain(dbHost, finalHosts); err != nil {
+			slog.Error("invalid ProxyJump chain", "host", dbHost.Host, "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Invalid ProxyJump chain: %v\n", err)
+			closeResource()
+			os.Exit(1)
+		}
+		if renaming {
+			// the edited host is written as the last dependent so the rename and the edit share a transaction
+			err = dbAO.RenameHost(host.Value, rename.Value, append(dependents, dbHost)...)
+			if err != nil {
+				slog.Error("Failed to rename host", "host", host.Value, "new host", rename.Value, "error", err)
+				_, _ = fmt.Fprintf(os.Stderr, "Failed to rename host %s to %s: %v\n", host.Value, rename.Value, err)
+				closeResource()
+				os.Exit(1)
+			}
+			for _, dependent := range dependents {
+				fmt.Printf("Updated ProxyJump of %s to use %s\n", dependent.Host, rename.Value)
+			}
+		} else if err = dbAO.Update(dbHost); err != nil {
 			slog.Error("failed to update host from quick edit command", "error", err, "updated-host", dbHost)
 			closeResource()
 			os.Exit(1)
@@ -540,6 +1266,10 @@ func main() {
 			if sshUtils.IsOptionYesNo(key) {
 				value = strings.ToLower(value)
 			}
+			if key == "ProxyJump" && !sshUtils.IsProxyJumpValid(value) {
+				slog.Warn("Skipping ProxyJump option as it is not in the form of [user@]host[:port][,...]", "value", value)
+				continue
+			}
 			hOptions = append(hOptions, sqlite.HostOptions{
 				Host:  host.Value,
 				Key:   key,
@@ -552,7 +1282,20 @@ func main() {
 			Notes:     "",
 			Options:   hOptions,
 		}
-		err := dbAO.Insert(sqHost)
+		allHosts, err := dbAO.GetAll()
+		if err != nil {
+			slog.Error("failed to get hosts", "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to get hosts from database\n")
+			closeResource()
+			os.Exit(1)
+		}
+		if err = sshUtils.ValidateJumpChain(sqHost, allHosts); err != nil {
+			slog.Error("invalid ProxyJump chain", "host", sqHost.Host, "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Invalid ProxyJump chain: %v\n", err)
+			closeResource()
+			os.Exit(1)
+		}
+		err = dbAO.Insert(sqHost)
 		if err != nil {
 			slog.Error("Failed to add host to host table", "error", err)
 			_, _ = fmt.Fprint(os.Stderr, "Failed to add host to host table\n")
@@ -574,7 +1317,18 @@ func main() {
 			closeResource()
 			os.Exit(1)
 		}
-		err := dbAO.Delete(sqlite.Host{
+		allHosts, err := dbAO.GetAll()
+		if err != nil {
+			slog.Error("failed to get hosts", "error", err)
+			_, _ = fmt.Fprintf(os.Stderr, "Failed to get hosts from database\n")
+			closeResource()
+			os.Exit(1)
+		}
+		if dependents := sshUtils.JumpDependents(host.Value, allHosts); len(dependents) > 0 {
+			_, _ = fmt.Fprintf(os.Stderr, "Warning: %s is used as a jump host by %s, their ProxyJump will no longer resolve\n",
+				host.Value, strings.Join(dependents, ", "))
+		}
+		err = dbAO.Delete(sqlite.Host{
 			Host: host.Value,
 		})
 		if err != nil {
@@ -605,11 +1359,35 @@ func main() {
 		prefixedOptions = append(prefixedOptions, "-o")
 		prefixedOptions = append(prefixedOptions, opt)
 	}
-	app := tui.NewAppModel(hosts, dbAO, cfg, prefixedOptions...)
+	tunnels := sshUtils.NewTunnelManager(cfg)
+	app := tui.NewAppModel(hosts, dbAO, forwardAO, keyAO, tunnels, cfg, prefixedOptions...)
+	if *rotateDue {
+		due := sshUtils.DueRotations(hosts, cfg, time.Now())
+		if len(due) == 0 {
+			fmt.Println("No keys are due for rotation")
+			return
+		}
+		for _, d := range due {
+			fmt.Printf("%s: %s (%s)\n", d.Host, d.Path, strings.Join(d.Reasons, ", "))
+		}
+		app.QueueRotations(due)
+	}
 	program := tea.NewProgram(app, tea.WithAltScreen())
 	if _, err := program.Run(); err != nil {
 		fmt.Printf("err: %s", err)
 	}
+	// background tunnels are owned by the tui and should not outlive it
+	tunnels.StopAll()
+}
+
+// runConnectHooks runs the hooks of stage printing their output, an interrupt stops the hook in progress
+func runConnectHooks(hooks []sqlite.Hook, stage sqlite.HookStage, host sqlite.Host, hosts []sqlite.Host, cfg config.Config) error {
+	if len(sshUtils.HooksOf(hooks, stage)) == 0 {
+		return nil
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	return sshUtils.RunHooks(ctx, hooks, stage, host, hosts, cfg, os.Stdout)
 }
 
 func createSSHCommand(host string, sshPath string, configPath string, options ...string) *exec.Cmd {
@@ -636,3 +1414,166 @@ func createSSHConfigFile(db *sqlite.HostDao, filePath string) error {
 	}
 	return sshParser.SerializeHostToFile(filePath, allHosts)
 }
+
+func printKeyInventory(entries []sshUtils.InventoryEntry) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "PATH\tTYPE\tBITS\tFINGERPRINT\tPASSPHRASE\tCREATED\tHOSTS\tFLAGS")
+	for _, e := range entries {
+		passphrase := "no"
+		if e.HasPassphrase {
+			passphrase = "yes"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\t%s\n", e.Path, e.Algorithm, e.Bits, e.Fingerprint, passphrase,
+			e.CreatedAt.Format("2006-01-02"), strings.Join(e.Hosts, ","), strings.Join(e.Flags(), ","))
+	}
+	_ = w.Flush()
+}
+
+// addKeyToAgent loads the key at path, prompting for its passphrase when it is encrypted
+func addKeyToAgent(ag *sshUtils.AgentConn, path string, lifetime time.Duration) error {
+	passphrase := ""
+	if sshUtils.KeyNeedsPassphrase(path) {
+		err := huh.NewInput().
+			Title("Passphrase for " + path).
+			EchoMode(huh.EchoModePassword).
+			Value(&passphrase).
+			Run()
+		if err != nil {
+			return err
+		}
+	}
+	return sshUtils.AddKeyToAgent(ag, path, passphrase, lifetime)
+}
+
+func printAgentKeys(keys []sshUtils.AgentKey) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "FINGERPRINT\tTYPE\tCERT\tKEY\tHOSTS")
+	for _, k := range keys {
+		cert := "no"
+		if k.Certificate {
+			cert = "yes"
+		}
+		name := k.Path
+		if name == "" {
+			name = k.Comment
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", k.Fingerprint, k.Type, cert, name, strings.Join(k.Hosts, ","))
+	}
+	_ = w.Flush()
+}
+
+func printCertificates(certs []sqlite.Certificate, now time.Time) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "HOST\tCERTIFICATE\tSERIAL\tPRINCIPALS\tEXPIRES\tLEFT\tCA")
+	for _, c := range certs {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%s\n", c.Host, c.Path, c.Serial, strings.Join(c.Principals, ","),
+			c.ValidBefore.Format("2006-01-02 15:04"), sshUtils.FormatCertExpiry(c.ValidBefore, now), c.CAFingerprint)
+	}
+	_ = w.Flush()
+}
+
+// printAuthorizedKeysAudit prints every entry of the audited hosts, returns false if a host could not be audited
+// or has flagged entries
+func printAuthorizedKeysAudit(audits []sshUtils.HostAudit) bool {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "HOST\tLINE\tTYPE\tFINGERPRINT\tCOMMENT\tLOCAL KEY\tOPTIONS\tFLAGS")
+	clean := true
+	for _, audit := range audits {
+		if audit.Err != nil {
+			clean = false
+			_, _ = fmt.Fprintf(w, "%s\t-\t-\t-\t-\t-\t-\t%v\n", audit.Host, audit.Err)
+			continue
+		}
+		if audit.Problems() > 0 {
+			clean = false
+		}
+		for _, e := range audit.Entries {
+			localKey := e.LocalKey
+			if localKey == "" {
+				localKey = "-"
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n", audit.Host, e.Line, e.Type, e.Fingerprint, e.Comment,
+				localKey, strings.Join(e.Options, ","), strings.Join(e.Flags(), ","))
+		}
+	}
+	_ = w.Flush()
+	return clean
+}
+
+// removeAuditedKeys asks which flagged entries to remove and removes them from the authorized_keys of their host
+func removeAuditedKeys(audits []sshUtils.HostAudit, cfg config.Config, sshOpts []string) error {
+	options := make([]huh.Option[string], 0)
+	flagged := make(map[string]sshUtils.AuditedKey)
+	for _, audit := range audits {
+		for _, e := range audit.Entries {
+			if len(e.Flags()) == 0 {
+				continue
+			}
+			id := fmt.Sprintf("%s:%d", audit.Host, e.Line)
+			flagged[id] = e
+			label := fmt.Sprintf("%s line %d %s %s (%s)", audit.Host, e.Line, e.Fingerprint, e.Comment, strings.Join(e.Flags(), ", "))
+			options = append(options, huh.NewOption(label, id))
+		}
+	}
+	if len(options) == 0 {
+		fmt.Println("No flagged entries to remove")
+		return nil
+	}
+	selected := make([]string, 0)
+	err := huh.NewMultiSelect[string]().
+		Title("Entries to remove").
+		Options(options...).
+		Value(&selected).
+		Run()
+	if err != nil {
+		return err
+	}
+	byHost := make(map[string][]sshUtils.AuditedKey)
+	hosts := make([]string, 0)
+	for _, id := range selected {
+		h := id[:strings.LastIndexByte(id, ':')]
+		if _, ok := byHost[h]; !ok {
+			hosts = append(hosts, h)
+		}
+		byHost[h] = append(byHost[h], flagged[id])
+	}
+	errs := make([]error, 0)
+	for _, h := range hosts {
+		removed := 0
+		session := sshUtils.RemoveAuthorizedKeyLinesSession(context.Background(), h, byHost[h], &removed, cfg, sshOpts...)
+		if err = session.Run(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", h, err))
+			continue
+		}
+		fmt.Printf("%s: removed %d entries, the previous file is at ~/.ssh/authorized_keys.bak\n", h, removed)
+	}
+	return errors.Join(errs...)
+}
+
+// printBulkRotateReport prints the outcome of every host, returns false if any host failed
+func printBulkRotateReport(results []sshUtils.BulkRotateStatus) bool {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "HOST\tRESULT\tNEW KEY\tOLD KEY\tERROR")
+	ok := true
+	for _, r := range results {
+		result := r.Stage.String()
+		errMsg := ""
+		if r.Err != nil {
+			ok = false
+			result = "failed " + r.FailedAt.String()
+			errMsg = r.Err.Error()
+		}
+		oldKey := r.OldKey
+		switch {
+		case oldKey == "":
+			oldKey = "-"
+		case r.OldRemoved:
+			oldKey += " (removed)"
+		default:
+			oldKey += " (kept)"
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Host, result, r.NewKey.PrivateKey, oldKey, errMsg)
+	}
+	_ = w.Flush()
+	return ok
+}
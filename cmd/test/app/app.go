@@ -3,6 +3,7 @@ package main
 import (
 	"andrew/sshman/internal/config"
 	"andrew/sshman/internal/sqlite"
+	"andrew/sshman/internal/sshUtils"
 	"andrew/sshman/internal/tui"
 	"fmt"
 	"os"
@@ -22,7 +23,7 @@ func main() {
 	cfg.EnablePing = true
 	*cfg.StorageConf.WriteThrough = true
 	cfg.DevMode = true
-	app := tui.NewAppModel([]sqlite.Host{}, hostDao, cfg)
+	app := tui.NewAppModel([]sqlite.Host{}, hostDao, sqlite.NewForwardDao(db), sqlite.NewKeyDao(db), sshUtils.NewTunnelManager(cfg), cfg)
 	program := tea.NewProgram(app, tea.WithAltScreen())
 	if _, err := program.Run(); err != nil {
 		fmt.Printf("err: %s", err)
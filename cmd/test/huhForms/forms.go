@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
@@ -8,6 +9,7 @@ import (
 	"strings"
 
 	"andrew/sshman/internal/config"
+	"andrew/sshman/internal/sqlite"
 	"andrew/sshman/internal/tui"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -42,8 +44,8 @@ func newKeyViewHarnessModel() keyViewHarnessModel {
 	keys := demoKeys(keyPath)
 	model := keyViewHarnessModel{
 		mode:      keyGenMode,
-		keyGen:    tui.NewKeyGenModel("demo-host", cfg),
-		keyRotate: tui.NewKeyRotateModel("demo-host", keys, cfg),
+		keyGen:    tui.NewKeyGenModel(context.Background(), nil, "demo-host", cfg),
+		keyRotate: tui.NewKeyRotateModel(context.Background(), nil, sqlite.Host{Host: "demo-host"}, keys, "", cfg),
 		status:    fmt.Sprintf("g: key gen, r: rotate, ctrl+c: exit. key path: %s", keyPath),
 		keyPath:   keyPath,
 	}
@@ -10,8 +10,11 @@ require (
 	github.com/charmbracelet/lipgloss v1.1.0
 	github.com/evertras/bubble-table v0.19.2
 	github.com/goccy/go-yaml v1.18.0
+	github.com/hashicorp/go-extract v1.1.4
 	github.com/kevinburke/ssh_config v1.4.0
+	github.com/pkg/sftp v1.13.6
 	github.com/rmhubbert/bubbletea-overlay v0.6.3
+	golang.org/x/crypto v0.45.0
 	zombiezen.com/go/sqlite v1.4.2
 )
 
@@ -38,9 +41,9 @@ require (
 	github.com/dustin/go-humanize v1.0.1 // indirect
 	github.com/erikgeiser/coninput v0.0.0-20211004153227-1c3628e74d0f // indirect
 	github.com/google/uuid v1.6.0 // indirect
-	github.com/hashicorp/go-extract v1.1.4 // indirect
 	github.com/hashicorp/golang-lru/v2 v2.0.7 // indirect
 	github.com/klauspost/compress v1.18.2 // indirect
+	github.com/kr/fs v0.1.0 // indirect
 	github.com/lucasb-eyer/go-colorful v1.3.0 // indirect
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/mattn/go-localereader v0.0.1 // indirect
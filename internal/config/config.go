@@ -1,11 +1,13 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/adrg/xdg"
 )
@@ -26,19 +28,94 @@ const (
 	RSA     = "RSA"
 	ECDSA   = "ECDSA"
 	ED25519 = "ED25519"
+	// security key types, the private key stays on a FIDO authenticator and the key file only references it
+	ECDSA_SK   = "ECDSA-SK"
+	ED25519_SK = "ED25519-SK"
 )
 
 type NamedDirectories string
 
 var KeyGenTypeSet = map[string]struct{}{
-	RSA: {}, ECDSA: {}, ED25519: {},
+	RSA: {}, ECDSA: {}, ED25519: {}, ECDSA_SK: {}, ED25519_SK: {},
+}
+
+// IsSecurityKeyType reports whether keyType is one of the FIDO authenticator backed key types
+func IsSecurityKeyType(keyType string) bool {
+	return strings.EqualFold(keyType, ECDSA_SK) || strings.EqualFold(keyType, ED25519_SK)
 }
 
 type Config struct {
 	DevMode     bool          `yaml:"-"`
 	StorageConf StorageConfig `yaml:"storage_config"`
 	Ssh         SSH           `yaml:"ssh"`
-	EnablePing  bool          `yaml:"enable_ping"` // trys to ping host to see if they are up and reports their ping
+	EnablePing  bool          `yaml:"enable_ping"`          // trys to ping host to see if they are up and reports their ping
+	PingProbe   string        `yaml:"ping_probe,omitempty"` // how hosts are pinged, one of PingProbeSet, defaults to PingProbeBanner
+	PingScan    PingScan      `yaml:"ping_scan,omitempty"`
+	Lint        Lint          `yaml:"lint,omitempty"`
+	KeyBindings KeyBindings   `yaml:"keybindings,omitempty"`
+}
+
+const (
+	PingProbeTCP     = "tcp"     // only dial the port
+	PingProbeBanner  = "banner"  // read the ssh identification banner as well
+	PingProbeHostKey = "hostkey" // read the banner and fetch the host key with a key exchange, nothing is authenticated
+)
+
+var PingProbeSet = map[string]struct{}{
+	PingProbeTCP: {}, PingProbeBanner: {}, PingProbeHostKey: {},
+}
+
+// GetPingProbe returns how hosts are pinged
+func (c Config) GetPingProbe() string {
+	if _, ok := PingProbeSet[strings.ToLower(c.PingProbe)]; !ok {
+		return PingProbeBanner
+	}
+	return strings.ToLower(c.PingProbe)
+}
+
+// PingScan controls the background scan that pings every host of the table, it only runs when ping is enabled
+type PingScan struct {
+	Enabled      *bool  `yaml:"enabled,omitempty"`       // by default the scan runs when the ui starts
+	Interval     string `yaml:"interval,omitempty"`      // time between scans, ie 5m, defaults to DefaultPingScanInterval, off only scans at start
+	Concurrency  int    `yaml:"concurrency,omitempty"`   // hosts pinged at once, defaults to DefaultPingScanConcurrency
+	FilteredOnly bool   `yaml:"filtered_only,omitempty"` // only scan the hosts the table filter shows
+	History      string `yaml:"history,omitempty"`       // how long ping samples are kept, defaults to DefaultPingHistory, off keeps none
+}
+
+const (
+	DefaultPingScanInterval    = "5m"
+	DefaultPingScanConcurrency = 16
+	DefaultPingHistory         = "7d"
+	PingScanOff                = "off"
+)
+
+// IsEnabled reports whether hosts are scanned in the background
+func (p PingScan) IsEnabled() bool {
+	return p.Enabled == nil || *p.Enabled
+}
+
+// GetInterval returns the time between scans, 0 when only the scan at start runs
+func (p PingScan) GetInterval() time.Duration {
+	if strings.EqualFold(strings.TrimSpace(p.Interval), PingScanOff) {
+		return 0
+	}
+	return parseAgeOr(p.Interval, DefaultPingScanInterval)
+}
+
+// GetHistory returns how long ping samples are kept, 0 when they are not recorded
+func (p PingScan) GetHistory() time.Duration {
+	if strings.EqualFold(strings.TrimSpace(p.History), PingScanOff) {
+		return 0
+	}
+	return parseAgeOr(p.History, DefaultPingHistory)
+}
+
+// GetConcurrency returns the number of hosts pinged at once
+func (p PingScan) GetConcurrency() int {
+	if p.Concurrency <= 0 {
+		return DefaultPingScanConcurrency
+	}
+	return p.Concurrency
 }
 
 type StorageConfig struct {
@@ -48,11 +125,103 @@ type StorageConfig struct {
 }
 
 type SSH struct {
-	ExcPath                    string   `yaml:"executable_path,omitempty"`
-	KeyOnly                    bool     `yaml:"key_only,omitempty"`
-	KeyPath                    string   `yaml:"key_path,omitempty"`                  // where to store generated keys
-	AcceptableKeyGenAlgorithms []string `yaml:"acceptable_key_algorithms,omitempty"` // Note this will reject DSA if provided
-	RemovePubKeyAfterGen       bool     `yaml:"remove_pub_after_gen,omitempty"`
+	ExcPath                    string               `yaml:"executable_path,omitempty"`
+	KeyOnly                    bool                 `yaml:"key_only,omitempty"`
+	KeyPath                    string               `yaml:"key_path,omitempty"`                  // where to store generated keys
+	AcceptableKeyGenAlgorithms []string             `yaml:"acceptable_key_algorithms,omitempty"` // Note this will reject DSA if provided
+	RemovePubKeyAfterGen       bool                 `yaml:"remove_pub_after_gen,omitempty"`
+	KeyPolicy                  KeyPolicy            `yaml:"key_policy,omitempty"`
+	Native                     bool                 `yaml:"native,omitempty"` // generate keys and edit authorized_keys in go instead of ssh-keygen, ssh-copy-id and sh
+	Certificates               CertificateAuthority `yaml:"certificates,omitempty"`
+	Agent                      Agent                `yaml:"agent,omitempty"`
+}
+
+// Agent controls how managed keys are loaded into the ssh agent
+type Agent struct {
+	AutoLoad bool   `yaml:"auto_load,omitempty"` // load the keys of a host into the agent right before connecting
+	Lifetime string `yaml:"lifetime,omitempty"`  // how long loaded keys stay in the agent, ie 8h, forever when empty
+}
+
+// GetLifetime returns how long keys stay loaded, 0 when they stay until removed
+func (a Agent) GetLifetime() time.Duration {
+	d, err := ParseKeyAge(a.Lifetime)
+	if err != nil {
+		return 0
+	}
+	return d
+}
+
+// CertificateAuthority is the ssh CA user certificates are signed with
+type CertificateAuthority struct {
+	CAKeyPath   string `yaml:"ca_key_path,omitempty"`  // private key of the CA, signing is disabled when empty
+	Validity    string `yaml:"validity,omitempty"`     // how long signed certificates are valid, ie 30d, defaults to DefaultCertValidity
+	RenewBefore string `yaml:"renew_before,omitempty"` // certificates expiring within this are due for renewal, defaults to DefaultCertRenewBefore
+}
+
+const (
+	DefaultCertValidity    = "30d"
+	DefaultCertRenewBefore = "7d"
+)
+
+// GetValidity returns how long signed certificates are valid
+func (ca CertificateAuthority) GetValidity() time.Duration {
+	return parseAgeOr(ca.Validity, DefaultCertValidity)
+}
+
+// GetRenewBefore returns how long before expiry certificates are renewed
+func (ca CertificateAuthority) GetRenewBefore() time.Duration {
+	return parseAgeOr(ca.RenewBefore, DefaultCertRenewBefore)
+}
+
+func parseAgeOr(age, fallback string) time.Duration {
+	if d, err := ParseKeyAge(age); err == nil {
+		return d
+	}
+	d, _ := ParseKeyAge(fallback)
+	return d
+}
+
+// KeyPolicy decides when generated keys are due for rotation
+type KeyPolicy struct {
+	MaxAge     map[string]string `yaml:"max_age,omitempty"`      // key algorithm (RSA, ECDSA, ED25519 or an -SK type) to max age, ie 90d, 12w, 1y
+	MinRSABits int               `yaml:"min_rsa_bits,omitempty"` // rsa keys smaller than this are due, 0 disables the check
+}
+
+// ParseKeyAge parses a key age, accepts a number followed by d (days), w (weeks) or y (years)
+// along with anything time.ParseDuration accepts
+func ParseKeyAge(age string) (time.Duration, error) {
+	age = strings.TrimSpace(age)
+	units := map[byte]time.Duration{'d': 24 * time.Hour, 'w': 7 * 24 * time.Hour, 'y': 365 * 24 * time.Hour}
+	if len(age) > 1 {
+		if unit, ok := units[age[len(age)-1]]; ok {
+			n, err := strconv.Atoi(age[:len(age)-1])
+			if err != nil || n <= 0 {
+				return 0, fmt.Errorf("invalid key age %q", age)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(age)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid key age %q", age)
+	}
+	return d, nil
+}
+
+const (
+	LintSeverityError   = "error"
+	LintSeverityWarning = "warning"
+	LintSeverityInfo    = "info"
+)
+
+var LintSeveritySet = map[string]struct{}{
+	LintSeverityError: {}, LintSeverityWarning: {}, LintSeverityInfo: {},
+}
+
+type Lint struct {
+	DisabledRules []string          `yaml:"disabled_rules,omitempty"` // rule names that should not run
+	Severity      map[string]string `yaml:"severity,omitempty"`       // rule name to severity override, one of error, warning, info
+	ProdTags      []string          `yaml:"prod_tags,omitempty"`      // tags marking production hosts, defaults to prod
 }
 
 func (cfg *Config) String() string {
@@ -63,6 +232,13 @@ func (cfg *Config) String() string {
 	builder.WriteString("config:\n")
 	builder.WriteString("Ping Enabled: ")
 	builder.WriteString(strconv.FormatBool(cfg.EnablePing) + "\n")
+	builder.WriteString("Ping Probe: " + cfg.GetPingProbe() + "\n")
+	builder.WriteString("PING_SCAN:\n")
+	builder.WriteString("\tEnabled: " + strconv.FormatBool(cfg.PingScan.IsEnabled()) + "\n")
+	builder.WriteString("\tInterval: " + cfg.PingScan.GetInterval().String() + "\n")
+	builder.WriteString("\tConcurrency: " + strconv.Itoa(cfg.PingScan.GetConcurrency()) + "\n")
+	builder.WriteString("\tFiltered Only: " + strconv.FormatBool(cfg.PingScan.FilteredOnly) + "\n")
+	builder.WriteString("\tHistory: " + cfg.PingScan.GetHistory().String() + "\n")
 	builder.WriteString("STORAGE_CONFIG:\n")
 	builder.WriteString("\tStorage Path: ")
 	if cfg.StorageConf.StoragePath == "" {
@@ -108,7 +284,7 @@ func (cfg *Config) String() string {
 	}
 	builder.WriteString("\tAcceptable KeyGenAlgorithms: ")
 	if cfg.Ssh.AcceptableKeyGenAlgorithms == nil {
-		builder.WriteString("RSA,ECDSA,ED25519\n")
+		builder.WriteString("RSA,ECDSA,ED25519,ECDSA-SK,ED25519-SK\n")
 	} else {
 		for idx, al := range cfg.Ssh.AcceptableKeyGenAlgorithms {
 			builder.WriteString(al)
@@ -116,6 +292,36 @@ func (cfg *Config) String() string {
 				builder.WriteString(",")
 			}
 		}
+		builder.WriteString("\n")
+	}
+	builder.WriteString("\tNative: " + strconv.FormatBool(cfg.Ssh.Native) + "\n")
+	builder.WriteString("\tKey Policy Min RSA Bits: " + strconv.Itoa(cfg.Ssh.KeyPolicy.MinRSABits) + "\n")
+	for algorithm, age := range cfg.Ssh.KeyPolicy.MaxAge {
+		builder.WriteString("\tKey Policy Max Age " + algorithm + ": " + age + "\n")
+	}
+	builder.WriteString("\tCertificate CA Key Path: " + cfg.Ssh.Certificates.CAKeyPath + "\n")
+	builder.WriteString("\tCertificate Validity: " + cfg.Ssh.Certificates.GetValidity().String() + "\n")
+	builder.WriteString("\tCertificate Renew Before: " + cfg.Ssh.Certificates.GetRenewBefore().String() + "\n")
+	builder.WriteString("\tAgent Auto Load: " + strconv.FormatBool(cfg.Ssh.Agent.AutoLoad) + "\n")
+	builder.WriteString("\tAgent Lifetime: " + cfg.Ssh.Agent.GetLifetime().String() + "\n")
+	builder.WriteString("LINT:\n")
+	builder.WriteString("\tDisabled Rules: " + strings.Join(cfg.Lint.DisabledRules, ",") + "\n")
+	builder.WriteString("\tProd Tags: ")
+	if len(cfg.Lint.ProdTags) == 0 {
+		builder.WriteString("prod\n")
+	} else {
+		builder.WriteString(strings.Join(cfg.Lint.ProdTags, ",") + "\n")
+	}
+	for rule, severity := range cfg.Lint.Severity {
+		builder.WriteString("\tSeverity " + rule + ": " + severity + "\n")
+	}
+	builder.WriteString("KEYBINDINGS:\n")
+	for _, scope := range KeyScopes {
+		builder.WriteString("\t" + scope + ":")
+		for _, action := range cfg.KeyBindings.Actions(scope) {
+			builder.WriteString(" " + action.Name + "=" + KeyHelp(action.Keys))
+		}
+		builder.WriteString("\n")
 	}
 	return builder.String()
 }
@@ -127,6 +333,14 @@ func (c Config) GetSshConfigFilePath() string {
 	return filepath.Join(xdg.ConfigHome, DefaultAppStorePath, SshConfigPath)
 }
 
+// GetKeyStorePath returns the directory generated keys are saved to
+func (c Config) GetKeyStorePath() string {
+	if c.Ssh.KeyPath == "" {
+		return filepath.Join(xdg.ConfigHome, DefaultAppStorePath, KeyStoreDir)
+	}
+	return c.Ssh.KeyPath
+}
+
 func GetDefaultConfig() Config {
 
 	return Config{
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
@@ -142,6 +143,161 @@ func ValidateConfig(config *Config) error {
 			}
 		}
 	}
+	for algorithm, age := range config.Ssh.KeyPolicy.MaxAge {
+		_, known := KeyGenTypeSet[strings.ToUpper(algorithm)]
+		_, err := ParseKeyAge(age)
+		if !known || err != nil {
+			if err == nil {
+				err = fmt.Errorf("unknown algorithm in key policy: %s", algorithm)
+			}
+			source, errorYml := yaml.PathString("$.ssh.key_policy.max_age." + algorithm)
+			if errorYml != nil {
+				return err
+			}
+			annotation, errorYml := source.AnnotateSource(ymlString, true)
+			if errorYml != nil {
+				return err
+			}
+			fmt.Printf("expected one of RSA, ECDSA, ED25519, ECDSA-SK, ED25519-SK mapped to an age such as 90d but given %s: %s\n%s\n", algorithm, age, string(annotation))
+			return err
+		}
+	}
+	if config.Ssh.KeyPolicy.MinRSABits < 0 {
+		err := fmt.Errorf("min_rsa_bits can not be negative")
+		source, errorYml := yaml.PathString("$.ssh.key_policy.min_rsa_bits")
+		if errorYml != nil {
+			return err
+		}
+		annotation, errorYml := source.AnnotateSource(ymlString, true)
+		if errorYml != nil {
+			return err
+		}
+		fmt.Printf("expected a positive bit count but given %d\n%s\n", config.Ssh.KeyPolicy.MinRSABits, string(annotation))
+		return err
+	}
+	durations := map[string]string{
+		"certificates.validity":     config.Ssh.Certificates.Validity,
+		"certificates.renew_before": config.Ssh.Certificates.RenewBefore,
+		"agent.lifetime":            config.Ssh.Agent.Lifetime,
+	}
+	for field, age := range durations {
+		if age == "" {
+			continue
+		}
+		if _, err := ParseKeyAge(age); err != nil {
+			source, errorYml := yaml.PathString("$.ssh." + field)
+			if errorYml != nil {
+				return err
+			}
+			annotation, errorYml := source.AnnotateSource(ymlString, true)
+			if errorYml != nil {
+				return err
+			}
+			fmt.Printf("expected a duration such as 30d but given %s\n%s\n", age, string(annotation))
+			return err
+		}
+	}
+	if probe := config.PingProbe; probe != "" {
+		if _, ok := PingProbeSet[strings.ToLower(probe)]; !ok {
+			err := fmt.Errorf("unknown ping probe %s", probe)
+			source, errorYml := yaml.PathString("$.ping_probe")
+			if errorYml != nil {
+				return err
+			}
+			annotation, errorYml := source.AnnotateSource(ymlString, true)
+			if errorYml != nil {
+				return err
+			}
+			fmt.Printf("expected one of tcp, banner, hostkey but given %s\n%s\n", probe, string(annotation))
+			return err
+		}
+	}
+	if interval := config.PingScan.Interval; interval != "" && !strings.EqualFold(strings.TrimSpace(interval), PingScanOff) {
+		if _, err := ParseKeyAge(interval); err != nil {
+			source, errorYml := yaml.PathString("$.ping_scan.interval")
+			if errorYml != nil {
+				return err
+			}
+			annotation, errorYml := source.AnnotateSource(ymlString, true)
+			if errorYml != nil {
+				return err
+			}
+			fmt.Printf("expected a duration such as 5m or off but given %s\n%s\n", interval, string(annotation))
+			return err
+		}
+	}
+	if history := config.PingScan.History; history != "" && !strings.EqualFold(strings.TrimSpace(history), PingScanOff) {
+		if _, err := ParseKeyAge(history); err != nil {
+			source, errorYml := yaml.PathString("$.ping_scan.history")
+			if errorYml != nil {
+				return err
+			}
+			annotation, errorYml := source.AnnotateSource(ymlString, true)
+			if errorYml != nil {
+				return err
+			}
+			fmt.Printf("expected a duration such as 7d or off but given %s\n%s\n", history, string(annotation))
+			return err
+		}
+	}
+	if config.PingScan.Concurrency < 0 {
+		err := fmt.Errorf("ping_scan concurrency can not be negative")
+		source, errorYml := yaml.PathString("$.ping_scan.concurrency")
+		if errorYml != nil {
+			return err
+		}
+		annotation, errorYml := source.AnnotateSource(ymlString, true)
+		if errorYml != nil {
+			return err
+		}
+		fmt.Printf("expected a positive number of hosts but given %d\n%s\n", config.PingScan.Concurrency, string(annotation))
+		return err
+	}
+	if path := config.Ssh.Certificates.CAKeyPath; path != "" {
+		if _, err := os.Stat(path); err != nil {
+			source, errorYml := yaml.PathString("$.ssh.certificates.ca_key_path")
+			if errorYml != nil {
+				return err
+			}
+			annotation, errorYml := source.AnnotateSource(ymlString, true)
+			if errorYml != nil {
+				return err
+			}
+			fmt.Printf("ca key path given can not be read: %s\n%s\n", path, string(annotation))
+			return err
+		}
+	}
+	for rule, severity := range config.Lint.Severity {
+		if _, ok := LintSeveritySet[strings.ToLower(severity)]; !ok {
+			err := fmt.Errorf("unknown lint severity %s for rule %s", severity, rule)
+			source, errorYml := yaml.PathString("$.lint.severity." + rule)
+			if errorYml != nil {
+				return err
+			}
+			annotation, errorYml := source.AnnotateSource(ymlString, true)
+			if errorYml != nil {
+				return err
+			}
+			fmt.Printf("expected one of error, warning, info but given %s\n%s\n", severity, string(annotation))
+			return err
+		}
+	}
+	if err := config.KeyBindings.Validate(); err != nil {
+		var bindingErr *KeyBindingError
+		if !errors.As(err, &bindingErr) {
+			return err
+		}
+		source, errorYml := yaml.PathString("$.keybindings." + bindingErr.Scope + "." + bindingErr.Action)
+		if errorYml != nil {
+			return err
+		}
+		annotation, errorYml := source.AnnotateSource(ymlString, true)
+		if errorYml != nil {
+			return err
+		}
+		fmt.Printf("invalid key binding, %v\n%s\n", bindingErr.Err, string(annotation))
+		return err
+	}
 	return nil
 }
 
@@ -0,0 +1,239 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// key binding scopes, each is a section of keybindings holding the actions of a part of the tui
+const (
+	KeyScopeTable     = "table"      // the host table
+	KeyScopeInfoPanel = "info_panel" // the host info panel and its edit mode
+	KeyScopeWizard    = "wizard"     // the add host wizard
+	KeyScopeForms     = "forms"      // the key generation and rotation forms
+	KeyScopeModals    = "modals"     // the modals opened from the table
+)
+
+// quitKey always quits the program, it can not be bound to an action
+const quitKey = "ctrl+c"
+
+// KeyBindings remaps the keys of the tui. Each scope maps action names to the keys triggering them, keys are
+// written the way bubbletea names them, ie ctrl+s, shift+tab, up or space. Actions left out keep their default keys
+type KeyBindings struct {
+	Table     map[string][]string `yaml:"table,omitempty"`
+	InfoPanel map[string][]string `yaml:"info_panel,omitempty"`
+	Wizard    map[string][]string `yaml:"wizard,omitempty"`
+	Forms     map[string][]string `yaml:"forms,omitempty"`
+	Modals    map[string][]string `yaml:"modals,omitempty"`
+}
+
+// KeyAction is an action of a scope and the keys triggering it. Actions of different groups are never active at
+// the same time, like the keys of different modals, so only actions sharing a group or without one can conflict
+type KeyAction struct {
+	Name  string
+	Keys  []string
+	Help  string
+	Group string
+}
+
+// KeyBindingError is a configured action that is unknown or whose keys conflict with another action of its scope
+type KeyBindingError struct {
+	Scope  string
+	Action string
+	Err    error
+}
+
+func (e *KeyBindingError) Error() string {
+	return fmt.Sprintf("keybindings.%s.%s: %v", e.Scope, e.Action, e.Err)
+}
+
+func (e *KeyBindingError) Unwrap() error {
+	return e.Err
+}
+
+var KeyScopes = []string{KeyScopeTable, KeyScopeInfoPanel, KeyScopeWizard, KeyScopeForms, KeyScopeModals}
+
+var defaultKeyActions = map[string][]KeyAction{
+	KeyScopeTable: {
+		{Name: "up", Keys: []string{"k", "up"}, Help: "up"},
+		{Name: "down", Keys: []string{"j", "down"}, Help: "down"},
+		{Name: "left", Keys: []string{"h", "left", "pgup"}, Help: "previous page"},
+		{Name: "right", Keys: []string{"l", "right", "pgdown"}, Help: "next page"},
+		{Name: "first_page", Keys: []string{"home"}, Help: "first page"},
+		{Name: "last_page", Keys: []string{"end"}, Help: "last page"},
+		{Name: "filter", Keys: []string{"/"}, Help: "filter"},
+		{Name: "edit", Keys: []string{"e"}, Help: "edit"},
+		{Name: "add", Keys: []string{"a"}, Help: "add"},
+		{Name: "delete", Keys: []string{"d"}, Help: "delete"},
+		{Name: "connect", Keys: []string{"enter"}, Help: "connect to host"},
+		{Name: "cycle_view", Keys: []string{"ctrl+w"}, Help: "cycle views"},
+		{Name: "ping", Keys: []string{"p"}, Help: "ping host"},
+		{Name: "generate_key", Keys: []string{"g"}, Help: "generate key"},
+		{Name: "rotate_key", Keys: []string{"r"}, Help: "rotate keys"},
+		{Name: "bulk_rotate", Keys: []string{"R"}, Help: "bulk rotate filtered hosts"},
+		{Name: "effective_config", Keys: []string{"c"}, Help: "effective config"},
+		{Name: "forwards", Keys: []string{"f"}, Help: "forward profiles"},
+		{Name: "tunnels", Keys: []string{"t"}, Help: "running tunnels"},
+		{Name: "lint", Keys: []string{"L"}, Help: "lint hosts"},
+		{Name: "keys", Keys: []string{"K"}, Help: "key inventory"},
+		{Name: "agent", Keys: []string{"A"}, Help: "ssh agent"},
+		{Name: "rotations", Keys: []string{"H"}, Help: "rotation ledger"},
+		{Name: "audit", Keys: []string{"u"}, Help: "audit authorized_keys"},
+		{Name: "audit_shown", Keys: []string{"U"}, Help: "audit shown hosts"},
+	},
+	KeyScopeInfoPanel: {
+		{Name: "up", Keys: []string{"k", "up"}, Help: "up"},
+		{Name: "down", Keys: []string{"j", "down"}, Help: "down"},
+		{Name: "next", Keys: []string{"tab"}, Help: "next"},
+		{Name: "prev", Keys: []string{"shift+tab"}, Help: "prev"},
+		{Name: "collapse", Keys: []string{"C"}, Help: "collapse"},
+		{Name: "save", Keys: []string{"ctrl+s"}, Help: "save"},
+		{Name: "add_option", Keys: []string{"ctrl+a"}, Help: "add option"},
+		{Name: "delete_option", Keys: []string{"ctrl+d"}, Help: "delete option"},
+		{Name: "change_view", Keys: []string{"ctrl+w"}, Help: "change view"},
+		{Name: "cancel", Keys: []string{"esc"}, Help: "exit/cancel"},
+		{Name: "scroll_preview_up", Keys: []string{"ctrl+k"}, Help: "scroll preview up"},
+		{Name: "scroll_preview_down", Keys: []string{"ctrl+j"}, Help: "scroll preview down"},
+	},
+	KeyScopeWizard: {
+		{Name: "next", Keys: []string{"j", "down", "tab"}, Help: "next", Group: "form"},
+		{Name: "prev", Keys: []string{"k", "up", "shift+tab"}, Help: "prev", Group: "form"},
+		{Name: "option_key", Keys: []string{"shift+tab", "left"}, Help: "option key", Group: "option_row"},
+		{Name: "option_value", Keys: []string{"tab", "right"}, Help: "option value", Group: "option_row"},
+		{Name: "first", Keys: []string{"home"}, Help: "first"},
+		{Name: "last", Keys: []string{"end"}, Help: "last"},
+		{Name: "select", Keys: []string{"enter"}, Help: "edit/confirm"},
+		{Name: "delete_option", Keys: []string{"d"}, Help: "delete option"},
+		{Name: "exit", Keys: []string{"esc"}, Help: "exit"},
+	},
+	KeyScopeForms: {
+		{Name: "quit", Keys: []string{"ctrl+q"}, Help: "quit form"},
+		{Name: "abort", Keys: []string{"esc"}, Help: "abort"},
+	},
+	KeyScopeModals: {
+		{Name: "up", Keys: []string{"k", "up"}, Help: "up"},
+		{Name: "down", Keys: []string{"j", "down"}, Help: "down"},
+		{Name: "left", Keys: []string{"h", "left"}, Help: "left"},
+		{Name: "right", Keys: []string{"l", "right"}, Help: "right"},
+		{Name: "confirm", Keys: []string{"enter"}, Help: "confirm"},
+		{Name: "close", Keys: []string{"esc"}, Help: "close"},
+		{Name: "agent_remove", Keys: []string{"x"}, Help: "remove key", Group: "agent"},
+		{Name: "agent_load", Keys: []string{"a"}, Help: "load keys", Group: "agent"},
+		{Name: "ledger_all", Keys: []string{"a"}, Help: "toggle all", Group: "ledger"},
+		{Name: "ledger_resume", Keys: []string{"r"}, Help: "resume", Group: "ledger"},
+		{Name: "ledger_rollback", Keys: []string{"b"}, Help: "roll back", Group: "ledger"},
+		{Name: "audit_mark", Keys: []string{"space"}, Help: "mark", Group: "audit"},
+		{Name: "audit_mark_flagged", Keys: []string{"f"}, Help: "mark flagged", Group: "audit"},
+		{Name: "audit_remove", Keys: []string{"x"}, Help: "remove marked", Group: "audit"},
+		{Name: "yes", Keys: []string{"y"}, Help: "yes", Group: "prompt"},
+		{Name: "no", Keys: []string{"n"}, Help: "no", Group: "prompt"},
+	},
+}
+
+// scope returns the configured actions of scope
+func (k KeyBindings) scope(name string) map[string][]string {
+	switch name {
+	case KeyScopeTable:
+		return k.Table
+	case KeyScopeInfoPanel:
+		return k.InfoPanel
+	case KeyScopeWizard:
+		return k.Wizard
+	case KeyScopeForms:
+		return k.Forms
+	case KeyScopeModals:
+		return k.Modals
+	}
+	return nil
+}
+
+// Actions returns the actions of scope with the configured keys applied, in the order the help lists them
+func (k KeyBindings) Actions(scope string) []KeyAction {
+	configured := k.scope(scope)
+	actions := slices.Clone(defaultKeyActions[scope])
+	for i, action := range actions {
+		if keys, ok := configured[action.Name]; ok && len(keys) > 0 {
+			actions[i].Keys = keys
+		}
+		actions[i].Keys = normalizeKeys(actions[i].Keys)
+	}
+	return actions
+}
+
+// normalizeKeys writes keys the way bubbletea reports them, space is reported as " "
+func normalizeKeys(keys []string) []string {
+	normalized := make([]string, 0, len(keys))
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if strings.EqualFold(key, "space") {
+			key = " "
+		} else if len(key) > 1 {
+			key = strings.ToLower(key) // single letters keep their case, shift+r is reported as R
+		}
+		normalized = append(normalized, key)
+	}
+	return normalized
+}
+
+// KeyHelp writes keys the way the help footer shows them, ie k/↑
+func KeyHelp(keys []string) string {
+	names := make([]string, 0, len(keys))
+	for _, key := range keys {
+		switch key {
+		case " ":
+			key = "space"
+		case "up":
+			key = "↑"
+		case "down":
+			key = "↓"
+		case "left":
+			key = "←"
+		case "right":
+			key = "→"
+		}
+		names = append(names, key)
+	}
+	return strings.Join(names, "/")
+}
+
+// Validate checks that every configured action exists and has keys, and that no key triggers two actions of a
+// scope that can be active at the same time
+func (k KeyBindings) Validate() error {
+	for _, scope := range KeyScopes {
+		configured := k.scope(scope)
+		for name, keys := range configured {
+			if !slices.ContainsFunc(defaultKeyActions[scope], func(a KeyAction) bool { return a.Name == name }) {
+				return &KeyBindingError{Scope: scope, Action: name, Err: fmt.Errorf("unknown action %s", name)}
+			}
+			if len(keys) == 0 {
+				return &KeyBindingError{Scope: scope, Action: name, Err: fmt.Errorf("no keys given")}
+			}
+		}
+		actions := k.Actions(scope)
+		for i, action := range actions {
+			for _, key := range action.Keys {
+				if key == "" {
+					return &KeyBindingError{Scope: scope, Action: action.Name, Err: fmt.Errorf("empty key")}
+				}
+				if key == quitKey {
+					return &KeyBindingError{Scope: scope, Action: action.Name, Err: fmt.Errorf("%s is reserved for quitting", quitKey)}
+				}
+				for _, other := range actions[i+1:] {
+					if action.Group != "" && other.Group != "" && action.Group != other.Group {
+						continue
+					}
+					if slices.Contains(other.Keys, key) {
+						// report the action the user configured so the annotation points at it
+						conflicting, with := action.Name, other.Name
+						if _, ok := configured[with]; ok {
+							conflicting, with = with, conflicting
+						}
+						return &KeyBindingError{Scope: scope, Action: conflicting, Err: fmt.Errorf("key %q is also bound to %s", KeyHelp([]string{key}), with)}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
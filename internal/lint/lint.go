@@ -0,0 +1,153 @@
+package lint
+
+import (
+	"andrew/sshman/internal/config"
+	"andrew/sshman/internal/sqlite"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+type Severity string
+
+const (
+	SeverityError   Severity = config.LintSeverityError
+	SeverityWarning Severity = config.LintSeverityWarning
+	SeverityInfo    Severity = config.LintSeverityInfo
+)
+
+// Finding is a single problem reported by a rule
+type Finding struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Host     string   `json:"host"`
+	Message  string   `json:"message"`
+}
+
+// Context is what rules get to inspect, it holds the whole database so rules can compare hosts with each other
+type Context struct {
+	Hosts    []sqlite.Host
+	Forwards []sqlite.ForwardProfile
+	Config   config.Config
+}
+
+// Rule checks the stored hosts for one kind of problem. Rules only fill in Host and Message of their
+// findings, the linter sets the rule name and the configured severity
+type Rule interface {
+	Name() string
+	Description() string
+	DefaultSeverity() Severity
+	Check(ctx Context) []Finding
+}
+
+var registry = make([]Rule, 0)
+
+// Register adds a rule to the set every linter is built from, panics if a rule with the same name exist
+func Register(rule Rule) {
+	if slices.ContainsFunc(registry, func(r Rule) bool { return r.Name() == rule.Name() }) {
+		panic("lint rule registered twice: " + rule.Name())
+	}
+	registry = append(registry, rule)
+}
+
+// Rules returns every registered rule
+func Rules() []Rule {
+	return slices.Clone(registry)
+}
+
+type Linter struct {
+	rules    []Rule
+	severity map[string]Severity
+}
+
+// New builds a linter from the registered rules with the lint section of cfg applied,
+// errors if the config references a rule that does not exist
+func New(cfg config.Lint) (*Linter, error) {
+	known := func(name string) bool {
+		return slices.ContainsFunc(registry, func(r Rule) bool { return r.Name() == name })
+	}
+	for _, name := range cfg.DisabledRules {
+		if !known(name) {
+			return nil, fmt.Errorf("unknown lint rule in disabled_rules: %s", name)
+		}
+	}
+	linter := &Linter{severity: make(map[string]Severity)}
+	for name, severity := range cfg.Severity {
+		if !known(name) {
+			return nil, fmt.Errorf("unknown lint rule in severity: %s", name)
+		}
+		linter.severity[name] = Severity(strings.ToLower(severity))
+	}
+	for _, rule := range registry {
+		if slices.Contains(cfg.DisabledRules, rule.Name()) {
+			continue
+		}
+		linter.rules = append(linter.rules, rule)
+	}
+	return linter, nil
+}
+
+// Run checks ctx against every enabled rule, findings are ordered by host then rule
+func (l *Linter) Run(ctx Context) []Finding {
+	findings := make([]Finding, 0)
+	for _, rule := range l.rules {
+		severity, ok := l.severity[rule.Name()]
+		if !ok {
+			severity = rule.DefaultSeverity()
+		}
+		for _, finding := range rule.Check(ctx) {
+			finding.Rule = rule.Name()
+			finding.Severity = severity
+			findings = append(findings, finding)
+		}
+	}
+	slices.SortStableFunc(findings, func(a, b Finding) int {
+		if c := strings.Compare(a.Host, b.Host); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Rule, b.Rule)
+	})
+	return findings
+}
+
+// HasErrors reports whether any finding has error severity, used to set the exit code in CI
+func HasErrors(findings []Finding) bool {
+	return slices.ContainsFunc(findings, func(f Finding) bool { return f.Severity == SeverityError })
+}
+
+type report struct {
+	Findings []Finding `json:"findings"`
+	Errors   int       `json:"errors"`
+	Warnings int       `json:"warnings"`
+	Infos    int       `json:"infos"`
+}
+
+// WriteJSON writes findings as a json document with per severity counts
+func WriteJSON(w io.Writer, findings []Finding) error {
+	r := report{Findings: findings}
+	for _, f := range findings {
+		switch f.Severity {
+		case SeverityError:
+			r.Errors++
+		case SeverityWarning:
+			r.Warnings++
+		default:
+			r.Infos++
+		}
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+// WriteText writes one finding per line in the form severity host [rule] message
+func WriteText(w io.Writer, findings []Finding) error {
+	for _, f := range findings {
+		if _, err := fmt.Fprintf(w, "%-7s %s [%s] %s\n", f.Severity, f.Host, f.Rule, f.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
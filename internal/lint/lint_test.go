@@ -0,0 +1,121 @@
+package lint
+
+import (
+	"andrew/sshman/internal/config"
+	"andrew/sshman/internal/sqlite"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func host(alias string, tags []string, opts ...string) sqlite.Host {
+	h := sqlite.Host{Host: alias, Tags: tags}
+	for i := 0; i+1 < len(opts); i += 2 {
+		h.Options = append(h.Options, sqlite.HostOptions{Key: opts[i], Value: opts[i+1], Host: alias})
+	}
+	return h
+}
+
+func findingsFor(findings []Finding, rule string) []Finding {
+	matched := make([]Finding, 0)
+	for _, f := range findings {
+		if f.Rule == rule {
+			matched = append(matched, f)
+		}
+	}
+	return matched
+}
+
+func TestRules(t *testing.T) {
+	dir := t.TempDir()
+	openKey := filepath.Join(dir, "open_key")
+	if err := os.WriteFile(openKey, []byte("key"), 0o644); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	closedKey := filepath.Join(dir, "closed_key")
+	if err := os.WriteFile(closedKey, []byte("key"), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	ctx := Context{
+		Hosts: []sqlite.Host{
+			host("web", []string{"prod"}, "HostName", "10.0.0.1", "PasswordAuthentication", "yes", "IdentityFile", openKey),
+			host("web-alias", nil, "HostName", "10.0.0.1", "Port", "22", "IdentityFile", filepath.Join(dir, "missing")),
+			host("dev", []string{"dev"}, "HostName", "10.0.0.2", "PasswordAuthentication", "yes", "IdentityFile", closedKey,
+				"StrictHostKeyChecking", "no", "LocalForward", "8080:localhost:80"),
+			host("db", nil, "HostName", "10.0.0.3", "Port", "99999", "ProxyJump", "nowhere"),
+		},
+		Forwards: []sqlite.ForwardProfile{{
+			Host: "db", Name: "web-tunnel",
+			Forwards: []sqlite.Forward{{Type: "LocalForward", Spec: "8080:localhost:8080"}},
+		}},
+	}
+	linter, err := New(config.Lint{})
+	if err != nil {
+		t.Fatalf("failed to create linter: %v", err)
+	}
+	findings := linter.Run(ctx)
+	expected := map[string][]string{
+		"identity-file-missing":     {"web-alias"},
+		"identity-file-permissions": {"web"},
+		"prod-password-auth":        {"web"},
+		"duplicate-endpoint":        {"web", "web-alias"},
+		"strict-host-key-checking":  {"dev"},
+		"forward-port-collision":    {"db", "dev"},
+		"invalid-option":            {"db"},
+		"proxy-jump-chain":          {"db"},
+	}
+	for rule, hosts := range expected {
+		matched := findingsFor(findings, rule)
+		if len(matched) != len(hosts) {
+			t.Fatalf("expected %d findings for %s but got %+v", len(hosts), rule, matched)
+		}
+		for i, h := range hosts {
+			if matched[i].Host != h {
+				t.Fatalf("expected %s finding for %s but got %+v", rule, h, matched[i])
+			}
+		}
+	}
+	if !HasErrors(findings) {
+		t.Fatalf("findings should contain errors")
+	}
+}
+
+func TestLinterConfig(t *testing.T) {
+	ctx := Context{Hosts: []sqlite.Host{host("dev", []string{"staging"}, "StrictHostKeyChecking", "no", "PasswordAuthentication", "yes")}}
+	linter, err := New(config.Lint{
+		DisabledRules: []string{"strict-host-key-checking"},
+		Severity:      map[string]string{"prod-password-auth": "Error"},
+		ProdTags:      []string{"staging"},
+	})
+	if err != nil {
+		t.Fatalf("failed to create linter: %v", err)
+	}
+	ctx.Config.Lint.ProdTags = []string{"staging"}
+	findings := linter.Run(ctx)
+	if len(findings) != 1 || findings[0].Rule != "prod-password-auth" || findings[0].Severity != SeverityError {
+		t.Fatalf("expected only the prod-password-auth finding with error severity, got %+v", findings)
+	}
+	if _, err = New(config.Lint{DisabledRules: []string{"no-such-rule"}}); err == nil {
+		t.Fatalf("unknown rules in config should be rejected")
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	findings := []Finding{
+		{Rule: "a", Severity: SeverityError, Host: "h1", Message: "m"},
+		{Rule: "b", Severity: SeverityWarning, Host: "h2", Message: "m"},
+	}
+	var buf bytes.Buffer
+	if err := WriteJSON(&buf, findings); err != nil {
+		t.Fatalf("failed to write json: %v", err)
+	}
+	var decoded report
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("output is not valid json: %v", err)
+	}
+	if decoded.Errors != 1 || decoded.Warnings != 1 || len(decoded.Findings) != 2 {
+		t.Fatalf("unexpected report %+v", decoded)
+	}
+}
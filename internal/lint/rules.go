@@ -0,0 +1,290 @@
+package lint
+
+import (
+	"andrew/sshman/internal/sqlite"
+	"andrew/sshman/internal/sshUtils"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// ruleFunc adapts a check function into a Rule, used by the built-in rules
+type ruleFunc struct {
+	name        string
+	description string
+	severity    Severity
+	check       func(ctx Context) []Finding
+}
+
+func (r ruleFunc) Name() string              { return r.name }
+func (r ruleFunc) Description() string       { return r.description }
+func (r ruleFunc) DefaultSeverity() Severity { return r.severity }
+func (r ruleFunc) Check(ctx Context) []Finding {
+	return r.check(ctx)
+}
+
+func init() {
+	Register(ruleFunc{
+		name:        "identity-file-missing",
+		description: "IdentityFile points at a key that does not exist",
+		severity:    SeverityError,
+		check:       checkIdentityFileMissing,
+	})
+	Register(ruleFunc{
+		name:        "identity-file-permissions",
+		description: "IdentityFile is readable by other users, ssh refuses to use such keys",
+		severity:    SeverityError,
+		check:       checkIdentityFilePermissions,
+	})
+	Register(ruleFunc{
+		name:        "prod-password-auth",
+		description: "PasswordAuthentication yes on a host tagged as production",
+		severity:    SeverityWarning,
+		check:       checkProdPasswordAuth,
+	})
+	Register(ruleFunc{
+		name:        "duplicate-endpoint",
+		description: "the same HostName and Port are stored under different aliases",
+		severity:    SeverityWarning,
+		check:       checkDuplicateEndpoint,
+	})
+	Register(ruleFunc{
+		name:        "strict-host-key-checking",
+		description: "StrictHostKeyChecking is turned off",
+		severity:    SeverityWarning,
+		check:       checkStrictHostKeyChecking,
+	})
+	Register(ruleFunc{
+		name:        "forward-port-collision",
+		description: "forwards of different hosts or profiles listen on the same local port",
+		severity:    SeverityWarning,
+		check:       checkForwardPortCollision,
+	})
+	Register(ruleFunc{
+		name:        "invalid-option",
+		description: "an option value is rejected by the option validators",
+		severity:    SeverityError,
+		check:       checkInvalidOption,
+	})
+	Register(ruleFunc{
+		name:        "proxy-jump-chain",
+		description: "ProxyJump chain has a cycle or references a host that is not managed",
+		severity:    SeverityError,
+		check:       checkProxyJumpChain,
+	})
+}
+
+func optionValues(host sqlite.Host, key string) []string {
+	values := make([]string, 0)
+	for _, opt := range host.Options {
+		if strings.EqualFold(opt.Key, key) {
+			values = append(values, strings.TrimSpace(opt.Value))
+		}
+	}
+	return values
+}
+
+// identityFiles returns the IdentityFile paths of host with ~ and percent tokens expanded, values
+// that fail to expand are reported by invalid-option and skipped here
+func identityFiles(host sqlite.Host) []string {
+	tokens := sshUtils.NewTokenContext(host)
+	paths := make([]string, 0)
+	for _, value := range optionValues(host, "IdentityFile") {
+		if strings.EqualFold(strings.Trim(value, "\""), "none") {
+			continue
+		}
+		path, err := sshUtils.ExpandOptionValue("IdentityFile", value, tokens)
+		if err != nil {
+			continue
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func checkIdentityFileMissing(ctx Context) []Finding {
+	findings := make([]Finding, 0)
+	for _, host := range ctx.Hosts {
+		for _, path := range identityFiles(host) {
+			if _, err := os.Stat(path); os.IsNotExist(err) {
+				findings = append(findings, Finding{Host: host.Host, Message: "IdentityFile does not exist: " + path})
+			}
+		}
+	}
+	return findings
+}
+
+func checkIdentityFilePermissions(ctx Context) []Finding {
+	findings := make([]Finding, 0)
+	if runtime.GOOS == "windows" {
+		return findings // file modes do not map to windows acls
+	}
+	for _, host := range ctx.Hosts {
+		for _, path := range identityFiles(host) {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if mode := info.Mode().Perm(); mode&0o077 != 0 {
+				findings = append(findings, Finding{
+					Host:    host.Host,
+					Message: fmt.Sprintf("IdentityFile %s has mode %04o, it should only be readable by its owner", path, mode),
+				})
+			}
+		}
+	}
+	return findings
+}
+
+func checkProdPasswordAuth(ctx Context) []Finding {
+	prodTags := ctx.Config.Lint.ProdTags
+	if len(prodTags) == 0 {
+		prodTags = []string{"prod"}
+	}
+	findings := make([]Finding, 0)
+	for _, host := range ctx.Hosts {
+		isProd := slices.ContainsFunc(host.Tags, func(tag string) bool {
+			return slices.ContainsFunc(prodTags, func(prod string) bool { return strings.EqualFold(tag, prod) })
+		})
+		if !isProd {
+			continue
+		}
+		for _, value := range optionValues(host, "PasswordAuthentication") {
+			if strings.EqualFold(value, "yes") {
+				findings = append(findings, Finding{Host: host.Host, Message: "PasswordAuthentication is enabled on a production host"})
+			}
+		}
+	}
+	return findings
+}
+
+func checkDuplicateEndpoint(ctx Context) []Finding {
+	endpoints := make(map[string][]string)
+	order := make([]string, 0)
+	for _, host := range ctx.Hosts {
+		hostname := host.Host
+		if values := optionValues(host, "HostName"); len(values) > 0 {
+			hostname = values[0]
+		}
+		port := "22"
+		if values := optionValues(host, "Port"); len(values) > 0 {
+			port = values[0]
+		}
+		// hosts behind different jump hosts can share an address without being the same machine
+		jump := strings.Join(optionValues(host, "ProxyJump"), ",")
+		endpoint := strings.ToLower(net.JoinHostPort(hostname, port)) + " via " + jump
+		if _, ok := endpoints[endpoint]; !ok {
+			order = append(order, endpoint)
+		}
+		endpoints[endpoint] = append(endpoints[endpoint], host.Host)
+	}
+	findings := make([]Finding, 0)
+	for _, endpoint := range order {
+		aliases := endpoints[endpoint]
+		if len(aliases) < 2 {
+			continue
+		}
+		for _, alias := range aliases {
+			others := slices.DeleteFunc(slices.Clone(aliases), func(a string) bool { return a == alias })
+			findings = append(findings, Finding{
+				Host:    alias,
+				Message: "same HostName and Port as " + strings.Join(others, ", "),
+			})
+		}
+	}
+	return findings
+}
+
+func checkStrictHostKeyChecking(ctx Context) []Finding {
+	findings := make([]Finding, 0)
+	for _, host := range ctx.Hosts {
+		for _, value := range optionValues(host, "StrictHostKeyChecking") {
+			if strings.EqualFold(value, "no") || strings.EqualFold(value, "off") {
+				findings = append(findings, Finding{Host: host.Host, Message: "StrictHostKeyChecking is " + value + ", host keys are not verified"})
+			}
+		}
+	}
+	return findings
+}
+
+type localListener struct {
+	host   string
+	source string // option or forward profile the address comes from
+	addr   string
+}
+
+func checkForwardPortCollision(ctx Context) []Finding {
+	listeners := make([]localListener, 0)
+	for _, host := range ctx.Hosts {
+		// forward options of a host are started on every connection so they are checked as one profile
+		profile := sqlite.ForwardProfile{Host: host.Host}
+		for _, opt := range host.Options {
+			if sshUtils.IsForwardOption(opt.Key) {
+				profile.Forwards = append(profile.Forwards, sqlite.Forward{Type: opt.Key, Spec: opt.Value})
+			}
+		}
+		for _, addr := range sshUtils.LocalListenAddrs(profile) {
+			listeners = append(listeners, localListener{host: host.Host, source: "options", addr: addr})
+		}
+	}
+	for _, profile := range ctx.Forwards {
+		for _, addr := range sshUtils.LocalListenAddrs(profile) {
+			listeners = append(listeners, localListener{host: profile.Host, source: "profile " + profile.Name, addr: addr})
+		}
+	}
+	findings := make([]Finding, 0)
+	for i, a := range listeners {
+		for j, b := range listeners {
+			if i == j || !listenersOverlap(a.addr, b.addr) {
+				continue
+			}
+			findings = append(findings, Finding{
+				Host:    a.host,
+				Message: fmt.Sprintf("%s listens on %s which collides with %s of %s (%s)", a.source, a.addr, b.source, b.host, b.addr),
+			})
+		}
+	}
+	return findings
+}
+
+// listenersOverlap reports whether two local addresses can not be bound at the same time
+func listenersOverlap(a, b string) bool {
+	hostA, portA, errA := net.SplitHostPort(a)
+	hostB, portB, errB := net.SplitHostPort(b)
+	if errA != nil || errB != nil || portA != portB {
+		return false
+	}
+	return hostA == hostB || hostA == "" || hostB == ""
+}
+
+func checkInvalidOption(ctx Context) []Finding {
+	findings := make([]Finding, 0)
+	for _, host := range ctx.Hosts {
+		for _, opt := range host.Options {
+			if !sshUtils.IsAcceptableOption(opt.Key) {
+				continue // options outside the settable list come from synced configs and are passed through as is
+			}
+			if err := sshUtils.ValidateSpecificOption(opt.Key, opt.Value); err != nil {
+				findings = append(findings, Finding{Host: host.Host, Message: err.Error()})
+			}
+		}
+	}
+	return findings
+}
+
+func checkProxyJumpChain(ctx Context) []Finding {
+	findings := make([]Finding, 0)
+	lookup := sshUtils.HostJumpLookup(ctx.Hosts)
+	for _, host := range ctx.Hosts {
+		if len(optionValues(host, "ProxyJump")) == 0 {
+			continue
+		}
+		if _, err := sshUtils.ResolveJumpChain(host.Host, lookup); err != nil {
+			findings = append(findings, Finding{Host: host.Host, Message: err.Error()})
+		}
+	}
+	return findings
+}
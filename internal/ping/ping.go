@@ -1,62 +1,394 @@
 package ping
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// maxBannerLines is the number of lines a server may send before its identification, RFC 4253 allows
+	// servers to send other lines first
+	maxBannerLines = 32
+	maxBannerLine  = 255 // the identification line including CR LF can not be longer than this
+	probeVersion   = "SSH-2.0-ssh_man_probe"
 )
 
+// errHostKeyFetched aborts the key exchange once the host key is known, no authentication is attempted
+var errHostKeyFetched = errors.New("host key fetched")
+
+// ErrResolve is returned when the name of a host does not resolve, as opposed to a host that does not answer
+var ErrResolve = errors.New("failed to resolve host")
+
 type PingResult struct {
 	Reachable bool
 	Latency   time.Duration
 	Err       error
+	Addr      string          // the address that answered, empty if none did
+	Addresses []AddressResult // every address the host resolved to in the order they were tried
+	// set by ProbeSSH only
+	SSH           bool   // the port answered with an ssh identification banner
+	ServerVersion string // software version of the banner, ie OpenSSH_9.6p1 Ubuntu-3ubuntu13
+	HostKey       string // SHA256 fingerprint of the host key, only set when it was fetched
+	HostKeyType   string
+	HostKeyErr    error // set when the host key was asked for but the key exchange failed
+}
+
+// AddressResult is the result of dialing one of the addresses of a host
+type AddressResult struct {
+	Addr      string
+	Tried     bool // false if another address answered first
+	Reachable bool
+	Refused   bool // the address answered but nothing listens on the port
+	Latency   time.Duration
+	Err       error
+}
+
+// DialOptions are the ssh options of a host that change how it is dialed
+type DialOptions struct {
+	AddressFamily string // any, inet or inet6, like the ssh option
+	BindAddress   string // local address to dial from
+	BindInterface string // local interface to dial from, BindAddress wins if both are set
 }
 
-func getIpFromHostname(hostname string) (net.IP, error) {
-	ips, err := net.LookupIP(hostname)
+// connectionAttemptDelay is how long an address gets before the next one is dialed alongside it, see RFC 8305
+const connectionAttemptDelay = 250 * time.Millisecond
+
+// resolve looks up the addresses of hostname limited to family and orders them for dialing
+func resolve(ctx context.Context, hostname, family string) ([]net.IP, error) {
+	network := "ip"
+	switch strings.ToLower(family) {
+	case "inet":
+		network = "ip4"
+	case "inet6":
+		network = "ip6"
+	}
+	ips, err := net.DefaultResolver.LookupIP(ctx, network, hostname)
 	if err != nil {
-		return nil, err
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w %s: %v", ErrResolve, hostname, err)
 	}
-	return ips[0], nil
+	return interleaveFamilies(ips), nil
 }
 
-func PingRemoteHost(hostname string, port uint, timeout time.Duration) PingResult {
-	ip, err := getIpFromHostname(hostname)
-	if err != nil {
-		return PingResult{
-			Reachable: false,
-			Err:       err,
+// interleaveFamilies alternates ipv6 and ipv4 addresses starting with ipv6, keeping the order within a family,
+// like RFC 8305 section 4
+func interleaveFamilies(ips []net.IP) []net.IP {
+	var v6, v4 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
 		}
 	}
-	dialer := net.Dialer{
-		Timeout: timeout,
+	ordered := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(v6) || i < len(v4); i++ {
+		if i < len(v6) {
+			ordered = append(ordered, v6[i])
+		}
+		if i < len(v4) {
+			ordered = append(ordered, v4[i])
+		}
+	}
+	return ordered
+}
+
+// localAddr returns the address to dial ip from, nil to let the system pick
+func localAddr(ip net.IP, opts DialOptions) (net.Addr, error) {
+	v4 := ip.To4() != nil
+	if opts.BindAddress != "" {
+		local := net.ParseIP(opts.BindAddress)
+		if local == nil {
+			return nil, fmt.Errorf("invalid bind address %q", opts.BindAddress)
+		}
+		if (local.To4() != nil) != v4 {
+			return nil, fmt.Errorf("bind address %s can not reach %s", opts.BindAddress, ip)
+		}
+		return &net.TCPAddr{IP: local}, nil
+	}
+	if opts.BindInterface == "" {
+		return nil, nil
+	}
+	iface, err := net.InterfaceByName(opts.BindInterface)
+	if err != nil {
+		return nil, fmt.Errorf("bind interface: %w", err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("bind interface: %w", err)
 	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || (ipNet.IP.To4() != nil) != v4 || (!v4 && ipNet.IP.IsLinkLocalUnicast()) {
+			continue
+		}
+		return &net.TCPAddr{IP: ipNet.IP}, nil
+	}
+	return nil, fmt.Errorf("interface %s has no address that can reach %s", opts.BindInterface, ip)
+}
+
+func dialAddress(ctx context.Context, ip net.IP, port uint, opts DialOptions) (net.Conn, AddressResult) {
 	addr := net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+	result := AddressResult{Addr: addr, Tried: true}
+	local, err := localAddr(ip, opts)
+	if err != nil {
+		result.Err = err
+		return nil, result
+	}
+	dialer := net.Dialer{LocalAddr: local}
 	start := time.Now()
-	conn, err := dialer.Dial("tcp", addr)
-	rtt := time.Since(start)
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	result.Latency = time.Since(start)
+	switch {
+	case err == nil:
+		result.Reachable = true
+	case errors.Is(err, syscall.ECONNREFUSED):
+		result.Refused = true
+	default:
+		result.Err = err
+	}
+	return conn, result
+}
+
+// PingRemoteHost dials hostname on port to see if it is reachable. Canceling ctx aborts the ping, its result then
+// holds the error of ctx
+func PingRemoteHost(ctx context.Context, hostname string, port uint, timeout time.Duration, opts DialOptions) PingResult {
+	conn, result := dial(ctx, hostname, port, timeout, opts)
+	if conn != nil {
+		conn.Close()
+	}
+	return result
+}
+
+// dial connects to hostname on port, the connection is nil unless the result is Reachable
+func dial(ctx context.Context, hostname string, port uint, timeout time.Duration, opts DialOptions) (net.Conn, PingResult) {
+	ips, err := resolve(ctx, hostname, opts.AddressFamily)
 	if err != nil {
-		if errors.Is(err, syscall.ECONNREFUSED) {
-			return PingResult{
-				Reachable: false,
-				Latency:   rtt,
-				Err:       nil,
-			}
+		return nil, PingResult{
+			Reachable: false,
+			Err:       err,
+		}
+	}
+	return dialAddresses(ctx, ips, port, timeout, opts)
+}
 
-		} else {
-			return PingResult{
-				Reachable: false,
-				Err:       fmt.Errorf("Failed to ping host, Error: %v", err),
+// dialAddresses dials ips in order and returns the first connection made. Like Happy Eyeballs the next address is
+// dialed once the one before it failed or did not connect within connectionAttemptDelay, the attempts still
+// running when one connects are dropped
+func dialAddresses(parent context.Context, ips []net.IP, port uint, timeout time.Duration, opts DialOptions) (net.Conn, PingResult) {
+	ctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	type attempt struct {
+		index  int
+		conn   net.Conn
+		result AddressResult
+	}
+	addresses := make([]AddressResult, len(ips))
+	for i, ip := range ips {
+		addresses[i].Addr = net.JoinHostPort(ip.String(), strconv.Itoa(int(port)))
+	}
+	attempts := make(chan attempt)
+	next, running := 0, 0
+	startNext := func() {
+		i := next
+		next++
+		running++
+		go func() {
+			conn, result := dialAddress(ctx, ips[i], port, opts)
+			attempts <- attempt{index: i, conn: conn, result: result}
+		}()
+	}
+	startNext()
+	delay := time.NewTimer(connectionAttemptDelay)
+	defer delay.Stop()
+	winner := -1
+	var conn net.Conn
+	for running > 0 {
+		select {
+		case done := <-attempts:
+			running--
+			if winner != -1 {
+				// dropped in favour of the winner
+				if done.conn != nil {
+					done.conn.Close()
+				}
+				continue
+			}
+			addresses[done.index] = done.result
+			if done.conn != nil {
+				winner, conn = done.index, done.conn
+				cancel()
+				continue
+			}
+			if next < len(ips) {
+				startNext()
+				delay.Reset(connectionAttemptDelay)
+			}
+		case <-delay.C:
+			if winner == -1 && next < len(ips) {
+				startNext()
+				delay.Reset(connectionAttemptDelay)
 			}
 		}
 	}
-	conn.Close()
-	return PingResult{
-		Reachable: true,
-		Latency:   rtt,
-		Err:       nil,
+	result := PingResult{Addresses: addresses}
+	if winner != -1 {
+		result.Reachable = true
+		result.Latency = addresses[winner].Latency
+		result.Addr = addresses[winner].Addr
+		return conn, result
+	}
+	if err := parent.Err(); err != nil {
+		result.Err = err
+		return nil, result
+	}
+	for _, address := range addresses {
+		if address.Refused {
+			result.Latency = address.Latency
+			return nil, result
+		}
+	}
+	for _, address := range addresses {
+		if address.Err != nil {
+			result.Err = fmt.Errorf("Failed to ping host, Error: %v", address.Err)
+			break
+		}
+	}
+	return nil, result
+}
+
+// ProbeSSH dials hostname like PingRemoteHost and reads the ssh identification banner of the server. A port that
+// accepts the connection but does not send a banner within timeout is Reachable but not SSH. When hostKey is set
+// a key exchange is done to fetch the host key, the connection is dropped before authenticating
+func ProbeSSH(ctx context.Context, hostname string, port uint, timeout time.Duration, hostKey bool, opts DialOptions) PingResult {
+	conn, result := dial(ctx, hostname, port, timeout, opts)
+	if conn == nil {
+		return result
+	}
+	defer conn.Close()
+	probed := ProbeConn(ctx, conn, timeout, hostKey)
+	probed.Latency, probed.Addr, probed.Addresses = result.Latency, result.Addr, result.Addresses
+	return probed
+}
+
+// ProbeConn reads the ssh identification banner from a connection that is already open, ie one dialed by ProbeSSH
+// or a stream through a jump host, see ProbeSSH. The result is Reachable, Latency is left for the caller to set
+// and conn is not closed. Canceling ctx expires the deadline of conn so a read in progress returns at once
+func ProbeConn(ctx context.Context, conn net.Conn, timeout time.Duration, hostKey bool) PingResult {
+	result := PingResult{Reachable: true}
+	_ = conn.SetDeadline(time.Now().Add(timeout))
+	stop := context.AfterFunc(ctx, func() { _ = conn.SetDeadline(time.Unix(1, 0)) })
+	defer stop()
+	consumed, version, err := readBanner(conn)
+	if ctx.Err() != nil {
+		result.Err = ctx.Err()
+		return result
+	}
+	if err != nil {
+		return result // not ssh, ie a load balancer or web server on the port
+	}
+	result.SSH = true
+	result.ServerVersion = version
+	if !hostKey {
+		_, _ = conn.Write([]byte(probeVersion + "\r\n")) // lets the server log a clean disconnect
+		return result
+	}
+	// hand the banner back to the ssh client as it reads the server identification itself
+	replay := &replayConn{Conn: conn, r: io.MultiReader(bytes.NewReader(consumed), conn)}
+	config := &ssh.ClientConfig{
+		ClientVersion: probeVersion,
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			result.HostKey = ssh.FingerprintSHA256(key)
+			result.HostKeyType = key.Type()
+			return errHostKeyFetched
+		},
+		Timeout: timeout,
+	}
+	_, _, _, err = ssh.NewClientConn(replay, conn.RemoteAddr().String(), config)
+	if result.HostKey == "" && ctx.Err() != nil {
+		result.Err = ctx.Err()
+	} else if result.HostKey == "" {
+		result.HostKeyErr = fmt.Errorf("key exchange failed: %w", err)
+	}
+	return result
+}
+
+// readBanner reads lines from r until the ssh identification line, returns every byte read from r along with
+// the software version of the line
+func readBanner(r io.Reader) ([]byte, string, error) {
+	var consumed bytes.Buffer
+	reader := bufio.NewReaderSize(io.TeeReader(r, &consumed), maxBannerLine)
+	for range maxBannerLines {
+		line, err := reader.ReadSlice('\n')
+		if err != nil {
+			return nil, "", fmt.Errorf("no ssh identification: %w", err)
+		}
+		text := strings.TrimRight(string(line), "\r\n")
+		if !strings.HasPrefix(text, "SSH-") {
+			continue
+		}
+		// SSH-protoversion-softwareversion SP comments
+		parts := strings.SplitN(text, "-", 3)
+		if len(parts) != 3 || (parts[1] != "2.0" && parts[1] != "1.99") {
+			return nil, "", fmt.Errorf("unsupported ssh identification %q", text)
+		}
+		// the bufio reader may have read past the identification line, those bytes are handed back too
+		return consumed.Bytes(), parts[2], nil
+	}
+	return nil, "", errors.New("no ssh identification")
+}
+
+// replayConn reads from r instead of the connection so bytes already read can be handed back
+type replayConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *replayConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// Scan runs ping for every target with at most concurrency running at once and sends each result to results as
+// soon as it is ready. It blocks until every target is handled and does not close results. Once ctx is canceled
+// no further targets are started and results still pending are dropped, ping is expected to return early
+func Scan[T, R any](ctx context.Context, targets []T, concurrency int, ping func(T) R, results chan<- R) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for _, target := range targets {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Add(1)
+		go func(target T) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			result := ping(target)
+			select {
+			case results <- result:
+			case <-ctx.Done():
+			}
+		}(target)
 	}
+	wg.Wait()
 }
@@ -2,10 +2,17 @@ package ping
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
 	"net"
 	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"golang.org/x/crypto/ssh"
 )
 
 func TestPingHost(t *testing.T) {
@@ -24,7 +31,7 @@ func TestPingHost(t *testing.T) {
 	}
 	t.Logf("Listening on Addr %v", listener.Addr())
 	portNum, _ := strconv.Atoi(port)
-	res := PingRemoteHost("127.0.0.1", uint(portNum), time.Second*1)
+	res := PingRemoteHost(context.Background(), "127.0.0.1", uint(portNum), time.Second*1, DialOptions{})
 	if res.Err != nil {
 		t.Fatalf("Received error ping valid host. Error %v", res.Err)
 	}
@@ -36,7 +43,7 @@ func TestPingHost(t *testing.T) {
 
 func TestPingHostConnectionRefused(t *testing.T) {
 	// dial localhost with a unused port
-	res := PingRemoteHost("localhost", 5673, time.Millisecond*150)
+	res := PingRemoteHost(context.Background(), "localhost", 5673, time.Millisecond*150, DialOptions{})
 	if res.Reachable {
 		t.Fatalf("Should not be able to reach host destination")
 	}
@@ -48,7 +55,7 @@ func TestPingHostConnectionRefused(t *testing.T) {
 
 func TestPingHostUnreachable(t *testing.T) {
 	notRealHost := "192.168.60.128"
-	res := PingRemoteHost(notRealHost, 22, time.Millisecond*250)
+	res := PingRemoteHost(context.Background(), notRealHost, 22, time.Millisecond*250, DialOptions{})
 	if res.Err == nil {
 		t.Fatalf("Should have received a host not found error but received no error")
 	}
@@ -57,3 +64,179 @@ func TestPingHostUnreachable(t *testing.T) {
 	}
 	t.Logf("Error Received pinging non existent host %v", res.Err)
 }
+
+func TestScan(t *testing.T) {
+	targets := make([]int, 20)
+	for i := range targets {
+		targets[i] = i
+	}
+	var running, peak atomic.Int32
+	results := make(chan int)
+	done := make(chan struct{})
+	go func() {
+		Scan(context.Background(), targets, 3, func(target int) int {
+			n := running.Add(1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			running.Add(-1)
+			return target
+		}, results)
+		close(done)
+	}()
+	seen := make(map[int]bool)
+	for len(seen) < len(targets) {
+		seen[<-results] = true
+	}
+	<-done
+	if p := peak.Load(); p > 3 || p == 0 {
+		t.Fatalf("expected at most 3 pings at once, got %d", p)
+	}
+}
+
+// serveOnce accepts connections on a local port and hands each to serve, returns the port
+func serveOnce(t *testing.T, serve func(conn net.Conn)) uint {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+	return uint(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestProbeSSH(t *testing.T) {
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate host key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatalf("failed to create host signer: %v", err)
+	}
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true, ServerVersion: "SSH-2.0-TestSSH_1.2 comment"}
+	serverConfig.AddHostKey(signer)
+	port := serveOnce(t, func(conn net.Conn) {
+		_, _, _, _ = ssh.NewServerConn(conn, serverConfig)
+	})
+
+	res := ProbeSSH(context.Background(), "127.0.0.1", port, time.Second, false, DialOptions{})
+	if !res.Reachable || !res.SSH || res.ServerVersion != "TestSSH_1.2 comment" || res.HostKey != "" {
+		t.Fatalf("expected the banner to be read without a key exchange, got %+v", res)
+	}
+	res = ProbeSSH(context.Background(), "127.0.0.1", port, time.Second, true, DialOptions{})
+	if res.HostKeyErr != nil || res.HostKey != ssh.FingerprintSHA256(signer.PublicKey()) || res.HostKeyType != ssh.KeyAlgoED25519 {
+		t.Fatalf("expected the host key to be fetched, got %+v", res)
+	}
+
+	preBanner := serveOnce(t, func(conn net.Conn) {
+		_, _ = conn.Write([]byte("welcome to the jump host\r\nSSH-2.0-Custom\r\n"))
+	})
+	if res = ProbeSSH(context.Background(), "127.0.0.1", preBanner, time.Second, false, DialOptions{}); !res.SSH || res.ServerVersion != "Custom" {
+		t.Fatalf("lines before the identification should be skipped, got %+v", res)
+	}
+
+	http := serveOnce(t, func(conn net.Conn) {
+		_, _ = conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+	})
+	if res = ProbeSSH(context.Background(), "127.0.0.1", http, time.Second, true, DialOptions{}); !res.Reachable || res.SSH || res.Err != nil {
+		t.Fatalf("an open port that is not ssh should be reachable but not ssh, got %+v", res)
+	}
+
+	silent := serveOnce(t, func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	})
+	if res = ProbeSSH(context.Background(), "127.0.0.1", silent, 200*time.Millisecond, false, DialOptions{}); !res.Reachable || res.SSH {
+		t.Fatalf("a port that never sends a banner should not be ssh, got %+v", res)
+	}
+}
+
+func TestInterleaveFamilies(t *testing.T) {
+	ips := []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"), net.ParseIP("2001:db8::1")}
+	got := interleaveFamilies(ips)
+	want := []string{"2001:db8::1", "192.0.2.1", "192.0.2.2"}
+	for i, ip := range got {
+		if ip.String() != want[i] {
+			t.Fatalf("expected %v but got %v", want, got)
+		}
+	}
+}
+
+func TestDialAddresses(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:")
+	if err != nil {
+		t.Fatalf("Failed to listen. Error %v", err)
+	}
+	defer listener.Close()
+	port := uint(listener.Addr().(*net.TCPAddr).Port)
+
+	// 127.0.0.2 has nothing listening, the next address is dialed as soon as it is refused
+	conn, res := dialAddresses(context.Background(), []net.IP{net.ParseIP("127.0.0.2"), net.ParseIP("127.0.0.1")}, port, time.Second, DialOptions{})
+	if conn == nil || !res.Reachable || res.Addr != listener.Addr().String() {
+		t.Fatalf("expected the second address to answer, got %+v", res)
+	}
+	conn.Close()
+	if len(res.Addresses) != 2 || !res.Addresses[0].Tried || !res.Addresses[0].Refused || !res.Addresses[1].Reachable {
+		t.Fatalf("every address should be reported, got %+v", res.Addresses)
+	}
+
+	conn, res = dialAddresses(context.Background(), []net.IP{net.ParseIP("127.0.0.1"), net.ParseIP("127.0.0.2")}, port, time.Second, DialOptions{})
+	if conn == nil || res.Addresses[1].Tried {
+		t.Fatalf("the addresses after the one answering should not be dialed, got %+v", res.Addresses)
+	}
+	conn.Close()
+
+	if res = PingRemoteHost(context.Background(), "127.0.0.1", port, time.Second, DialOptions{BindAddress: "127.0.0.1"}); !res.Reachable {
+		t.Fatalf("expected the bind address to be used, got %+v", res)
+	}
+	if res = PingRemoteHost(context.Background(), "127.0.0.1", port, time.Second, DialOptions{BindAddress: "::1"}); res.Reachable || res.Err == nil {
+		t.Fatalf("a bind address of the other family can not reach the host, got %+v", res)
+	}
+	if res = PingRemoteHost(context.Background(), "127.0.0.1", port, time.Second, DialOptions{AddressFamily: "inet6"}); !errors.Is(res.Err, ErrResolve) {
+		t.Fatalf("an ipv4 address should not resolve with AddressFamily inet6, got %+v", res)
+	}
+	if res = PingRemoteHost(context.Background(), "does-not-exist.invalid", port, time.Second, DialOptions{}); !errors.Is(res.Err, ErrResolve) {
+		t.Fatalf("expected a resolve error, got %+v", res)
+	}
+}
+
+func TestProbeCanceled(t *testing.T) {
+	silent := serveOnce(t, func(conn net.Conn) {
+		_, _ = io.Copy(io.Discard, conn)
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	res := ProbeSSH(ctx, "127.0.0.1", silent, 5*time.Second, false, DialOptions{})
+	if !errors.Is(res.Err, context.Canceled) || time.Since(start) > time.Second {
+		t.Fatalf("canceling should abort the probe at once, got %+v after %v", res, time.Since(start))
+	}
+	if res = PingRemoteHost(ctx, "127.0.0.1", silent, time.Second, DialOptions{}); !errors.Is(res.Err, context.Canceled) {
+		t.Fatalf("a canceled ping should report the error of its context, got %+v", res)
+	}
+
+	pinged := atomic.Int32{}
+	Scan(ctx, []int{1, 2, 3}, 1, func(target int) int {
+		pinged.Add(1)
+		return target
+	}, make(chan int))
+	if pinged.Load() != 0 {
+		t.Fatalf("a canceled scan should not start any ping, started %d", pinged.Load())
+	}
+}
@@ -0,0 +1,125 @@
+package sqlite
+
+import (
+	"fmt"
+	"time"
+
+	"zombiezen.com/go/sqlite"
+)
+
+// Forward is a single forward of a profile, Type is one of LocalForward, RemoteForward or DynamicForward
+// and Spec is the forward in the same form as the option value, ie 8080:localhost:80
+type Forward struct {
+	Type string
+	Spec string
+}
+
+// ForwardProfile is a named group of forwards for a host that are started together
+type ForwardProfile struct {
+	Host      string
+	Name      string
+	CreatedAt time.Time
+	Forwards  []Forward
+}
+
+type ForwardDao struct {
+	conn *Connection
+}
+
+const (
+	forwardProfileInsertString = `INSERT INTO forward_profiles (host, name, created_at) VALUES (?,?,?)`
+	forwardEntryInsertString   = `INSERT INTO forward_profile_entries (host, name, type, spec) VALUES (?,?,?,?)`
+	forwardProfileDeleteString = `DELETE FROM forward_profiles WHERE host = ? AND name = ?`
+)
+
+func NewForwardDao(conn *Connection) *ForwardDao {
+	if conn == nil {
+		return nil
+	}
+	return &ForwardDao{conn: conn}
+}
+
+// Insert stores a new profile, errors if the host does not exist or already has a profile with the same name
+func (dao *ForwardDao) Insert(profile ForwardProfile) error {
+	if len(profile.Forwards) == 0 {
+		return fmt.Errorf("forward profile %s has no forwards", profile.Name)
+	}
+	err := dao.conn.transaction(func() error {
+		err := dao.conn.execute(forwardProfileInsertString, profile.Host, profile.Name, ts(&profile.CreatedAt))
+		if err != nil {
+			return err
+		}
+		for _, fwd := range profile.Forwards {
+			err = dao.conn.execute(forwardEntryInsertString, profile.Host, profile.Name, fwd.Type, fwd.Spec)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// Delete removes the profile and its forwards
+func (dao *ForwardDao) Delete(host, name string) error {
+	err := dao.conn.execute(forwardProfileDeleteString, host, name)
+	if err != nil {
+		return err
+	}
+	if dao.conn.conn.Changes() < 1 {
+		return fmt.Errorf("Forward profile does not exist %s/%s", host, name)
+	}
+	return nil
+}
+
+func (dao *ForwardDao) Get(host, name string) (ForwardProfile, error) {
+	profiles, err := dao.queryProfiles(`SELECT * FROM forward_profiles WHERE host = ? AND name = ?`, host, name)
+	if err != nil {
+		return ForwardProfile{}, err
+	}
+	if len(profiles) == 0 {
+		return ForwardProfile{}, fmt.Errorf("Forward profile does not exist %s/%s", host, name)
+	}
+	return profiles[0], nil
+}
+
+// GetForHost returns every profile of host ordered by name
+func (dao *ForwardDao) GetForHost(host string) ([]ForwardProfile, error) {
+	return dao.queryProfiles(`SELECT * FROM forward_profiles WHERE host = ? ORDER BY name`, host)
+}
+
+// GetAll returns every stored profile ordered by host then name
+func (dao *ForwardDao) GetAll() ([]ForwardProfile, error) {
+	return dao.queryProfiles(`SELECT * FROM forward_profiles ORDER BY host, name`)
+}
+
+func (dao *ForwardDao) queryProfiles(query string, args ...any) ([]ForwardProfile, error) {
+	profiles := make([]ForwardProfile, 0)
+	err := dao.conn.query(query, func(stmt *sqlite.Stmt) error {
+		profiles = append(profiles, ForwardProfile{
+			Host:      stmt.GetText("host"),
+			Name:      stmt.GetText("name"),
+			CreatedAt: time.UnixMilli(stmt.GetInt64("created_at")),
+		})
+		return nil
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range profiles {
+		err = dao.conn.query(`SELECT * FROM forward_profile_entries WHERE host = ? AND name = ? ORDER BY id`, func(stmt *sqlite.Stmt) error {
+			profiles[i].Forwards = append(profiles[i].Forwards, Forward{
+				Type: stmt.GetText("type"),
+				Spec: stmt.GetText("spec"),
+			})
+			return nil
+		}, profiles[i].Host, profiles[i].Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return profiles, nil
+}
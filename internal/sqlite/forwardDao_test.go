@@ -0,0 +1,73 @@
+package sqlite
+
+import (
+	"testing"
+	"time"
+)
+
+func TestForwardProfiles(t *testing.T) {
+	hostDao := NewHostDao(conn)
+	dao := NewForwardDao(conn)
+	err := hostDao.Insert(Host{Host: "fwd-host", CreatedAt: time.Now()})
+	if err != nil {
+		t.Fatalf("failed to insert host: %v", err)
+	}
+	profile := ForwardProfile{
+		Host:      "fwd-host",
+		Name:      "db-tunnel",
+		CreatedAt: time.Now(),
+		Forwards: []Forward{
+			{Type: "LocalForward", Spec: "5432:db.internal:5432"},
+			{Type: "DynamicForward", Spec: "1080"},
+		},
+	}
+	if err = dao.Insert(profile); err != nil {
+		t.Fatalf("failed to insert forward profile: %v", err)
+	}
+	if err = dao.Insert(profile); err == nil {
+		t.Fatalf("inserting a duplicate profile name should fail")
+	}
+	if err = dao.Insert(ForwardProfile{Host: "missing-host", Name: "x", Forwards: profile.Forwards}); err == nil {
+		t.Fatalf("inserting a profile for a missing host should fail")
+	}
+	got, err := dao.Get("fwd-host", "db-tunnel")
+	if err != nil {
+		t.Fatalf("failed to get forward profile: %v", err)
+	}
+	if len(got.Forwards) != 2 || got.Forwards[0] != profile.Forwards[0] || got.Forwards[1] != profile.Forwards[1] {
+		t.Fatalf("forwards were not stored in order, got %+v", got.Forwards)
+	}
+
+	if err = hostDao.RenameHost("fwd-host", "fwd-host-renamed"); err != nil {
+		t.Fatalf("failed to rename host: %v", err)
+	}
+	profiles, err := dao.GetForHost("fwd-host-renamed")
+	if err != nil {
+		t.Fatalf("failed to get forward profiles: %v", err)
+	}
+	if len(profiles) != 1 || len(profiles[0].Forwards) != 2 {
+		t.Fatalf("forward profiles should follow a renamed host, got %+v", profiles)
+	}
+
+	if err = dao.Delete("fwd-host-renamed", "db-tunnel"); err != nil {
+		t.Fatalf("failed to delete forward profile: %v", err)
+	}
+	if err = dao.Delete("fwd-host-renamed", "db-tunnel"); err == nil {
+		t.Fatalf("deleting a missing profile should fail")
+	}
+	if err = dao.Insert(ForwardProfile{Host: "fwd-host-renamed", Name: "web", CreatedAt: time.Now(), Forwards: profile.Forwards[:1]}); err != nil {
+		t.Fatalf("failed to insert forward profile: %v", err)
+	}
+	if err = hostDao.Delete(Host{Host: "fwd-host-renamed"}); err != nil {
+		t.Fatalf("failed to delete host: %v", err)
+	}
+	profiles, err = dao.GetAll()
+	if err != nil {
+		t.Fatalf("failed to get forward profiles: %v", err)
+	}
+	for _, p := range profiles {
+		if p.Host == "fwd-host-renamed" {
+			t.Fatalf("forward profiles should be removed along with their host")
+		}
+	}
+}
@@ -362,6 +362,77 @@ func (dao *HostDao) Delete(host Host) error {
 	return nil
 }
 
+// RenameHost moves oldHost along with its options and forward profiles to newHost, dependents are hosts that need to be updated in the same
+// transaction (ie hosts whose ProxyJump referenced the old alias). Dependents are written after the move so the renamed host can be passed
+// under newHost to update its options as well, errors if newHost already exist
+func (dao *HostDao) RenameHost(oldHost, newHost string, dependents ...Host) error {
+	err := dao.conn.transaction(func() error {
+		err := dao.conn.execute(`INSERT INTO hosts (host, created_at, updated_at, last_connection, notes, tags)
+SELECT ?, created_at, ?, last_connection, notes, tags FROM hosts WHERE host = ?`, newHost, time.Now().UnixMilli(), oldHost)
+		if err != nil {
+			return err
+		}
+		if dao.conn.conn.Changes() < 1 {
+			return fmt.Errorf("Host Does not exist %s", oldHost)
+		}
+		err = dao.conn.execute(`UPDATE host_options SET host = ? WHERE host = ?`, newHost, oldHost)
+		if err != nil {
+			return err
+		}
+		err = dao.conn.execute(`UPDATE forward_profiles SET host = ? WHERE host = ?`, newHost, oldHost)
+		if err != nil {
+			return err
+		}
+		err = dao.conn.execute(`UPDATE key_hosts SET host = ? WHERE host = ?`, newHost, oldHost)
+		if err != nil {
+			return err
+		}
+		err = dao.conn.execute(`UPDATE certificates SET host = ? WHERE host = ?`, newHost, oldHost)
+		if err != nil {
+			return err
+		}
+		err = dao.conn.execute(`UPDATE rotations SET host = ? WHERE host = ?`, newHost, oldHost)
+		if err != nil {
+			return err
+		}
+		err = dao.conn.execute(`UPDATE ping_samples SET host = ? WHERE host = ?`, newHost, oldHost)
+		if err != nil {
+			return err
+		}
+		err = dao.conn.execute(`UPDATE host_hooks SET host = ? WHERE host = ?`, newHost, oldHost)
+		if err != nil {
+			return err
+		}
+		err = dao.conn.execute(hostDeleteString, oldHost)
+		if err != nil {
+			return err
+		}
+		for _, host := range dependents {
+			deleteOptString, args := generateDeleteStringOpts(&host)
+			tagsJoined := strings.Join(host.Tags, ",")
+			err = dao.conn.execute(hostUpdateString, ts(&host.CreatedAt), ts(host.UpdatedAt), ts(host.LastConnection), host.Notes, tagsJoined, host.Host)
+			if err != nil {
+				return err
+			}
+			for _, opt := range host.Options {
+				err = dao.conn.execute(hostOptUpdateString, host.Host, opt.Key, opt.Value)
+				if err != nil {
+					return err
+				}
+			}
+			err = dao.conn.execute(deleteOptString, args...)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func (dao *HostDao) serializeHostFromStatement(stmt *sqlite.Stmt, host *Host) error {
 	host.Host = stmt.GetText("host")
 	host.CreatedAt = time.UnixMilli(stmt.GetInt64("created_at"))
@@ -581,3 +652,133 @@ func (dao *HostDao) DeRegisterIdentityKeyFromHost(host, keyPath string) error {
 	err := dao.conn.executeWithResultFunc(removalString, rowsChangedCheck, host, keyPath)
 	return err
 }
+
+// RegisterCertificateForHost adds certPath as a CertificateFile of host unless the host already has it
+func (dao *HostDao) RegisterCertificateForHost(host, certPath string) error {
+	insertString := `INSERT into host_options (host, key, value) SELECT ?, 'CertificateFile', ?
+WHERE NOT EXISTS (SELECT 1 FROM host_options WHERE host = ? AND key = 'CertificateFile' AND value = ?)`
+	return dao.conn.execute(insertString, host, certPath, host, certPath)
+}
+
+// PingStatus is what a ping of a host found
+type PingStatus string
+
+const (
+	PingUp      PingStatus = "up"
+	PingRefused PingStatus = "refused" // the address answered but nothing listens on the port
+	PingNotSSH  PingStatus = "not_ssh" // the port is open but did not answer like an ssh server
+	PingDown    PingStatus = "down"
+)
+
+// PingSample is the result of pinging a host once, kept to show the latency and availability of a host over time
+type PingSample struct {
+	Host    string
+	TakenAt time.Time
+	Status  PingStatus
+	Latency time.Duration // 0 unless the host answered
+}
+
+func (dao *HostDao) InsertPingSample(sample PingSample) error {
+	return dao.conn.execute(`INSERT INTO ping_samples (host, taken_at, status, latency_us) VALUES (?, ?, ?, ?)`,
+		sample.Host, ts(&sample.TakenAt), string(sample.Status), sample.Latency.Microseconds())
+}
+
+// GetPingSamples returns the samples taken since since by host, the oldest first
+func (dao *HostDao) GetPingSamples(since time.Time) (map[string][]PingSample, error) {
+	samples := make(map[string][]PingSample)
+	err := dao.conn.query(`SELECT * FROM ping_samples WHERE taken_at >= ? ORDER BY taken_at, id`, func(stmt *sqlite.Stmt) error {
+		sample := PingSample{
+			Host:    stmt.GetText("host"),
+			TakenAt: time.UnixMilli(stmt.GetInt64("taken_at")),
+			Status:  PingStatus(stmt.GetText("status")),
+			Latency: time.Duration(stmt.GetInt64("latency_us")) * time.Microsecond,
+		}
+		samples[sample.Host] = append(samples[sample.Host], sample)
+		return nil
+	}, ts(&since))
+	if err != nil {
+		return nil, err
+	}
+	return samples, nil
+}
+
+// PrunePingSamples deletes the samples taken before before
+func (dao *HostDao) PrunePingSamples(before time.Time) error {
+	return dao.conn.execute(`DELETE FROM ping_samples WHERE taken_at < ?`, ts(&before))
+}
+
+// HookStage is when a hook of a host runs
+type HookStage string
+
+const (
+	HookPreConnect     HookStage = "pre"  // before ssh is started, a failing hook stops the connection
+	HookPostDisconnect HookStage = "post" // once ssh exited
+)
+
+// HookAction is what a hook does
+type HookAction string
+
+const (
+	HookWakeOnLan HookAction = "wol"  // send a Wake-on-LAN magic packet, Arg is the mac address and an optional broadcast address
+	HookWait      HookAction = "wait" // wait until the host answers, Arg is an optional timeout
+	HookRun       HookAction = "run"  // run a local command, Arg is the command line
+)
+
+// Hook is an action run around the ssh connections to a host, hooks of a stage run in the order they were added
+type Hook struct {
+	ID     int64
+	Host   string
+	Stage  HookStage
+	Action HookAction
+	Arg    string
+}
+
+// InsertHook adds hook to its host and returns its id, errors if the host does not exist
+func (dao *HostDao) InsertHook(hook Hook) (int64, error) {
+	err := dao.conn.execute(`INSERT INTO host_hooks (host, stage, action, arg) VALUES (?, ?, ?, ?)`,
+		hook.Host, string(hook.Stage), string(hook.Action), hook.Arg)
+	if err != nil {
+		return 0, err
+	}
+	return dao.conn.conn.LastInsertRowID(), nil
+}
+
+// DeleteHook removes the hook with id from host
+func (dao *HostDao) DeleteHook(host string, id int64) error {
+	err := dao.conn.execute(`DELETE FROM host_hooks WHERE host = ? AND id = ?`, host, id)
+	if err != nil {
+		return err
+	}
+	if dao.conn.conn.Changes() < 1 {
+		return fmt.Errorf("Hook %d does not exist for host %s", id, host)
+	}
+	return nil
+}
+
+// GetHooks returns the hooks of host in the order they run
+func (dao *HostDao) GetHooks(host string) ([]Hook, error) {
+	return dao.queryHooks(`SELECT * FROM host_hooks WHERE host = ? ORDER BY id`, host)
+}
+
+// GetAllHooks returns every hook ordered by host then the order they run in
+func (dao *HostDao) GetAllHooks() ([]Hook, error) {
+	return dao.queryHooks(`SELECT * FROM host_hooks ORDER BY host, id`)
+}
+
+func (dao *HostDao) queryHooks(query string, args ...any) ([]Hook, error) {
+	hooks := make([]Hook, 0)
+	err := dao.conn.query(query, func(stmt *sqlite.Stmt) error {
+		hooks = append(hooks, Hook{
+			ID:     stmt.GetInt64("id"),
+			Host:   stmt.GetText("host"),
+			Stage:  HookStage(stmt.GetText("stage")),
+			Action: HookAction(stmt.GetText("action")),
+			Arg:    stmt.GetText("arg"),
+		})
+		return nil
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
@@ -319,3 +319,137 @@ func TestDeRegisterIdentityKeyFromHost(t *testing.T) {
 		t.Fatalf("key should have been removed yet still exists")
 	}
 }
+
+func TestRenameHost(t *testing.T) {
+	db := NewHostDao(conn)
+	bastion := Host{
+		Host:      "Rename_Bastion",
+		CreatedAt: time.Now(),
+		Options:   []HostOptions{{Key: "HostName", Value: "bastion.local"}},
+	}
+	target := Host{
+		Host:      "Rename_Target",
+		CreatedAt: time.Now(),
+		Options:   []HostOptions{{Key: "ProxyJump", Value: "Rename_Bastion"}},
+	}
+	if err := db.InsertMany(bastion, target); err != nil {
+		t.Fatalf("Failed to insert hosts for rename test. Error %v", err)
+	}
+	target.Options = []HostOptions{{Key: "ProxyJump", Value: "Renamed_Bastion"}}
+	err := db.RenameHost("Rename_Bastion", "Renamed_Bastion", target)
+	if err != nil {
+		t.Fatalf("Failed to rename host. Error %v", err)
+	}
+	if _, err := db.Get("Rename_Bastion"); err == nil {
+		t.Fatalf("Old host should no longer exist after rename")
+	}
+	renamed, err := db.Get("Renamed_Bastion")
+	if err != nil {
+		t.Fatalf("Failed to get renamed host. Error %v", err)
+	}
+	if len(renamed.Options) != 1 || renamed.Options[0].Value != "bastion.local" {
+		t.Fatalf("Options should follow the host after a rename. Host %v", renamed)
+	}
+	dependent, err := db.Get("Rename_Target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dependent.Options) != 1 || dependent.Options[0].Value != "Renamed_Bastion" {
+		t.Fatalf("Dependent ProxyJump should be updated in the same transaction. Host %v", dependent)
+	}
+	if err := db.RenameHost("Does_Not_Exist", "Still_Missing"); err == nil {
+		t.Fatalf("Renaming a missing host should error")
+	}
+	renamed.Host = "Edited_Bastion"
+	renamed.Options = []HostOptions{{Key: "HostName", Value: "edited.local"}}
+	if err = db.RenameHost("Renamed_Bastion", "Edited_Bastion", renamed); err != nil {
+		t.Fatalf("Failed to rename and edit host. Error %v", err)
+	}
+	if edited, err := db.Get("Edited_Bastion"); err != nil || len(edited.Options) != 1 || edited.Options[0].Value != "edited.local" {
+		t.Fatalf("The renamed host should be editable in the same transaction. Host %v Error %v", edited, err)
+	}
+}
+
+func TestPingSamples(t *testing.T) {
+	db := NewHostDao(conn)
+	host := Host{Host: "Ping_Samples", CreatedAt: time.Now()}
+	if err := db.Insert(host); err != nil {
+		t.Fatalf("Failed to insert host for ping samples. Error %v", err)
+	}
+	now := time.UnixMilli(time.Now().UnixMilli())
+	samples := []PingSample{
+		{Host: host.Host, TakenAt: now.Add(-8 * 24 * time.Hour), Status: PingUp, Latency: time.Millisecond},
+		{Host: host.Host, TakenAt: now.Add(-time.Hour), Status: PingDown},
+		{Host: host.Host, TakenAt: now, Status: PingUp, Latency: 1500 * time.Microsecond},
+	}
+	for _, sample := range samples {
+		if err := db.InsertPingSample(sample); err != nil {
+			t.Fatalf("Failed to insert ping sample. Error %v", err)
+		}
+	}
+	got, err := db.GetPingSamples(now.Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to get ping samples. Error %v", err)
+	}
+	if !slices.Equal(got[host.Host], samples[1:]) {
+		t.Fatalf("expected the samples of the last day oldest first, got %v", got[host.Host])
+	}
+	if err := db.PrunePingSamples(now.Add(-7 * 24 * time.Hour)); err != nil {
+		t.Fatalf("Failed to prune ping samples. Error %v", err)
+	}
+	got, _ = db.GetPingSamples(time.Time{})
+	if len(got[host.Host]) != 2 {
+		t.Fatalf("samples older than a week should be pruned, got %v", got[host.Host])
+	}
+	if err := db.Delete(host); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = db.GetPingSamples(time.Time{}); len(got[host.Host]) != 0 {
+		t.Fatalf("samples should be deleted along with their host, got %v", got[host.Host])
+	}
+}
+
+func TestHooks(t *testing.T) {
+	db := NewHostDao(conn)
+	host := Host{Host: "Hook_Host", CreatedAt: time.Now()}
+	if err := db.Insert(host); err != nil {
+		t.Fatalf("Failed to insert host for hooks. Error %v", err)
+	}
+	hooks := []Hook{
+		{Host: host.Host, Stage: HookPreConnect, Action: HookWakeOnLan, Arg: "aa:bb:cc:dd:ee:ff"},
+		{Host: host.Host, Stage: HookPreConnect, Action: HookWait, Arg: "2m"},
+		{Host: host.Host, Stage: HookPostDisconnect, Action: HookRun, Arg: "echo %n"},
+	}
+	for i := range hooks {
+		id, err := db.InsertHook(hooks[i])
+		if err != nil {
+			t.Fatalf("Failed to insert hook. Error %v", err)
+		}
+		hooks[i].ID = id
+	}
+	if _, err := db.InsertHook(Hook{Host: "Hook_Missing", Stage: HookPreConnect, Action: HookWait}); err == nil {
+		t.Fatalf("hooks of a host that does not exist should be rejected")
+	}
+	got, err := db.GetHooks(host.Host)
+	if err != nil || !slices.Equal(got, hooks) {
+		t.Fatalf("expected the hooks in the order they were added, got %v %v", got, err)
+	}
+	if err = db.DeleteHook(host.Host, hooks[1].ID); err != nil {
+		t.Fatalf("Failed to delete hook. Error %v", err)
+	}
+	if err = db.DeleteHook(host.Host, hooks[1].ID); err == nil {
+		t.Fatalf("deleting a hook twice should fail")
+	}
+	if err = db.RenameHost(host.Host, "Hook_Renamed"); err != nil {
+		t.Fatalf("Failed to rename host. Error %v", err)
+	}
+	if got, _ = db.GetHooks("Hook_Renamed"); len(got) != 2 || got[0].Host != "Hook_Renamed" {
+		t.Fatalf("hooks should follow a renamed host, got %v", got)
+	}
+	if err = db.Delete(Host{Host: "Hook_Renamed"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ = db.GetAllHooks(); slices.ContainsFunc(got, func(h Hook) bool { return h.Host == "Hook_Renamed" }) {
+		t.Fatalf("hooks should be deleted along with their host, got %v", got)
+	}
+}
@@ -0,0 +1,326 @@
+package sqlite
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"zombiezen.com/go/sqlite"
+)
+
+// Key is an ssh key known to sshman, either generated into the key store or referenced by a host's IdentityFile
+type Key struct {
+	Path          string
+	Algorithm     string // ssh key type, ie ssh-ed25519, empty if the key could not be read
+	Bits          int
+	Fingerprint   string // SHA256 fingerprint as printed by ssh-keygen -l
+	Comment       string
+	CreatedAt     time.Time
+	HasPassphrase bool
+	Hosts         []string // hosts whose IdentityFile resolves to the key
+}
+
+// Certificate is an ssh user certificate signed by sshman for a key of a host
+type Certificate struct {
+	Path          string // the -cert.pub file next to the key
+	KeyPath       string
+	Host          string
+	Serial        uint64
+	KeyID         string
+	Principals    []string
+	CAFingerprint string // SHA256 fingerprint of the signing CA key
+	ValidAfter    time.Time
+	ValidBefore   time.Time
+	CreatedAt     time.Time
+}
+
+// RotationStatus is how far a step of a key rotation got
+type RotationStatus string
+
+const (
+	RotationPending  RotationStatus = "pending"
+	RotationDone     RotationStatus = "done"
+	RotationFailed   RotationStatus = "failed"
+	RotationCanceled RotationStatus = "canceled" // the user stopped the rotation at this step
+	RotationSkipped  RotationStatus = "skipped"  // the step does not apply, ie there was no old key to remove
+)
+
+// Rotation is a ledger entry of replacing the key of a host, one is kept for every rotation started
+type Rotation struct {
+	ID         int64
+	Host       string
+	OldKey     string // expanded path of the replaced key, empty if the new key was only added
+	OldKeyOpt  string // IdentityFile value the old key is registered under
+	NewKey     string
+	NewPubKey  string
+	Copy       RotationStatus
+	Verify     RotationStatus
+	Remove     RotationStatus
+	RolledBack bool   // the old IdentityFile registration was restored
+	Error      string // error of the last failed step
+	StartedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// Finished reports whether nothing is left to do for the rotation, the new key logged in and the old key is gone
+// or there was none, or the rotation was rolled back
+func (r Rotation) Finished() bool {
+	return r.RolledBack || r.Verify == RotationDone && (r.Remove == RotationDone || r.Remove == RotationSkipped)
+}
+
+type KeyDao struct {
+	conn *Connection
+}
+
+const (
+	keyUpsertString = `INSERT INTO keys (path, algorithm, bits, fingerprint, comment, created_at, has_passphrase) VALUES (?,?,?,?,?,?,?)
+ON CONFLICT(path) DO UPDATE SET algorithm = excluded.algorithm, bits = excluded.bits, fingerprint = excluded.fingerprint,
+comment = excluded.comment, has_passphrase = excluded.has_passphrase`
+	keyDeleteString      = `DELETE FROM keys WHERE path = ?`
+	keyHostsDeleteString = `DELETE FROM key_hosts`
+	keyHostsInsertString = `INSERT OR IGNORE INTO key_hosts (path, host) SELECT ?, ? WHERE EXISTS (SELECT 1 FROM keys WHERE path = ?)`
+	certUpsertString     = `INSERT INTO certificates (path, key_path, host, serial, key_id, principals, ca_fingerprint, valid_after, valid_before, created_at)
+VALUES (?,?,?,?,?,?,?,?,?,?) ON CONFLICT(path) DO UPDATE SET key_path = excluded.key_path, host = excluded.host, serial = excluded.serial,
+key_id = excluded.key_id, principals = excluded.principals, ca_fingerprint = excluded.ca_fingerprint, valid_after = excluded.valid_after,
+valid_before = excluded.valid_before`
+	certDeleteString     = `DELETE FROM certificates WHERE path = ?`
+	rotationInsertString = `INSERT INTO rotations (host, old_key, old_key_opt, new_key, new_pub_key, copy_status, verify_status, remove_status,
+rolled_back, error, started_at, updated_at) VALUES (?,?,?,?,?,?,?,?,?,?,?,?)`
+	rotationUpdateString = `UPDATE rotations SET copy_status = ?, verify_status = ?, remove_status = ?, rolled_back = ?, error = ?, updated_at = ?
+WHERE id = ?`
+)
+
+func NewKeyDao(conn *Connection) *KeyDao {
+	if conn == nil {
+		return nil
+	}
+	return &KeyDao{conn: conn}
+}
+
+// Upsert stores key, an existing key keeps its creation date. Hosts of key are not touched, see SetUsage
+func (dao *KeyDao) Upsert(key Key) error {
+	return dao.conn.execute(keyUpsertString, key.Path, key.Algorithm, key.Bits, key.Fingerprint, key.Comment, ts(&key.CreatedAt), key.HasPassphrase)
+}
+
+// Delete removes the key from the inventory, the file itself is left alone
+func (dao *KeyDao) Delete(path string) error {
+	err := dao.conn.execute(keyDeleteString, path)
+	if err != nil {
+		return err
+	}
+	if dao.conn.conn.Changes() < 1 {
+		return fmt.Errorf("Key does not exist %s", path)
+	}
+	return nil
+}
+
+// SetUsage replaces which hosts use which key, usage maps key paths to host aliases.
+// Paths that are not in the inventory are ignored
+func (dao *KeyDao) SetUsage(usage map[string][]string) error {
+	return dao.conn.transaction(func() error {
+		err := dao.conn.execute(keyHostsDeleteString)
+		if err != nil {
+			return err
+		}
+		for path, hosts := range usage {
+			for _, host := range hosts {
+				err = dao.conn.execute(keyHostsInsertString, path, host, path)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (dao *KeyDao) Get(path string) (Key, error) {
+	keys, err := dao.queryKeys(`SELECT * FROM keys WHERE path = ?`, path)
+	if err != nil {
+		return Key{}, err
+	}
+	if len(keys) == 0 {
+		return Key{}, fmt.Errorf("Key does not exist %s", path)
+	}
+	return keys[0], nil
+}
+
+// GetAll returns every key ordered by path along with the hosts using it
+func (dao *KeyDao) GetAll() ([]Key, error) {
+	return dao.queryKeys(`SELECT * FROM keys ORDER BY path`)
+}
+
+func (dao *KeyDao) queryKeys(query string, args ...any) ([]Key, error) {
+	keys := make([]Key, 0)
+	err := dao.conn.query(query, func(stmt *sqlite.Stmt) error {
+		keys = append(keys, Key{
+			Path:          stmt.GetText("path"),
+			Algorithm:     stmt.GetText("algorithm"),
+			Bits:          int(stmt.GetInt64("bits")),
+			Fingerprint:   stmt.GetText("fingerprint"),
+			Comment:       stmt.GetText("comment"),
+			CreatedAt:     time.UnixMilli(stmt.GetInt64("created_at")),
+			HasPassphrase: stmt.GetBool("has_passphrase"),
+		})
+		return nil
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		err = dao.conn.query(`SELECT host FROM key_hosts WHERE path = ? ORDER BY host`, func(stmt *sqlite.Stmt) error {
+			keys[i].Hosts = append(keys[i].Hosts, stmt.GetText("host"))
+			return nil
+		}, keys[i].Path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// UpsertCertificate stores cert, a renewed certificate replaces the previous one at the same path but keeps its creation date
+func (dao *KeyDao) UpsertCertificate(cert Certificate) error {
+	return dao.conn.execute(certUpsertString, cert.Path, cert.KeyPath, cert.Host, strconv.FormatUint(cert.Serial, 10), cert.KeyID,
+		strings.Join(cert.Principals, ","), cert.CAFingerprint, ts(&cert.ValidAfter), ts(&cert.ValidBefore), ts(&cert.CreatedAt))
+}
+
+// DeleteCertificate removes the certificate from the inventory, the file itself is left alone
+func (dao *KeyDao) DeleteCertificate(path string) error {
+	err := dao.conn.execute(certDeleteString, path)
+	if err != nil {
+		return err
+	}
+	if dao.conn.conn.Changes() < 1 {
+		return fmt.Errorf("Certificate does not exist %s", path)
+	}
+	return nil
+}
+
+func (dao *KeyDao) GetCertificate(path string) (Certificate, error) {
+	certs, err := dao.queryCertificates(`SELECT * FROM certificates WHERE path = ?`, path)
+	if err != nil {
+		return Certificate{}, err
+	}
+	if len(certs) == 0 {
+		return Certificate{}, fmt.Errorf("Certificate does not exist %s", path)
+	}
+	return certs[0], nil
+}
+
+// GetCertificates returns every certificate ordered by host and expiry
+func (dao *KeyDao) GetCertificates() ([]Certificate, error) {
+	return dao.queryCertificates(`SELECT * FROM certificates ORDER BY host, valid_before`)
+}
+
+func (dao *KeyDao) queryCertificates(query string, args ...any) ([]Certificate, error) {
+	certs := make([]Certificate, 0)
+	err := dao.conn.query(query, func(stmt *sqlite.Stmt) error {
+		serial, err := strconv.ParseUint(stmt.GetText("serial"), 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid serial for certificate %s: %w", stmt.GetText("path"), err)
+		}
+		principals := make([]string, 0)
+		if joined := stmt.GetText("principals"); joined != "" {
+			principals = strings.Split(joined, ",")
+		}
+		certs = append(certs, Certificate{
+			Path:          stmt.GetText("path"),
+			KeyPath:       stmt.GetText("key_path"),
+			Host:          stmt.GetText("host"),
+			Serial:        serial,
+			KeyID:         stmt.GetText("key_id"),
+			Principals:    principals,
+			CAFingerprint: stmt.GetText("ca_fingerprint"),
+			ValidAfter:    time.UnixMilli(stmt.GetInt64("valid_after")),
+			ValidBefore:   time.UnixMilli(stmt.GetInt64("valid_before")),
+			CreatedAt:     time.UnixMilli(stmt.GetInt64("created_at")),
+		})
+		return nil
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+	return certs, nil
+}
+
+// InsertRotation records a new rotation and returns its id, steps without a status are stored as pending
+func (dao *KeyDao) InsertRotation(rotation Rotation) (int64, error) {
+	for _, status := range []*RotationStatus{&rotation.Copy, &rotation.Verify, &rotation.Remove} {
+		if *status == "" {
+			*status = RotationPending
+		}
+	}
+	if rotation.UpdatedAt.IsZero() {
+		rotation.UpdatedAt = rotation.StartedAt
+	}
+	err := dao.conn.execute(rotationInsertString, rotation.Host, rotation.OldKey, rotation.OldKeyOpt, rotation.NewKey, rotation.NewPubKey,
+		string(rotation.Copy), string(rotation.Verify), string(rotation.Remove), rotation.RolledBack, rotation.Error,
+		ts(&rotation.StartedAt), ts(&rotation.UpdatedAt))
+	if err != nil {
+		return 0, err
+	}
+	return dao.conn.conn.LastInsertRowID(), nil
+}
+
+// UpdateRotation stores the step statuses, rollback and error of rotation, the keys and host are fixed once inserted
+func (dao *KeyDao) UpdateRotation(rotation Rotation) error {
+	err := dao.conn.execute(rotationUpdateString, string(rotation.Copy), string(rotation.Verify), string(rotation.Remove),
+		rotation.RolledBack, rotation.Error, ts(&rotation.UpdatedAt), rotation.ID)
+	if err != nil {
+		return err
+	}
+	if dao.conn.conn.Changes() < 1 {
+		return fmt.Errorf("Rotation does not exist %d", rotation.ID)
+	}
+	return nil
+}
+
+func (dao *KeyDao) GetRotation(id int64) (Rotation, error) {
+	rotations, err := dao.queryRotations(`SELECT * FROM rotations WHERE id = ?`, id)
+	if err != nil {
+		return Rotation{}, err
+	}
+	if len(rotations) == 0 {
+		return Rotation{}, fmt.Errorf("Rotation does not exist %d", id)
+	}
+	return rotations[0], nil
+}
+
+// GetRotations returns every rotation, the most recently started first
+func (dao *KeyDao) GetRotations() ([]Rotation, error) {
+	return dao.queryRotations(`SELECT * FROM rotations ORDER BY started_at DESC, id DESC`)
+}
+
+// GetUnfinishedRotations returns the rotations that are not Finished, the most recently started first
+func (dao *KeyDao) GetUnfinishedRotations() ([]Rotation, error) {
+	return dao.queryRotations(`SELECT * FROM rotations WHERE rolled_back = 0 AND NOT (verify_status = ? AND remove_status IN (?, ?))
+ORDER BY started_at DESC, id DESC`, string(RotationDone), string(RotationDone), string(RotationSkipped))
+}
+
+func (dao *KeyDao) queryRotations(query string, args ...any) ([]Rotation, error) {
+	rotations := make([]Rotation, 0)
+	err := dao.conn.query(query, func(stmt *sqlite.Stmt) error {
+		rotations = append(rotations, Rotation{
+			ID:         stmt.GetInt64("id"),
+			Host:       stmt.GetText("host"),
+			OldKey:     stmt.GetText("old_key"),
+			OldKeyOpt:  stmt.GetText("old_key_opt"),
+			NewKey:     stmt.GetText("new_key"),
+			NewPubKey:  stmt.GetText("new_pub_key"),
+			Copy:       RotationStatus(stmt.GetText("copy_status")),
+			Verify:     RotationStatus(stmt.GetText("verify_status")),
+			Remove:     RotationStatus(stmt.GetText("remove_status")),
+			RolledBack: stmt.GetBool("rolled_back"),
+			Error:      stmt.GetText("error"),
+			StartedAt:  time.UnixMilli(stmt.GetInt64("started_at")),
+			UpdatedAt:  time.UnixMilli(stmt.GetInt64("updated_at")),
+		})
+		return nil
+	}, args...)
+	if err != nil {
+		return nil, err
+	}
+	return rotations, nil
+}
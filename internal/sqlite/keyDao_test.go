@@ -0,0 +1,197 @@
+package sqlite
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestKeyInventory(t *testing.T) {
+	hostDao := NewHostDao(conn)
+	dao := NewKeyDao(conn)
+	for _, alias := range []string{"key-host-a", "key-host-b"} {
+		if err := hostDao.Insert(Host{Host: alias, CreatedAt: time.Now()}); err != nil {
+			t.Fatalf("failed to insert host: %v", err)
+		}
+	}
+	created := time.UnixMilli(time.Now().UnixMilli())
+	key := Key{
+		Path:        "/keys/ed25519_web",
+		Algorithm:   "ssh-ed25519",
+		Bits:        256,
+		Fingerprint: "SHA256:abc",
+		Comment:     "sshman:web",
+		CreatedAt:   created,
+	}
+	if err := dao.Upsert(key); err != nil {
+		t.Fatalf("failed to insert key: %v", err)
+	}
+	key.HasPassphrase = true
+	key.CreatedAt = created.Add(time.Hour)
+	if err := dao.Upsert(key); err != nil {
+		t.Fatalf("failed to update key: %v", err)
+	}
+	got, err := dao.Get(key.Path)
+	if err != nil {
+		t.Fatalf("failed to get key: %v", err)
+	}
+	if !got.HasPassphrase || !got.CreatedAt.Equal(created) {
+		t.Fatalf("upsert should update the key but keep its creation date, got %+v", got)
+	}
+
+	err = dao.SetUsage(map[string][]string{
+		key.Path:        {"key-host-b", "key-host-a"},
+		"/keys/unknown": {"key-host-a"},
+	})
+	if err != nil {
+		t.Fatalf("failed to set key usage: %v", err)
+	}
+	if got, _ = dao.Get(key.Path); !slices.Equal(got.Hosts, []string{"key-host-a", "key-host-b"}) {
+		t.Fatalf("expected both hosts to use the key, got %v", got.Hosts)
+	}
+	if err = hostDao.RenameHost("key-host-a", "key-host-c"); err != nil {
+		t.Fatalf("failed to rename host: %v", err)
+	}
+	if got, _ = dao.Get(key.Path); !slices.Equal(got.Hosts, []string{"key-host-b", "key-host-c"}) {
+		t.Fatalf("key usage should follow a renamed host, got %v", got.Hosts)
+	}
+	if err = hostDao.Delete(Host{Host: "key-host-b"}); err != nil {
+		t.Fatalf("failed to delete host: %v", err)
+	}
+	if got, _ = dao.Get(key.Path); !slices.Equal(got.Hosts, []string{"key-host-c"}) {
+		t.Fatalf("deleted hosts should no longer use the key, got %v", got.Hosts)
+	}
+
+	if err = dao.Delete(key.Path); err != nil {
+		t.Fatalf("failed to delete key: %v", err)
+	}
+	if err = dao.Delete(key.Path); err == nil {
+		t.Fatalf("deleting a missing key should fail")
+	}
+	if _, err = dao.Get(key.Path); err == nil {
+		t.Fatalf("getting a deleted key should fail")
+	}
+}
+
+func TestCertificates(t *testing.T) {
+	hostDao := NewHostDao(conn)
+	dao := NewKeyDao(conn)
+	if err := hostDao.Insert(Host{Host: "cert-host-a", CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("failed to insert host: %v", err)
+	}
+	now := time.UnixMilli(time.Now().UnixMilli())
+	cert := Certificate{
+		Path:          "/keys/web-cert.pub",
+		KeyPath:       "/keys/web",
+		Host:          "cert-host-a",
+		Serial:        1<<63 + 5, // serials use the full uint64 range
+		KeyID:         "sshman:cert-host-a",
+		Principals:    []string{"deploy", "root"},
+		CAFingerprint: "SHA256:ca",
+		ValidAfter:    now,
+		ValidBefore:   now.Add(24 * time.Hour),
+		CreatedAt:     now,
+	}
+	if err := dao.UpsertCertificate(cert); err != nil {
+		t.Fatalf("failed to insert certificate: %v", err)
+	}
+	renewed := cert
+	renewed.Serial = 7
+	renewed.ValidBefore = now.Add(48 * time.Hour)
+	renewed.CreatedAt = now.Add(time.Hour)
+	if err := dao.UpsertCertificate(renewed); err != nil {
+		t.Fatalf("failed to renew certificate: %v", err)
+	}
+	got, err := dao.GetCertificate(cert.Path)
+	if err != nil {
+		t.Fatalf("failed to get certificate: %v", err)
+	}
+	if got.Serial != 7 || !got.ValidBefore.Equal(renewed.ValidBefore) || !got.CreatedAt.Equal(now) ||
+		!slices.Equal(got.Principals, cert.Principals) {
+		t.Fatalf("renewal should replace the certificate but keep its creation date, got %+v", got)
+	}
+	if err = hostDao.RegisterCertificateForHost("cert-host-a", cert.Path); err != nil {
+		t.Fatalf("failed to register certificate: %v", err)
+	}
+	if err = hostDao.RegisterCertificateForHost("cert-host-a", cert.Path); err != nil {
+		t.Fatalf("registering a certificate twice should be a no-op: %v", err)
+	}
+	if count, _ := hostDao.CountOpts("cert-host-a"); count != 1 {
+		t.Fatalf("expected a single CertificateFile option, got %d", count)
+	}
+	if err = hostDao.RenameHost("cert-host-a", "cert-host-b"); err != nil {
+		t.Fatalf("failed to rename host: %v", err)
+	}
+	if got, _ = dao.GetCertificate(cert.Path); got.Host != "cert-host-b" {
+		t.Fatalf("certificate should follow a renamed host, got %s", got.Host)
+	}
+	if err = hostDao.Delete(Host{Host: "cert-host-b"}); err != nil {
+		t.Fatalf("failed to delete host: %v", err)
+	}
+	if certs, _ := dao.GetCertificates(); slices.ContainsFunc(certs, func(c Certificate) bool { return c.Path == cert.Path }) {
+		t.Fatalf("certificates of deleted hosts should be removed")
+	}
+	if err = dao.DeleteCertificate(cert.Path); err == nil {
+		t.Fatalf("deleting a missing certificate should fail")
+	}
+}
+
+func TestRotations(t *testing.T) {
+	hostDao := NewHostDao(conn)
+	dao := NewKeyDao(conn)
+	if err := hostDao.Insert(Host{Host: "rotate-host-a", CreatedAt: time.Now()}); err != nil {
+		t.Fatalf("failed to insert host: %v", err)
+	}
+	now := time.UnixMilli(time.Now().UnixMilli())
+	id, err := dao.InsertRotation(Rotation{
+		Host:      "rotate-host-a",
+		OldKey:    "/keys/old",
+		OldKeyOpt: "~/keys/old",
+		NewKey:    "/keys/new",
+		NewPubKey: "/keys/new.pub",
+		StartedAt: now,
+	})
+	if err != nil {
+		t.Fatalf("failed to insert rotation: %v", err)
+	}
+	got, err := dao.GetRotation(id)
+	if err != nil {
+		t.Fatalf("failed to get rotation: %v", err)
+	}
+	if got.Copy != RotationPending || got.Verify != RotationPending || got.Remove != RotationPending ||
+		got.OldKeyOpt != "~/keys/old" || !got.StartedAt.Equal(now) {
+		t.Fatalf("expected a pending rotation, got %+v", got)
+	}
+	got.Copy, got.Verify = RotationDone, RotationDone
+	got.Remove = RotationFailed
+	got.Error = "permission denied"
+	if err = dao.UpdateRotation(got); err != nil {
+		t.Fatalf("failed to update rotation: %v", err)
+	}
+	unfinished, err := dao.GetUnfinishedRotations()
+	if err != nil || !slices.ContainsFunc(unfinished, func(r Rotation) bool { return r.ID == id && r.Error == "permission denied" }) {
+		t.Fatalf("a rotation that failed to remove the old key should be unfinished, got %+v %v", unfinished, err)
+	}
+	got.Remove = RotationDone
+	if err = dao.UpdateRotation(got); err != nil {
+		t.Fatalf("failed to update rotation: %v", err)
+	}
+	if unfinished, _ = dao.GetUnfinishedRotations(); slices.ContainsFunc(unfinished, func(r Rotation) bool { return r.ID == id }) {
+		t.Fatalf("a verified rotation with the old key removed should be finished")
+	}
+	if err = hostDao.RenameHost("rotate-host-a", "rotate-host-b"); err != nil {
+		t.Fatalf("failed to rename host: %v", err)
+	}
+	if got, _ = dao.GetRotation(id); got.Host != "rotate-host-b" {
+		t.Fatalf("rotation should follow a renamed host, got %s", got.Host)
+	}
+	if err = hostDao.Delete(Host{Host: "rotate-host-b"}); err != nil {
+		t.Fatalf("failed to delete host: %v", err)
+	}
+	if _, err = dao.GetRotation(id); err == nil {
+		t.Fatalf("rotations of deleted hosts should be removed")
+	}
+	if err = dao.UpdateRotation(got); err == nil {
+		t.Fatalf("updating a missing rotation should fail")
+	}
+}
@@ -75,7 +75,91 @@ func (conn *Connection) createTable() error {
 	ON host_options(host);
 
     CREATE INDEX IF NOT EXISTS idx_host_options_key
-    ON host_options(key)
+    ON host_options(key);
+
+	CREATE TABLE IF NOT EXISTS forward_profiles(
+		host TEXT NOT NULL REFERENCES hosts(host) ON DELETE CASCADE,
+		name TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		PRIMARY KEY(host, name)
+	);
+
+	CREATE TABLE IF NOT EXISTS forward_profile_entries(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		host TEXT NOT NULL,
+		name TEXT NOT NULL,
+		type TEXT NOT NULL,
+		spec TEXT NOT NULL,
+		FOREIGN KEY(host, name) REFERENCES forward_profiles(host, name) ON DELETE CASCADE ON UPDATE CASCADE,
+		UNIQUE(host, name, type, spec)
+	);
+
+	CREATE TABLE IF NOT EXISTS keys(
+		path TEXT NOT NULL PRIMARY KEY,
+		algorithm TEXT NOT NULL,
+		bits INTEGER NOT NULL,
+		fingerprint TEXT NOT NULL,
+		comment TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		has_passphrase INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS key_hosts(
+		path TEXT NOT NULL REFERENCES keys(path) ON DELETE CASCADE,
+		host TEXT NOT NULL REFERENCES hosts(host) ON DELETE CASCADE,
+		PRIMARY KEY(path, host)
+	);
+
+	CREATE TABLE IF NOT EXISTS certificates(
+		path TEXT NOT NULL PRIMARY KEY,
+		key_path TEXT NOT NULL,
+		host TEXT NOT NULL REFERENCES hosts(host) ON DELETE CASCADE,
+		serial TEXT NOT NULL,
+		key_id TEXT NOT NULL,
+		principals TEXT NOT NULL,
+		ca_fingerprint TEXT NOT NULL,
+		valid_after INTEGER NOT NULL,
+		valid_before INTEGER NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS rotations(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		host TEXT NOT NULL REFERENCES hosts(host) ON DELETE CASCADE,
+		old_key TEXT NOT NULL,
+		old_key_opt TEXT NOT NULL,
+		new_key TEXT NOT NULL,
+		new_pub_key TEXT NOT NULL,
+		copy_status TEXT NOT NULL,
+		verify_status TEXT NOT NULL,
+		remove_status TEXT NOT NULL,
+		rolled_back INTEGER NOT NULL,
+		error TEXT NOT NULL,
+		started_at INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS ping_samples(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		host TEXT NOT NULL REFERENCES hosts(host) ON DELETE CASCADE,
+		taken_at INTEGER NOT NULL,
+		status TEXT NOT NULL,
+		latency_us INTEGER NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_ping_samples_taken_at
+	ON ping_samples(taken_at);
+
+	CREATE TABLE IF NOT EXISTS host_hooks(
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		host TEXT NOT NULL REFERENCES hosts(host) ON DELETE CASCADE,
+		stage TEXT NOT NULL,
+		action TEXT NOT NULL,
+		arg TEXT NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_host_hooks_host
+	ON host_hooks(host);
 	`
 	err := sqlitex.ExecScript(sqlCon, createTableString)
 	if err != nil {
@@ -0,0 +1,279 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/sqlite"
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// ErrNoAgent is returned when SSH_AUTH_SOCK is not set
+var ErrNoAgent = errors.New("no ssh agent running, SSH_AUTH_SOCK is not set")
+
+// AgentConn is a connection to the running ssh agent
+type AgentConn struct {
+	agent.ExtendedAgent
+	conn net.Conn
+}
+
+// DialAgent connects to the agent listening on SSH_AUTH_SOCK
+func DialAgent() (*AgentConn, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, ErrNoAgent
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to ssh agent: %w", err)
+	}
+	return &AgentConn{ExtendedAgent: agent.NewClient(conn), conn: conn}, nil
+}
+
+func (a *AgentConn) Close() error {
+	return a.conn.Close()
+}
+
+// AgentKey is a key loaded in the agent along with the managed key and hosts it unlocks
+type AgentKey struct {
+	Fingerprint string
+	Type        string
+	Comment     string
+	Certificate bool     // the agent holds a certificate for the key
+	Path        string   // managed key with the same fingerprint, empty if the key is not used by any host
+	Hosts       []string // hosts with an IdentityFile resolving to Path
+}
+
+// HostIdentityFiles returns the expanded IdentityFile paths of host in the order they are given
+func HostIdentityFiles(host sqlite.Host) []string {
+	paths := make([]string, 0)
+	tokens := NewTokenContext(host)
+	for _, opt := range host.Options {
+		if !strings.EqualFold(opt.Key, "IdentityFile") {
+			continue
+		}
+		path, err := ExpandOptionValue(opt.Key, opt.Value, tokens)
+		if err != nil {
+			continue
+		}
+		paths = append(paths, filepath.Clean(path))
+	}
+	return paths
+}
+
+// keyFingerprint returns the SHA256 fingerprint of the key at path, see AuthorizedKeyEntry
+func keyFingerprint(path string) (string, error) {
+	entry, err := AuthorizedKeyEntry(path)
+	if err != nil {
+		return "", err
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(entry))
+	if err != nil {
+		return "", err
+	}
+	return ssh.FingerprintSHA256(pub), nil
+}
+
+// ListAgentKeys lists the keys loaded in ag and matches them to the managed keys of hosts by fingerprint.
+// A key loaded both plain and with its certificate is listed once
+func ListAgentKeys(ag agent.Agent, hosts []sqlite.Host) ([]AgentKey, error) {
+	loaded, err := ag.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list agent keys: %w", err)
+	}
+	type managedKey struct {
+		path  string
+		hosts []string
+	}
+	managed := make(map[string]*managedKey)
+	fingerprints := make(map[string]string) // path -> fingerprint, keys that can not be read are skipped
+	for _, host := range hosts {
+		for _, path := range HostIdentityFiles(host) {
+			fp, ok := fingerprints[path]
+			if !ok {
+				fp, _ = keyFingerprint(path)
+				fingerprints[path] = fp
+			}
+			if fp == "" {
+				continue
+			}
+			if managed[fp] == nil {
+				managed[fp] = &managedKey{path: path}
+			}
+			if !slices.Contains(managed[fp].hosts, host.Host) {
+				managed[fp].hosts = append(managed[fp].hosts, host.Host)
+			}
+		}
+	}
+	keys := make([]AgentKey, 0, len(loaded))
+	index := make(map[string]int)
+	for _, l := range loaded {
+		pub, err := ssh.ParsePublicKey(l.Blob)
+		if err != nil {
+			continue
+		}
+		underlying, isCert := pub, false
+		if cert, ok := pub.(*ssh.Certificate); ok {
+			underlying, isCert = cert.Key, true
+		}
+		fp := ssh.FingerprintSHA256(underlying)
+		if i, ok := index[fp]; ok {
+			keys[i].Certificate = keys[i].Certificate || isCert
+			continue
+		}
+		key := AgentKey{Fingerprint: fp, Type: underlying.Type(), Comment: l.Comment, Certificate: isCert}
+		if m, ok := managed[fp]; ok {
+			key.Path = m.path
+			key.Hosts = m.hosts
+		}
+		index[fp] = len(keys)
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// MissingAgentKeys returns the IdentityFile paths of host that are not loaded in ag
+func MissingAgentKeys(ag agent.Agent, host sqlite.Host) ([]string, error) {
+	loaded, err := ag.List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list agent keys: %w", err)
+	}
+	present := make(map[string]struct{})
+	for _, l := range loaded {
+		pub, err := ssh.ParsePublicKey(l.Blob)
+		if err != nil {
+			continue
+		}
+		if cert, ok := pub.(*ssh.Certificate); ok {
+			pub = cert.Key
+		}
+		present[ssh.FingerprintSHA256(pub)] = struct{}{}
+	}
+	missing := make([]string, 0)
+	for _, path := range HostIdentityFiles(host) {
+		fp, err := keyFingerprint(path)
+		if err != nil {
+			continue // missing keys are reported by the inventory and lint
+		}
+		if _, ok := present[fp]; !ok && !slices.Contains(missing, path) {
+			missing = append(missing, path)
+		}
+	}
+	return missing, nil
+}
+
+// KeyNeedsPassphrase reports whether the private key at path is encrypted
+func KeyNeedsPassphrase(path string) bool {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	_, err = ssh.ParseRawPrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	return errors.As(err, &missing)
+}
+
+// AddKeyToAgent loads the private key at path into ag, its certificate is loaded along with it when one is
+// next to the key. A lifetime of 0 keeps the key until the agent exits or it is removed, lifetimes above
+// math.MaxUint32 seconds are clamped to it
+func AddKeyToAgent(ag agent.Agent, path, passphrase string, lifetime time.Duration) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var raw any
+	if passphrase == "" {
+		raw, err = ssh.ParseRawPrivateKey(data)
+	} else {
+		raw, err = ssh.ParseRawPrivateKeyWithPassphrase(data, []byte(passphrase))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if key, ok := raw.(*ed25519.PrivateKey); ok {
+		raw = *key
+	}
+	// the agent protocol carries the lifetime in 32 bits, longer lifetimes are clamped instead of wrapping
+	seconds := min(uint64(max(lifetime, 0)/time.Second), math.MaxUint32)
+	added := agent.AddedKey{PrivateKey: raw, Comment: path, LifetimeSecs: uint32(seconds)}
+	if err = ag.Add(added); err != nil {
+		return fmt.Errorf("failed to add %s to the agent: %w", path, err)
+	}
+	signer, err := ssh.NewSignerFromKey(raw)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if cert, ok := loadCertificateFor(path); ok && bytes.Equal(cert.Key.Marshal(), signer.PublicKey().Marshal()) {
+		added.Certificate = cert
+		if err = ag.Add(added); err != nil {
+			return fmt.Errorf("failed to add the certificate of %s to the agent: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// loadCertificateFor returns the unexpired certificate next to the key at path
+func loadCertificateFor(path string) (*ssh.Certificate, bool) {
+	data, err := os.ReadFile(CertificatePath(path))
+	if err != nil {
+		return nil, false
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, false
+	}
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok || cert.ValidBefore != ssh.CertTimeInfinity && int64(cert.ValidBefore) < time.Now().Unix() {
+		return nil, false
+	}
+	return cert, true
+}
+
+// RemoveKeyFromAgent unloads the key at path from ag
+func RemoveKeyFromAgent(ag agent.Agent, path string) error {
+	fp, err := keyFingerprint(path)
+	if err != nil {
+		return err
+	}
+	return RemoveAgentKey(ag, fp)
+}
+
+// RemoveAgentKey unloads every identity of ag with the fingerprint, certificates of the key included
+func RemoveAgentKey(ag agent.Agent, fingerprint string) error {
+	loaded, err := ag.List()
+	if err != nil {
+		return fmt.Errorf("failed to list agent keys: %w", err)
+	}
+	removed := false
+	for _, l := range loaded {
+		pub, err := ssh.ParsePublicKey(l.Blob)
+		if err != nil {
+			continue
+		}
+		underlying := pub
+		if cert, ok := pub.(*ssh.Certificate); ok {
+			underlying = cert.Key
+		}
+		if ssh.FingerprintSHA256(underlying) != fingerprint {
+			continue
+		}
+		if err = ag.Remove(pub); err != nil {
+			return fmt.Errorf("failed to remove %s from the agent: %w", fingerprint, err)
+		}
+		removed = true
+	}
+	if !removed {
+		return fmt.Errorf("%s is not loaded in the agent", fingerprint)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/sqlite"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestAgentKeys(t *testing.T) {
+	dir := t.TempDir()
+	shared := filepath.Join(dir, "shared")
+	sharedPub := writeTestKey(t, shared, "shared", "")
+	locked := filepath.Join(dir, "locked")
+	writeTestKey(t, locked, "locked", "secret")
+	hosts := []sqlite.Host{
+		{Host: "a", Options: []sqlite.HostOptions{{Key: "IdentityFile", Value: shared}}},
+		{Host: "b", Options: []sqlite.HostOptions{{Key: "IdentityFile", Value: shared}, {Key: "identityfile", Value: locked}}},
+	}
+	ag := agent.NewKeyring()
+	if err := AddKeyToAgent(ag, shared, "", time.Hour); err != nil {
+		t.Fatalf("failed to add key: %v", err)
+	}
+	keys, err := ListAgentKeys(ag, hosts)
+	if err != nil {
+		t.Fatalf("failed to list agent keys: %v", err)
+	}
+	if len(keys) != 1 || keys[0].Path != shared || !slices.Equal(keys[0].Hosts, []string{"a", "b"}) ||
+		keys[0].Fingerprint != ssh.FingerprintSHA256(sharedPub) {
+		t.Fatalf("expected the shared key to unlock a and b, got %+v", keys)
+	}
+	missing, err := MissingAgentKeys(ag, hosts[1])
+	if err != nil || !slices.Equal(missing, []string{locked}) {
+		t.Fatalf("expected only the locked key to be missing, got %v %v", missing, err)
+	}
+	if KeyNeedsPassphrase(shared) || !KeyNeedsPassphrase(locked) {
+		t.Fatalf("expected only the locked key to need a passphrase")
+	}
+	if err = AddKeyToAgent(ag, locked, "wrong", 0); err == nil {
+		t.Fatalf("expected a wrong passphrase to fail")
+	}
+	if err = AddKeyToAgent(ag, locked, "secret", 0); err != nil {
+		t.Fatalf("failed to add locked key: %v", err)
+	}
+	if missing, _ = MissingAgentKeys(ag, hosts[1]); len(missing) != 0 {
+		t.Fatalf("expected every key of b to be loaded, got %v", missing)
+	}
+
+	caPath := filepath.Join(dir, "ca")
+	writeTestKey(t, caPath, "ca", "")
+	ca, err := ssh.ParsePrivateKey(mustRead(t, caPath))
+	if err != nil {
+		t.Fatalf("failed to parse ca: %v", err)
+	}
+	if _, err = SignCertificate(ca, shared, "a", []string{"deploy"}, time.Hour, time.Now()); err != nil {
+		t.Fatalf("failed to sign certificate: %v", err)
+	}
+	if err = AddKeyToAgent(ag, shared, "", 0); err != nil {
+		t.Fatalf("failed to add key with certificate: %v", err)
+	}
+	if loaded, _ := ag.List(); len(loaded) != 3 {
+		t.Fatalf("expected the certificate to be loaded next to the keys, got %d identities", len(loaded))
+	}
+	if keys, _ = ListAgentKeys(ag, hosts); len(keys) != 2 || !keys[0].Certificate {
+		t.Fatalf("a key with its certificate should be listed once, got %+v", keys)
+	}
+
+	if err = RemoveKeyFromAgent(ag, shared); err != nil {
+		t.Fatalf("failed to remove key: %v", err)
+	}
+	if loaded, _ := ag.List(); len(loaded) != 1 {
+		t.Fatalf("removing a key should remove its certificate too, got %d identities", len(loaded))
+	}
+	if err = RemoveKeyFromAgent(ag, shared); err == nil {
+		t.Fatalf("removing a key that is not loaded should fail")
+	}
+}
+
+func mustRead(t *testing.T, path string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read %s: %v", path, err)
+	}
+	return data
+}
+
+// recordingAgent keeps the keys added to it
+type recordingAgent struct {
+	agent.Agent
+	added []agent.AddedKey
+}
+
+func (r *recordingAgent) Add(key agent.AddedKey) error {
+	r.added = append(r.added, key)
+	return r.Agent.Add(key)
+}
+
+func TestAddKeyToAgentLifetime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	writeTestKey(t, path, "key", "")
+	ag := &recordingAgent{Agent: agent.NewKeyring()}
+	for _, lifetime := range []time.Duration{time.Hour, 200 * 365 * 24 * time.Hour, 0} {
+		if err := AddKeyToAgent(ag, path, "", lifetime); err != nil {
+			t.Fatalf("failed to add key: %v", err)
+		}
+	}
+	want := []uint32{3600, math.MaxUint32, 0}
+	for i, added := range ag.added {
+		if added.LifetimeSecs != want[i] {
+			t.Fatalf("expected a lifetime of %d seconds, got %d", want[i], added.LifetimeSecs)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/config"
+	"andrew/sshman/internal/sqlite"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// AuditConcurrency is the number of hosts whose authorized_keys are fetched at once
+const AuditConcurrency = 4
+
+// AuditedKey is a line of a remote authorized_keys file along with what the audit found about it
+type AuditedKey struct {
+	Line        int    // line number in the file, starting at 1
+	Raw         string // the line as it is in the file
+	Options     []string
+	Type        string
+	Fingerprint string
+	Comment     string
+	Invalid     bool   // the line could not be parsed as a key
+	ManagedHost string // host of a ssh_man:host:tag comment, empty if the key was not generated by ssh_man
+	LocalKey    string // private key in the key store or inventory holding the key, empty if there is none
+	Duplicate   bool   // an earlier line holds the same key
+}
+
+// Managed reports whether the comment of the entry follows the ssh_man:host:tag convention of generated keys
+func (e AuditedKey) Managed() bool {
+	return e.ManagedHost != ""
+}
+
+// Unknown reports whether the key is neither a local key nor one ssh_man generated
+func (e AuditedKey) Unknown() bool {
+	return !e.Invalid && e.LocalKey == "" && !e.Managed()
+}
+
+// Stale reports whether ssh_man generated the key but its private key no longer exists locally
+func (e AuditedKey) Stale() bool {
+	return !e.Invalid && e.LocalKey == "" && e.Managed()
+}
+
+// Flags returns short descriptions of the problems with the entry, empty if there are none
+func (e AuditedKey) Flags() []string {
+	flags := make([]string, 0)
+	if e.Invalid {
+		flags = append(flags, "invalid")
+	}
+	if e.Unknown() {
+		flags = append(flags, "unknown")
+	}
+	if e.Stale() {
+		flags = append(flags, "private key gone")
+	}
+	if e.Duplicate {
+		flags = append(flags, "duplicate")
+	}
+	return flags
+}
+
+// parseManagedComment returns the host of a ssh_man:host:tag comment, see getKeyComment
+func parseManagedComment(comment string) (string, bool) {
+	parts := strings.SplitN(comment, ":", 3)
+	if len(parts) != 3 || parts[0] != config.AppName || parts[1] == "" || parts[2] == "" {
+		return "", false
+	}
+	return parts[1], true
+}
+
+// ParseAuthorizedKeys parses the lines of an authorized_keys file, blank lines and comments are left out
+func ParseAuthorizedKeys(data []byte) []AuditedKey {
+	entries := make([]AuditedKey, 0)
+	for i, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		entry := AuditedKey{Line: i + 1, Raw: line}
+		pub, comment, options, _, err := ssh.ParseAuthorizedKey([]byte(trimmed))
+		if err != nil {
+			entry.Invalid = true
+			entries = append(entries, entry)
+			continue
+		}
+		entry.Options = options
+		entry.Type = pub.Type()
+		entry.Fingerprint = ssh.FingerprintSHA256(pub)
+		entry.Comment = comment
+		entry.ManagedHost, _ = parseManagedComment(comment)
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// LocalKeys are the keys an audit matches authorized_keys entries against
+type LocalKeys struct {
+	fingerprints map[string]string // fingerprint -> private key path
+	comments     map[string]string // comment of a generated key -> private key path
+}
+
+// LocalPublicKeys collects the keys of the key store whose private key still exists along with the keys of the
+// inventory that are not missing
+func LocalPublicKeys(keyStore string, inventory []sqlite.Key) LocalKeys {
+	local := LocalKeys{fingerprints: make(map[string]string), comments: make(map[string]string)}
+	for _, pubPath := range keyStoreFiles(keyStore, func(name string) bool { return strings.HasSuffix(name, ".pub") }) {
+		private := strings.TrimSuffix(pubPath, ".pub")
+		if exists, _ := doesFileExist(private); !exists {
+			continue
+		}
+		data, err := os.ReadFile(pubPath)
+		if err != nil {
+			continue
+		}
+		pub, comment, _, _, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			continue
+		}
+		local.fingerprints[ssh.FingerprintSHA256(pub)] = private
+		if _, ok := parseManagedComment(comment); ok {
+			local.comments[comment] = private
+		}
+	}
+	for _, key := range inventory {
+		if key.Fingerprint == "" {
+			continue
+		}
+		if _, ok := local.fingerprints[key.Fingerprint]; ok {
+			continue
+		}
+		if exists, _ := doesFileExist(key.Path); exists {
+			local.fingerprints[key.Fingerprint] = key.Path
+		}
+	}
+	return local
+}
+
+// AuditAuthorizedKeys matches entries against local by fingerprint, falling back to the comment of generated
+// keys, and marks every repeat of a key as a duplicate
+func AuditAuthorizedKeys(entries []AuditedKey, local LocalKeys) []AuditedKey {
+	seen := make(map[string]bool)
+	audited := slices.Clone(entries)
+	for i := range audited {
+		entry := &audited[i]
+		if entry.Invalid {
+			continue
+		}
+		entry.LocalKey = local.fingerprints[entry.Fingerprint]
+		if entry.LocalKey == "" && entry.Managed() {
+			entry.LocalKey = local.comments[entry.Comment]
+		}
+		entry.Duplicate = seen[entry.Fingerprint]
+		seen[entry.Fingerprint] = true
+	}
+	return audited
+}
+
+// HostAudit is the audited authorized_keys of a host, Err is set if the file could not be fetched
+type HostAudit struct {
+	Host    string
+	Entries []AuditedKey
+	Err     error
+}
+
+// Problems returns the number of entries with at least one flag
+func (h HostAudit) Problems() int {
+	count := 0
+	for _, entry := range h.Entries {
+		if len(entry.Flags()) > 0 {
+			count++
+		}
+	}
+	return count
+}
+
+// SelectAuditHosts returns the aliases of hosts with tag whose alias matches the glob pattern, empty tag and
+// pattern select every host
+func SelectAuditHosts(hosts []sqlite.Host, tag, pattern string) []string {
+	selected := make([]string, 0)
+	for _, host := range hosts {
+		if tag != "" && !slices.ContainsFunc(host.Tags, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			continue
+		}
+		if pattern != "" {
+			if matched, _ := path.Match(pattern, host.Host); !matched {
+				continue
+			}
+		}
+		selected = append(selected, host.Host)
+	}
+	return selected
+}
+
+// FetchAuthorizedKeys reads ~/.ssh/authorized_keys of the sftp session, a missing file reads as empty
+func FetchAuthorizedKeys(client *sftp.Client) ([]byte, error) {
+	home, err := client.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find remote home directory: %w", err)
+	}
+	data, err := readRemoteFile(client, path.Join(home, authorizedKeysFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// AuditHosts fetches and audits the authorized_keys of every host over sftp. Hosts are handled concurrently,
+// the native transport never prompts so a host asking for a password fails instead of blocking the others.
+// This is blocking and should be run outside the ui thread
+func AuditHosts(ctx context.Context, hosts []string, local LocalKeys, cfg config.Config, concurrency int, sshOpts ...string) []HostAudit {
+	if concurrency <= 0 {
+		concurrency = AuditConcurrency
+	}
+	audits := make([]HostAudit, len(hosts))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, host string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			audits[i] = HostAudit{Host: host}
+			var data []byte
+			err := NewSftpSession(ctx, host, cfg, func(client *sftp.Client) error {
+				var err error
+				data, err = FetchAuthorizedKeys(client)
+				return err
+			}, sshOpts...).Run()
+			if err != nil {
+				audits[i].Err = fmt.Errorf("failed to fetch authorized_keys: %w", err)
+				return
+			}
+			audits[i].Entries = AuditAuthorizedKeys(ParseAuthorizedKeys(data), local)
+		}(i, host)
+	}
+	wg.Wait()
+	return audits
+}
+
+// RemoveAuthorizedKeyLines removes the lines of entries from the authorized_keys of the sftp session by their
+// line number and returns how many were removed. A line is only removed while it still holds the entry, ignoring
+// surrounding whitespace, so a file edited since it was audited keeps its lines. Unlike RemoveAuthorizedKey
+// other lines holding the same key, even byte-identical copies, are kept
+func RemoveAuthorizedKeyLines(client *sftp.Client, entries []AuditedKey) (int, error) {
+	drop := make(map[int]string, len(entries))
+	for _, entry := range entries {
+		drop[entry.Line] = strings.TrimSpace(entry.Raw)
+	}
+	removed := 0
+	err := editAuthorizedKeysFile(client, func(fileLines []string) []string {
+		removed = 0
+		kept := make([]string, 0, len(fileLines))
+		for i, line := range fileLines {
+			if raw, ok := drop[i+1]; ok && raw == strings.TrimSpace(line) {
+				removed++
+				continue
+			}
+			kept = append(kept, line)
+		}
+		return kept
+	})
+	return removed, err
+}
+
+// RemoveAuthorizedKeyLinesSession removes the audited entries of host from its authorized_keys, see
+// RemoveAuthorizedKeyLines. removed is set to the number of lines removed once the session ran
+func RemoveAuthorizedKeyLinesSession(ctx context.Context, host string, entries []AuditedKey, removed *int, cfg config.Config, options ...string) *SftpSession {
+	return NewSftpSession(ctx, host, cfg, func(client *sftp.Client) error {
+		var err error
+		*removed, err = RemoveAuthorizedKeyLines(client, entries)
+		return err
+	}, options...)
+}
+
+// RemoveAuditedKeys removes entries from the authorized_keys of host, see RemoveAuthorizedKeyLinesSession, and
+// returns how many lines were removed. This is blocking and should be run outside the ui thread
+func RemoveAuditedKeys(ctx context.Context, host string, entries []AuditedKey, cfg config.Config, sshOpts ...string) (int, error) {
+	removed := 0
+	err := RemoveAuthorizedKeyLinesSession(ctx, host, entries, &removed, cfg, sshOpts...).Run()
+	return removed, err
+}
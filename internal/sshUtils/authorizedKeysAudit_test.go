@@ -0,0 +1,127 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/sqlite"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAuditAuthorizedKeys(t *testing.T) {
+	store := t.TempDir()
+	known := filepath.Join(store, "ed25519_web_aa_20250101")
+	knownPub := writeTestKey(t, known, "ssh_man:web:aa_20250101", "")
+	gone := filepath.Join(store, "ed25519_web_bb_20240101")
+	gonePub := writeTestKey(t, gone, "ssh_man:web:bb_20240101", "")
+	if err := os.Remove(gone); err != nil {
+		t.Fatalf("failed to remove key: %v", err)
+	}
+	outside := filepath.Join(t.TempDir(), "id_ed25519")
+	outsidePub := writeTestKey(t, outside, "me@laptop", "")
+	strangerPub := writeTestKey(t, filepath.Join(t.TempDir(), "stranger"), "", "")
+
+	data := "# keys\n" +
+		authorizedKeyLine(knownPub, "ssh_man:web:aa_20250101") + "\n" +
+		`no-pty,from="10.0.0.0/8" ` + authorizedKeyLine(outsidePub, "me@laptop") + "\n" +
+		"\n" +
+		authorizedKeyLine(gonePub, "ssh_man:web:bb_20240101") + "\n" +
+		authorizedKeyLine(strangerPub, "someone@else") + "\n" +
+		authorizedKeyLine(knownPub, "copied again") + "\n" +
+		"not a key\n"
+	entries := ParseAuthorizedKeys([]byte(data))
+	if len(entries) != 6 || entries[0].Line != 2 || entries[1].Line != 3 || entries[2].Line != 5 {
+		t.Fatalf("blank and comment lines should be skipped, got %+v", entries)
+	}
+	if !slices.Equal(entries[1].Options, []string{"no-pty", `from="10.0.0.0/8"`}) || entries[1].Comment != "me@laptop" {
+		t.Fatalf("options and comment should be parsed, got %+v", entries[1])
+	}
+
+	local := LocalPublicKeys(store, []sqlite.Key{
+		{Path: outside, Fingerprint: ssh.FingerprintSHA256(outsidePub)},
+		{Path: filepath.Join(store, "missing"), Fingerprint: ssh.FingerprintSHA256(strangerPub)},
+	})
+	audited := AuditAuthorizedKeys(entries, local)
+	want := [][]string{
+		{},
+		{},
+		{"private key gone"},
+		{"unknown"},
+		{"duplicate"},
+		{"invalid"},
+	}
+	for i, entry := range audited {
+		if flags := entry.Flags(); !slices.Equal(flags, want[i]) {
+			t.Fatalf("line %d: expected flags %v, got %v", entry.Line, want[i], flags)
+		}
+	}
+	if audited[0].LocalKey != known || audited[1].LocalKey != outside || audited[0].ManagedHost != "web" {
+		t.Fatalf("keys should be matched to their private key, got %+v", audited[:2])
+	}
+	if problems := (HostAudit{Entries: audited}).Problems(); problems != 4 {
+		t.Fatalf("expected 4 problems, got %d", problems)
+	}
+
+	home := t.TempDir()
+	client := dialTestSftp(t, startSftpServer(t, home))
+	if fetched, err := FetchAuthorizedKeys(client); err != nil || len(fetched) != 0 {
+		t.Fatalf("a missing authorized_keys should read as empty, got %q %v", fetched, err)
+	}
+	target := filepath.Join(home, ".ssh", "authorized_keys")
+	if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+		t.Fatalf("failed to create .ssh: %v", err)
+	}
+	if err := os.WriteFile(target, []byte(data), 0o600); err != nil {
+		t.Fatalf("failed to write authorized_keys: %v", err)
+	}
+	fetched, err := FetchAuthorizedKeys(client)
+	if err != nil || string(fetched) != data {
+		t.Fatalf("expected the file to be fetched as is, got %q %v", fetched, err)
+	}
+	removed, err := RemoveAuthorizedKeyLines(client, []AuditedKey{audited[2], audited[4]})
+	if err != nil || removed != 2 {
+		t.Fatalf("expected the selected lines to be removed, got %d %v", removed, err)
+	}
+	content, _ := os.ReadFile(target)
+	left := ParseAuthorizedKeys(content)
+	if len(left) != 4 || left[0].Fingerprint != audited[0].Fingerprint {
+		t.Fatalf("only the selected lines should be removed, the first copy of a duplicate is kept, got %+v", left)
+	}
+	if removed, err = RemoveAuthorizedKeyLines(client, []AuditedKey{audited[2]}); err != nil || removed != 0 {
+		t.Fatalf("a line no longer holding the entry should be kept, got %d %v", removed, err)
+	}
+
+	line := authorizedKeyLine(strangerPub, "someone@else")
+	if err = os.WriteFile(target, []byte(line+"\n"+line+"\n"), 0o600); err != nil {
+		t.Fatalf("failed to write authorized_keys: %v", err)
+	}
+	copies := AuditAuthorizedKeys(ParseAuthorizedKeys([]byte(line+"\n"+line+"\n")), local)
+	if !copies[1].Duplicate {
+		t.Fatalf("the second copy should be flagged as a duplicate, got %+v", copies)
+	}
+	if removed, err = RemoveAuthorizedKeyLines(client, copies[1:]); err != nil || removed != 1 {
+		t.Fatalf("expected only the duplicate to be removed, got %d %v", removed, err)
+	}
+	if content, _ = os.ReadFile(target); string(content) != line+"\n" {
+		t.Fatalf("one copy of a byte-identical duplicate should be kept, got %q", content)
+	}
+}
+
+func TestSelectAuditHosts(t *testing.T) {
+	hosts := []sqlite.Host{
+		{Host: "web-1", Tags: []string{"Prod"}},
+		{Host: "web-2"},
+		{Host: "db-1", Tags: []string{"prod"}},
+	}
+	if got := SelectAuditHosts(hosts, "prod", ""); !slices.Equal(got, []string{"web-1", "db-1"}) {
+		t.Fatalf("expected the tagged hosts, got %v", got)
+	}
+	if got := SelectAuditHosts(hosts, "prod", "web-*"); !slices.Equal(got, []string{"web-1"}) {
+		t.Fatalf("expected the tagged hosts matching the pattern, got %v", got)
+	}
+	if got := SelectAuditHosts(hosts, "", ""); len(got) != 3 {
+		t.Fatalf("expected every host, got %v", got)
+	}
+}
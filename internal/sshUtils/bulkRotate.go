@@ -0,0 +1,470 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/config"
+	"andrew/sshman/internal/sqlite"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// BulkRotateConcurrency is the number of hosts rotated at once when no limit is given
+const BulkRotateConcurrency = 8
+
+// ErrBulkSecurityKey is returned when a bulk rotation asks for security keys, they need a touch to be generated
+// and for every verifying login so they can not be rotated unattended
+var ErrBulkSecurityKey = errors.New("security keys need a touch for every login, rotate them one host at a time")
+
+// BulkRotateStage is how far the rotation of a host has got
+type BulkRotateStage int
+
+const (
+	BulkPending BulkRotateStage = iota
+	BulkGenerating
+	BulkCopying
+	BulkVerifying
+	BulkRemoving
+	BulkDone
+	BulkFailed
+)
+
+func (s BulkRotateStage) String() string {
+	switch s {
+	case BulkPending:
+		return "pending"
+	case BulkGenerating:
+		return "generating"
+	case BulkCopying:
+		return "copying"
+	case BulkVerifying:
+		return "verifying"
+	case BulkRemoving:
+		return "removing old key"
+	case BulkDone:
+		return "done"
+	case BulkFailed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// BulkRotateTarget is a host and the key of it to replace
+type BulkRotateTarget struct {
+	Host    string
+	OldKey  string // IdentityFile value the old key is stored under, empty to only add the new key
+	OldPath string // expanded path of the old key
+}
+
+// BulkRotateOptions decides how the new keys are generated and how many hosts are rotated at once
+type BulkRotateOptions struct {
+	Algorithm   string // RSA, ECDSA or ED25519, see config
+	PerHost     bool   // generate a key per host instead of one key shared by every host
+	SharedName  string // name the shared key is generated under
+	Concurrency int    // defaults to BulkRotateConcurrency
+	SSHOptions  []string
+}
+
+// BulkRotateStatus is the progress of the rotation of a single host
+type BulkRotateStatus struct {
+	BulkRotateTarget
+	Stage      BulkRotateStage
+	FailedAt   BulkRotateStage // stage the rotation failed in, only set if Stage is BulkFailed
+	NewKey     KeyPair
+	Verified   bool // login with the new key succeeded, the new key can be registered to the host
+	OldRemoved bool // the old key was removed from the authorized_keys of the host
+	Err        error
+}
+
+// bulkRotateSteps are the blocking operations of a bulk rotation, replaced in tests
+type bulkRotateSteps struct {
+	gen    func(name string) (KeyPair, error)
+	copy   func(pubKey, host string) error
+	verify func(privateKey, host string) error
+	remove func(oldKey, host string) error
+}
+
+// SelectBulkTargets picks the hosts to rotate. Hosts must have tag and their alias must match the glob
+// pattern, either can be empty to skip the check. If oldKey is set only hosts using it are selected,
+// otherwise the single key each host has in keyStore is replaced. Hosts that cannot be rotated are
+// returned in skipped with the reason
+func SelectBulkTargets(hosts []sqlite.Host, tag, pattern, oldKey, keyStore string) (targets []BulkRotateTarget, skipped []string) {
+	targets = make([]BulkRotateTarget, 0)
+	skipped = make([]string, 0)
+	if oldKey != "" {
+		if expanded, err := ExpandTilde(oldKey, NewTokenContext(sqlite.Host{}).Home); err == nil {
+			oldKey = filepath.Clean(expanded)
+		}
+	}
+	keyStore = filepath.Clean(keyStore) + string(filepath.Separator)
+	for _, host := range hosts {
+		if tag != "" && !slices.ContainsFunc(host.Tags, func(t string) bool { return strings.EqualFold(t, tag) }) {
+			continue
+		}
+		if pattern != "" {
+			if matched, _ := path.Match(pattern, host.Host); !matched {
+				continue
+			}
+		}
+		tokens := NewTokenContext(host)
+		candidates := make([]BulkRotateTarget, 0)
+		for _, opt := range host.Options {
+			if !strings.EqualFold(opt.Key, "IdentityFile") {
+				continue
+			}
+			expanded, err := ExpandOptionValue(opt.Key, opt.Value, tokens)
+			if err != nil {
+				continue
+			}
+			expanded = filepath.Clean(expanded)
+			if (oldKey != "" && expanded == oldKey) || (oldKey == "" && strings.HasPrefix(expanded, keyStore)) {
+				candidates = append(candidates, BulkRotateTarget{Host: host.Host, OldKey: opt.Value, OldPath: expanded})
+			}
+		}
+		switch {
+		case oldKey != "" && len(candidates) == 0:
+			continue // host does not use the key being replaced
+		case len(candidates) == 0:
+			targets = append(targets, BulkRotateTarget{Host: host.Host})
+		case len(candidates) == 1:
+			targets = append(targets, candidates[0])
+		default:
+			skipped = append(skipped, fmt.Sprintf("%s: has %d keys in the key store, pick the key to replace", host.Host, len(candidates)))
+		}
+	}
+	return targets, skipped
+}
+
+// BulkRotate rotates the key of every target, at most opts.Concurrency hosts at once. The old key of a host
+// is only removed once a login with the new key succeeded. Progress is sent to updates if it is not nil,
+// updates is not closed. The database is not touched, see ApplyBulkRotation. Canceling ctx kills the commands
+// still running and fails the hosts not started yet. This is blocking and should be run outside the ui thread
+func BulkRotate(ctx context.Context, targets []BulkRotateTarget, opts BulkRotateOptions, cfg config.Config, updates chan<- BulkRotateStatus) []BulkRotateStatus {
+	if config.IsSecurityKeyType(opts.Algorithm) {
+		// fail every host up front rather than host by host, none of them is touched
+		results := make([]BulkRotateStatus, len(targets))
+		for i, target := range targets {
+			results[i] = BulkRotateStatus{BulkRotateTarget: target, Stage: BulkFailed, FailedAt: BulkGenerating, Err: ErrBulkSecurityKey}
+		}
+		return results
+	}
+	sshOpts := append(slices.Clone(opts.SSHOptions), "-o", "BatchMode=yes") // prompts would block the other hosts
+	steps := bulkRotateSteps{
+		gen: func(name string) (KeyPair, error) {
+			return GenKey(ctx, name, opts.Algorithm, "", KeyGenOptions{}, cfg)
+		},
+		copy: func(pubKey, host string) error {
+			if cfg.Ssh.Native {
+				session, err := InstallKeySession(ctx, pubKey, host, cfg, sshOpts...)
+				if err != nil {
+					return err
+				}
+				return session.Run()
+			}
+			out, err := CopyKey(ctx, pubKey, host, cfg, sshOpts...).CombinedOutput()
+			return commandError(err, out)
+		},
+		verify: func(privateKey, host string) error {
+			return VerifyKeyLogin(ctx, privateKey, host, cfg, opts.SSHOptions...)
+		},
+		remove: func(oldKey, host string) error {
+			entry, err := AuthorizedKeyEntry(oldKey)
+			if err != nil {
+				return err
+			}
+			if cfg.Ssh.Native {
+				return RemoveKeySession(ctx, entry, host, cfg, sshOpts...).Run()
+			}
+			out, err := RemoveAuthorizedKeyFromRemoteServer(ctx, entry, host, cfg, sshOpts...).CombinedOutput()
+			return commandError(err, out)
+		},
+	}
+	return bulkRotate(ctx, targets, opts, steps, updates)
+}
+
+func bulkRotate(ctx context.Context, targets []BulkRotateTarget, opts BulkRotateOptions, steps bulkRotateSteps, updates chan<- BulkRotateStatus) []BulkRotateStatus {
+	results := make([]BulkRotateStatus, len(targets))
+	for i, target := range targets {
+		results[i] = BulkRotateStatus{BulkRotateTarget: target}
+	}
+	var mu sync.Mutex // guards results, the status is copied so a slow receiver of updates never holds it
+	report := func(i int, stage BulkRotateStage, err error) {
+		mu.Lock()
+		results[i].Stage = stage
+		if err != nil {
+			results[i].FailedAt = stage
+			results[i].Stage = BulkFailed
+			results[i].Err = err
+		}
+		status := results[i]
+		mu.Unlock()
+		if updates != nil {
+			updates <- status
+		}
+	}
+
+	var shared KeyPair
+	if !opts.PerHost {
+		name := opts.SharedName
+		if name == "" {
+			name = "bulk"
+		}
+		var err error
+		if shared, err = steps.gen(name); err != nil {
+			for i := range results {
+				report(i, BulkGenerating, fmt.Errorf("failed to generate shared key: %w", err))
+			}
+			return results
+		}
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency <= 0 {
+		concurrency = BulkRotateConcurrency
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range results {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if err := ctx.Err(); err != nil {
+			report(i, BulkPending, err)
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			host := results[i].Host
+			keys := shared
+			if opts.PerHost {
+				report(i, BulkGenerating, nil)
+				var err error
+				if keys, err = steps.gen(host); err != nil {
+					report(i, BulkGenerating, err)
+					return
+				}
+			}
+			mu.Lock()
+			results[i].NewKey = keys
+			mu.Unlock()
+			report(i, BulkCopying, nil)
+			if err := steps.copy(keys.PubKey, host); err != nil {
+				report(i, BulkCopying, err)
+				return
+			}
+			report(i, BulkVerifying, nil)
+			if err := steps.verify(keys.PrivateKey, host); err != nil {
+				report(i, BulkVerifying, err)
+				return
+			}
+			mu.Lock()
+			results[i].Verified = true
+			mu.Unlock()
+			if oldPath := results[i].OldPath; oldPath != "" && oldPath != keys.PrivateKey {
+				report(i, BulkRemoving, nil)
+				if err := steps.remove(oldPath, host); err != nil {
+					report(i, BulkRemoving, err)
+					return
+				}
+				mu.Lock()
+				results[i].OldRemoved = true
+				mu.Unlock()
+			}
+			report(i, BulkDone, nil)
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// ApplyBulkRotation registers the new key of every verified host that does not have it yet and deregisters
+// the old key of every host it was removed from. Old keys no host uses anymore are deleted from disk once every host they were
+// removed from succeeded, the key inventory is updated to match if keyDao is not nil. ledger is the recorder the
+// progress of the rotation was recorded with, it is given the final results
+func ApplyBulkRotation(dao *sqlite.HostDao, keyDao *sqlite.KeyDao, ledger *BulkLedgerRecorder, results []BulkRotateStatus, cfg config.Config) error {
+	errs := make([]error, 0)
+	if err := ledger.RecordAll(results); err != nil {
+		errs = append(errs, err)
+	}
+	newKeys := make([]string, 0)
+	oldKeys := make(map[string]bool) // old key path -> removed from every host it was rotated on
+	for _, r := range results {
+		if r.OldPath != "" {
+			removed, seen := oldKeys[r.OldPath]
+			oldKeys[r.OldPath] = r.OldRemoved && (!seen || removed)
+		}
+		if !r.Verified {
+			continue
+		}
+		host, err := dao.Get(r.Host)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !hasIdentityFile(host, r.NewKey.PrivateKey) {
+			if err = dao.RegisterNewIdentityKeyForHost(r.Host, r.NewKey.PrivateKey); err != nil {
+				errs = append(errs, fmt.Errorf("failed to register new key for %s: %w", r.Host, err))
+				continue
+			}
+		}
+		if !slices.Contains(newKeys, r.NewKey.PrivateKey) {
+			newKeys = append(newKeys, r.NewKey.PrivateKey)
+		}
+		if r.OldRemoved {
+			if err := dao.DeRegisterIdentityKeyFromHost(r.Host, r.OldKey); err != nil {
+				errs = append(errs, fmt.Errorf("failed to deregister old key from %s: %w", r.Host, err))
+			}
+		}
+	}
+	for _, newKey := range newKeys {
+		if keyDao != nil {
+			if key, err := InspectKey(newKey); err == nil {
+				if err = keyDao.Upsert(key); err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+		if cfg.Ssh.RemovePubKeyAfterGen {
+			if err := os.Remove(newKey + ".pub"); err != nil {
+				errs = append(errs, fmt.Errorf("failed to remove public key %s: %w", newKey+".pub", err))
+			}
+		}
+	}
+
+	hosts, err := dao.GetAll()
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	usage := KeyUsage(hosts)
+	for oldPath, removed := range oldKeys {
+		if !removed || len(usage[oldPath]) > 0 {
+			continue
+		}
+		if err = os.Remove(oldPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("failed to delete old key %s: %w", oldPath, err))
+			continue
+		}
+		_ = os.Remove(oldPath + ".pub")
+		if keyDao != nil {
+			_ = keyDao.Delete(oldPath) // keys outside the inventory are fine to miss
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// VerifyKeyLogin logs into host offering only privateKey, the agent and the other identities of the host are
+// not used so a successful login proves the server accepts the key. ssh is killed once ctx is canceled
+func VerifyKeyLogin(ctx context.Context, privateKey, host string, cfg config.Config, options ...string) error {
+	out, err := VerifyKeyLoginCommand(ctx, privateKey, host, true, cfg, options...).CombinedOutput()
+	return CheckKeyLogin(out, err, privateKey, host)
+}
+
+// VerifyKeyLoginCommand returns the command VerifyKeyLogin runs. Without batch ssh can prompt for the passphrase
+// of privateKey on the terminal, password and keyboard interactive logins stay disabled either way
+func VerifyKeyLoginCommand(ctx context.Context, privateKey, host string, batch bool, cfg config.Config, options ...string) *exec.Cmd {
+	args := []string{
+		"-F", cfg.GetSshConfigFilePath(),
+		"-v",
+		"-i", privateKey,
+		"-o", "IdentitiesOnly=yes",
+		"-o", "IdentityAgent=none",
+		"-o", "PasswordAuthentication=no",
+		"-o", "KbdInteractiveAuthentication=no",
+	}
+	if batch {
+		args = append(args, "-o", "BatchMode=yes")
+	}
+	args = append(args, options...)
+	args = append(args, host, "true")
+	return exec.CommandContext(ctx, getSshExecutable(cfg), args...)
+}
+
+// CheckKeyLogin checks the result of a VerifyKeyLoginCommand, output must hold its stderr
+func CheckKeyLogin(output []byte, err error, privateKey, host string) error {
+	if err != nil {
+		return fmt.Errorf("login with %s failed: %w", filepath.Base(privateKey), commandError(err, output))
+	}
+	if !serverAcceptedKey(output, privateKey) {
+		return fmt.Errorf("login to %s did not use %s", host, filepath.Base(privateKey))
+	}
+	return nil
+}
+
+// serverAcceptedKey checks the verbose output of ssh for the server accepting privateKey, the host config can
+// still list other identity files so a successful login alone is not proof
+func serverAcceptedKey(output []byte, privateKey string) bool {
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		_, accepted, found := strings.Cut(scanner.Text(), "Server accepts key: ")
+		if found && (accepted == privateKey || strings.HasPrefix(accepted, privateKey+" ")) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthorizedKeyEntry returns the key type and base64 blob of the public half of the private key at path,
+// the part of an authorized_keys line that identifies the key whatever its comment is
+func AuthorizedKeyEntry(path string) (string, error) {
+	var pub ssh.PublicKey
+	if pubBytes, err := os.ReadFile(path + ".pub"); err == nil {
+		pub, _, _, _, err = ssh.ParseAuthorizedKey(pubBytes)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse public key %s: %w", path+".pub", err)
+		}
+	} else {
+		pemBytes, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		signer, err := ssh.ParsePrivateKey(pemBytes)
+		var missing *ssh.PassphraseMissingError
+		switch {
+		case err == nil:
+			pub = signer.PublicKey()
+		case errors.As(err, &missing) && missing.PublicKey != nil:
+			pub = missing.PublicKey
+		default:
+			return "", fmt.Errorf("failed to read public key of %s: %w", path, err)
+		}
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))), nil
+}
+
+// RemoveAuthorizedKeyFromRemoteServer returns the command removing every authorized_keys line of host holding
+// entry, see AuthorizedKeyEntry. Unlike RemoveOldKeyFromRemoteServer it works for keys not generated for host.
+// The command is killed once ctx is canceled
+func RemoveAuthorizedKeyFromRemoteServer(ctx context.Context, entry, host string, cfg config.Config, options ...string) *exec.Cmd {
+	args := []string{
+		"-F", cfg.GetSshConfigFilePath(),
+	}
+	args = append(args, options...)
+	args = append(args, host)
+	args = append(args, "sh", "-c", generateShellScriptToRemoveOldKey(entry))
+	return exec.CommandContext(ctx, "ssh", args...)
+}
+
+// commandError adds the last line of a failed command's output to err
+func commandError(err error, output []byte) error {
+	if err == nil {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if last := strings.TrimSpace(lines[len(lines)-1]); last != "" {
+		return fmt.Errorf("%w: %s", err, last)
+	}
+	return err
+}
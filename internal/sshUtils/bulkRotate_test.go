@@ -0,0 +1,269 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/config"
+	"andrew/sshman/internal/sqlite"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSelectBulkTargets(t *testing.T) {
+	store := "/keys"
+	hosts := []sqlite.Host{
+		{Host: "web-1", Tags: []string{"Prod"}, Options: []sqlite.HostOptions{{Key: "IdentityFile", Value: "/keys/web"}}},
+		{Host: "web-2", Tags: []string{"prod"}, Options: []sqlite.HostOptions{{Key: "IdentityFile", Value: "/keys/a"}, {Key: "IdentityFile", Value: "/keys/b"}}},
+		{Host: "web-3", Tags: []string{"prod"}},
+		{Host: "db-1", Tags: []string{"prod"}, Options: []sqlite.HostOptions{{Key: "IdentityFile", Value: "/keys/%n/../web"}}},
+		{Host: "web-4", Options: []sqlite.HostOptions{{Key: "IdentityFile", Value: "/keys/web"}}},
+	}
+	targets, skipped := SelectBulkTargets(hosts, "prod", "web-*", "", store)
+	if len(targets) != 2 || targets[0].OldPath != "/keys/web" || targets[1].Host != "web-3" || targets[1].OldKey != "" {
+		t.Fatalf("unexpected targets %+v", targets)
+	}
+	if len(skipped) != 1 || !strings.HasPrefix(skipped[0], "web-2") {
+		t.Fatalf("host with two keys should be skipped, got %v", skipped)
+	}
+	targets, _ = SelectBulkTargets(hosts, "", "", "/keys/web", store)
+	got := make([]string, 0)
+	for _, target := range targets {
+		got = append(got, target.Host)
+	}
+	if !slices.Equal(got, []string{"web-1", "db-1", "web-4"}) || targets[1].OldKey != "/keys/%n/../web" {
+		t.Fatalf("expected every host using the key, got %+v", targets)
+	}
+}
+
+func TestBulkRotate(t *testing.T) {
+	targets := make([]BulkRotateTarget, 0)
+	for _, host := range []string{"a", "b", "c", "d", "e", "f"} {
+		targets = append(targets, BulkRotateTarget{Host: host, OldKey: "/keys/old", OldPath: "/keys/old"})
+	}
+	var running, peak, generated atomic.Int32
+	var mu sync.Mutex
+	removed := make([]string, 0)
+	steps := bulkRotateSteps{
+		gen: func(name string) (KeyPair, error) {
+			generated.Add(1)
+			return KeyPair{PrivateKey: "/keys/" + name, PubKey: "/keys/" + name + ".pub"}, nil
+		},
+		copy: func(pubKey, host string) error {
+			n := running.Add(1)
+			defer running.Add(-1)
+			for {
+				p := peak.Load()
+				if n <= p || peak.CompareAndSwap(p, n) {
+					break
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+			if host == "b" {
+				return errors.New("permission denied")
+			}
+			return nil
+		},
+		verify: func(privateKey, host string) error {
+			if host == "c" {
+				return errors.New("login failed")
+			}
+			return nil
+		},
+		remove: func(oldKey, host string) error {
+			mu.Lock()
+			defer mu.Unlock()
+			removed = append(removed, host)
+			return nil
+		},
+	}
+	updates := make(chan BulkRotateStatus, 100)
+	results := bulkRotate(context.Background(), targets, BulkRotateOptions{Concurrency: 2}, steps, updates)
+	close(updates)
+	if peak.Load() > 2 {
+		t.Fatalf("expected at most 2 hosts at once, got %d", peak.Load())
+	}
+	if generated.Load() != 1 || results[0].NewKey.PrivateKey != "/keys/bulk" {
+		t.Fatalf("expected a single shared key, generated %d got %+v", generated.Load(), results[0].NewKey)
+	}
+	if results[1].Stage != BulkFailed || results[1].FailedAt != BulkCopying || results[1].Verified {
+		t.Fatalf("expected b to fail copying, got %+v", results[1])
+	}
+	if results[2].FailedAt != BulkVerifying || results[2].OldRemoved {
+		t.Fatalf("expected c to fail verifying and keep its old key, got %+v", results[2])
+	}
+	slices.Sort(removed)
+	if !slices.Equal(removed, []string{"a", "d", "e", "f"}) {
+		t.Fatalf("old key should only be removed from verified hosts, got %v", removed)
+	}
+	last := make(map[string]BulkRotateStage)
+	for update := range updates {
+		last[update.Host] = update.Stage
+	}
+	if last["a"] != BulkDone || last["c"] != BulkFailed {
+		t.Fatalf("expected final stages to be reported, got %v", last)
+	}
+
+	generated.Store(0)
+	results = bulkRotate(context.Background(), targets[:3], BulkRotateOptions{PerHost: true}, steps, nil)
+	if generated.Load() != 3 || results[0].NewKey.PrivateKey != "/keys/a" {
+		t.Fatalf("expected a key per host, generated %d", generated.Load())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	running.Store(0)
+	peak.Store(0)
+	results = bulkRotate(ctx, targets, BulkRotateOptions{}, steps, nil)
+	for _, result := range results {
+		if !errors.Is(result.Err, context.Canceled) || result.Stage != BulkFailed {
+			t.Fatalf("hosts of a canceled rotation should fail, got %+v", result)
+		}
+	}
+	if peak.Load() != 0 {
+		t.Fatalf("no host should be copied to once canceled")
+	}
+
+	results = BulkRotate(context.Background(), targets, BulkRotateOptions{Algorithm: config.ED25519_SK}, config.Config{}, nil)
+	for _, result := range results {
+		if !errors.Is(result.Err, ErrBulkSecurityKey) || result.FailedAt != BulkGenerating {
+			t.Fatalf("security keys should be rejected before any host is touched, got %+v", result)
+		}
+	}
+}
+
+func TestServerAcceptedKey(t *testing.T) {
+	output := []byte("debug1: Offering public key: /keys/new ED25519 SHA256:abc explicit\n" +
+		"debug1: Server accepts key: /keys/new ED25519 SHA256:abc explicit\n" +
+		"Authenticated to example.com ([10.0.0.1]:22) using \"publickey\".\n")
+	if !serverAcceptedKey(output, "/keys/new") {
+		t.Fatalf("expected key to be accepted")
+	}
+	if serverAcceptedKey(output, "/keys/ne") || serverAcceptedKey(output, "/keys/old") {
+		t.Fatalf("only the exact key should be accepted")
+	}
+}
+
+func TestCheckKeyLogin(t *testing.T) {
+	accepted := []byte("debug1: Server accepts key: /keys/new ED25519 SHA256:abc explicit\n")
+	if err := CheckKeyLogin(accepted, nil, "/keys/new", "web"); err != nil {
+		t.Fatalf("expected login to be verified, got %v", err)
+	}
+	if err := CheckKeyLogin([]byte("debug1: Server accepts key: /keys/old\n"), nil, "/keys/new", "web"); err == nil {
+		t.Fatalf("login with another key should not verify the new key")
+	}
+	denied := []byte("debug1: Offering public key: /keys/new\nweb: Permission denied (publickey).\n")
+	err := CheckKeyLogin(denied, errors.New("exit status 255"), "/keys/new", "web")
+	if err == nil || !strings.Contains(err.Error(), "Permission denied") {
+		t.Fatalf("expected the ssh error in the failure, got %v", err)
+	}
+
+	cfg := config.Config{}
+	if args := VerifyKeyLoginCommand(context.Background(), "/keys/new", "web", true, cfg).Args; !slices.Contains(args, "BatchMode=yes") ||
+		!slices.Contains(args, "IdentitiesOnly=yes") || args[len(args)-1] != "true" {
+		t.Fatalf("unexpected batch verify command %v", args)
+	}
+	if args := VerifyKeyLoginCommand(context.Background(), "/keys/new", "web", false, cfg).Args; slices.Contains(args, "BatchMode=yes") {
+		t.Fatalf("interactive verify should allow the passphrase prompt, got %v", args)
+	}
+}
+
+func TestApplyBulkRotation(t *testing.T) {
+	conn, err := sqlite.CreateAndLoadDB(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	defer conn.Close()
+	dao := sqlite.NewHostDao(conn)
+	keyDao := sqlite.NewKeyDao(conn)
+	store := t.TempDir()
+	oldKey := filepath.Join(store, "old")
+	newKey := filepath.Join(store, "new")
+	writeTestKey(t, oldKey, "old", "")
+	pub := writeTestKey(t, newKey, "new", "")
+	for _, alias := range []string{"a", "b"} {
+		err = dao.Insert(sqlite.Host{Host: alias, CreatedAt: time.Now(), Options: []sqlite.HostOptions{
+			{Key: "IdentityFile", Value: oldKey, Host: alias},
+		}})
+		if err != nil {
+			t.Fatalf("failed to insert host: %v", err)
+		}
+	}
+	results := []BulkRotateStatus{
+		{BulkRotateTarget: BulkRotateTarget{Host: "a", OldKey: oldKey, OldPath: oldKey}, Stage: BulkDone, Verified: true, OldRemoved: true,
+			NewKey: KeyPair{PrivateKey: newKey, PubKey: newKey + ".pub"}},
+		{BulkRotateTarget: BulkRotateTarget{Host: "b", OldKey: oldKey, OldPath: oldKey}, Stage: BulkFailed, FailedAt: BulkRemoving, Verified: true,
+			NewKey: KeyPair{PrivateKey: newKey, PubKey: newKey + ".pub"}},
+	}
+	ledger := NewBulkLedgerRecorder(keyDao, time.Now())
+	copying := results[0]
+	copying.Stage, copying.Verified, copying.OldRemoved = BulkCopying, false, false
+	if err = ledger.Record(copying); err != nil {
+		t.Fatalf("failed to record progress: %v", err)
+	}
+	if rotations, _ := keyDao.GetRotations(); len(rotations) != 1 || NextRotationStep(rotations[0]) != RotationStepCopy {
+		t.Fatalf("a host should be in the ledger once its new key is generated, got %+v", rotations)
+	}
+	if err = ApplyBulkRotation(dao, keyDao, ledger, results, config.Config{}); err != nil {
+		t.Fatalf("failed to apply rotation: %v", err)
+	}
+	if rotations, _ := keyDao.GetRotations(); len(rotations) != 2 || !slices.ContainsFunc(rotations, func(r sqlite.Rotation) bool { return r.Host == "a" && r.Finished() }) {
+		t.Fatalf("the entry of a should be updated rather than added again, got %+v", rotations)
+	}
+	a, _ := dao.Get("a")
+	b, _ := dao.Get("b")
+	if keys := identityFiles(a); !slices.Equal(keys, []string{newKey}) {
+		t.Fatalf("a should only have the new key, got %v", keys)
+	}
+	if keys := identityFiles(b); !slices.Contains(keys, oldKey) || !slices.Contains(keys, newKey) {
+		t.Fatalf("b should keep its old key next to the new one, got %v", keys)
+	}
+	if _, err = os.Stat(oldKey); err != nil {
+		t.Fatalf("old key is still used by b and should be kept: %v", err)
+	}
+	if key, err := keyDao.Get(newKey); err != nil || key.Fingerprint != ssh.FingerprintSHA256(pub) {
+		t.Fatalf("new key should be recorded in the inventory, got %+v %v", key, err)
+	}
+
+	results[1].OldRemoved = true
+	if err = ApplyBulkRotation(dao, keyDao, nil, results[1:], config.Config{}); err != nil {
+		t.Fatalf("failed to apply rotation: %v", err)
+	}
+	if _, err = os.Stat(oldKey); !os.IsNotExist(err) {
+		t.Fatalf("old key should be deleted once no host uses it, got %v", err)
+	}
+}
+
+func TestAuthorizedKeyEntry(t *testing.T) {
+	dir := t.TempDir()
+	key := filepath.Join(dir, "key")
+	pub := writeTestKey(t, key, "comment", "")
+	want := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	entry, err := AuthorizedKeyEntry(key)
+	if err != nil || entry != want {
+		t.Fatalf("expected %q, got %q %v", want, entry, err)
+	}
+	if err = os.Remove(key + ".pub"); err != nil {
+		t.Fatalf("failed to remove public key: %v", err)
+	}
+	if entry, err = AuthorizedKeyEntry(key); err != nil || entry != want {
+		t.Fatalf("expected entry from the private key %q, got %q %v", want, entry, err)
+	}
+}
+
+func identityFiles(host sqlite.Host) []string {
+	keys := make([]string, 0)
+	for _, opt := range host.Options {
+		if strings.EqualFold(opt.Key, "IdentityFile") {
+			keys = append(keys, opt.Value)
+		}
+	}
+	return keys
+}
@@ -0,0 +1,268 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/config"
+	"andrew/sshman/internal/sqlite"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// certClockSkew backdates certificates so hosts with a slightly slow clock accept them right away
+const certClockSkew = 5 * time.Minute
+
+// certPermissions are the extensions ssh-keygen grants user certificates by default
+var certPermissions = map[string]string{
+	"permit-X11-forwarding":   "",
+	"permit-agent-forwarding": "",
+	"permit-port-forwarding":  "",
+	"permit-pty":              "",
+	"permit-user-rc":          "",
+}
+
+// CertificatePath returns where ssh looks for the certificate of the key at keyPath
+func CertificatePath(keyPath string) string {
+	return keyPath + "-cert.pub"
+}
+
+// LoadCA reads the CA key configured in cfg, encrypted CA keys are not supported
+func LoadCA(cfg config.Config) (ssh.Signer, error) {
+	path := cfg.Ssh.Certificates.CAKeyPath
+	if path == "" {
+		return nil, errors.New("no ca key configured, set ssh.certificates.ca_key_path")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ca key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, fmt.Errorf("ca key %s is encrypted, sshman can only sign with an unencrypted ca key", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ca key: %w", err)
+	}
+	return signer, nil
+}
+
+// SignCertificate signs the public key of keyPath as a user certificate for host valid for principals from now
+// until validity has passed. The certificate is written next to the key, replacing a previous one, and its
+// metadata is returned for the inventory
+func SignCertificate(ca ssh.Signer, keyPath, host string, principals []string, validity time.Duration, now time.Time) (sqlite.Certificate, error) {
+	if len(principals) == 0 {
+		return sqlite.Certificate{}, errors.New("a certificate needs at least one principal")
+	}
+	entry, err := AuthorizedKeyEntry(keyPath)
+	if err != nil {
+		return sqlite.Certificate{}, err
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(entry))
+	if err != nil {
+		return sqlite.Certificate{}, fmt.Errorf("invalid public key for %s: %w", keyPath, err)
+	}
+	var serial [8]byte
+	if _, err = rand.Read(serial[:]); err != nil {
+		return sqlite.Certificate{}, err
+	}
+	cert := &ssh.Certificate{
+		Key:             pub,
+		Serial:          binary.BigEndian.Uint64(serial[:]),
+		CertType:        ssh.UserCert,
+		KeyId:           "sshman:" + host,
+		ValidPrincipals: principals,
+		ValidAfter:      uint64(now.Add(-certClockSkew).Unix()),
+		ValidBefore:     uint64(now.Add(validity).Unix()),
+		Permissions:     ssh.Permissions{Extensions: certPermissions},
+	}
+	if err = cert.SignCert(rand.Reader, ca); err != nil {
+		return sqlite.Certificate{}, fmt.Errorf("failed to sign certificate: %w", err)
+	}
+	path := CertificatePath(keyPath)
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, ssh.MarshalAuthorizedKey(cert), 0o644); err != nil {
+		return sqlite.Certificate{}, err
+	}
+	if err = os.Rename(tmp, path); err != nil {
+		_ = os.Remove(tmp)
+		return sqlite.Certificate{}, err
+	}
+	meta := certificateMetadata(cert, path, keyPath)
+	meta.Host = host
+	meta.CreatedAt = now
+	return meta, nil
+}
+
+// CertNoExpiry is the ValidBefore of certificates that never expire, it keeps comparisons working and still
+// fits the milliseconds the inventory stores
+var CertNoExpiry = time.UnixMilli(math.MaxInt64)
+
+func certificateMetadata(cert *ssh.Certificate, path, keyPath string) sqlite.Certificate {
+	meta := sqlite.Certificate{
+		Path:        path,
+		KeyPath:     keyPath,
+		Serial:      cert.Serial,
+		KeyID:       cert.KeyId,
+		Principals:  cert.ValidPrincipals,
+		ValidAfter:  time.Unix(int64(cert.ValidAfter), 0),
+		ValidBefore: time.Unix(int64(cert.ValidBefore), 0),
+	}
+	if cert.ValidBefore == ssh.CertTimeInfinity {
+		meta.ValidBefore = CertNoExpiry
+	}
+	if cert.SignatureKey != nil {
+		meta.CAFingerprint = ssh.FingerprintSHA256(cert.SignatureKey)
+	}
+	return meta
+}
+
+// CertificateKeyForHost returns the key of host a certificate should be signed for, the host must have exactly
+// one IdentityFile
+func CertificateKeyForHost(host sqlite.Host) (string, error) {
+	keys := make([]string, 0)
+	tokens := NewTokenContext(host)
+	for _, opt := range host.Options {
+		if !strings.EqualFold(opt.Key, "IdentityFile") {
+			continue
+		}
+		path, err := ExpandOptionValue(opt.Key, opt.Value, tokens)
+		if err != nil {
+			return "", err
+		}
+		keys = append(keys, filepath.Clean(path))
+	}
+	switch len(keys) {
+	case 0:
+		return "", fmt.Errorf("%s has no IdentityFile to certify", host.Host)
+	case 1:
+		return keys[0], nil
+	}
+	return "", fmt.Errorf("%s has %d IdentityFile options, give the key to certify", host.Host, len(keys))
+}
+
+// DefaultPrincipals returns the principals used when none are given, the user ssh logs in to host as
+func DefaultPrincipals(host sqlite.Host) []string {
+	if user := NewTokenContext(host).RemoteUser; user != "" {
+		return []string{user}
+	}
+	return nil
+}
+
+// CertifyHost signs a certificate for the key of host with the configured CA, registers it as a
+// CertificateFile of the host and records it in the inventory. keyPath and principals fall back to
+// CertificateKeyForHost and DefaultPrincipals when empty
+func CertifyHost(dao *sqlite.HostDao, keyDao *sqlite.KeyDao, host sqlite.Host, keyPath string, principals []string, cfg config.Config, now time.Time) (sqlite.Certificate, error) {
+	ca, err := LoadCA(cfg)
+	if err != nil {
+		return sqlite.Certificate{}, err
+	}
+	if keyPath == "" {
+		if keyPath, err = CertificateKeyForHost(host); err != nil {
+			return sqlite.Certificate{}, err
+		}
+	}
+	if len(principals) == 0 {
+		principals = DefaultPrincipals(host)
+	}
+	cert, err := SignCertificate(ca, keyPath, host.Host, principals, cfg.Ssh.Certificates.GetValidity(), now)
+	if err != nil {
+		return sqlite.Certificate{}, err
+	}
+	if err = dao.RegisterCertificateForHost(host.Host, cert.Path); err != nil {
+		return cert, fmt.Errorf("certificate written to %s but failed to add it to %s: %w", cert.Path, host.Host, err)
+	}
+	if err = keyDao.UpsertCertificate(cert); err != nil {
+		return cert, fmt.Errorf("certificate written to %s but failed to record it: %w", cert.Path, err)
+	}
+	return cert, nil
+}
+
+// CertificateExpiry maps each host to the earliest expiry of its certificates
+func CertificateExpiry(certs []sqlite.Certificate) map[string]time.Time {
+	expiry := make(map[string]time.Time)
+	for _, cert := range certs {
+		if current, ok := expiry[cert.Host]; !ok || cert.ValidBefore.Before(current) {
+			expiry[cert.Host] = cert.ValidBefore
+		}
+	}
+	return expiry
+}
+
+// ExpiringCertificates returns the certificates expiring within renewBefore of now, expired ones included
+func ExpiringCertificates(certs []sqlite.Certificate, renewBefore time.Duration, now time.Time) []sqlite.Certificate {
+	expiring := make([]sqlite.Certificate, 0)
+	for _, cert := range certs {
+		if cert.ValidBefore.Sub(now) <= renewBefore {
+			expiring = append(expiring, cert)
+		}
+	}
+	return expiring
+}
+
+// SelectCertificates narrows certs to the hosts with tag whose alias matches pattern, empty values match every host
+func SelectCertificates(certs []sqlite.Certificate, hosts []sqlite.Host, tag, pattern string) []sqlite.Certificate {
+	tagged := make(map[string]bool)
+	for _, host := range hosts {
+		tagged[host.Host] = tag == "" || slices.ContainsFunc(host.Tags, func(t string) bool { return strings.EqualFold(t, tag) })
+	}
+	selected := make([]sqlite.Certificate, 0)
+	for _, cert := range certs {
+		if !tagged[cert.Host] {
+			continue
+		}
+		if pattern != "" {
+			if matched, _ := path.Match(pattern, cert.Host); !matched {
+				continue
+			}
+		}
+		selected = append(selected, cert)
+	}
+	return selected
+}
+
+// CertificateRenewal is the outcome of renewing a single certificate
+type CertificateRenewal struct {
+	Old sqlite.Certificate
+	New sqlite.Certificate
+	Err error
+}
+
+// RenewCertificates signs a new certificate for each of certs with the same key, host and principals, the
+// inventory is updated for every certificate renewed
+func RenewCertificates(keyDao *sqlite.KeyDao, certs []sqlite.Certificate, ca ssh.Signer, validity time.Duration, now time.Time) []CertificateRenewal {
+	results := make([]CertificateRenewal, 0, len(certs))
+	for _, cert := range certs {
+		renewed, err := SignCertificate(ca, cert.KeyPath, cert.Host, cert.Principals, validity, now)
+		if err == nil && keyDao != nil {
+			err = keyDao.UpsertCertificate(renewed)
+		}
+		results = append(results, CertificateRenewal{Old: cert, New: renewed, Err: err})
+	}
+	return results
+}
+
+// FormatCertExpiry renders the time left on a certificate compactly, ie 12d, 5h, 40m or expired
+func FormatCertExpiry(validBefore, now time.Time) string {
+	left := validBefore.Sub(now)
+	switch {
+	case left <= 0:
+		return "expired"
+	case left >= 100*365*24*time.Hour:
+		return "forever"
+	case left >= 24*time.Hour:
+		return fmt.Sprintf("%dd", int(left.Hours()/24))
+	case left >= time.Hour:
+		return fmt.Sprintf("%dh", int(left.Hours()))
+	}
+	return fmt.Sprintf("%dm", int(math.Ceil(left.Minutes())))
+}
@@ -0,0 +1,149 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/config"
+	"andrew/sshman/internal/sqlite"
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestCertifyHost(t *testing.T) {
+	conn, err := sqlite.CreateAndLoadDB(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	defer conn.Close()
+	dao := sqlite.NewHostDao(conn)
+	keyDao := sqlite.NewKeyDao(conn)
+	dir := t.TempDir()
+	caPath := filepath.Join(dir, "ca")
+	caPub := writeTestKey(t, caPath, "ca", "")
+	keyPath := filepath.Join(dir, "web")
+	keyPub := writeTestKey(t, keyPath, "web", "")
+	host := sqlite.Host{Host: "web", CreatedAt: time.Now(), Options: []sqlite.HostOptions{
+		{Key: "User", Value: "deploy"}, {Key: "IdentityFile", Value: keyPath},
+	}}
+	if err = dao.Insert(host); err != nil {
+		t.Fatalf("failed to insert host: %v", err)
+	}
+	cfg := config.Config{Ssh: config.SSH{Certificates: config.CertificateAuthority{CAKeyPath: caPath, Validity: "10d"}}}
+	now := time.Now()
+	cert, err := CertifyHost(dao, keyDao, host, "", nil, cfg, now)
+	if err != nil {
+		t.Fatalf("failed to certify host: %v", err)
+	}
+	if cert.Path != keyPath+"-cert.pub" || !slices.Equal(cert.Principals, []string{"deploy"}) ||
+		cert.CAFingerprint != ssh.FingerprintSHA256(caPub) {
+		t.Fatalf("unexpected certificate %+v", cert)
+	}
+	if left := cert.ValidBefore.Sub(now); left < 10*24*time.Hour-time.Second || left > 10*24*time.Hour+time.Second {
+		t.Fatalf("expected the configured validity, got %v", left)
+	}
+
+	data, err := os.ReadFile(cert.Path)
+	if err != nil {
+		t.Fatalf("failed to read certificate: %v", err)
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v", err)
+	}
+	signed := pub.(*ssh.Certificate)
+	if !bytes.Equal(signed.Key.Marshal(), keyPub.Marshal()) {
+		t.Fatalf("certificate should be for the host key")
+	}
+	checker := ssh.CertChecker{IsUserAuthority: func(auth ssh.PublicKey) bool {
+		return bytes.Equal(auth.Marshal(), caPub.Marshal())
+	}}
+	if err = checker.CheckCert("deploy", signed); err != nil {
+		t.Fatalf("certificate should be accepted for its principal: %v", err)
+	}
+	if err = checker.CheckCert("root", signed); err == nil {
+		t.Fatalf("certificate should not be accepted for other principals")
+	}
+
+	stored, err := dao.Get("web")
+	if err != nil || !slices.ContainsFunc(stored.Options, func(o sqlite.HostOptions) bool { return o.Key == "CertificateFile" && o.Value == cert.Path }) {
+		t.Fatalf("expected CertificateFile to be registered, got %+v %v", stored.Options, err)
+	}
+	forever := certificateMetadata(&ssh.Certificate{ValidBefore: ssh.CertTimeInfinity}, filepath.Join(dir, "forever-cert.pub"), keyPath)
+	forever.Host = "web"
+	if err = keyDao.UpsertCertificate(forever); err != nil {
+		t.Fatalf("failed to store a certificate that never expires: %v", err)
+	}
+	if stored, _ := keyDao.GetCertificate(forever.Path); !stored.ValidBefore.Equal(CertNoExpiry) || FormatCertExpiry(stored.ValidBefore, now) != "forever" {
+		t.Fatalf("a certificate that never expires should be stored as such, got %v", stored.ValidBefore)
+	}
+	if err = keyDao.DeleteCertificate(forever.Path); err != nil {
+		t.Fatalf("failed to delete certificate: %v", err)
+	}
+
+	certs, _ := keyDao.GetCertificates()
+	if expiring := ExpiringCertificates(certs, 7*24*time.Hour, now); len(expiring) != 0 {
+		t.Fatalf("a fresh certificate should not be expiring, got %+v", expiring)
+	}
+	later := now.Add(5 * 24 * time.Hour)
+	expiring := ExpiringCertificates(certs, 7*24*time.Hour, later)
+	if len(expiring) != 1 {
+		t.Fatalf("expected the certificate to be expiring, got %+v", expiring)
+	}
+	ca, err := LoadCA(cfg)
+	if err != nil {
+		t.Fatalf("failed to load ca: %v", err)
+	}
+	results := RenewCertificates(keyDao, expiring, ca, cfg.Ssh.Certificates.GetValidity(), later)
+	if len(results) != 1 || results[0].Err != nil || results[0].New.Serial == cert.Serial {
+		t.Fatalf("expected a renewed certificate, got %+v", results)
+	}
+	if renewed, _ := keyDao.GetCertificate(cert.Path); !renewed.ValidBefore.After(cert.ValidBefore) {
+		t.Fatalf("inventory should hold the renewed certificate, got %+v", renewed)
+	}
+	if expiry := CertificateExpiry([]sqlite.Certificate{cert, results[0].New}); !expiry["web"].Equal(cert.ValidBefore) {
+		t.Fatalf("expected the earliest expiry, got %v", expiry)
+	}
+
+	if _, err = LoadCA(config.Config{}); err == nil {
+		t.Fatalf("signing without a ca should fail")
+	}
+	encrypted := filepath.Join(dir, "encrypted")
+	writeTestKey(t, encrypted, "", "secret")
+	if _, err = LoadCA(config.Config{Ssh: config.SSH{Certificates: config.CertificateAuthority{CAKeyPath: encrypted}}}); err == nil {
+		t.Fatalf("encrypted ca keys should be rejected")
+	}
+}
+
+func TestFormatCertExpiry(t *testing.T) {
+	now := time.Now()
+	cases := map[time.Duration]string{
+		-time.Minute:                "expired",
+		30 * time.Minute:            "30m",
+		5*time.Hour + time.Hour/2:   "5h",
+		12*24*time.Hour + time.Hour: "12d",
+	}
+	for left, want := range cases {
+		if got := FormatCertExpiry(now.Add(left), now); got != want {
+			t.Fatalf("expected %s for %v, got %s", want, left, got)
+		}
+	}
+}
+
+func TestSelectCertificates(t *testing.T) {
+	hosts := []sqlite.Host{{Host: "web-1", Tags: []string{"Prod"}}, {Host: "web-2"}, {Host: "db-1", Tags: []string{"prod"}}}
+	certs := []sqlite.Certificate{{Host: "web-1"}, {Host: "web-2"}, {Host: "db-1"}}
+	got := make([]string, 0)
+	for _, cert := range SelectCertificates(certs, hosts, "prod", "web-*") {
+		got = append(got, cert.Host)
+	}
+	if !slices.Equal(got, []string{"web-1"}) {
+		t.Fatalf("expected only web-1, got %v", got)
+	}
+	if all := SelectCertificates(certs, hosts, "", ""); len(all) != 3 {
+		t.Fatalf("expected every certificate without filters, got %d", len(all))
+	}
+}
@@ -0,0 +1,126 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/config"
+	"bufio"
+	"bytes"
+	"os/exec"
+	"strings"
+)
+
+// EffectiveOption is a single option as resolved by ssh -G, along with what ssh-man has stored for the host
+type EffectiveOption struct {
+	Key         string   // canonical key name, falls back to the lowercase form ssh prints
+	Value       string   // value ssh will actually use
+	StoredValue []string // values stored in the database for this key, empty if the key is not stored
+}
+
+// Stored reports whether the host has this option stored in the database
+func (e EffectiveOption) Stored() bool {
+	return len(e.StoredValue) > 0
+}
+
+// Overridden reports whether the option is stored for the host but ssh resolved a different value,
+// this happens when an earlier Host block or an Include sets the option first
+func (e EffectiveOption) Overridden() bool {
+	if !e.Stored() {
+		return false
+	}
+	for _, v := range e.StoredValue {
+		if strings.EqualFold(strings.TrimSpace(v), strings.TrimSpace(e.Value)) {
+			return false
+		}
+	}
+	return true
+}
+
+func getSshExecutable(cfg config.Config) string {
+	if cfg.Ssh.ExcPath == "" {
+		return "ssh"
+	}
+	return cfg.Ssh.ExcPath
+}
+
+// EffectiveConfigCommand returns the cmd that asks ssh to print the fully resolved config for host,
+// this is evaluated against the generated config file so Includes and Host * defaults are taken into account
+func EffectiveConfigCommand(host string, cfg config.Config, options ...string) *exec.Cmd {
+	return effectiveConfigCommand(host, cfg.GetSshConfigFilePath(), cfg, options...)
+}
+
+func effectiveConfigCommand(host, configFile string, cfg config.Config, options ...string) *exec.Cmd {
+	args := []string{
+		"-G",
+		"-F", configFile,
+	}
+	args = append(args, options...)
+	args = append(args, host)
+	return exec.Command(getSshExecutable(cfg), args...)
+}
+
+// GetEffectiveConfig runs ssh -G for host and marks the options that are stored for the host.
+// stored is a map of option key to stored values, keys are matched case-insensitively.
+// Note this function is blocking and should be run outside the ui thread
+func GetEffectiveConfig(host string, stored map[string][]string, cfg config.Config) ([]EffectiveOption, error) {
+	return runEffectiveConfig(EffectiveConfigCommand(host, cfg), stored)
+}
+
+// runEffectiveConfig runs the ssh -G cmd and parses what it printed, see GetEffectiveConfig
+func runEffectiveConfig(cmd *exec.Cmd, stored map[string][]string) ([]EffectiveOption, error) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, &EffectiveConfigError{Err: err, Stderr: msg}
+		}
+		return nil, err
+	}
+	return ParseEffectiveConfig(out, stored), nil
+}
+
+// EffectiveConfigError wraps a failed ssh -G run with what ssh printed to stderr
+type EffectiveConfigError struct {
+	Err    error
+	Stderr string
+}
+
+func (e *EffectiveConfigError) Error() string {
+	return e.Err.Error() + ": " + e.Stderr
+}
+
+func (e *EffectiveConfigError) Unwrap() error {
+	return e.Err
+}
+
+// ParseEffectiveConfig parses the output of ssh -G, options that ssh prints more than once
+// (IdentityFile, LocalForward, ...) are kept as separate entries in the order ssh printed them
+func ParseEffectiveConfig(output []byte, stored map[string][]string) []EffectiveOption {
+	lowerStored := make(map[string][]string, len(stored))
+	for k, v := range stored {
+		lowerStored[strings.ToLower(k)] = append(lowerStored[strings.ToLower(k)], v...)
+	}
+	canonical := make(map[string]string)
+	for _, opt := range GetListOfAcceptableOptions() {
+		canonical[strings.ToLower(opt)] = opt
+	}
+	opts := make([]EffectiveOption, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(line, " ")
+		lowerKey := strings.ToLower(key)
+		name, ok := canonical[lowerKey]
+		if !ok {
+			name = lowerKey
+		}
+		opts = append(opts, EffectiveOption{
+			Key:         name,
+			Value:       strings.TrimSpace(value),
+			StoredValue: lowerStored[lowerKey],
+		})
+	}
+	return opts
+}
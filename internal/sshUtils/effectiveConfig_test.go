@@ -0,0 +1,59 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/config"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseEffectiveConfig(t *testing.T) {
+	output := []byte("user root\nhostname 10.0.0.5\nport 22\nidentityfile ~/.ssh/id_rsa\nidentityfile ~/.ssh/id_ed25519\nforwardagent no\n")
+	stored := map[string][]string{
+		"User":     {"deploy"},
+		"HostName": {"10.0.0.5"},
+	}
+	opts := ParseEffectiveConfig(output, stored)
+	if len(opts) != 6 {
+		t.Fatalf("expected 6 options but got %d", len(opts))
+	}
+	if opts[0].Key != "User" || !opts[0].Stored() || !opts[0].Overridden() {
+		t.Fatalf("User should be stored and overridden, got %+v", opts[0])
+	}
+	if opts[1].Key != "HostName" || !opts[1].Stored() || opts[1].Overridden() {
+		t.Fatalf("HostName should be stored and not overridden, got %+v", opts[1])
+	}
+	if opts[3].Key != "IdentityFile" || opts[4].Key != "IdentityFile" {
+		t.Fatalf("multi valued options should be kept as separate entries")
+	}
+	if opts[5].Key != "forwardagent" || opts[5].Stored() {
+		t.Fatalf("unknown options should keep their lowercase name and not be stored, got %+v", opts[5])
+	}
+}
+
+func TestGetEffectiveConfig(t *testing.T) {
+	if _, err := exec.LookPath("ssh"); err != nil {
+		t.Skip("ssh is not installed")
+	}
+	configFile := filepath.Join(t.TempDir(), "config")
+	content := "Host *\n  User fallback\nHost effective-test\n  HostName 127.0.0.1\n  User stored\n  Port 2222\n"
+	if err := os.WriteFile(configFile, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write temp ssh config: %v", err)
+	}
+	cmd := effectiveConfigCommand("effective-test", configFile, config.Config{})
+	opts, err := runEffectiveConfig(cmd, map[string][]string{"User": {"stored"}, "Port": {"2222"}})
+	if err != nil {
+		t.Fatalf("failed to get effective config: %v", err)
+	}
+	found := map[string]EffectiveOption{}
+	for _, opt := range opts {
+		found[opt.Key] = opt
+	}
+	if user := found["User"]; user.Value != "fallback" || !user.Overridden() {
+		t.Fatalf("Host * block should win over the stored user, got %+v", user)
+	}
+	if port := found["Port"]; port.Value != "2222" || port.Overridden() {
+		t.Fatalf("stored port should be used, got %+v", port)
+	}
+}
@@ -0,0 +1,221 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/config"
+	"andrew/sshman/internal/ping"
+	"andrew/sshman/internal/sqlite"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"text/tabwriter"
+	"time"
+)
+
+// DefaultCheckTimeout bounds probing a single hop of a host during a health check
+const DefaultCheckTimeout = 5 * time.Second
+
+var errNotChecked = errors.New("not checked, the check was canceled")
+
+// HealthCheck is the outcome of probing a host the way ssh reaches it
+type HealthCheck struct {
+	Host          string
+	Up            bool
+	Addr          string // the address that answered
+	Latency       time.Duration
+	ServerVersion string
+	HostKey       string
+	FailedHop     string // the jump host the route broke at
+	Err           error  // why the host is down
+	Duration      time.Duration
+}
+
+// CheckHosts probes every host of selected through its ProxyJump chain, see ProbeRoute, concurrency hosts at a
+// time. hosts are every stored host so jump hosts can be resolved. A host is up when its port answers, and
+// answers like an ssh server unless the tcp probe is configured. Results are in the order of selected, hosts
+// not reached before ctx was canceled are reported down. This is blocking
+func CheckHosts(ctx context.Context, selected []sqlite.Host, hosts []sqlite.Host, timeout time.Duration, concurrency int, cfg config.Config) []HealthCheck {
+	checks := make([]HealthCheck, len(selected))
+	for i, host := range selected {
+		checks[i] = HealthCheck{Host: host.Host, Err: errNotChecked}
+	}
+	probe := cfg.GetPingProbe()
+	indexes := make([]int, len(selected))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	results := make(chan HealthCheck)
+	go func() {
+		ping.Scan(ctx, indexes, concurrency, func(i int) HealthCheck {
+			return checkHost(ctx, selected[i], hosts, probe, timeout, cfg)
+		}, results)
+		close(results)
+	}()
+	for check := range results {
+		checks[slices.IndexFunc(selected, func(h sqlite.Host) bool { return h.Host == check.Host })] = check
+	}
+	return checks
+}
+
+func checkHost(ctx context.Context, host sqlite.Host, hosts []sqlite.Host, probe string, timeout time.Duration, cfg config.Config) HealthCheck {
+	start := time.Now()
+	check := HealthCheck{Host: host.Host}
+	route, err := ProbeRoute(ctx, host, hosts, probe, timeout, cfg)
+	if err != nil {
+		check.Err = err
+		check.Duration = time.Since(start)
+		return check
+	}
+	target := route.Target()
+	switch {
+	case !target.Probed && route.FailedHop == "":
+		check.Err = route.Err
+	case !target.Probed:
+		check.FailedHop = route.FailedHop
+		check.Err = fmt.Errorf("route failed at %s: %w", route.FailedHop, route.Err)
+	default:
+		res := target.Result
+		check.Addr, check.Latency, check.ServerVersion, check.HostKey = res.Addr, res.Latency, res.ServerVersion, res.HostKey
+		check.Err = hopFailure(res, probe)
+		check.Up = check.Err == nil
+	}
+	check.Duration = time.Since(start)
+	return check
+}
+
+// HealthChecksDown reports whether any host of checks is down, used to set the exit code in CI
+func HealthChecksDown(checks []HealthCheck) bool {
+	return slices.ContainsFunc(checks, func(c HealthCheck) bool { return !c.Up })
+}
+
+// WriteHealthTable writes checks as an aligned table with a summary line
+func WriteHealthTable(w io.Writer, checks []HealthCheck) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "HOST\tSTATUS\tLATENCY\tADDRESS\tDETAIL")
+	up := 0
+	for _, c := range checks {
+		status, latency, detail := "down", "-", ""
+		if c.Up {
+			up++
+			status, latency, detail = "up", c.Latency.Round(time.Millisecond).String(), c.ServerVersion
+		} else if c.Err != nil {
+			detail = c.Err.Error()
+		}
+		addr := c.Addr
+		if addr == "" {
+			addr = "-"
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", c.Host, status, latency, addr, detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "\n%d of %d hosts up\n", up, len(checks))
+	return err
+}
+
+type healthEntry struct {
+	Host          string  `json:"host"`
+	Up            bool    `json:"up"`
+	Addr          string  `json:"address,omitempty"`
+	LatencyMs     float64 `json:"latency_ms,omitempty"`
+	ServerVersion string  `json:"server_version,omitempty"`
+	HostKey       string  `json:"host_key,omitempty"`
+	FailedHop     string  `json:"failed_hop,omitempty"`
+	Error         string  `json:"error,omitempty"`
+}
+
+type healthReport struct {
+	Hosts []healthEntry `json:"hosts"`
+	Up    int           `json:"up"`
+	Down  int           `json:"down"`
+}
+
+// WriteHealthJSON writes checks as a json document with the number of hosts up and down
+func WriteHealthJSON(w io.Writer, checks []HealthCheck) error {
+	r := healthReport{Hosts: make([]healthEntry, 0, len(checks))}
+	for _, c := range checks {
+		entry := healthEntry{
+			Host:          c.Host,
+			Up:            c.Up,
+			Addr:          c.Addr,
+			ServerVersion: c.ServerVersion,
+			HostKey:       c.HostKey,
+			FailedHop:     c.FailedHop,
+		}
+		if c.Up {
+			r.Up++
+			entry.LatencyMs = float64(c.Latency.Microseconds()) / 1000
+		} else {
+			r.Down++
+		}
+		if c.Err != nil {
+			entry.Error = c.Err.Error()
+		}
+		r.Hosts = append(r.Hosts, entry)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(r)
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Output    string        `xml:"system-out,omitempty"`
+}
+
+type junitSuite struct {
+	XMLName  xml.Name    `xml:"testsuite"`
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+// WriteHealthJUnit writes checks as a JUnit XML test suite with a test case per host, down hosts are failures
+func WriteHealthJUnit(w io.Writer, checks []HealthCheck) error {
+	suite := junitSuite{Name: "sshman check", Tests: len(checks)}
+	var total time.Duration
+	for _, c := range checks {
+		total = max(total, c.Duration) // hosts are checked in parallel
+		tc := junitCase{Name: c.Host, ClassName: "sshman.check", Time: junitSeconds(c.Duration)}
+		if c.Up {
+			tc.Output = fmt.Sprintf("%s answered in %v %s", c.Addr, c.Latency.Round(time.Millisecond), c.ServerVersion)
+		} else {
+			suite.Failures++
+			message := "host is down"
+			if c.Err != nil {
+				message = c.Err.Error()
+			}
+			tc.Failure = &junitFailure{Message: message, Text: message}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+	suite.Time = junitSeconds(total)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func junitSeconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
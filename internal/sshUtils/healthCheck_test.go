@@ -0,0 +1,106 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/config"
+	"andrew/sshman/internal/sqlite"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// bannerListener answers every connection with an ssh identification banner
+func bannerListener(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = conn.Write([]byte("SSH-2.0-OpenSSH_9.6\r\n"))
+			_ = conn.Close()
+		}
+	}()
+	return strconv.Itoa(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestCheckHosts(t *testing.T) {
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	closedPort := strconv.Itoa(closed.Addr().(*net.TCPAddr).Port)
+	_ = closed.Close()
+	hosts := []sqlite.Host{
+		routeHost("up", "127.0.0.1", "Port", bannerListener(t)),
+		routeHost("down", "127.0.0.1", "Port", closedPort),
+		routeHost("loop", "127.0.0.1", "ProxyJump", "loop"),
+	}
+	checks := CheckHosts(context.Background(), hosts, hosts, time.Second, 2, config.Config{})
+	if len(checks) != 3 || checks[0].Host != "up" || checks[1].Host != "down" {
+		t.Fatalf("checks should keep the order of the hosts, got %+v", checks)
+	}
+	if !checks[0].Up || checks[0].ServerVersion != "OpenSSH_9.6" {
+		t.Fatalf("expected up to be up, got %+v", checks[0])
+	}
+	if checks[1].Up || checks[1].Err == nil || checks[2].Up {
+		t.Fatalf("expected down and loop to be down, got %+v %+v", checks[1], checks[2])
+	}
+	if !HealthChecksDown(checks) || HealthChecksDown(checks[:1]) {
+		t.Fatal("HealthChecksDown should only report the down hosts")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, check := range CheckHosts(ctx, hosts, hosts, time.Second, 1, config.Config{}) {
+		if check.Up || check.Err == nil {
+			t.Fatalf("hosts not checked before the check was canceled should be down, got %+v", check)
+		}
+	}
+}
+
+func TestWriteHealthReports(t *testing.T) {
+	checks := []HealthCheck{
+		{Host: "web", Up: true, Addr: "10.0.0.1:22", Latency: 12 * time.Millisecond, ServerVersion: "OpenSSH_9.6", Duration: 20 * time.Millisecond},
+		{Host: "db", FailedHop: "bastion", Err: errors.New("route failed at bastion: connection refused"), Duration: time.Second},
+	}
+	var out bytes.Buffer
+	if err := WriteHealthTable(&out, checks); err != nil {
+		t.Fatalf("failed to write table: %v", err)
+	}
+	if !strings.Contains(out.String(), "web   up") || !strings.Contains(out.String(), "1 of 2 hosts up") {
+		t.Fatalf("unexpected table %q", out.String())
+	}
+
+	out.Reset()
+	if err := WriteHealthJSON(&out, checks); err != nil {
+		t.Fatalf("failed to write json: %v", err)
+	}
+	var report healthReport
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil || report.Up != 1 || report.Down != 1 || report.Hosts[1].FailedHop != "bastion" {
+		t.Fatalf("unexpected json report %s %v", out.String(), err)
+	}
+
+	out.Reset()
+	if err := WriteHealthJUnit(&out, checks); err != nil {
+		t.Fatalf("failed to write junit: %v", err)
+	}
+	var suite junitSuite
+	if err := xml.Unmarshal(out.Bytes(), &suite); err != nil {
+		t.Fatalf("junit report is not valid xml: %v", err)
+	}
+	if suite.Tests != 2 || suite.Failures != 1 || suite.Cases[0].Failure != nil || suite.Cases[1].Failure == nil || suite.Time != "1.000" {
+		t.Fatalf("unexpected junit report %s", out.String())
+	}
+}
@@ -0,0 +1,231 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/config"
+	"andrew/sshman/internal/sqlite"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultHookWait bounds a wait hook that does not give a timeout
+	DefaultHookWait = 2 * time.Minute
+	// hookWaitInterval is the pause between the pings of a wait hook
+	hookWaitInterval = 2 * time.Second
+	// wakeOnLanTarget is where magic packets are sent when the hook does not give an address, port 9 is discard
+	wakeOnLanTarget = "255.255.255.255:9"
+)
+
+// ParseHook parses a hook of host written as "<pre|post> <wol|wait|run> [arg]", ie "pre wol aa:bb:cc:dd:ee:ff",
+// "pre wait 2m" or "post run notify-send %n"
+func ParseHook(host, spec string) (sqlite.Hook, error) {
+	fields := strings.Fields(spec)
+	if len(fields) < 2 {
+		return sqlite.Hook{}, fmt.Errorf("hook %q should be <pre|post> <wol|wait|run> [arg]", spec)
+	}
+	hook := sqlite.Hook{
+		Host:   host,
+		Stage:  sqlite.HookStage(strings.ToLower(fields[0])),
+		Action: sqlite.HookAction(strings.ToLower(fields[1])),
+	}
+	// the command of a run hook is kept as written
+	_, rest, _ := strings.Cut(strings.TrimSpace(spec), fields[0])
+	_, hook.Arg, _ = strings.Cut(rest, fields[1])
+	hook.Arg = strings.TrimSpace(hook.Arg)
+	return hook, ValidateHook(hook)
+}
+
+// ValidateHook checks the stage, action and argument of hook
+func ValidateHook(hook sqlite.Hook) error {
+	if hook.Stage != sqlite.HookPreConnect && hook.Stage != sqlite.HookPostDisconnect {
+		return fmt.Errorf("unknown hook stage %q, expected pre or post", hook.Stage)
+	}
+	switch hook.Action {
+	case sqlite.HookWakeOnLan:
+		_, _, err := parseWakeOnLan(hook.Arg)
+		return err
+	case sqlite.HookWait:
+		_, err := hookWaitTimeout(hook.Arg)
+		return err
+	case sqlite.HookRun:
+		if hook.Arg == "" {
+			return errors.New("run hook needs a command")
+		}
+		_, err := ExpandTokens(hook.Arg, TokenContext{})
+		return err
+	}
+	return fmt.Errorf("unknown hook action %q, expected wol, wait or run", hook.Action)
+}
+
+// HookString writes hook the way ParseHook reads it
+func HookString(hook sqlite.Hook) string {
+	return strings.TrimSpace(fmt.Sprintf("%s %s %s", hook.Stage, hook.Action, hook.Arg))
+}
+
+// HooksOf returns the hooks of stage in the order they run
+func HooksOf(hooks []sqlite.Hook, stage sqlite.HookStage) []sqlite.Hook {
+	staged := make([]sqlite.Hook, 0, len(hooks))
+	for _, hook := range hooks {
+		if hook.Stage == stage {
+			staged = append(staged, hook)
+		}
+	}
+	return staged
+}
+
+// parseWakeOnLan reads the mac address of a wol hook and the address its packet is sent to, written as
+// "<mac> [broadcast[:port]]"
+func parseWakeOnLan(arg string) (net.HardwareAddr, string, error) {
+	fields := strings.Fields(arg)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, "", errors.New("wol hook should be <mac> [broadcast[:port]]")
+	}
+	mac, err := net.ParseMAC(fields[0])
+	if err != nil || len(mac) != 6 {
+		return nil, "", fmt.Errorf("invalid mac address %q", fields[0])
+	}
+	target := wakeOnLanTarget
+	if len(fields) == 2 {
+		target = fields[1]
+		if _, _, err = net.SplitHostPort(target); err != nil {
+			target = net.JoinHostPort(target, "9")
+		}
+		if host, _, _ := net.SplitHostPort(target); net.ParseIP(host) == nil {
+			return nil, "", fmt.Errorf("invalid broadcast address %q", fields[1])
+		}
+	}
+	return mac, target, nil
+}
+
+// MagicPacket builds the Wake-on-LAN packet waking mac, six 0xff bytes followed by mac sixteen times
+func MagicPacket(mac net.HardwareAddr) []byte {
+	packet := bytes.Repeat([]byte{0xff}, 6)
+	for range 16 {
+		packet = append(packet, mac...)
+	}
+	return packet
+}
+
+// SendWakeOnLan sends the magic packet of a wol hook argument, see parseWakeOnLan
+func SendWakeOnLan(ctx context.Context, arg string) error {
+	mac, target, err := parseWakeOnLan(arg)
+	if err != nil {
+		return err
+	}
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "udp", target)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", target, err)
+	}
+	defer conn.Close()
+	if _, err = conn.Write(MagicPacket(mac)); err != nil {
+		return fmt.Errorf("failed to send magic packet to %s: %w", target, err)
+	}
+	return nil
+}
+
+func hookWaitTimeout(arg string) (time.Duration, error) {
+	if arg == "" {
+		return DefaultHookWait, nil
+	}
+	timeout, err := time.ParseDuration(arg)
+	if err != nil || timeout <= 0 {
+		return 0, fmt.Errorf("invalid wait timeout %q, expected a duration such as 2m", arg)
+	}
+	return timeout, nil
+}
+
+// WaitReachable pings host the way ssh reaches it, see ProbeRoute, until it answers or timeout passes. An attempt
+// is written to out every time the host did not answer
+func WaitReachable(ctx context.Context, host sqlite.Host, hosts []sqlite.Host, timeout time.Duration, cfg config.Config, out io.Writer) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	probe := cfg.GetPingProbe()
+	for attempt := 1; ; attempt++ {
+		route, err := ProbeRoute(ctx, host, hosts, probe, hookWaitInterval, cfg)
+		if err != nil {
+			return err
+		}
+		target := route.Target()
+		if res := target.Result; target.Probed && res.Reachable && (res.SSH || probe == config.PingProbeTCP) {
+			_, _ = fmt.Fprintf(out, "%s is up\n", host.Host)
+			return nil
+		}
+		_, _ = fmt.Fprintf(out, "%s is not up yet, attempt %d\n", host.Host, attempt)
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("%s did not come up within %v", host.Host, timeout)
+			}
+			return ctx.Err()
+		case <-time.After(hookWaitInterval):
+		}
+	}
+}
+
+// HookCommand returns the command of a run hook with the tokens of host expanded as quoted words, see
+// ExpandShellTokens. The host is also passed in the SSHMAN_HOST, SSHMAN_HOSTNAME, SSHMAN_PORT, SSHMAN_USER and SSHMAN_STAGE environment variables.
+// The command is killed once ctx is canceled
+func HookCommand(ctx context.Context, hook sqlite.Hook, host sqlite.Host) (*exec.Cmd, error) {
+	tokens := NewTokenContext(host)
+	command, err := ExpandShellTokens(hook.Arg, tokens)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"SSHMAN_HOST="+host.Host,
+		"SSHMAN_HOSTNAME="+tokens.HostName,
+		"SSHMAN_PORT="+tokens.Port,
+		"SSHMAN_USER="+tokens.RemoteUser,
+		"SSHMAN_STAGE="+string(hook.Stage),
+	)
+	cmd.WaitDelay = time.Second
+	return cmd, nil
+}
+
+// RunHooks runs the hooks of stage of host in order, progress and the output of commands are written to out.
+// It stops at the first hook that fails, hosts are the stored hosts wait hooks resolve the route of host with.
+// This is blocking and should be run outside the ui thread
+func RunHooks(ctx context.Context, hooks []sqlite.Hook, stage sqlite.HookStage, host sqlite.Host, hosts []sqlite.Host, cfg config.Config, out io.Writer) error {
+	for _, hook := range HooksOf(hooks, stage) {
+		_, _ = fmt.Fprintf(out, "> %s\n", HookString(hook))
+		if err := runHook(ctx, hook, host, hosts, cfg, out); err != nil {
+			return fmt.Errorf("hook %q failed: %w", HookString(hook), err)
+		}
+	}
+	return nil
+}
+
+func runHook(ctx context.Context, hook sqlite.Hook, host sqlite.Host, hosts []sqlite.Host, cfg config.Config, out io.Writer) error {
+	switch hook.Action {
+	case sqlite.HookWakeOnLan:
+		return SendWakeOnLan(ctx, hook.Arg)
+	case sqlite.HookWait:
+		timeout, err := hookWaitTimeout(hook.Arg)
+		if err != nil {
+			return err
+		}
+		return WaitReachable(ctx, host, hosts, timeout, cfg, out)
+	case sqlite.HookRun:
+		cmd, err := HookCommand(ctx, hook, host)
+		if err != nil {
+			return err
+		}
+		cmd.Stdout = out
+		cmd.Stderr = out
+		if err = cmd.Run(); ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return ValidateHook(hook)
+}
@@ -0,0 +1,95 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/config"
+	"andrew/sshman/internal/sqlite"
+	"bytes"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHook(t *testing.T) {
+	hook, err := ParseHook("lab", "pre wol aa:bb:cc:dd:ee:ff 192.168.1.255")
+	if err != nil || hook.Stage != sqlite.HookPreConnect || hook.Action != sqlite.HookWakeOnLan || hook.Arg != "aa:bb:cc:dd:ee:ff 192.168.1.255" {
+		t.Fatalf("unexpected hook %+v %v", hook, err)
+	}
+	if hook, err = ParseHook("lab", "post run  echo  %n  done"); err != nil || hook.Arg != "echo  %n  done" {
+		t.Fatalf("the command of a run hook should be kept as written, got %+v %v", hook, err)
+	}
+	if HookString(hook) != "post run echo  %n  done" {
+		t.Fatalf("unexpected hook string %q", HookString(hook))
+	}
+	for _, spec := range []string{"pre", "during wait", "pre sleep", "pre wol zz:bb", "pre wait soon", "post run", "pre run echo %x"} {
+		if _, err = ParseHook("lab", spec); err == nil {
+			t.Fatalf("expected %q to be rejected", spec)
+		}
+	}
+	if _, target, _ := parseWakeOnLan("aa:bb:cc:dd:ee:ff 10.0.0.255:7"); target != "10.0.0.255:7" {
+		t.Fatalf("the port of the broadcast address should be kept, got %s", target)
+	}
+}
+
+func TestMagicPacket(t *testing.T) {
+	mac, _ := net.ParseMAC("aa:bb:cc:dd:ee:ff")
+	packet := MagicPacket(mac)
+	if len(packet) != 102 || !bytes.Equal(packet[:6], bytes.Repeat([]byte{0xff}, 6)) || !bytes.Equal(packet[96:], mac) {
+		t.Fatalf("unexpected magic packet %x", packet)
+	}
+}
+
+func TestRunHooks(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+	host := routeHost("lab", "10.0.0.9", "User", "admin")
+	hooks := []sqlite.Hook{
+		{Host: "lab", Stage: sqlite.HookPreConnect, Action: sqlite.HookWakeOnLan, Arg: "aa:bb:cc:dd:ee:ff " + listener.LocalAddr().String()},
+		{Host: "lab", Stage: sqlite.HookPreConnect, Action: sqlite.HookRun, Arg: `echo %r@%h "$SSHMAN_STAGE"`},
+		{Host: "lab", Stage: sqlite.HookPostDisconnect, Action: sqlite.HookRun, Arg: "echo post"},
+	}
+	var out bytes.Buffer
+	if err = RunHooks(context.Background(), hooks, sqlite.HookPreConnect, host, nil, config.Config{}, &out); err != nil {
+		t.Fatalf("failed to run hooks: %v", err)
+	}
+	packet := make([]byte, 200)
+	_ = listener.SetReadDeadline(time.Now().Add(time.Second))
+	if n, _, err := listener.ReadFrom(packet); err != nil || n != 102 {
+		t.Fatalf("expected a magic packet, read %d bytes %v", n, err)
+	}
+	if !strings.Contains(out.String(), "admin@10.0.0.9 pre") || strings.Contains(out.String(), "post\n") {
+		t.Fatalf("only the pre connect hooks should run with the host expanded, got %q", out.String())
+	}
+
+	out.Reset()
+	evil := routeHost("evil", "10.0.0.9", "User", "$(echo pwned);echo 'x")
+	quoted := []sqlite.Hook{{Host: "evil", Stage: sqlite.HookPreConnect, Action: sqlite.HookRun, Arg: "echo %r"}}
+	if err = RunHooks(context.Background(), quoted, sqlite.HookPreConnect, evil, nil, config.Config{}, &out); err != nil {
+		t.Fatalf("failed to run hooks: %v", err)
+	}
+	if !strings.Contains(out.String(), "$(echo pwned);echo 'x\n") {
+		t.Fatalf("expanded tokens should be passed as a single quoted word, got %q", out.String())
+	}
+
+	out.Reset()
+	failing := []sqlite.Hook{
+		{Host: "lab", Stage: sqlite.HookPostDisconnect, Action: sqlite.HookRun, Arg: "echo broken >&2; exit 3"},
+		{Host: "lab", Stage: sqlite.HookPostDisconnect, Action: sqlite.HookRun, Arg: "echo after"},
+	}
+	err = RunHooks(context.Background(), failing, sqlite.HookPostDisconnect, host, nil, config.Config{}, &out)
+	if err == nil || !strings.Contains(out.String(), "broken") || strings.Contains(out.String(), "after") {
+		t.Fatalf("hooks should stop at the first failure, got %q %v", out.String(), err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	slow := []sqlite.Hook{{Host: "lab", Stage: sqlite.HookPreConnect, Action: sqlite.HookRun, Arg: "sleep 5"}}
+	if err = RunHooks(ctx, slow, sqlite.HookPreConnect, host, nil, config.Config{}, &out); err == nil || time.Since(start) > 2*time.Second {
+		t.Fatalf("canceling should stop a running hook, got %v after %v", err, time.Since(start))
+	}
+}
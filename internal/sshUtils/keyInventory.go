@@ -0,0 +1,201 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/sqlite"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// SharedKeyThreshold is the number of hosts a key can be used by before the inventory flags it
+const SharedKeyThreshold = 3
+
+// InspectKey reads the private key at path and its .pub file if there is one. Keys protected by a
+// passphrase are read from the public half so no passphrase is needed
+func InspectKey(path string) (sqlite.Key, error) {
+	key := sqlite.Key{Path: path}
+	info, err := os.Stat(path)
+	if err != nil {
+		return key, err
+	}
+	key.CreatedAt = info.ModTime()
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return key, err
+	}
+	var pub ssh.PublicKey
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	var missing *ssh.PassphraseMissingError
+	switch {
+	case err == nil:
+		pub = signer.PublicKey()
+	case errors.As(err, &missing):
+		key.HasPassphrase = true
+		pub = missing.PublicKey // only set for keys in the openssh format
+	case isSecurityKeyFile(path):
+		// x/crypto can not parse security key files, they only reference the key kept on the authenticator.
+		// The public key is read from the .pub file below
+	default:
+		return key, fmt.Errorf("failed to parse key %s: %w", path, err)
+	}
+	if pubBytes, err := os.ReadFile(path + ".pub"); err == nil {
+		if filePub, comment, _, _, err := ssh.ParseAuthorizedKey(pubBytes); err == nil {
+			key.Comment = comment
+			if pub == nil {
+				pub = filePub
+			}
+		}
+	}
+	if pub == nil {
+		return key, fmt.Errorf("key %s is encrypted and has no public key file", path)
+	}
+	key.Algorithm = pub.Type()
+	key.Bits = publicKeyBits(pub)
+	key.Fingerprint = ssh.FingerprintSHA256(pub)
+	return key, nil
+}
+
+// isSecurityKeyFile reports whether the .pub file of the key at path holds a security key
+func isSecurityKeyFile(path string) bool {
+	pubBytes, err := os.ReadFile(path + ".pub")
+	if err != nil {
+		return false
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(pubBytes)
+	return err == nil && strings.HasPrefix(pub.Type(), "sk-")
+}
+
+func publicKeyBits(pub ssh.PublicKey) int {
+	cryptoPub, ok := pub.(ssh.CryptoPublicKey)
+	if !ok {
+		return 0
+	}
+	switch k := cryptoPub.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		return k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return 256
+	}
+	return 0
+}
+
+// KeyUsage maps the expanded IdentityFile paths of hosts to the aliases using them
+func KeyUsage(hosts []sqlite.Host) map[string][]string {
+	usage := make(map[string][]string)
+	for _, host := range hosts {
+		tokens := NewTokenContext(host)
+		for _, opt := range host.Options {
+			if !strings.EqualFold(opt.Key, "IdentityFile") || strings.EqualFold(strings.TrimSpace(opt.Value), "none") {
+				continue
+			}
+			path, err := ExpandOptionValue(opt.Key, opt.Value, tokens)
+			if err != nil {
+				continue
+			}
+			path = filepath.Clean(path)
+			if !slices.Contains(usage[path], host.Host) {
+				usage[path] = append(usage[path], host.Host)
+			}
+		}
+	}
+	return usage
+}
+
+// InventoryEntry is a key of the inventory along with the problems found with it
+type InventoryEntry struct {
+	sqlite.Key
+	Missing  bool  // the file no longer exists
+	Orphaned bool  // no host uses the key
+	Shared   bool  // used by at least SharedKeyThreshold hosts
+	Err      error // set if the file exists but could not be read
+}
+
+// Flags returns short descriptions of the problems with the key, empty if there are none
+func (e InventoryEntry) Flags() []string {
+	flags := make([]string, 0)
+	if e.Missing {
+		flags = append(flags, "missing")
+	}
+	if e.Orphaned {
+		flags = append(flags, "orphaned")
+	}
+	if e.Shared {
+		flags = append(flags, fmt.Sprintf("shared by %d hosts", len(e.Hosts)))
+	}
+	if e.Err != nil {
+		flags = append(flags, "unreadable")
+	}
+	return flags
+}
+
+// RefreshKeyInventory updates the inventory with the private keys in keyStore and every key referenced
+// by hosts, then records which hosts use which key. Keys that were recorded before but no longer exist
+// are kept and reported as missing
+func RefreshKeyInventory(dao *sqlite.KeyDao, hosts []sqlite.Host, keyStore string) ([]InventoryEntry, error) {
+	stored, err := dao.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	usage := KeyUsage(hosts)
+	paths := make([]string, 0)
+	for _, key := range stored {
+		paths = append(paths, key.Path)
+	}
+	paths = append(paths, keyStoreFiles(keyStore, func(name string) bool {
+		return !strings.HasSuffix(name, ".pub")
+	})...)
+	for path := range usage {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	paths = slices.Compact(paths)
+
+	readErrs := make(map[string]error)
+	for _, path := range paths {
+		key, err := InspectKey(path)
+		if err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				readErrs[path] = err
+			}
+			if slices.ContainsFunc(stored, func(k sqlite.Key) bool { return k.Path == path }) {
+				continue // keep what was recorded while the file could be read
+			}
+			if key.CreatedAt.IsZero() {
+				key.CreatedAt = time.Now()
+			}
+		}
+		if err = dao.Upsert(key); err != nil {
+			return nil, err
+		}
+	}
+	if err = dao.SetUsage(usage); err != nil {
+		return nil, err
+	}
+	keys, err := dao.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]InventoryEntry, 0, len(keys))
+	for _, key := range keys {
+		_, statErr := os.Stat(key.Path)
+		entries = append(entries, InventoryEntry{
+			Key:      key,
+			Missing:  errors.Is(statErr, os.ErrNotExist),
+			Orphaned: len(key.Hosts) == 0,
+			Shared:   len(key.Hosts) >= SharedKeyThreshold,
+			Err:      readErrs[key.Path],
+		})
+	}
+	return entries, nil
+}
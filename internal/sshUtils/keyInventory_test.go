@@ -0,0 +1,138 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/sqlite"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// writeTestKey writes an ed25519 key pair to path and path.pub, encrypted if passphrase is set
+func writeTestKey(t *testing.T, path, comment, passphrase string) ssh.PublicKey {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	var block *pem.Block
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(priv, comment)
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, comment, []byte(passphrase))
+	}
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	if err = os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		t.Fatalf("failed to write key: %v", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatalf("failed to convert public key: %v", err)
+	}
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))) + " " + comment + "\n"
+	if err = os.WriteFile(path+".pub", []byte(authorized), 0o644); err != nil {
+		t.Fatalf("failed to write public key: %v", err)
+	}
+	return sshPub
+}
+
+func TestInspectKey(t *testing.T) {
+	dir := t.TempDir()
+	plain := filepath.Join(dir, "plain")
+	pub := writeTestKey(t, plain, "sshman:web", "")
+	key, err := InspectKey(plain)
+	if err != nil {
+		t.Fatalf("failed to inspect key: %v", err)
+	}
+	if key.Algorithm != ssh.KeyAlgoED25519 || key.Bits != 256 || key.Fingerprint != ssh.FingerprintSHA256(pub) ||
+		key.Comment != "sshman:web" || key.HasPassphrase {
+		t.Fatalf("unexpected key %+v", key)
+	}
+	encrypted := filepath.Join(dir, "encrypted")
+	pub = writeTestKey(t, encrypted, "sshman:db", "secret")
+	key, err = InspectKey(encrypted)
+	if err != nil {
+		t.Fatalf("failed to inspect encrypted key: %v", err)
+	}
+	if !key.HasPassphrase || key.Fingerprint != ssh.FingerprintSHA256(pub) {
+		t.Fatalf("encrypted key should be read without its passphrase, got %+v", key)
+	}
+	if _, err = InspectKey(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Fatalf("expected not exist error for missing key, got %v", err)
+	}
+}
+
+func TestRefreshKeyInventory(t *testing.T) {
+	conn, err := sqlite.CreateAndLoadDB(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	defer conn.Close()
+	hostDao := sqlite.NewHostDao(conn)
+	dao := sqlite.NewKeyDao(conn)
+
+	store := t.TempDir()
+	shared := filepath.Join(store, "shared")
+	orphan := filepath.Join(store, "orphan")
+	writeTestKey(t, shared, "shared", "")
+	writeTestKey(t, orphan, "orphan", "")
+	missing := filepath.Join(store, "gone")
+	hosts := make([]sqlite.Host, 0)
+	for _, alias := range []string{"a", "b", "c"} {
+		host := sqlite.Host{Host: alias, CreatedAt: time.Now(), Options: []sqlite.HostOptions{
+			{Key: "IdentityFile", Value: shared, Host: alias},
+		}}
+		if alias == "a" {
+			// tokens are expanded before looking up the key
+			host.Options[0].Value = filepath.Join(store, "%n") + "/../shared"
+			host.Options = append(host.Options, sqlite.HostOptions{Key: "IdentityFile", Value: missing, Host: alias})
+		}
+		if err = hostDao.Insert(host); err != nil {
+			t.Fatalf("failed to insert host: %v", err)
+		}
+		hosts = append(hosts, host)
+	}
+
+	entries, err := RefreshKeyInventory(dao, hosts, store)
+	if err != nil {
+		t.Fatalf("failed to refresh inventory: %v", err)
+	}
+	byPath := make(map[string]InventoryEntry)
+	for _, e := range entries {
+		byPath[e.Path] = e
+	}
+	if e := byPath[orphan]; !e.Orphaned || e.Missing || e.Shared {
+		t.Fatalf("orphan key should only be flagged orphaned, got %+v", e)
+	}
+	if e := byPath[missing]; !e.Missing || !slices.Equal(e.Hosts, []string{"a"}) {
+		t.Fatalf("missing key should be flagged and used by a, got %+v", e)
+	}
+	if e := byPath[shared]; !e.Shared || e.Orphaned || len(e.Hosts) != 3 {
+		t.Fatalf("shared key should be used by every host, got %+v", e)
+	}
+	if len(byPath[shared].Flags()) != 1 {
+		t.Fatalf("shared key should have a single flag, got %v", byPath[shared].Flags())
+	}
+
+	// a recorded key keeps its details once its file is removed
+	if err = os.Remove(orphan); err != nil {
+		t.Fatalf("failed to remove key: %v", err)
+	}
+	entries, err = RefreshKeyInventory(dao, hosts, store)
+	if err != nil {
+		t.Fatalf("failed to refresh inventory: %v", err)
+	}
+	idx := slices.IndexFunc(entries, func(e InventoryEntry) bool { return e.Path == orphan })
+	if idx < 0 || !entries[idx].Missing || entries[idx].Fingerprint == "" {
+		t.Fatalf("removed key should be reported missing with its recorded details, got %+v", entries)
+	}
+}
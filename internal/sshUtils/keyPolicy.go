@@ -0,0 +1,136 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/config"
+	"andrew/sshman/internal/sqlite"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// keyMarkerPattern matches the hex tag and date newKeyMarker appends to generated key names,
+// GenKey adds _1 when the name is already taken
+var keyMarkerPattern = regexp.MustCompile(`_[0-9a-f]{6}_(\d{8})(_1)?$`)
+
+// KeyMarkerDate returns the generation date embedded in the name of a key generated by sshman
+func KeyMarkerDate(path string) (time.Time, bool) {
+	match := keyMarkerPattern.FindStringSubmatch(filepath.Base(path))
+	if match == nil {
+		return time.Time{}, false
+	}
+	date, err := time.ParseInLocation("20060102", match[1], time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return date, true
+}
+
+// KeyGenAlgorithm maps an ssh key type to the key generation algorithm names used in the config,
+// empty if the type is not one sshman generates
+func KeyGenAlgorithm(keyType string) string {
+	switch {
+	case keyType == ssh.KeyAlgoRSA:
+		return config.RSA
+	case strings.HasPrefix(keyType, "ecdsa-sha2-"):
+		return config.ECDSA
+	case keyType == ssh.KeyAlgoED25519:
+		return config.ED25519
+	case keyType == ssh.KeyAlgoSKECDSA256:
+		return config.ECDSA_SK
+	case keyType == ssh.KeyAlgoSKED25519:
+		return config.ED25519_SK
+	}
+	return ""
+}
+
+// CheckKeyPolicy returns why key breaks policy, empty if the key is fine. The age of a key is taken
+// from its generated name, falling back to key.CreatedAt for keys named differently
+func CheckKeyPolicy(key sqlite.Key, policy config.KeyPolicy, now time.Time) []string {
+	reasons := make([]string, 0)
+	algorithm := KeyGenAlgorithm(key.Algorithm)
+	if algorithm == "" {
+		return reasons
+	}
+	created, ok := KeyMarkerDate(key.Path)
+	if !ok {
+		created = key.CreatedAt
+	}
+	for name, age := range policy.MaxAge {
+		if !strings.EqualFold(name, algorithm) {
+			continue
+		}
+		maxAge, err := config.ParseKeyAge(age)
+		if err != nil {
+			continue // rejected when the config is validated
+		}
+		if keyAge := now.Sub(created); keyAge > maxAge {
+			reasons = append(reasons, fmt.Sprintf("%s key is %d days old, policy allows %s", algorithm, int(keyAge.Hours()/24), age))
+		}
+	}
+	if algorithm == config.RSA && policy.MinRSABits > 0 && key.Bits < policy.MinRSABits {
+		reasons = append(reasons, fmt.Sprintf("RSA key has %d bits, policy requires %d", key.Bits, policy.MinRSABits))
+	}
+	return reasons
+}
+
+// DueRotation is a key of a host that breaks the key policy
+type DueRotation struct {
+	Host    string
+	Key     string // IdentityFile value the key is stored under
+	Path    string // expanded path of the key
+	Reasons []string
+}
+
+// DueRotations checks the keys of hosts against the key policy of cfg. Only keys in the key store are
+// checked as those are the keys the rotate flow can replace
+func DueRotations(hosts []sqlite.Host, cfg config.Config, now time.Time) []DueRotation {
+	due := make([]DueRotation, 0)
+	policy := cfg.Ssh.KeyPolicy
+	if len(policy.MaxAge) == 0 && policy.MinRSABits == 0 {
+		return due
+	}
+	keyStore := filepath.Clean(cfg.GetKeyStorePath()) + string(filepath.Separator)
+	inspected := make(map[string]sqlite.Key)
+	for _, host := range hosts {
+		tokens := NewTokenContext(host)
+		for _, opt := range host.Options {
+			if !strings.EqualFold(opt.Key, "IdentityFile") {
+				continue
+			}
+			path, err := ExpandOptionValue(opt.Key, opt.Value, tokens)
+			if err != nil {
+				continue
+			}
+			path = filepath.Clean(path)
+			if !strings.HasPrefix(path, keyStore) {
+				continue
+			}
+			key, ok := inspected[path]
+			if !ok {
+				if key, err = InspectKey(path); err != nil {
+					continue // missing keys are reported by the inventory and lint
+				}
+				inspected[path] = key
+			}
+			if reasons := CheckKeyPolicy(key, policy, now); len(reasons) > 0 {
+				due = append(due, DueRotation{Host: host.Host, Key: opt.Value, Path: path, Reasons: reasons})
+			}
+		}
+	}
+	return due
+}
+
+// DueHosts returns the aliases of the hosts in due in the order they appear, due is grouped by host as returned by DueRotations
+func DueHosts(due []DueRotation) []string {
+	hosts := make([]string, 0)
+	for _, d := range due {
+		if len(hosts) == 0 || hosts[len(hosts)-1] != d.Host {
+			hosts = append(hosts, d.Host)
+		}
+	}
+	return hosts
+}
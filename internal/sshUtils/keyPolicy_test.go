@@ -0,0 +1,81 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/config"
+	"andrew/sshman/internal/sqlite"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestKeyMarkerDate(t *testing.T) {
+	date, ok := KeyMarkerDate("/keys/web_sshman_a1b2c3_20250102_1")
+	if !ok || date.Year() != 2025 || date.Month() != time.January || date.Day() != 2 {
+		t.Fatalf("expected 2025-01-02, got %v %v", date, ok)
+	}
+	if _, ok = KeyMarkerDate("/keys/id_ed25519"); ok {
+		t.Fatalf("keys without a marker should have no date")
+	}
+}
+
+func TestCheckKeyPolicy(t *testing.T) {
+	now := time.Date(2026, time.June, 1, 0, 0, 0, 0, time.Local)
+	policy := config.KeyPolicy{MaxAge: map[string]string{"ed25519": "30d", "RSA": "1y"}, MinRSABits: 3072}
+	fresh := sqlite.Key{Path: "/keys/web_a1b2c3_20260520", Algorithm: ssh.KeyAlgoED25519, CreatedAt: now.AddDate(-2, 0, 0)}
+	if reasons := CheckKeyPolicy(fresh, policy, now); len(reasons) != 0 {
+		t.Fatalf("the name date should take precedence over the creation date, got %v", reasons)
+	}
+	old := sqlite.Key{Path: "/keys/id_ed25519", Algorithm: ssh.KeyAlgoED25519, CreatedAt: now.AddDate(0, -2, 0)}
+	if reasons := CheckKeyPolicy(old, policy, now); len(reasons) != 1 {
+		t.Fatalf("expected old key to be due, got %v", reasons)
+	}
+	small := sqlite.Key{Path: "/keys/id_rsa", Algorithm: ssh.KeyAlgoRSA, Bits: 2048, CreatedAt: now}
+	if reasons := CheckKeyPolicy(small, policy, now); len(reasons) != 1 {
+		t.Fatalf("expected small rsa key to be due, got %v", reasons)
+	}
+	unknown := sqlite.Key{Path: "/keys/broken", CreatedAt: now.AddDate(-5, 0, 0)}
+	if reasons := CheckKeyPolicy(unknown, policy, now); len(reasons) != 0 {
+		t.Fatalf("unreadable keys should not be due, got %v", reasons)
+	}
+	if _, err := config.ParseKeyAge("12x"); err == nil {
+		t.Fatalf("expected invalid key age to fail")
+	}
+}
+
+func TestDueRotations(t *testing.T) {
+	store := t.TempDir()
+	outside := t.TempDir()
+	stale := filepath.Join(store, "stale")
+	writeTestKey(t, stale, "stale", "")
+	if err := os.Chtimes(stale, time.Now(), time.Now().AddDate(0, -3, 0)); err != nil {
+		t.Fatalf("failed to age key: %v", err)
+	}
+	current := filepath.Join(store, "web_a1b2c3_"+time.Now().Format("20060102"))
+	writeTestKey(t, current, "current", "")
+	external := filepath.Join(outside, "external")
+	writeTestKey(t, external, "external", "")
+	if err := os.Chtimes(external, time.Now(), time.Now().AddDate(-1, 0, 0)); err != nil {
+		t.Fatalf("failed to age key: %v", err)
+	}
+
+	hosts := []sqlite.Host{
+		{Host: "a", Options: []sqlite.HostOptions{{Key: "IdentityFile", Value: stale}, {Key: "IdentityFile", Value: current}}},
+		{Host: "b", Options: []sqlite.HostOptions{{Key: "IdentityFile", Value: external}}},
+		{Host: "c", Options: []sqlite.HostOptions{{Key: "identityfile", Value: filepath.Join(store, "%n") + "/../stale"}}},
+	}
+	cfg := config.Config{Ssh: config.SSH{KeyPath: store, KeyPolicy: config.KeyPolicy{MaxAge: map[string]string{"ED25519": "30d"}}}}
+	due := DueRotations(hosts, cfg, time.Now())
+	if len(due) != 2 || due[0].Key != stale || due[1].Key != hosts[2].Options[0].Value {
+		t.Fatalf("expected the stale key of a and c to be due, got %+v", due)
+	}
+	if got := DueHosts(due); !slices.Equal(got, []string{"a", "c"}) {
+		t.Fatalf("expected hosts a and c, got %v", got)
+	}
+	if due = DueRotations(hosts, config.Config{Ssh: config.SSH{KeyPath: store}}, time.Now()); len(due) != 0 {
+		t.Fatalf("nothing should be due without a policy, got %+v", due)
+	}
+}
@@ -2,10 +2,13 @@ package sshUtils
 
 import (
 	"andrew/sshman/internal/config"
+	"bytes"
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -13,8 +16,6 @@ import (
 	"strings"
 	"time"
 	"unicode"
-
-	"github.com/adrg/xdg"
 )
 
 const (
@@ -28,25 +29,74 @@ type KeyPair struct {
 
 // todo add code here to automatically gen keys etc
 
-// GenKey takes host that the key is generated for a keyType find under config package RSA ECDSA ED25519
+// KeyGenOptions are the options of security key generation, the other key types ignore them
+type KeyGenOptions struct {
+	Resident       bool // store the key on the authenticator so it can be loaded on other machines with ssh-keygen -K
+	VerifyRequired bool // require the authenticator PIN or a biometric on every login on top of a touch
+}
+
+var (
+	// ErrNoAuthenticator is returned when ssh-keygen could not find a FIDO authenticator to generate a security key on
+	ErrNoAuthenticator = errors.New("no FIDO authenticator found, plug in a security key and try again")
+	// ErrSecurityKeyUnsupported is returned when ssh-keygen or the authenticator can not generate the requested security key
+	ErrSecurityKeyUnsupported = errors.New("security key generation is not supported")
+)
+
+// runKeygen runs ssh-keygen with args until it exits or ctx is canceled, tests replace it so no authenticator is
+// needed
+var runKeygen = func(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	exe := exec.CommandContext(ctx, "ssh-keygen", args...)
+	exe.Stdin = stdin
+	exe.Stdout = stdout
+	exe.Stderr = stderr
+	return exe.Run()
+}
+
+// GenKey takes host that the key is generated for a keyType find under config package RSA ECDSA ED25519 ECDSA-SK ED25519-SK
 // returns the generated key path (should be relative if cfg uses default path), returns non nill error if incorrect key type
-// or process error during the generation of the key. Note this function is blocking and should be run outside the ui thread
-func GenKey(host, keyType, password string, cfg config.Config) (KeyPair, error) {
+// or process error during the generation of the key. Note this function is blocking and should be run outside the ui thread.
+// Security keys are generated attached to the terminal of the process, the ui should use NewSecurityKeySession instead.
+// Canceling ctx kills ssh-keygen, the files it left behind are removed and the error of ctx is returned
+func GenKey(ctx context.Context, host, keyType, password string, opts KeyGenOptions, cfg config.Config) (KeyPair, error) {
 
 	switch keyType {
 	case config.RSA:
-		return genRSAKey(host, password, cfg)
+		return genRSAKey(ctx, host, password, cfg)
 	case config.ECDSA:
-		return genECDSAKey(host, password, cfg)
+		return genECDSAKey(ctx, host, password, cfg)
 	case config.ED25519:
-		return genED25519Key(host, password, cfg)
+		return genED25519Key(ctx, host, password, cfg)
+	case config.ECDSA_SK, config.ED25519_SK:
+		session, err := NewSecurityKeySession(host, keyType, password, opts, cfg)
+		if err != nil {
+			return KeyPair{}, err
+		}
+		session.ctx = ctx
+		session.SetStdin(os.Stdin)
+		session.SetStdout(os.Stdout)
+		session.SetStderr(os.Stderr)
+		if err = session.Run(); err != nil {
+			return KeyPair{}, err
+		}
+		return session.Keys, nil
 	default:
 		return KeyPair{}, errors.New("Key type given is not recognized")
 	}
 }
 
+// abortedKeygen returns err unless ctx was canceled, the key files left at path are removed then and the error of
+// ctx is returned instead
+func abortedKeygen(ctx context.Context, path string, err error) error {
+	if ctx.Err() == nil {
+		return err
+	}
+	_ = os.Remove(path)
+	_ = os.Remove(path + ".pub")
+	return ctx.Err()
+}
+
 // genRSA key function
-func genRSAKey(host string, password string, cfg config.Config) (KeyPair, error) {
+func genRSAKey(ctx context.Context, host string, password string, cfg config.Config) (KeyPair, error) {
 	safeHost := sanitizeName(host)
 	// if slice not provided all keys valid
 	if len(cfg.Ssh.AcceptableKeyGenAlgorithms) > 0 {
@@ -57,12 +107,7 @@ func genRSAKey(host string, password string, cfg config.Config) (KeyPair, error)
 		}
 	}
 	// call ssh key gen function add host string as comment and name key after host
-	var keyGenPath string
-	if cfg.Ssh.KeyPath == "" {
-		keyGenPath = filepath.Join(xdg.ConfigHome, config.DefaultAppStorePath, config.KeyStoreDir)
-	} else {
-		keyGenPath = cfg.Ssh.KeyPath
-	}
+	keyGenPath := cfg.GetKeyStorePath()
 	marker, err := newKeyMarker()
 	if err != nil {
 		return KeyPair{}, err
@@ -81,17 +126,20 @@ func genRSAKey(host string, password string, cfg config.Config) (KeyPair, error)
 		}
 		full_path += "_1"
 	}
-	args := []string{
-		"-t", "rsa",
-		"-f", full_path,
-		"-C", comment,
-		"-N", password,
-		"-b", "4096",
+	if cfg.Ssh.Native {
+		err = WriteKeyPair(full_path, config.RSA, comment, password)
+	} else {
+		args := []string{
+			"-t", "rsa",
+			"-f", full_path,
+			"-C", comment,
+			"-N", password,
+			"-b", "4096",
+		}
+		err = runKeygen(ctx, args, nil, nil, nil)
 	}
-	exe := exec.Command("ssh-keygen", args...)
-	err = exe.Run()
 	if err != nil {
-		return KeyPair{}, err
+		return KeyPair{}, abortedKeygen(ctx, full_path, err)
 	}
 	return KeyPair{
 		PubKey:     full_path + ".pub",
@@ -100,7 +148,7 @@ func genRSAKey(host string, password string, cfg config.Config) (KeyPair, error)
 }
 
 // genECDSAKey function
-func genECDSAKey(host string, password string, cfg config.Config) (KeyPair, error) {
+func genECDSAKey(ctx context.Context, host string, password string, cfg config.Config) (KeyPair, error) {
 	safeHost := sanitizeName(host)
 	// if slice not provided all keys valid
 	if len(cfg.Ssh.AcceptableKeyGenAlgorithms) > 0 {
@@ -111,12 +159,7 @@ func genECDSAKey(host string, password string, cfg config.Config) (KeyPair, erro
 		}
 	}
 	// call ssh key gen function add host string as comment and name key after host
-	var keyGenPath string
-	if cfg.Ssh.KeyPath == "" {
-		keyGenPath = filepath.Join(xdg.ConfigHome, config.DefaultAppStorePath, config.KeyStoreDir)
-	} else {
-		keyGenPath = cfg.Ssh.KeyPath
-	}
+	keyGenPath := cfg.GetKeyStorePath()
 	marker, err := newKeyMarker()
 	if err != nil {
 		return KeyPair{}, err
@@ -135,17 +178,20 @@ func genECDSAKey(host string, password string, cfg config.Config) (KeyPair, erro
 		}
 		full_path += "_1"
 	}
-	args := []string{
-		"-t", "ecdsa",
-		"-f", full_path,
-		"-C", comment,
-		"-N", password,
-		"-b", "521",
+	if cfg.Ssh.Native {
+		err = WriteKeyPair(full_path, config.ECDSA, comment, password)
+	} else {
+		args := []string{
+			"-t", "ecdsa",
+			"-f", full_path,
+			"-C", comment,
+			"-N", password,
+			"-b", "521",
+		}
+		err = runKeygen(ctx, args, nil, nil, nil)
 	}
-	exe := exec.Command("ssh-keygen", args...)
-	err = exe.Run()
 	if err != nil {
-		return KeyPair{}, err
+		return KeyPair{}, abortedKeygen(ctx, full_path, err)
 	}
 	return KeyPair{
 		PubKey:     full_path + ".pub",
@@ -154,7 +200,7 @@ func genECDSAKey(host string, password string, cfg config.Config) (KeyPair, erro
 }
 
 // genED25519Key function
-func genED25519Key(host string, password string, cfg config.Config) (KeyPair, error) {
+func genED25519Key(ctx context.Context, host string, password string, cfg config.Config) (KeyPair, error) {
 	safeHost := sanitizeName(host)
 	// if slice not provided all keys valid
 	if len(cfg.Ssh.AcceptableKeyGenAlgorithms) > 0 {
@@ -165,12 +211,7 @@ func genED25519Key(host string, password string, cfg config.Config) (KeyPair, er
 		}
 	}
 	// call ssh key gen function add host string as comment and name key after host
-	var keyGenPath string
-	if cfg.Ssh.KeyPath == "" {
-		keyGenPath = filepath.Join(xdg.ConfigHome, config.DefaultAppStorePath, config.KeyStoreDir)
-	} else {
-		keyGenPath = cfg.Ssh.KeyPath
-	}
+	keyGenPath := cfg.GetKeyStorePath()
 	marker, err := newKeyMarker()
 	if err != nil {
 		return KeyPair{}, err
@@ -189,16 +230,19 @@ func genED25519Key(host string, password string, cfg config.Config) (KeyPair, er
 		}
 		full_path += "_1"
 	}
-	args := []string{
-		"-t", "ed25519",
-		"-f", full_path,
-		"-C", comment,
-		"-N", password,
+	if cfg.Ssh.Native {
+		err = WriteKeyPair(full_path, config.ED25519, comment, password)
+	} else {
+		args := []string{
+			"-t", "ed25519",
+			"-f", full_path,
+			"-C", comment,
+			"-N", password,
+		}
+		err = runKeygen(ctx, args, nil, nil, nil)
 	}
-	exe := exec.Command("ssh-keygen", args...)
-	err = exe.Run()
 	if err != nil {
-		return KeyPair{}, err
+		return KeyPair{}, abortedKeygen(ctx, full_path, err)
 	}
 	return KeyPair{
 		PubKey:     full_path + ".pub",
@@ -206,6 +250,135 @@ func genED25519Key(host string, password string, cfg config.Config) (KeyPair, er
 	}, nil
 }
 
+// SecurityKeySession generates a key on a FIDO authenticator with ssh-keygen, it satisfies tea.ExecCommand as
+// the authenticator has to be touched and may ask for its PIN on the terminal. Keys holds the generated key
+// once Run succeeds
+type SecurityKeySession struct {
+	Keys   KeyPair
+	ctx    context.Context // kills ssh-keygen once canceled, nil runs it until it exits
+	args   []string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// NewSecurityKeySession prepares the generation of a keyType security key for host, keyType is one of
+// ECDSA-SK or ED25519-SK. The key file only references the authenticator so cfg.Ssh.Native does not apply
+func NewSecurityKeySession(host, keyType, password string, opts KeyGenOptions, cfg config.Config) (*SecurityKeySession, error) {
+	var sshType string
+	switch keyType {
+	case config.ECDSA_SK:
+		sshType = "ecdsa-sk"
+	case config.ED25519_SK:
+		sshType = "ed25519-sk"
+	default:
+		return nil, fmt.Errorf("%s is not a security key type", keyType)
+	}
+	safeHost := sanitizeName(host)
+	// if slice not provided all keys valid
+	if len(cfg.Ssh.AcceptableKeyGenAlgorithms) > 0 {
+		if !slices.ContainsFunc(cfg.Ssh.AcceptableKeyGenAlgorithms, func(e string) bool {
+			return strings.EqualFold(e, keyType)
+		}) {
+			return nil, errors.New(keyType + " key generation is disabled in config")
+		}
+	}
+	keyGenPath := cfg.GetKeyStorePath()
+	marker, err := newKeyMarker()
+	if err != nil {
+		return nil, err
+	}
+	comment := config.AppName + ":" + safeHost + ":" + marker
+	// the prefix has no underscore so getKeyComment can still split the name
+	filename := sshType + "_" + safeHost + "_" + marker
+	full_path := filepath.Join(keyGenPath, filename)
+	if exist, err := doesFileExist(full_path); exist || err != nil {
+		if err != nil {
+			return nil, err
+		}
+		full_path += "_1"
+	} else if exist, err := doesFileExist(full_path + ".pub"); exist || err != nil {
+		if err != nil {
+			return nil, err
+		}
+		full_path += "_1"
+	}
+	args := []string{
+		"-t", sshType,
+		"-f", full_path,
+		"-C", comment,
+		"-N", password,
+	}
+	if opts.Resident {
+		// resident keys of the same application overwrite each other unless their user differs
+		user := safeHost + "_" + marker
+		if len(user) > 64 {
+			user = user[len(user)-64:]
+		}
+		args = append(args, "-O", "resident", "-O", "user="+user)
+	}
+	if opts.VerifyRequired {
+		args = append(args, "-O", "verify-required")
+	}
+	return &SecurityKeySession{
+		Keys: KeyPair{
+			PubKey:     full_path + ".pub",
+			PrivateKey: full_path,
+		},
+		args: args,
+	}, nil
+}
+
+// Run runs ssh-keygen, its output is checked to report a missing or unsupported authenticator clearly
+func (s *SecurityKeySession) Run() error {
+	var output bytes.Buffer
+	stderr := io.Writer(&output)
+	if s.stderr != nil {
+		stderr = io.MultiWriter(s.stderr, &output)
+	}
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := runKeygen(ctx, s.args, s.stdin, s.stdout, stderr); err != nil {
+		if ctx.Err() != nil {
+			return abortedKeygen(ctx, s.Keys.PrivateKey, err)
+		}
+		return securityKeyError(err, output.String())
+	}
+	return nil
+}
+
+func (s *SecurityKeySession) SetStdin(r io.Reader) {
+	s.stdin = r
+}
+
+func (s *SecurityKeySession) SetStdout(w io.Writer) {
+	s.stdout = w
+}
+
+func (s *SecurityKeySession) SetStderr(w io.Writer) {
+	s.stderr = w
+}
+
+// securityKeyError turns a failed ssh-keygen run into ErrNoAuthenticator or ErrSecurityKeyUnsupported when its
+// output says why
+func securityKeyError(err error, output string) error {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	detail := strings.TrimSpace(lines[len(lines)-1])
+	lower := strings.ToLower(output)
+	switch {
+	// older ssh-keygen versions report a missing device as an invalid format
+	case strings.Contains(lower, "device not found"), strings.Contains(lower, "invalid format"):
+		return fmt.Errorf("%w: %s", ErrNoAuthenticator, detail)
+	case strings.Contains(lower, "unknown key type"), strings.Contains(lower, "securitykeyprovider"):
+		return fmt.Errorf("%w, ssh-keygen needs OpenSSH 8.2 or newer built with FIDO support: %s", ErrSecurityKeyUnsupported, detail)
+	case strings.Contains(lower, "feature not supported"):
+		return fmt.Errorf("%w, the authenticator does not support resident keys or verify-required: %s", ErrSecurityKeyUnsupported, detail)
+	}
+	return fmt.Errorf("security key generation failed: %w", commandError(err, []byte(output)))
+}
+
 func doesFileExist(file string) (bool, error) {
 	_, err := os.Stat(file)
 	if err == nil {
@@ -269,7 +442,9 @@ func getKeyComment(keyPath string, cleanedHost string) (string, error) {
 // cfg is the config of the program
 // options are options the user passed in when calling the program, note each of these need to options need to be
 // prefixed with -o, ie "-o Port=22" would be a valid string
-func CopyKey(keyToCopy, host string, cfg config.Config, options ...string) *exec.Cmd {
+//
+// the command is killed once ctx is canceled
+func CopyKey(ctx context.Context, keyToCopy, host string, cfg config.Config, options ...string) *exec.Cmd {
 	configFilePath := cfg.GetSshConfigFilePath()
 	args := []string{
 		"-f",
@@ -278,7 +453,7 @@ func CopyKey(keyToCopy, host string, cfg config.Config, options ...string) *exec
 	}
 	args = append(args, options...)
 	args = append(args, host)
-	return exec.Command("ssh-copy-id", args...)
+	return exec.CommandContext(ctx, "ssh-copy-id", args...)
 }
 
 func generateShellScriptToRemoveOldKey(comment string) string {
@@ -301,7 +476,9 @@ func generateShellScriptToRemoveOldKey(comment string) string {
 	return script
 }
 
-func RemoveOldKeyFromRemoteServer(keyToRemove, host string, cfg config.Config, options ...string) (*exec.Cmd, error) {
+// RemoveOldKeyFromRemoteServer returns the ssh command removing the lines holding the comment of keyToRemove from the
+// authorized_keys of host, the command is killed once ctx is canceled
+func RemoveOldKeyFromRemoteServer(ctx context.Context, keyToRemove, host string, cfg config.Config, options ...string) (*exec.Cmd, error) {
 	sanitizedHost := sanitizeName(host)
 	comment, err := getKeyComment(keyToRemove, sanitizedHost)
 	if err != nil {
@@ -316,6 +493,6 @@ func RemoveOldKeyFromRemoteServer(keyToRemove, host string, cfg config.Config, o
 	args = append(args, host)
 	args = append(args, "sh", "-c", scriptString)
 
-	return exec.Command("ssh", args...), nil
+	return exec.CommandContext(ctx, "ssh", args...), nil
 
 }
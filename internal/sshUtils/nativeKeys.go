@@ -0,0 +1,300 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/config"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	rsaKeyBits = 4096 // matches the -b given to ssh-keygen
+
+	authorizedKeysFile = ".ssh/authorized_keys" // relative to the remote home directory
+)
+
+// WriteKeyPair generates a key of algorithm, see config, and writes it to path in the OpenSSH format along
+// with path.pub. The private key is encrypted when passphrase is set. Existing files are never overwritten
+func WriteKeyPair(path, algorithm, comment, passphrase string) error {
+	var priv crypto.PrivateKey
+	var pub crypto.PublicKey
+	switch algorithm {
+	case config.RSA:
+		key, err := rsa.GenerateKey(rand.Reader, rsaKeyBits)
+		if err != nil {
+			return err
+		}
+		priv, pub = key, &key.PublicKey
+	case config.ECDSA:
+		key, err := ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+		if err != nil {
+			return err
+		}
+		priv, pub = key, &key.PublicKey
+	case config.ED25519:
+		pubKey, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		priv, pub = key, pubKey
+	default:
+		return errors.New("Key type given is not recognized")
+	}
+	var block *pem.Block
+	var err error
+	if passphrase == "" {
+		block, err = ssh.MarshalPrivateKey(priv, comment)
+	} else {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(priv, comment, []byte(passphrase))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode private key: %w", err)
+	}
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return fmt.Errorf("failed to encode public key: %w", err)
+	}
+	if err = writeNewFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+		return err
+	}
+	if err = writeNewFile(path+".pub", []byte(authorizedKeyLine(sshPub, comment)+"\n"), 0o644); err != nil {
+		_ = os.Remove(path)
+		return err
+	}
+	return nil
+}
+
+func writeNewFile(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		_ = os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+func authorizedKeyLine(pub ssh.PublicKey, comment string) string {
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub)))
+	if comment != "" {
+		line += " " + comment
+	}
+	return line
+}
+
+// AddAuthorizedKey appends line to the authorized_keys of the sftp session unless the key is already in it,
+// returns false if nothing had to be added
+func AddAuthorizedKey(client *sftp.Client, line string) (bool, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return false, fmt.Errorf("invalid public key: %w", err)
+	}
+	added := false
+	err = EditAuthorizedKeys(client, func(lines []string) []string {
+		if slices.ContainsFunc(lines, func(l string) bool { return lineHoldsKey(l, pub) }) {
+			return lines
+		}
+		added = true
+		return append(lines, strings.TrimSpace(line))
+	})
+	return added, err
+}
+
+// RemoveAuthorizedKey removes every line of the authorized_keys of the sftp session holding the key of entry,
+// see AuthorizedKeyEntry, and returns how many were removed. Comments and options of a line do not matter
+func RemoveAuthorizedKey(client *sftp.Client, entry string) (int, error) {
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(entry))
+	if err != nil {
+		return 0, fmt.Errorf("invalid public key: %w", err)
+	}
+	removed := 0
+	err = EditAuthorizedKeys(client, func(lines []string) []string {
+		kept := make([]string, 0, len(lines))
+		for _, line := range lines {
+			if lineHoldsKey(line, pub) {
+				removed++
+				continue
+			}
+			kept = append(kept, line)
+		}
+		return kept
+	})
+	return removed, err
+}
+
+func lineHoldsKey(line string, pub ssh.PublicKey) bool {
+	linePub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	return err == nil && bytes.Equal(linePub.Marshal(), pub.Marshal())
+}
+
+// EditAuthorizedKeys rewrites ~/.ssh/authorized_keys of the sftp session with the lines edit returns. The
+// current file is copied to authorized_keys.bak first and the new one is written to a temporary file that
+// replaces it, so a dropped connection never leaves a partial file behind. Nothing is written if edit
+// returns the lines unchanged
+func EditAuthorizedKeys(client *sftp.Client, edit func(lines []string) []string) error {
+	return editAuthorizedKeysFile(client, func(fileLines []string) []string {
+		return edit(nonBlankLines(fileLines))
+	})
+}
+
+// editAuthorizedKeysFile is EditAuthorizedKeys with edit given every line of the file, blank ones included, so
+// line numbers of the file can be used. The lines edit returns are written without blank ones
+func editAuthorizedKeysFile(client *sftp.Client, edit func(fileLines []string) []string) error {
+	home, err := client.Getwd() // sftp sessions start in the home directory of the user
+	if err != nil {
+		return fmt.Errorf("failed to find remote home directory: %w", err)
+	}
+	target := path.Join(home, authorizedKeysFile)
+	if err = client.MkdirAll(path.Dir(target)); err != nil {
+		return fmt.Errorf("failed to create %s: %w", path.Dir(target), err)
+	}
+	if err = client.Chmod(path.Dir(target), 0o700); err != nil {
+		return fmt.Errorf("failed to set permissions of %s: %w", path.Dir(target), err)
+	}
+	current, err := readRemoteFile(client, target)
+	exists := err == nil
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read %s: %w", target, err)
+	}
+	fileLines := strings.Split(string(current), "\n")
+	lines := nonBlankLines(fileLines)
+	updated := nonBlankLines(edit(slices.Clone(fileLines)))
+	if slices.Equal(lines, updated) {
+		return nil
+	}
+	if exists {
+		if err = writeRemoteFile(client, target+".bak", current); err != nil {
+			return fmt.Errorf("failed to back up %s: %w", target, err)
+		}
+	}
+	content := []byte(strings.Join(updated, "\n"))
+	if len(updated) > 0 {
+		content = append(content, '\n')
+	}
+	tmp := target + ".tmp"
+	if err = writeRemoteFile(client, tmp, content); err != nil {
+		_ = client.Remove(tmp)
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err = client.PosixRename(tmp, target); err != nil {
+		// servers without the posix rename extension refuse to rename over an existing file
+		if removeErr := client.Remove(target); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+			_ = client.Remove(tmp)
+			return fmt.Errorf("failed to replace %s: %w", target, err)
+		}
+		if err = client.Rename(tmp, target); err != nil {
+			// the host must not be left without an authorized_keys, put the previous file back
+			if exists {
+				if restoreErr := writeRemoteFile(client, target, current); restoreErr != nil {
+					return fmt.Errorf("failed to replace %s and to restore it, backup is at %s: %w", target, target+".bak", errors.Join(err, restoreErr))
+				}
+			}
+			_ = client.Remove(tmp)
+			return fmt.Errorf("failed to replace %s: %w", target, err)
+		}
+	}
+	return nil
+}
+
+func nonBlankLines(lines []string) []string {
+	kept := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if strings.TrimSpace(line) != "" {
+			kept = append(kept, line)
+		}
+	}
+	return kept
+}
+
+func readRemoteFile(client *sftp.Client, name string) ([]byte, error) {
+	f, err := client.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+func writeRemoteFile(client *sftp.Client, name string, data []byte) error {
+	f, err := client.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(data); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return client.Chmod(name, 0o600) // sshd ignores authorized_keys writable by others
+}
+
+// SftpSession runs a function against the sftp subsystem of a host over a connection made with
+// golang.org/x/crypto/ssh, no ssh binary or remote shell is involved. The host is read from the generated config
+// so HostName, Port, User, IdentityFile, ProxyJump and known_hosts apply, see nativeDialer
+type SftpSession struct {
+	ctx     context.Context
+	host    string
+	cfg     config.Config
+	options []string
+	fn      func(*sftp.Client) error
+}
+
+// NewSftpSession prepares a session against host, options are read like the ssh command line, see
+// newNativeDialer. The connection is closed once ctx is canceled, which fails the session function
+func NewSftpSession(ctx context.Context, host string, cfg config.Config, fn func(*sftp.Client) error, options ...string) *SftpSession {
+	return &SftpSession{ctx: ctx, host: host, cfg: cfg, options: slices.Clone(options), fn: fn}
+}
+
+// Run connects to the host and runs the session function. Nothing prompts, keys that need a passphrase have to
+// be in the agent and the host key has to be known
+func (s *SftpSession) Run() error {
+	client, err := dialSftp(s.ctx, s.host, s.cfg, s.options...)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	return s.fn(client)
+}
+
+// InstallKeySession adds the public key at pubKeyPath to the authorized_keys of host, the native
+// replacement of CopyKey
+func InstallKeySession(ctx context.Context, pubKeyPath, host string, cfg config.Config, options ...string) (*SftpSession, error) {
+	line, err := os.ReadFile(pubKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	return NewSftpSession(ctx, host, cfg, func(client *sftp.Client) error {
+		_, err := AddAuthorizedKey(client, string(line))
+		return err
+	}, options...), nil
+}
+
+// RemoveKeySession removes the key of entry from the authorized_keys of host, see AuthorizedKeyEntry, the
+// native replacement of RemoveAuthorizedKeyFromRemoteServer
+func RemoveKeySession(ctx context.Context, entry, host string, cfg config.Config, options ...string) *SftpSession {
+	return NewSftpSession(ctx, host, cfg, func(client *sftp.Client) error {
+		_, err := RemoveAuthorizedKey(client, entry)
+		return err
+	}, options...)
+}
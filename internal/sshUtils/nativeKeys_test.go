@@ -0,0 +1,315 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/config"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestWriteKeyPair(t *testing.T) {
+	dir := t.TempDir()
+	for _, algorithm := range []string{config.ED25519, config.ECDSA, config.RSA} {
+		for _, passphrase := range []string{"", "secret"} {
+			path := filepath.Join(dir, algorithm+passphrase)
+			if err := WriteKeyPair(path, algorithm, "native", passphrase); err != nil {
+				t.Fatalf("failed to write %s key: %v", algorithm, err)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("failed to read key: %v", err)
+			}
+			if passphrase != "" {
+				var missing *ssh.PassphraseMissingError
+				if _, err = ssh.ParsePrivateKey(data); !errors.As(err, &missing) {
+					t.Fatalf("expected %s key to be encrypted, got %v", algorithm, err)
+				}
+			}
+			signer, err := ssh.ParsePrivateKeyWithPassphrase(data, []byte(passphrase))
+			if passphrase == "" {
+				signer, err = ssh.ParsePrivateKey(data)
+			}
+			if err != nil {
+				t.Fatalf("failed to parse %s key: %v", algorithm, err)
+			}
+			if KeyGenAlgorithm(signer.PublicKey().Type()) != algorithm {
+				t.Fatalf("expected a %s key, got %s", algorithm, signer.PublicKey().Type())
+			}
+			pubData, err := os.ReadFile(path + ".pub")
+			if err != nil {
+				t.Fatalf("failed to read public key: %v", err)
+			}
+			pub, comment, _, _, err := ssh.ParseAuthorizedKey(pubData)
+			if err != nil || comment != "native" || string(pub.Marshal()) != string(signer.PublicKey().Marshal()) {
+				t.Fatalf("public key does not match the private key: %v", err)
+			}
+			if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+				t.Fatalf("private key should be 0600, got %v", info.Mode().Perm())
+			}
+		}
+	}
+	if err := WriteKeyPair(filepath.Join(dir, config.ED25519), config.ED25519, "", ""); err == nil {
+		t.Fatalf("existing keys should never be overwritten")
+	}
+	if err := WriteKeyPair(filepath.Join(dir, "dsa"), "DSA", "", ""); err == nil {
+		t.Fatalf("expected unknown algorithm to fail")
+	}
+}
+
+// startSftpServer serves the sftp subsystem over an in-process ssh server with home as the working directory and
+// returns the ssh options pointing the native transport at it. The config holds the alias target for the server
+// and jumped, which reaches the server through itself. The server only accepts the key the config names and
+// forwards direct-tcpip channels so it can act as a jump host
+func startSftpServer(t *testing.T, home string) []string {
+	t.Helper()
+	t.Setenv("SSH_AUTH_SOCK", "") // only the identity file of the config may log in
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate host key: %v", err)
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatalf("failed to create host signer: %v", err)
+	}
+	dir := t.TempDir()
+	identity := filepath.Join(dir, "id_ed25519")
+	clientKey := writeTestKey(t, identity, "client", "")
+	serverConfig := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(clientKey.Marshal()) {
+				return nil, errors.New("unknown key")
+			}
+			return nil, nil
+		},
+	}
+	serverConfig.AddHostKey(signer)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestConn(conn, serverConfig, home)
+		}
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	knownHosts := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(addr.String())}, signer.PublicKey())
+	if err = os.WriteFile(knownHosts, []byte(line+"\n"), 0o600); err != nil {
+		t.Fatalf("failed to write known_hosts: %v", err)
+	}
+	sshConfig := fmt.Sprintf("Host target\n\tHostName 127.0.0.1\n\tPort %d\n\n"+
+		"Host jumped\n\tHostName 127.0.0.1\n\tPort %d\n\tProxyJump target\n\n"+
+		"Host *\n\tUser test\n\tIdentityFile %s\n\tUserKnownHostsFile %s\n\tGlobalKnownHostsFile %s\n",
+		addr.Port, addr.Port, identity, knownHosts, filepath.Join(dir, "missing"))
+	configFile := filepath.Join(dir, "config")
+	if err = os.WriteFile(configFile, []byte(sshConfig), 0o600); err != nil {
+		t.Fatalf("failed to write ssh config: %v", err)
+	}
+	return []string{"-F", configFile}
+}
+
+func serveTestConn(conn net.Conn, serverConfig *ssh.ServerConfig, home string) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, serverConfig)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				return
+			}
+			go serveTestSftp(channel, requests, home)
+		case "direct-tcpip":
+			var target struct {
+				Host     string
+				Port     uint32
+				OrigHost string
+				OrigPort uint32
+			}
+			if err = ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+				_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			forward, err := net.Dial("tcp", net.JoinHostPort(target.Host, fmt.Sprint(target.Port)))
+			if err != nil {
+				_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				_ = forward.Close()
+				return
+			}
+			go ssh.DiscardRequests(requests)
+			go func() {
+				_, _ = io.Copy(channel, forward)
+				_ = channel.Close()
+			}()
+			go func() {
+				_, _ = io.Copy(forward, channel)
+				_ = forward.Close()
+			}()
+		default:
+			_ = newChannel.Reject(ssh.UnknownChannelType, "only sessions and direct-tcpip are served")
+		}
+	}
+}
+
+func serveTestSftp(channel ssh.Channel, requests <-chan *ssh.Request, home string) {
+	for req := range requests {
+		// the payload is the length prefixed subsystem name
+		ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+		_ = req.Reply(ok, nil)
+		if !ok {
+			continue
+		}
+		server, err := sftp.NewServer(channel, sftp.WithServerWorkingDirectory(home))
+		if err != nil {
+			_ = channel.Close()
+			return
+		}
+		go func() {
+			_ = server.Serve()
+			_ = channel.Close()
+		}()
+	}
+}
+
+// dialTestSftp connects to the server of startSftpServer the way SftpSession does
+func dialTestSftp(t *testing.T, options []string) *sftp.Client {
+	t.Helper()
+	client, err := dialSftp(context.Background(), "target", config.Config{}, options...)
+	if err != nil {
+		t.Fatalf("failed to start sftp: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestEditAuthorizedKeys(t *testing.T) {
+	home := t.TempDir()
+	client := dialTestSftp(t, startSftpServer(t, home))
+	keys := t.TempDir()
+	newPub := writeTestKey(t, filepath.Join(keys, "new"), "new", "")
+	oldPub := writeTestKey(t, filepath.Join(keys, "old"), "old", "")
+	newLine := authorizedKeyLine(newPub, "new@laptop")
+	oldEntry := authorizedKeyLine(oldPub, "")
+
+	added, err := AddAuthorizedKey(client, newLine)
+	if err != nil || !added {
+		t.Fatalf("expected key to be added, got %v %v", added, err)
+	}
+	sshDir := filepath.Join(home, ".ssh")
+	if info, err := os.Stat(sshDir); err != nil || info.Mode().Perm() != 0o700 {
+		t.Fatalf("expected .ssh to be created with 0700, got %v", err)
+	}
+	target := filepath.Join(sshDir, "authorized_keys")
+	if info, err := os.Stat(target); err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("expected authorized_keys with 0600, got %v", err)
+	}
+	if _, err = os.Stat(target + ".bak"); !os.IsNotExist(err) {
+		t.Fatalf("no backup should be written for a new file, got %v", err)
+	}
+	if added, err = AddAuthorizedKey(client, authorizedKeyLine(newPub, "other comment")); err != nil || added {
+		t.Fatalf("key already present should not be added again, got %v %v", added, err)
+	}
+
+	existing := "# managed by hand\n" +
+		`from="10.0.0.0/8",no-pty ` + oldEntry + " old@laptop\n" +
+		newLine + "\n" +
+		oldEntry + "\n"
+	if err = os.WriteFile(target, []byte(existing), 0o600); err != nil {
+		t.Fatalf("failed to write authorized_keys: %v", err)
+	}
+	removed, err := RemoveAuthorizedKey(client, oldEntry)
+	if err != nil || removed != 2 {
+		t.Fatalf("expected both lines of the old key to be removed, got %d %v", removed, err)
+	}
+	content, _ := os.ReadFile(target)
+	if want := "# managed by hand\n" + newLine + "\n"; string(content) != want {
+		t.Fatalf("expected %q, got %q", want, content)
+	}
+	if backup, _ := os.ReadFile(target + ".bak"); string(backup) != existing {
+		t.Fatalf("expected backup of the previous file, got %q", backup)
+	}
+	if _, err = os.Stat(target + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("temporary file should be gone, got %v", err)
+	}
+	if removed, err = RemoveAuthorizedKey(client, oldEntry); err != nil || removed != 0 {
+		t.Fatalf("expected nothing left to remove, got %d %v", removed, err)
+	}
+	if _, err = AddAuthorizedKey(client, "not a key"); err == nil || !strings.Contains(err.Error(), "invalid public key") {
+		t.Fatalf("expected invalid key to be rejected, got %v", err)
+	}
+}
+
+func TestSftpSession(t *testing.T) {
+	home := t.TempDir()
+	options := startSftpServer(t, home)
+	pub := filepath.Join(t.TempDir(), "new")
+	key := writeTestKey(t, pub, "new@laptop", "")
+	target := filepath.Join(home, ".ssh", "authorized_keys")
+
+	for _, host := range []string{"target", "jumped"} {
+		session, err := InstallKeySession(context.Background(), pub+".pub", host, config.Config{}, options...)
+		if err != nil {
+			t.Fatalf("failed to prepare session: %v", err)
+		}
+		if err = session.Run(); err != nil {
+			t.Fatalf("expected the key to be installed on %s, got %v", host, err)
+		}
+		content, _ := os.ReadFile(target)
+		if entries := ParseAuthorizedKeys(content); len(entries) != 1 || entries[0].Fingerprint != ssh.FingerprintSHA256(key) {
+			t.Fatalf("expected the key once in authorized_keys, got %q", content)
+		}
+		if err = RemoveKeySession(context.Background(), authorizedKeyLine(key, ""), host, config.Config{}, options...).Run(); err != nil {
+			t.Fatalf("expected the key to be removed from %s, got %v", host, err)
+		}
+		if content, _ = os.ReadFile(target); len(ParseAuthorizedKeys(content)) != 0 {
+			t.Fatalf("expected the key to be removed, got %q", content)
+		}
+	}
+
+	untrusted := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(untrusted, nil, 0o600); err != nil {
+		t.Fatalf("failed to write known_hosts: %v", err)
+	}
+	run := func(ctx context.Context, extra ...string) error {
+		return NewSftpSession(ctx, "target", config.Config{}, func(*sftp.Client) error { return nil },
+			append(slices.Clone(options), extra...)...).Run()
+	}
+	if err := run(context.Background(), "-o", "UserKnownHostsFile="+untrusted); err == nil || !strings.Contains(err.Error(), "not known") {
+		t.Fatalf("a host missing from known_hosts should be refused, got %v", err)
+	}
+	if err := run(context.Background(), "-o", "ProxyCommand=nc %h %p"); !errors.Is(err, ErrNativeProxyCommand) {
+		t.Fatalf("expected ProxyCommand to be refused, got %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := run(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected a canceled session to fail, got %v", err)
+	}
+}
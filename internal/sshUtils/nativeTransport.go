@@ -0,0 +1,318 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/config"
+	"andrew/sshman/internal/sqlite"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kevinburke/ssh_config"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// ErrNativeProxyCommand is returned when a host is reached through a ProxyCommand, the native transport only
+// follows ProxyJump
+var ErrNativeProxyCommand = errors.New("ProxyCommand is not supported by the native transport, use ProxyJump instead")
+
+// nativeOptions are the options of a host the native transport reads from the ssh config
+var nativeOptions = []string{
+	"HostName", "Port", "User", "IdentityFile", "ProxyJump", "ProxyCommand", "HostKeyAlias",
+	"UserKnownHostsFile", "GlobalKnownHostsFile", "ConnectTimeout",
+}
+
+// defaultIdentityFiles are tried when a host sets no IdentityFile, in the order ssh tries them
+var defaultIdentityFiles = []string{"~/.ssh/id_rsa", "~/.ssh/id_ecdsa", "~/.ssh/id_ed25519"}
+
+const (
+	defaultUserKnownHosts   = "~/.ssh/known_hosts ~/.ssh/known_hosts2"
+	defaultGlobalKnownHosts = "/etc/ssh/ssh_known_hosts /etc/ssh/ssh_known_hosts2"
+)
+
+// nativeDialer connects to hosts with golang.org/x/crypto/ssh the way ssh would with the same config file,
+// overrides are the options given on the command line, they only apply to the target like they do for ssh
+type nativeDialer struct {
+	config    *ssh_config.Config
+	overrides []sqlite.HostOptions
+}
+
+// newNativeDialer reads the config file and the options the way ssh reads its command line. -F, -i, -l, -p, -J
+// and -o for the options in nativeOptions apply, everything else, ie BatchMode, is ignored since the native
+// transport never prompts
+func newNativeDialer(cfg config.Config, options ...string) (*nativeDialer, error) {
+	configFile := ""
+	overrides := make([]sqlite.HostOptions, 0)
+	flags := map[string]string{"-i": "IdentityFile", "-l": "User", "-p": "Port", "-J": "ProxyJump"}
+	for i := 0; i < len(options); i++ {
+		flag, value := options[i], ""
+		if len(flag) > 2 && strings.HasPrefix(flag, "-") {
+			flag, value = flag[:2], flag[2:]
+		} else if i+1 < len(options) {
+			i++
+			value = options[i]
+		}
+		switch flag {
+		case "-F":
+			configFile = value
+		case "-o":
+			key, val, ok := strings.Cut(value, "=")
+			if !ok {
+				key, val, _ = strings.Cut(value, " ")
+			}
+			overrides = append(overrides, sqlite.HostOptions{Key: strings.TrimSpace(key), Value: strings.TrimSpace(val)})
+		default:
+			if key, ok := flags[flag]; ok {
+				overrides = append(overrides, sqlite.HostOptions{Key: key, Value: value})
+			}
+		}
+	}
+	if configFile == "" {
+		configFile = cfg.GetSshConfigFilePath()
+	}
+	f, err := os.Open(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open ssh config: %w", err)
+	}
+	defer f.Close()
+	sshCfg, err := ssh_config.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ssh config: %w", err)
+	}
+	return &nativeDialer{config: sshCfg, overrides: overrides}, nil
+}
+
+// host collects the options ssh would use for alias, extra options go first so they win like the command line
+func (d *nativeDialer) host(alias string, extra ...sqlite.HostOptions) (host sqlite.Host, err error) {
+	defer func() {
+		// ssh_config panics on Match blocks, which ssh_man never writes
+		if r := recover(); r != nil {
+			err = fmt.Errorf("failed to read ssh config of %s: %v", alias, r)
+		}
+	}()
+	host = sqlite.Host{Host: alias, Options: slices.Clone(extra)}
+	for _, key := range nativeOptions {
+		values, err := d.config.GetAll(alias, key)
+		if err != nil {
+			return sqlite.Host{}, fmt.Errorf("failed to read ssh config of %s: %w", alias, err)
+		}
+		for _, value := range values {
+			host.Options = append(host.Options, sqlite.HostOptions{Key: key, Value: value, Host: alias})
+		}
+	}
+	return host, nil
+}
+
+// dial connects to alias through its ProxyJump chain. via is the connection of the previous hop, ssh only
+// follows the ProxyJump of the first hop of a list, see resolveJumpChain. via is closed along with the returned
+// client or when the connection fails, canceling ctx closes the connection
+func (d *nativeDialer) dial(ctx context.Context, alias string, extra []sqlite.HostOptions, via *ssh.Client, visiting []string) (*ssh.Client, error) {
+	host, err := d.host(alias, extra...)
+	if err != nil {
+		return nil, err
+	}
+	tokens := NewTokenContext(host)
+	if via == nil {
+		if proxyCommand(host) != "" {
+			return nil, fmt.Errorf("%s: %w", alias, ErrNativeProxyCommand)
+		}
+		hops, err := ParseProxyJump(tokens.ProxyJump)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ProxyJump of %s: %w", alias, err)
+		}
+		for _, hop := range hops {
+			if slices.Contains(visiting, hop.Host) {
+				return nil, fmt.Errorf("%w: %s -> %s", ErrJumpCycle, strings.Join(visiting, " -> "), hop.Host)
+			}
+			next, err := d.dial(ctx, hop.Host, jumpHopOptions(hop), via, append(slices.Clone(visiting), hop.Host))
+			if err != nil {
+				return nil, err // via was closed by the failed hop
+			}
+			via = next
+		}
+	}
+	client, err := connectNative(ctx, host, tokens, via)
+	if err != nil {
+		if via != nil {
+			_ = via.Close()
+		}
+		return nil, err
+	}
+	return client, nil
+}
+
+// jumpHopOptions are the options a ProxyJump hop sets for the host it names
+func jumpHopOptions(hop JumpHop) []sqlite.HostOptions {
+	options := make([]sqlite.HostOptions, 0)
+	if hop.User != "" {
+		options = append(options, sqlite.HostOptions{Key: "User", Value: hop.User})
+	}
+	if hop.Port != "" {
+		options = append(options, sqlite.HostOptions{Key: "Port", Value: hop.Port})
+	}
+	return options
+}
+
+// connectNative runs the ssh handshake with host, over via if it is set
+func connectNative(ctx context.Context, host sqlite.Host, tokens TokenContext, via *ssh.Client) (*ssh.Client, error) {
+	hostKeyCallback, err := nativeHostKeyCallback(host, tokens)
+	if err != nil {
+		return nil, err
+	}
+	signers := identitySigners(host)
+	agentConn, agentErr := DialAgent()
+	if agentErr == nil {
+		defer agentConn.Close()
+	}
+	clientConfig := &ssh.ClientConfig{
+		User: tokens.RemoteUser,
+		Auth: []ssh.AuthMethod{ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			// a single method, the client gives up on publickey once a method of that type failed
+			if agentErr != nil {
+				return signers, nil
+			}
+			agentSigners, err := agentConn.Signers()
+			if err != nil {
+				return signers, nil
+			}
+			return append(slices.Clone(signers), agentSigners...), nil
+		})},
+		HostKeyCallback: hostKeyCallback,
+	}
+	if seconds, err := strconv.Atoi(hostOption(host, "ConnectTimeout")); err == nil && seconds > 0 {
+		clientConfig.Timeout = time.Duration(seconds) * time.Second
+	}
+	addr := net.JoinHostPort(tokens.HostName, tokens.Port)
+	var conn net.Conn
+	if via != nil {
+		conn, err = via.DialContext(ctx, "tcp", addr)
+	} else {
+		conn, err = (&net.Dialer{Timeout: clientConfig.Timeout}).DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", host.Host, err)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, clientConfig)
+	if err != nil {
+		stop()
+		_ = conn.Close()
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("failed to connect to %s: %w", host.Host, err)
+	}
+	client := ssh.NewClient(c, chans, reqs)
+	go func() {
+		_ = client.Wait()
+		stop()
+		if via != nil {
+			_ = via.Close()
+		}
+	}()
+	return client, nil
+}
+
+// identitySigners loads the IdentityFile keys of host, falling back to the ssh defaults. Keys that need a
+// passphrase are left to the agent and a certificate next to a key is offered before the key itself
+func identitySigners(host sqlite.Host) []ssh.Signer {
+	paths := HostIdentityFiles(host)
+	if len(paths) == 0 {
+		tokens := NewTokenContext(host)
+		for _, file := range defaultIdentityFiles {
+			if path, err := ExpandOptionValue("IdentityFile", file, tokens); err == nil {
+				paths = append(paths, path)
+			}
+		}
+	}
+	signers := make([]ssh.Signer, 0)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			continue
+		}
+		if cert, ok := loadCertificateFor(path); ok {
+			if certSigner, err := ssh.NewCertSigner(cert, signer); err == nil {
+				signers = append(signers, certSigner)
+			}
+		}
+		signers = append(signers, signer)
+	}
+	return signers
+}
+
+// nativeHostKeyCallback checks host keys against the known_hosts files of host, unknown hosts are rejected
+// since there is no prompt to accept them
+func nativeHostKeyCallback(host sqlite.Host, tokens TokenContext) (ssh.HostKeyCallback, error) {
+	userFiles := hostOption(host, "UserKnownHostsFile")
+	if userFiles == "" {
+		userFiles = defaultUserKnownHosts
+	}
+	globalFiles := hostOption(host, "GlobalKnownHostsFile")
+	if globalFiles == "" {
+		globalFiles = defaultGlobalKnownHosts
+	}
+	files := make([]string, 0)
+	for _, file := range strings.Fields(userFiles + " " + globalFiles) {
+		path, err := ExpandOptionValue("UserKnownHostsFile", file, tokens)
+		if err != nil {
+			return nil, fmt.Errorf("invalid known hosts file of %s: %w", host.Host, err)
+		}
+		if exists, _ := doesFileExist(path); exists {
+			files = append(files, filepath.Clean(path))
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no known_hosts file for %s, connect with ssh once to accept its host key", host.Host)
+	}
+	callback, err := knownhosts.New(files...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if hostOption(host, "HostKeyAlias") != "" {
+			// the alias is looked up as is, without the port
+			hostname = net.JoinHostPort(tokens.KeyAlias, "22")
+		}
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if errors.As(err, &keyErr) && len(keyErr.Want) == 0 {
+			return fmt.Errorf("host key of %s is not known, connect with ssh once to accept it", host.Host)
+		}
+		return err
+	}, nil
+}
+
+// dialSftp starts the sftp subsystem of host over a native connection, closing the client closes the connection
+func dialSftp(ctx context.Context, host string, cfg config.Config, options ...string) (*sftp.Client, error) {
+	dialer, err := newNativeDialer(cfg, options...)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dialer.dial(ctx, host, dialer.overrides, nil, []string{host})
+	if err != nil {
+		return nil, err
+	}
+	client, err := sftp.NewClient(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to start sftp session: %w", err)
+	}
+	go func() {
+		_ = client.Wait()
+		_ = conn.Close()
+	}()
+	return client, nil
+}
@@ -2,6 +2,7 @@ package sshUtils
 
 import (
 	"errors"
+	"fmt"
 	"log/slog"
 	"maps"
 	"net"
@@ -37,6 +38,7 @@ var validOptionsToBeSet = map[string]struct{}{
 	"KbdInteractiveAuthentication": {},
 	"LocalForward":                 {},
 	"PasswordAuthentication":       {},
+	"ProxyJump":                    {},
 	"RemoteForward":                {},
 }
 
@@ -60,12 +62,43 @@ func IsAcceptableOption(opt string) bool {
 	return ok
 }
 
+// optionValueValidators maps settable options to the validator of their value, BindAddress and BindInterface
+// are left out as they depend on the interfaces of the machine running the check
+var optionValueValidators = map[string]func(string) bool{
+	"Port":                         IsValidPort,
+	"AddressFamily":                IsAddressFamilyValid,
+	"BatchMode":                    IsBatchModeValid,
+	"CertificateFile":              IsCertificateFileValid,
+	"ChannelTimeout":               IsChannelTimeoutValid,
+	"CheckHostIP":                  IsCheckHostIPValid,
+	"Compression":                  IsCompressionModeValid,
+	"ConnectionAttempts":           IsConnectionAttemptsValid,
+	"ConnectTimeout":               IsConnectTimeoutValid,
+	"DynamicForward":               IsDynamicForwardValid,
+	"ForwardX11":                   IsForwardX11Valid,
+	"ForwardX11Timeout":            IsForwardX11TimeoutValid,
+	"HostKeyAlias":                 IsHostKeyAliasValid,
+	"IdentityFile":                 IsIdentityFileValid,
+	"KbdInteractiveAuthentication": IsKbdInteractiveAuthenticationValid,
+	"LocalForward":                 IsLocalForwardValid,
+	"PasswordAuthentication":       IsPasswordAuthenticationValid,
+	"ProxyJump":                    IsProxyJumpValid,
+	"RemoteForward":                IsRemoteForwardValid,
+}
+
 func ValidateSpecificOption(opt, value string) error {
 	//check if option is valid
 	if !IsAcceptableOption(opt) {
 		return errors.New("option isn't in settable list")
 	}
+	if strings.TrimSpace(value) == "" {
+		return errors.New("option value is empty")
+	}
 	// match against option name to validate value for the option
+	validator, ok := optionValueValidators[opt]
+	if ok && !validator(value) {
+		return fmt.Errorf("invalid value %q for %s", value, opt)
+	}
 	return nil
 }
 
@@ -358,10 +391,10 @@ func IsRemoteForwardValid(remoteForward string) bool {
 
 func IsDynamicForwardValid(dynamicForward string) bool {
 	parts := splitForwardSpec(dynamicForward)
-	if len(parts) > 1 {
+	if len(parts) > 2 {
 		return false
 	}
-	if len(parts) == 1 {
+	if len(parts) == 2 {
 		return ValidHost(parts[0]) && IsValidPort(parts[1])
 	} else {
 		return IsValidPort(parts[0])
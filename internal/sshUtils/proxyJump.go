@@ -0,0 +1,222 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/sqlite"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+var (
+	ErrJumpCycle       = errors.New("proxy jump chain contains a cycle")
+	ErrJumpHostMissing = errors.New("proxy jump references a host that is not managed")
+)
+
+// JumpHop is a single entry of a ProxyJump value in the form [user@]host[:port]
+type JumpHop struct {
+	User string
+	Host string
+	Port string
+}
+
+func (j JumpHop) String() string {
+	builder := strings.Builder{}
+	if j.User != "" {
+		builder.WriteString(j.User + "@")
+	}
+	if strings.Contains(j.Host, ":") {
+		builder.WriteString("[" + j.Host + "]")
+	} else {
+		builder.WriteString(j.Host)
+	}
+	if j.Port != "" {
+		builder.WriteString(":" + j.Port)
+	}
+	return builder.String()
+}
+
+// ParseProxyJump splits a ProxyJump value into its hops, the special value none yields no hops
+func ParseProxyJump(value string) ([]JumpHop, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || strings.EqualFold(value, "none") {
+		return nil, nil
+	}
+	hops := make([]JumpHop, 0)
+	for _, part := range strings.Split(value, ",") {
+		hop, err := parseJumpHop(strings.TrimSpace(part))
+		if err != nil {
+			return nil, err
+		}
+		hops = append(hops, hop)
+	}
+	return hops, nil
+}
+
+func parseJumpHop(spec string) (JumpHop, error) {
+	spec = strings.TrimPrefix(spec, "ssh://")
+	if spec == "" {
+		return JumpHop{}, fmt.Errorf("empty jump host")
+	}
+	hop := JumpHop{}
+	if idx := strings.LastIndexByte(spec, '@'); idx >= 0 {
+		hop.User = spec[:idx]
+		spec = spec[idx+1:]
+	}
+	if strings.HasPrefix(spec, "[") {
+		end := strings.IndexByte(spec, ']')
+		if end < 0 {
+			return JumpHop{}, fmt.Errorf("unterminated ipv6 jump host %q", spec)
+		}
+		hop.Host = spec[1:end]
+		rest := spec[end+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ":") {
+				return JumpHop{}, fmt.Errorf("invalid jump host %q", spec)
+			}
+			hop.Port = rest[1:]
+		}
+	} else if strings.Count(spec, ":") == 1 {
+		hop.Host, hop.Port, _ = strings.Cut(spec, ":")
+	} else {
+		hop.Host = spec
+	}
+	if hop.Host == "" {
+		return JumpHop{}, fmt.Errorf("invalid jump host %q", spec)
+	}
+	if hop.Port != "" && !IsValidPort(hop.Port) {
+		return JumpHop{}, fmt.Errorf("invalid port for jump host %q", spec)
+	}
+	return hop, nil
+}
+
+func IsProxyJumpValid(value string) bool {
+	_, err := ParseProxyJump(value)
+	return err == nil
+}
+
+// ResolveJumpChain walks the ProxyJump references of host and returns the hops in the order ssh will connect
+// through them, the target itself is not included. lookup should return the ProxyJump value of a managed host
+// (empty if it has none) and false if the alias is not managed.
+// Returns ErrJumpCycle if a host is reached twice and ErrJumpHostMissing if a hop is not a managed host
+func ResolveJumpChain(host string, lookup func(alias string) (string, bool)) ([]JumpHop, error) {
+	return resolveJumpChain(host, lookup, []string{host})
+}
+
+func resolveJumpChain(host string, lookup func(alias string) (string, bool), visiting []string) ([]JumpHop, error) {
+	value, ok := lookup(host)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrJumpHostMissing, host)
+	}
+	hops, err := ParseProxyJump(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(hops) == 0 {
+		return nil, nil
+	}
+	for _, hop := range hops {
+		if slices.Contains(visiting, hop.Host) {
+			return nil, fmt.Errorf("%w: %s -> %s", ErrJumpCycle, strings.Join(visiting, " -> "), hop.Host)
+		}
+	}
+	// when a list is given ssh connects to the first hop with its own config, every other hop
+	// is reached through the one before it
+	first := hops[0]
+	chain, err := resolveJumpChain(first.Host, lookup, append(slices.Clone(visiting), first.Host))
+	if err != nil {
+		return nil, err
+	}
+	chain = append(chain, first)
+	for _, hop := range hops[1:] {
+		if _, ok := lookup(hop.Host); !ok {
+			return nil, fmt.Errorf("%w: %s", ErrJumpHostMissing, hop.Host)
+		}
+		chain = append(chain, hop)
+	}
+	return chain, nil
+}
+
+// HostJumpLookup builds a lookup usable by ResolveJumpChain from a set of stored hosts
+func HostJumpLookup(hosts []sqlite.Host) func(alias string) (string, bool) {
+	index := make(map[string]string, len(hosts))
+	for _, host := range hosts {
+		index[host.Host] = ""
+		for _, opt := range host.Options {
+			if strings.EqualFold(opt.Key, "ProxyJump") {
+				index[host.Host] = opt.Value
+				break
+			}
+		}
+	}
+	return func(alias string) (string, bool) {
+		value, ok := index[alias]
+		return value, ok
+	}
+}
+
+// ValidateJumpChain checks the ProxyJump chain of host against the other stored hosts,
+// host replaces any stored host with the same alias so pending edits can be validated before saving
+func ValidateJumpChain(host sqlite.Host, hosts []sqlite.Host) error {
+	all := slices.DeleteFunc(slices.Clone(hosts), func(h sqlite.Host) bool {
+		return h.Host == host.Host
+	})
+	all = append(all, host)
+	_, err := ResolveJumpChain(host.Host, HostJumpLookup(all))
+	return err
+}
+
+// JumpDependents returns the aliases of hosts that reference alias in their ProxyJump value
+func JumpDependents(alias string, hosts []sqlite.Host) []string {
+	dependents := make([]string, 0)
+	for _, host := range hosts {
+		for _, opt := range host.Options {
+			if !strings.EqualFold(opt.Key, "ProxyJump") {
+				continue
+			}
+			hops, err := ParseProxyJump(opt.Value)
+			if err != nil {
+				continue
+			}
+			if slices.ContainsFunc(hops, func(hop JumpHop) bool { return hop.Host == alias }) {
+				dependents = append(dependents, host.Host)
+				break
+			}
+		}
+	}
+	slices.Sort(dependents)
+	return dependents
+}
+
+// RenameJumpHost rewrites the ProxyJump references of dependents from oldAlias to newAlias,
+// only the hosts that changed are returned
+func RenameJumpHost(oldAlias, newAlias string, hosts []sqlite.Host) []sqlite.Host {
+	changed := make([]sqlite.Host, 0)
+	for _, host := range hosts {
+		updated := false
+		opts := slices.Clone(host.Options)
+		for i, opt := range opts {
+			if !strings.EqualFold(opt.Key, "ProxyJump") {
+				continue
+			}
+			hops, err := ParseProxyJump(opt.Value)
+			if err != nil {
+				continue
+			}
+			parts := make([]string, len(hops))
+			for j, hop := range hops {
+				if hop.Host == oldAlias {
+					hop.Host = newAlias
+					updated = true
+				}
+				parts[j] = hop.String()
+			}
+			opts[i].Value = strings.Join(parts, ",")
+		}
+		if updated {
+			host.Options = opts
+			changed = append(changed, host)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,103 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/sqlite"
+	"errors"
+	"slices"
+	"testing"
+)
+
+func jumpHost(alias, proxyJump string) sqlite.Host {
+	host := sqlite.Host{Host: alias}
+	if proxyJump != "" {
+		host.Options = append(host.Options, sqlite.HostOptions{Host: alias, Key: "ProxyJump", Value: proxyJump})
+	}
+	return host
+}
+
+func TestParseProxyJump(t *testing.T) {
+	hops, err := ParseProxyJump("admin@bastion:2222,[fd00::1]:22,inner")
+	if err != nil {
+		t.Fatalf("Failed to parse valid ProxyJump value. Error %v", err)
+	}
+	if len(hops) != 3 {
+		t.Fatalf("expected 3 hops but got %d", len(hops))
+	}
+	if hops[0].User != "admin" || hops[0].Host != "bastion" || hops[0].Port != "2222" {
+		t.Fatalf("first hop parsed incorrectly %+v", hops[0])
+	}
+	if hops[1].Host != "fd00::1" || hops[1].Port != "22" || hops[1].String() != "[fd00::1]:22" {
+		t.Fatalf("ipv6 hop parsed incorrectly %+v", hops[1])
+	}
+	if hops, _ := ParseProxyJump("none"); len(hops) != 0 {
+		t.Fatalf("none should yield no hops")
+	}
+	if IsProxyJumpValid("bastion:notaport") {
+		t.Fatalf("invalid port should not be accepted")
+	}
+}
+
+func TestResolveJumpChain(t *testing.T) {
+	hosts := []sqlite.Host{
+		jumpHost("edge", ""),
+		jumpHost("bastion", "edge"),
+		jumpHost("target", "bastion"),
+		jumpHost("multi", "edge,bastion"),
+	}
+	chain, err := ResolveJumpChain("target", HostJumpLookup(hosts))
+	if err != nil {
+		t.Fatalf("Failed to resolve valid chain. Error %v", err)
+	}
+	got := make([]string, len(chain))
+	for i, hop := range chain {
+		got[i] = hop.Host
+	}
+	if !slices.Equal(got, []string{"edge", "bastion"}) {
+		t.Fatalf("chain resolved in wrong order %v", got)
+	}
+	chain, err = ResolveJumpChain("multi", HostJumpLookup(hosts))
+	if err != nil || len(chain) != 2 {
+		t.Fatalf("list values should resolve hop by hop, chain %v error %v", chain, err)
+	}
+}
+
+func TestResolveJumpChainErrors(t *testing.T) {
+	hosts := []sqlite.Host{
+		jumpHost("a", "b"),
+		jumpHost("b", "c"),
+		jumpHost("c", "a"),
+		jumpHost("lonely", "ghost"),
+	}
+	if _, err := ResolveJumpChain("a", HostJumpLookup(hosts)); !errors.Is(err, ErrJumpCycle) {
+		t.Fatalf("expected a cycle error but got %v", err)
+	}
+	if _, err := ResolveJumpChain("lonely", HostJumpLookup(hosts)); !errors.Is(err, ErrJumpHostMissing) {
+		t.Fatalf("expected a missing host error but got %v", err)
+	}
+	if err := ValidateJumpChain(jumpHost("a", "a"), nil); !errors.Is(err, ErrJumpCycle) {
+		t.Fatalf("self reference should be reported as a cycle but got %v", err)
+	}
+}
+
+func TestJumpDependentsAndRename(t *testing.T) {
+	hosts := []sqlite.Host{
+		jumpHost("bastion", ""),
+		jumpHost("web", "ops@bastion:22"),
+		jumpHost("db", "web,bastion"),
+		jumpHost("other", ""),
+	}
+	dependents := JumpDependents("bastion", hosts)
+	if !slices.Equal(dependents, []string{"db", "web"}) {
+		t.Fatalf("unexpected dependents %v", dependents)
+	}
+	changed := RenameJumpHost("bastion", "gateway", hosts)
+	if len(changed) != 2 {
+		t.Fatalf("expected 2 changed hosts but got %d", len(changed))
+	}
+	if changed[0].Options[0].Value != "ops@gateway:22" || changed[1].Options[0].Value != "web,gateway" {
+		t.Fatalf("ProxyJump values were not rewritten correctly %v", changed)
+	}
+	if hosts[1].Options[0].Value != "ops@bastion:22" {
+		t.Fatalf("rename should not modify the given slice")
+	}
+}
@@ -0,0 +1,455 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/config"
+	"andrew/sshman/internal/ping"
+	"andrew/sshman/internal/sqlite"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// JumpProbeTimeout bounds probing a hop through the hops before it, logging into every jump host is included
+const JumpProbeTimeout = 10 * time.Second
+
+var ErrJumpLogin = errors.New("failed to log into jump host")
+
+// RouteHop is a host on the way to a host, a jump host of its ProxyJump chain or the host itself
+type RouteHop struct {
+	Jump         JumpHop // the hop as it is written in ProxyJump, the target is its alias
+	HostName     string  // address the hop is reached at, HostName of the hop falling back to its alias
+	Port         string
+	User         string
+	ProxyCommand string           // only used for the first hop, later hops are reached through the one before
+	Dial         ping.DialOptions // only used for the first hop, later hops are dialed by the hop before them
+}
+
+// Name returns the alias of the hop
+func (r RouteHop) Name() string {
+	return r.Jump.Host
+}
+
+// tokens returns the values ssh expands the tokens of the ProxyCommand of the hop to, an unset port or user
+// falls back to the ssh defaults
+func (r RouteHop) tokens() TokenContext {
+	ctx := NewTokenContext(sqlite.Host{Host: r.Name()})
+	ctx.HostName = r.HostName
+	if r.Port != "" {
+		ctx.Port = r.Port
+	}
+	if r.User != "" {
+		ctx.RemoteUser = r.User
+	}
+	return ctx
+}
+
+// HopProbe is the result of probing a hop, a hop is not probed if the route broke before it
+type HopProbe struct {
+	Hop    RouteHop
+	Via    string // the hop it was probed through, empty for the first hop
+	Probed bool
+	Result ping.PingResult
+}
+
+// RouteProbe holds the probes of the jump hosts in connection order followed by the probe of the target
+type RouteProbe struct {
+	Hops      []HopProbe
+	FailedHop string // the jump host the route broke at, empty if the target was probed
+	Err       error  // why the route broke at FailedHop, or the error of the context when the probe was canceled
+}
+
+// Target returns the probe of the host itself
+func (r RouteProbe) Target() HopProbe {
+	return r.Hops[len(r.Hops)-1]
+}
+
+// hostOption returns the first value of key in the options of host, empty if it is not set
+func hostOption(host sqlite.Host, key string) string {
+	for _, opt := range host.Options {
+		if strings.EqualFold(opt.Key, key) {
+			return opt.Value
+		}
+	}
+	return ""
+}
+
+// proxyCommand returns the ProxyCommand ssh uses for host, empty if there is none. Like ssh the first of
+// ProxyJump and ProxyCommand wins
+func proxyCommand(host sqlite.Host) string {
+	for _, opt := range host.Options {
+		switch {
+		case strings.EqualFold(opt.Key, "ProxyJump"):
+			return ""
+		case strings.EqualFold(opt.Key, "ProxyCommand"):
+			if strings.EqualFold(strings.TrimSpace(opt.Value), "none") {
+				return ""
+			}
+			return opt.Value
+		}
+	}
+	return ""
+}
+
+// newRouteHop resolves the address of jump from its stored host, jump may override the port
+func newRouteHop(jump JumpHop, hosts []sqlite.Host) RouteHop {
+	hop := RouteHop{Jump: jump, HostName: jump.Host, Port: jump.Port, User: jump.User}
+	idx := slices.IndexFunc(hosts, func(h sqlite.Host) bool { return h.Host == jump.Host })
+	if idx == -1 {
+		return hop
+	}
+	if hostName := hostOption(hosts[idx], "HostName"); hostName != "" {
+		hop.HostName = hostName
+	}
+	if hop.Port == "" {
+		hop.Port = hostOption(hosts[idx], "Port")
+	}
+	if hop.User == "" {
+		hop.User = hostOption(hosts[idx], "User")
+	}
+	hop.ProxyCommand = proxyCommand(hosts[idx])
+	hop.Dial = ping.DialOptions{
+		AddressFamily: hostOption(hosts[idx], "AddressFamily"),
+		BindAddress:   hostOption(hosts[idx], "BindAddress"),
+		BindInterface: hostOption(hosts[idx], "BindInterface"),
+	}
+	return hop
+}
+
+// ResolveRoute returns the hops ssh connects through to reach host followed by host itself, see
+// ResolveJumpChain. A host using a ProxyCommand is reached through its command and has no jump hosts.
+// On error the route only holds host
+func ResolveRoute(host sqlite.Host, hosts []sqlite.Host) ([]RouteHop, error) {
+	target := newRouteHop(JumpHop{Host: host.Host}, []sqlite.Host{host})
+	if target.ProxyCommand != "" {
+		return []RouteHop{target}, nil
+	}
+	others := slices.DeleteFunc(slices.Clone(hosts), func(h sqlite.Host) bool { return h.Host == host.Host })
+	chain, err := ResolveJumpChain(host.Host, HostJumpLookup(append(others, host)))
+	if err != nil {
+		return []RouteHop{target}, err
+	}
+	route := make([]RouteHop, 0, len(chain)+1)
+	for _, jump := range chain {
+		route = append(route, newRouteHop(jump, hosts))
+	}
+	return append(route, target), nil
+}
+
+// ProbeRoute probes the route to host hop by hop with probe, see config.PingProbeSet. The first hop is probed
+// directly, or through its ProxyCommand, every later hop is probed through the hop before it with ssh -W so
+// hosts behind a bastion are checked the way ssh reaches them. Jump hosts are logged into with BatchMode set.
+// The host key is only fetched for the target. The route stops at the first jump host that does not answer,
+// see RouteProbe.FailedHop. Canceling ctx stops the probe at the hop in progress and kills the commands it
+// started. This is blocking and should be run outside the ui thread
+func ProbeRoute(ctx context.Context, host sqlite.Host, hosts []sqlite.Host, probe string, timeout time.Duration, cfg config.Config) (RouteProbe, error) {
+	route, err := ResolveRoute(host, hosts)
+	result := RouteProbe{Hops: make([]HopProbe, len(route))}
+	for i, hop := range route {
+		result.Hops[i] = HopProbe{Hop: hop}
+		if i > 0 {
+			result.Hops[i].Via = route[i-1].Name()
+		}
+	}
+	for i, hop := range route {
+		hopProbe := probe
+		isTarget := i == len(route)-1
+		if !isTarget && hopProbe == config.PingProbeHostKey {
+			hopProbe = config.PingProbeBanner
+		}
+		var res ping.PingResult
+		if i == 0 {
+			res = probeDirect(ctx, hop, hopProbe, timeout)
+		} else {
+			var loginErr error
+			res, loginErr = probeThrough(ctx, route[:i], hop, hopProbe, timeout, cfg)
+			if loginErr != nil {
+				result.FailedHop, result.Err = route[i-1].Name(), loginErr
+				break
+			}
+		}
+		result.Hops[i].Probed = true
+		result.Hops[i].Result = res
+		if ctx.Err() != nil {
+			result.Err = ctx.Err()
+			break
+		}
+		if isTarget {
+			break
+		}
+		if cause := hopFailure(res, hopProbe); cause != nil {
+			result.FailedHop, result.Err = hop.Name(), cause
+			break
+		}
+	}
+	return result, err
+}
+
+// hopFailure returns why a jump host can not be connected through, nil if it answered. The tcp probe only
+// asks for an open port
+func hopFailure(res ping.PingResult, probe string) error {
+	switch {
+	case res.Err != nil:
+		return res.Err
+	case !res.Reachable:
+		return errors.New("connection refused")
+	case probe != config.PingProbeTCP && !res.SSH:
+		return errors.New("port open but not ssh")
+	}
+	return nil
+}
+
+// probeDirect probes hop from here, through its ProxyCommand if it has one
+func probeDirect(ctx context.Context, hop RouteHop, probe string, timeout time.Duration) ping.PingResult {
+	port := hop.Port
+	if port == "" {
+		port = "22"
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return ping.PingResult{Err: err}
+	}
+	if hop.ProxyCommand != "" {
+		command, err := expandTokensWith(hop.ProxyCommand, hop.tokens(), proxyTokens, shellQuote)
+		if err != nil {
+			return ping.PingResult{Err: fmt.Errorf("invalid proxy command: %w", err)}
+		}
+		cmd := exec.CommandContext(ctx, "sh", "-c", "exec "+command)
+		res, closed, stderr := probeCommand(ctx, cmd, net.JoinHostPort(hop.HostName, port), probe, timeout)
+		if !res.SSH && closed {
+			if len(bytes.TrimSpace(stderr)) == 0 {
+				return ping.PingResult{Err: errors.New("proxy command closed the connection")}
+			}
+			return ping.PingResult{Err: fmt.Errorf("proxy command failed: %s", lastLine(stderr))}
+		}
+		return res
+	}
+	if probe == config.PingProbeTCP {
+		return ping.PingRemoteHost(ctx, hop.HostName, uint(portNum), timeout, hop.Dial)
+	}
+	return ping.ProbeSSH(ctx, hop.HostName, uint(portNum), timeout, probe == config.PingProbeHostKey, hop.Dial)
+}
+
+// JumpProbeCommand returns the ssh command that opens a stream to hop through jumps, the last jump is logged into
+// and asked to connect to hop, the ones before it are passed with -J. Debug output is turned on as the stream
+// itself does not say if the jump host could be logged into or if it could connect to hop. The command is killed
+// once ctx is canceled
+func JumpProbeCommand(ctx context.Context, jumps []RouteHop, hop RouteHop, cfg config.Config) *exec.Cmd {
+	via := jumps[len(jumps)-1]
+	port := hop.Port
+	if port == "" {
+		port = "22"
+	}
+	args := []string{
+		"-F", cfg.GetSshConfigFilePath(),
+		"-vv",
+		"-o", "BatchMode=yes",
+		"-o", "ConnectTimeout=" + strconv.Itoa(int(JumpProbeTimeout/time.Second)),
+	}
+	if len(jumps) > 1 {
+		specs := make([]string, 0, len(jumps)-1)
+		for _, jump := range jumps[:len(jumps)-1] {
+			specs = append(specs, jump.Jump.String())
+		}
+		args = append(args, "-J", strings.Join(specs, ","))
+	}
+	if via.Jump.User != "" {
+		args = append(args, "-l", via.Jump.User)
+	}
+	if via.Jump.Port != "" {
+		args = append(args, "-p", via.Jump.Port)
+	}
+	args = append(args, "-W", net.JoinHostPort(hop.HostName, port), via.Jump.Host)
+	return exec.CommandContext(ctx, getSshExecutable(cfg), args...)
+}
+
+// probeThrough probes hop through jumps, see JumpProbeCommand. The returned error is set when the last jump host
+// could not be logged into, hop was not probed then
+func probeThrough(ctx context.Context, jumps []RouteHop, hop RouteHop, probe string, timeout time.Duration, cfg config.Config) (ping.PingResult, error) {
+	via := jumps[len(jumps)-1].Name()
+	res, closed, stderr := probeCommand(ctx, JumpProbeCommand(ctx, jumps, hop, cfg), hop.Name(), probe, JumpProbeTimeout+timeout)
+	output := parseJumpOutput(stderr)
+	switch {
+	case ctx.Err() != nil:
+		return ping.PingResult{Err: ctx.Err()}, nil
+	case res.SSH:
+		return res, nil
+	case output.openFailed != "":
+		// the jump host was logged into but could not connect to hop
+		if strings.Contains(strings.ToLower(output.openFailed), "connection refused") {
+			return ping.PingResult{Latency: res.Latency}, nil
+		}
+		return ping.PingResult{Err: fmt.Errorf("%s could not connect: %s", via, output.openFailed)}, nil
+	case output.opened:
+		return res, nil // open but not ssh
+	case output.authenticated < len(jumps) && closed:
+		return ping.PingResult{}, fmt.Errorf("%w %s: %s", ErrJumpLogin, via, lastLine(stderr))
+	case output.authenticated < len(jumps):
+		return ping.PingResult{}, fmt.Errorf("%w %s: timed out", ErrJumpLogin, via)
+	}
+	return ping.PingResult{Err: fmt.Errorf("%s did not connect in time", via)}, nil
+}
+
+type jumpOutput struct {
+	authenticated int    // number of hosts logged into, jump hosts passed with -J log through the same stderr
+	opened        bool   // the last jump host connected to the target
+	openFailed    string // why the last jump host could not connect to the target
+}
+
+// parseJumpOutput reads the debug output of a JumpProbeCommand
+func parseJumpOutput(stderr []byte) jumpOutput {
+	out := jumpOutput{}
+	for _, line := range strings.Split(string(stderr), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.Contains(line, "Authenticated to "), strings.Contains(line, "Authentication succeeded"):
+			out.authenticated++
+		case strings.Contains(line, "open confirm"):
+			out.opened = true
+		case strings.Contains(line, "open failed: "):
+			_, out.openFailed, _ = strings.Cut(line, "open failed: ")
+		}
+	}
+	return out
+}
+
+func lastLine(output []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// probeCommand reads the banner from the stdout of cmd, a command connecting its stdin and stdout to a server.
+// The banner is read even with the tcp probe as a command gives no other sign of being connected. closed is set
+// when the command closed its stdout before timeout instead of being killed, stderr of cmd is returned too
+func probeCommand(ctx context.Context, cmd *exec.Cmd, addr, probe string, timeout time.Duration) (res ping.PingResult, closed bool, stderr []byte) {
+	conn, err := startCommandConn(cmd, addr)
+	if err != nil {
+		return ping.PingResult{Err: err}, true, nil
+	}
+	start := time.Now()
+	res = ping.ProbeConn(ctx, conn, timeout, probe == config.PingProbeHostKey)
+	res.Latency = time.Since(start)
+	closed = !conn.timedOut()
+	grace := time.Duration(0)
+	if closed && !res.SSH {
+		grace = time.Second // a command closing its stdout is likely about to exit with an error
+	}
+	return res, closed, conn.closeAndWait(grace)
+}
+
+// commandConn is a net.Conn over the stdin and stdout of a command, closing it kills the command
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *os.File
+	stderr bytes.Buffer
+	addr   commandAddr
+
+	mu       sync.Mutex
+	timer    *time.Timer
+	deadline bool // the command was killed by a deadline
+	done     chan struct{}
+}
+
+type commandAddr string
+
+func (a commandAddr) Network() string { return "command" }
+func (a commandAddr) String() string  { return string(a) }
+
+func startCommandConn(cmd *exec.Cmd, addr string) (*commandConn, error) {
+	conn := &commandConn{cmd: cmd, addr: commandAddr(addr), done: make(chan struct{})}
+	var err error
+	if conn.stdin, err = cmd.StdinPipe(); err != nil {
+		return nil, err
+	}
+	// unlike StdoutPipe the read end stays open after Wait, the command may exit right after writing its banner
+	stdout, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	conn.stdout = stdout
+	cmd.Stdout = w
+	cmd.Stderr = &conn.stderr
+	// a shell running the command may leave children holding the pipes
+	cmd.WaitDelay = time.Second
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		stdout.Close()
+		return nil, fmt.Errorf("failed to start proxy: %w", err)
+	}
+	go func() {
+		_ = cmd.Wait()
+		close(conn.done)
+	}()
+	return conn, nil
+}
+
+func (c *commandConn) Read(p []byte) (int, error)  { return c.stdout.Read(p) }
+func (c *commandConn) Write(p []byte) (int, error) { return c.stdin.Write(p) }
+func (c *commandConn) LocalAddr() net.Addr         { return commandAddr("local") }
+func (c *commandConn) RemoteAddr() net.Addr        { return c.addr }
+
+// SetDeadline kills the command at t, there is no way to interrupt a read of the pipe otherwise
+func (c *commandConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.timer = time.AfterFunc(time.Until(t), func() {
+		c.mu.Lock()
+		c.deadline = true
+		c.mu.Unlock()
+		c.kill()
+	})
+	return nil
+}
+
+func (c *commandConn) SetReadDeadline(t time.Time) error  { return c.SetDeadline(t) }
+func (c *commandConn) SetWriteDeadline(t time.Time) error { return c.SetDeadline(t) }
+
+func (c *commandConn) kill() {
+	if c.cmd.Process != nil {
+		_ = c.cmd.Process.Kill()
+	}
+}
+
+// timedOut reports whether the command was killed by a deadline
+func (c *commandConn) timedOut() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.deadline
+}
+
+func (c *commandConn) Close() error {
+	c.closeAndWait(0)
+	return nil
+}
+
+// closeAndWait kills the command once it did not exit within grace and returns its stderr
+func (c *commandConn) closeAndWait(grace time.Duration) []byte {
+	c.mu.Lock()
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.mu.Unlock()
+	_ = c.stdin.Close()
+	select {
+	case <-c.done:
+	case <-time.After(grace):
+		c.kill()
+		<-c.done
+	}
+	_ = c.stdout.Close()
+	return c.stderr.Bytes()
+}
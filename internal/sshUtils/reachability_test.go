@@ -0,0 +1,120 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/config"
+	"andrew/sshman/internal/sqlite"
+	"context"
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeJumpSsh stands in for ssh -W, it answers like ssh logging into a jump host based on the address asked for
+const fakeJumpSsh = `#!/bin/sh
+case "$*" in
+*"-W 10.0.0.2:22"*)
+	echo 'debug1: Authenticated to bastion ([192.0.2.1]:22) using "publickey".' >&2
+	echo 'debug2: channel 0: open confirm rwindow 0 rmax 32768' >&2
+	printf 'SSH-2.0-OpenSSH_9.6\r\n'
+	exec sleep 5;;
+*"-W 10.0.0.3:22"*)
+	echo 'debug1: Authenticated to bastion ([192.0.2.1]:22) using "publickey".' >&2
+	echo 'channel 0: open failed: connect failed: Connection refused' >&2
+	echo 'stdio forwarding failed' >&2
+	exit 255;;
+*"-W 10.0.0.4:22"*)
+	echo 'debug1: Authenticated to bastion ([192.0.2.1]:22) using "publickey".' >&2
+	echo 'admin@10.0.0.2: Permission denied (publickey).' >&2
+	exit 255;;
+esac
+exit 1
+`
+
+func routeHost(alias, hostName string, options ...string) sqlite.Host {
+	host := sqlite.Host{Host: alias}
+	if hostName != "" {
+		host.Options = append(host.Options, sqlite.HostOptions{Host: alias, Key: "HostName", Value: hostName})
+	}
+	for i := 0; i+1 < len(options); i += 2 {
+		host.Options = append(host.Options, sqlite.HostOptions{Host: alias, Key: options[i], Value: options[i+1]})
+	}
+	return host
+}
+
+func TestProbeRoute(t *testing.T) {
+	dir := t.TempDir()
+	sshPath := filepath.Join(dir, "ssh")
+	if err := os.WriteFile(sshPath, []byte(fakeJumpSsh), 0o755); err != nil {
+		t.Fatalf("failed to write fake ssh: %v", err)
+	}
+	cfg := config.Config{Ssh: config.SSH{ExcPath: sshPath}}
+	hosts := []sqlite.Host{
+		routeHost("bastion", "192.0.2.1", "ProxyCommand", `printf 'SSH-2.0-Bastion_1.0\r\n'`),
+		routeHost("internal", "10.0.0.2", "ProxyJump", "bastion"),
+		routeHost("closed", "10.0.0.3", "ProxyJump", "bastion"),
+		routeHost("web", "10.0.0.4", "ProxyJump", "internal"),
+		routeHost("gone", "", "ProxyCommand", "echo 'no route to host' >&2"),
+		routeHost("stuck", "", "ProxyCommand", "sleep 5"),
+		routeHost("quoted", "a;b", "ProxyCommand", `printf 'SSH-2.0-%%s_%%s\r\n' %h %r`),
+	}
+
+	route, err := ResolveRoute(hosts[3], hosts)
+	if err != nil {
+		t.Fatalf("failed to resolve route: %v", err)
+	}
+	names := make([]string, len(route))
+	for i, hop := range route {
+		names[i] = hop.Name()
+	}
+	if !slices.Equal(names, []string{"bastion", "internal", "web"}) || route[1].HostName != "10.0.0.2" {
+		t.Fatalf("unexpected route %+v", route)
+	}
+	args := strings.Join(JumpProbeCommand(context.Background(), route[:2], route[2], cfg).Args, " ")
+	if !strings.Contains(args, "-J bastion") || !strings.HasSuffix(args, "-W 10.0.0.4:22 internal") {
+		t.Fatalf("later hops should be reached through the one before, got %s", args)
+	}
+
+	probe, err := ProbeRoute(context.Background(), hosts[1], hosts, config.PingProbeBanner, time.Second, cfg)
+	if err != nil || probe.FailedHop != "" {
+		t.Fatalf("expected the route to reach internal, got %+v %v", probe, err)
+	}
+	if bastion := probe.Hops[0].Result; !bastion.SSH || bastion.ServerVersion != "Bastion_1.0" {
+		t.Fatalf("the first hop should be probed through its ProxyCommand, got %+v", bastion)
+	}
+	if target := probe.Target(); !target.Probed || target.Via != "bastion" || !target.Result.SSH || target.Result.ServerVersion != "OpenSSH_9.6" {
+		t.Fatalf("the target should be probed through bastion, got %+v", target)
+	}
+
+	probe, _ = ProbeRoute(context.Background(), hosts[2], hosts, config.PingProbeBanner, time.Second, cfg)
+	if target := probe.Target().Result; target.Reachable || target.Err != nil || probe.FailedHop != "" {
+		t.Fatalf("a refused connection behind the jump host should not fail the route, got %+v", probe)
+	}
+
+	probe, _ = ProbeRoute(context.Background(), hosts[3], hosts, config.PingProbeBanner, time.Second, cfg)
+	if probe.FailedHop != "internal" || !errors.Is(probe.Err, ErrJumpLogin) || probe.Target().Probed {
+		t.Fatalf("the route should break at internal, got %+v", probe)
+	}
+
+	probe, _ = ProbeRoute(context.Background(), hosts[4], hosts, config.PingProbeTCP, time.Second, cfg)
+	if target := probe.Target().Result; target.Err == nil || !strings.Contains(target.Err.Error(), "no route to host") {
+		t.Fatalf("a failing ProxyCommand should report its error, got %+v", target)
+	}
+
+	probe, _ = ProbeRoute(context.Background(), hosts[6], hosts, config.PingProbeBanner, time.Second, cfg)
+	if local, _ := user.Current(); probe.Target().Result.ServerVersion != "a;b_"+local.Username {
+		t.Fatalf("ProxyCommand tokens should be quoted and %%r should fall back to the local user, got %+v", probe.Target())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	probe, _ = ProbeRoute(ctx, hosts[5], hosts, config.PingProbeBanner, 5*time.Second, cfg)
+	if !errors.Is(probe.Err, context.Canceled) || time.Since(start) > 2*time.Second {
+		t.Fatalf("canceling should stop the probe and its command, got %+v after %v", probe, time.Since(start))
+	}
+}
@@ -0,0 +1,194 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/sqlite"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RotationStep is the step a rotation continues from when it is resumed
+type RotationStep int
+
+const (
+	RotationStepCopy RotationStep = iota
+	RotationStepVerify
+	RotationStepRemove
+	RotationStepNone // the rotation is finished or rolled back
+)
+
+// NextRotationStep returns the first step of rotation that did not complete
+func NextRotationStep(rotation sqlite.Rotation) RotationStep {
+	switch {
+	case rotation.Finished():
+		return RotationStepNone
+	case rotation.Copy != sqlite.RotationDone:
+		return RotationStepCopy
+	case rotation.Verify != sqlite.RotationDone:
+		return RotationStepVerify
+	}
+	return RotationStepRemove
+}
+
+// hasIdentityFile reports whether host has an IdentityFile option stored as value
+func hasIdentityFile(host sqlite.Host, value string) bool {
+	return slices.ContainsFunc(host.Options, func(opt sqlite.HostOptions) bool {
+		return strings.EqualFold(opt.Key, "IdentityFile") && opt.Value == value
+	})
+}
+
+// EnsureRotationKey registers the new key of rotation to its host unless it already is, a bulk rotation only
+// registers keys that logged in and the user may have removed it by hand since
+func EnsureRotationKey(dao *sqlite.HostDao, rotation sqlite.Rotation) error {
+	host, err := dao.Get(rotation.Host)
+	if err != nil {
+		return err
+	}
+	if hasIdentityFile(host, rotation.NewKey) {
+		return nil
+	}
+	return dao.RegisterNewIdentityKeyForHost(rotation.Host, rotation.NewKey)
+}
+
+// RollbackRotation restores the IdentityFile registration the host had before rotation started, the old key is
+// registered again and the new key deregistered. The new key is left in the authorized_keys of the host and on
+// disk. Rotations that removed the old key can not be rolled back as the old key no longer logs in
+func RollbackRotation(dao *sqlite.HostDao, keyDao *sqlite.KeyDao, rotation sqlite.Rotation, now time.Time) (sqlite.Rotation, error) {
+	if rotation.RolledBack {
+		return rotation, fmt.Errorf("rotation of %s was already rolled back", rotation.Host)
+	}
+	if rotation.Remove == sqlite.RotationDone {
+		return rotation, fmt.Errorf("the old key of %s was already removed from the server, it can not be restored", rotation.Host)
+	}
+	host, err := dao.Get(rotation.Host)
+	if err != nil {
+		return rotation, err
+	}
+	if rotation.OldKeyOpt != "" && !hasIdentityFile(host, rotation.OldKeyOpt) {
+		if err = dao.RegisterNewIdentityKeyForHost(rotation.Host, rotation.OldKeyOpt); err != nil {
+			return rotation, fmt.Errorf("failed to register the old key of %s again: %w", rotation.Host, err)
+		}
+	}
+	if hasIdentityFile(host, rotation.NewKey) {
+		if err = dao.DeRegisterIdentityKeyFromHost(rotation.Host, rotation.NewKey); err != nil {
+			return rotation, fmt.Errorf("failed to deregister the new key of %s: %w", rotation.Host, err)
+		}
+	}
+	rotation.RolledBack = true
+	rotation.UpdatedAt = now
+	if err = keyDao.UpdateRotation(rotation); err != nil {
+		return rotation, fmt.Errorf("rolled back %s but failed to update the ledger: %w", rotation.Host, err)
+	}
+	return rotation, nil
+}
+
+// BulkRotationLedger turns the results of a bulk rotation into ledger entries, hosts that failed before a new key
+// was generated are left out
+func BulkRotationLedger(results []BulkRotateStatus, now time.Time) []sqlite.Rotation {
+	rotations := make([]sqlite.Rotation, 0, len(results))
+	for _, r := range results {
+		if rotation, ok := bulkRotationEntry(r, now); ok {
+			rotations = append(rotations, rotation)
+		}
+	}
+	return rotations
+}
+
+// bulkRotationEntry is the ledger entry of r as far as the host got, r may still be running. ok is false if no
+// new key was generated for the host yet
+func bulkRotationEntry(r BulkRotateStatus, now time.Time) (rotation sqlite.Rotation, ok bool) {
+	if r.NewKey.PrivateKey == "" {
+		return rotation, false
+	}
+	rotation = sqlite.Rotation{
+		Host:      r.Host,
+		OldKey:    r.OldPath,
+		OldKeyOpt: r.OldKey,
+		NewKey:    r.NewKey.PrivateKey,
+		NewPubKey: r.NewKey.PubKey,
+		Copy:      sqlite.RotationDone,
+		Verify:    sqlite.RotationPending,
+		Remove:    sqlite.RotationPending,
+		StartedAt: now,
+		UpdatedAt: now,
+	}
+	failed := r.Stage == BulkFailed
+	switch {
+	case failed && r.FailedAt == BulkCopying:
+		rotation.Copy = sqlite.RotationFailed
+	case r.Stage == BulkCopying:
+		rotation.Copy = sqlite.RotationPending
+	case r.Verified:
+		rotation.Verify = sqlite.RotationDone
+	case failed && r.FailedAt == BulkVerifying:
+		rotation.Verify = sqlite.RotationFailed
+	}
+	switch {
+	case r.OldKey == "":
+		rotation.Remove = sqlite.RotationSkipped
+	case r.OldRemoved:
+		rotation.Remove = sqlite.RotationDone
+	case failed && r.FailedAt == BulkRemoving:
+		rotation.Remove = sqlite.RotationFailed
+	}
+	if r.Err != nil {
+		rotation.Error = r.Err.Error()
+	}
+	return rotation, true
+}
+
+// BulkLedgerRecorder keeps the ledger entries of a running bulk rotation, the entry of a host is added once its new
+// key is generated and updated as its stages complete so a rotation that is killed still leaves its hosts in the
+// ledger. A nil recorder records nothing, it is not safe for concurrent use
+type BulkLedgerRecorder struct {
+	keyDao    *sqlite.KeyDao
+	startedAt time.Time
+	ids       map[string]int64 // ledger entry of every host recorded so far
+}
+
+// NewBulkLedgerRecorder returns the recorder of a bulk rotation started at startedAt, nil if keyDao is nil
+func NewBulkLedgerRecorder(keyDao *sqlite.KeyDao, startedAt time.Time) *BulkLedgerRecorder {
+	if keyDao == nil {
+		return nil
+	}
+	return &BulkLedgerRecorder{keyDao: keyDao, startedAt: startedAt, ids: make(map[string]int64)}
+}
+
+// Record adds or updates the ledger entry of the host of status, see BulkRotationLedger
+func (l *BulkLedgerRecorder) Record(status BulkRotateStatus) error {
+	if l == nil {
+		return nil
+	}
+	rotation, ok := bulkRotationEntry(status, l.startedAt)
+	if !ok {
+		return nil
+	}
+	rotation.UpdatedAt = time.Now()
+	id, recorded := l.ids[status.Host]
+	if !recorded {
+		id, err := l.keyDao.InsertRotation(rotation)
+		if err != nil {
+			return fmt.Errorf("failed to record the rotation of %s: %w", status.Host, err)
+		}
+		l.ids[status.Host] = id
+		return nil
+	}
+	rotation.ID = id
+	if err := l.keyDao.UpdateRotation(rotation); err != nil {
+		return fmt.Errorf("failed to update the rotation of %s: %w", status.Host, err)
+	}
+	return nil
+}
+
+// RecordAll records every status, see Record
+func (l *BulkLedgerRecorder) RecordAll(results []BulkRotateStatus) error {
+	errs := make([]error, 0)
+	for _, status := range results {
+		if err := l.Record(status); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,105 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/sqlite"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRollbackRotation(t *testing.T) {
+	conn, err := sqlite.CreateAndLoadDB(":memory:")
+	if err != nil {
+		t.Fatalf("failed to create db: %v", err)
+	}
+	defer conn.Close()
+	dao := sqlite.NewHostDao(conn)
+	keyDao := sqlite.NewKeyDao(conn)
+	err = dao.Insert(sqlite.Host{Host: "a", CreatedAt: time.Now(), Options: []sqlite.HostOptions{
+		{Key: "IdentityFile", Value: "/keys/new", Host: "a"},
+	}})
+	if err != nil {
+		t.Fatalf("failed to insert host: %v", err)
+	}
+	rotation := sqlite.Rotation{
+		Host:      "a",
+		OldKey:    "/keys/old",
+		OldKeyOpt: "~/keys/old",
+		NewKey:    "/keys/new",
+		NewPubKey: "/keys/new.pub",
+		Copy:      sqlite.RotationDone,
+		Verify:    sqlite.RotationFailed,
+		StartedAt: time.Now(),
+	}
+	if rotation.ID, err = keyDao.InsertRotation(rotation); err != nil {
+		t.Fatalf("failed to insert rotation: %v", err)
+	}
+	if step := NextRotationStep(rotation); step != RotationStepVerify {
+		t.Fatalf("expected the rotation to resume at verify, got %d", step)
+	}
+	if rotation, err = RollbackRotation(dao, keyDao, rotation, time.Now()); err != nil {
+		t.Fatalf("failed to roll back: %v", err)
+	}
+	host, _ := dao.Get("a")
+	if keys := identityFiles(host); !slices.Equal(keys, []string{"~/keys/old"}) {
+		t.Fatalf("only the old key should be registered after a rollback, got %v", keys)
+	}
+	if stored, _ := keyDao.GetRotation(rotation.ID); !stored.RolledBack || NextRotationStep(stored) != RotationStepNone {
+		t.Fatalf("the ledger should record the rollback, got %+v", stored)
+	}
+	if _, err = RollbackRotation(dao, keyDao, rotation, time.Now()); err == nil {
+		t.Fatalf("rolling back twice should fail")
+	}
+	if err = EnsureRotationKey(dao, rotation); err != nil {
+		t.Fatalf("failed to register the new key again: %v", err)
+	}
+	if err = EnsureRotationKey(dao, rotation); err != nil {
+		t.Fatalf("registering the new key twice should be a no-op: %v", err)
+	}
+	if count, _ := dao.CountOpts("a"); count != 2 {
+		t.Fatalf("expected the old and new key to be registered, got %d options", count)
+	}
+
+	removed := rotation
+	removed.RolledBack = false
+	removed.Remove = sqlite.RotationDone
+	if _, err = RollbackRotation(dao, keyDao, removed, time.Now()); err == nil {
+		t.Fatalf("a rotation that removed the old key should not roll back")
+	}
+}
+
+func TestBulkRotationLedger(t *testing.T) {
+	newKey := KeyPair{PrivateKey: "/keys/new", PubKey: "/keys/new.pub"}
+	results := []BulkRotateStatus{
+		{BulkRotateTarget: BulkRotateTarget{Host: "done", OldKey: "/keys/old", OldPath: "/keys/old"}, Stage: BulkDone,
+			Verified: true, OldRemoved: true, NewKey: newKey},
+		{BulkRotateTarget: BulkRotateTarget{Host: "copy", OldKey: "/keys/old", OldPath: "/keys/old"}, Stage: BulkFailed,
+			FailedAt: BulkCopying, NewKey: newKey, Err: errors.New("connection refused")},
+		{BulkRotateTarget: BulkRotateTarget{Host: "verify", OldKey: "/keys/old", OldPath: "/keys/old"}, Stage: BulkFailed,
+			FailedAt: BulkVerifying, NewKey: newKey},
+		{BulkRotateTarget: BulkRotateTarget{Host: "remove", OldKey: "/keys/old", OldPath: "/keys/old"}, Stage: BulkFailed,
+			FailedAt: BulkRemoving, Verified: true, NewKey: newKey},
+		{BulkRotateTarget: BulkRotateTarget{Host: "nokey"}, Stage: BulkDone, Verified: true, NewKey: newKey},
+		{BulkRotateTarget: BulkRotateTarget{Host: "keygen"}, Stage: BulkFailed, FailedAt: BulkGenerating},
+	}
+	ledger := BulkRotationLedger(results, time.Now())
+	if len(ledger) != 5 {
+		t.Fatalf("hosts without a new key should be left out, got %d entries", len(ledger))
+	}
+	want := map[string]RotationStep{
+		"done":   RotationStepNone,
+		"copy":   RotationStepCopy,
+		"verify": RotationStepVerify,
+		"remove": RotationStepRemove,
+		"nokey":  RotationStepNone,
+	}
+	for _, rotation := range ledger {
+		if step := NextRotationStep(rotation); step != want[rotation.Host] {
+			t.Fatalf("expected %s to resume at %d, got %d (%+v)", rotation.Host, want[rotation.Host], step, rotation)
+		}
+	}
+	if ledger[1].Error != "connection refused" || ledger[4].Remove != sqlite.RotationSkipped {
+		t.Fatalf("unexpected ledger entries %+v", ledger)
+	}
+}
@@ -0,0 +1,105 @@
+package sshUtils
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// optionHints are short explanations of the settable options shown while editing them
+var optionHints = map[string]string{
+	"HostName":                     "real host name or ip to connect to, the alias is used when unset",
+	"Port":                         "port the ssh server listens on, defaults to 22",
+	"User":                         "user to log in as",
+	"IdentityFile":                 "private key used for authentication, may be given more than once",
+	"IgnoreUnknown":                "pattern list of unknown options that should not cause an error",
+	"Include":                      "reads another ssh config file",
+	"IPQoS":                        "type of service/dscp class used for the connection",
+	"AddressFamily":                "restrict the address family: any, inet (ipv4) or inet6 (ipv6)",
+	"BatchMode":                    "yes disables every interactive prompt, useful for scripts",
+	"BindAddress":                  "local address to connect from",
+	"BindInterface":                "local interface to connect from",
+	"CertificateFile":              "certificate presented along with the matching IdentityFile",
+	"ChannelTimeout":               "close idle channels, given as type=interval pairs",
+	"CheckHostIP":                  "also check the ip of the host in known_hosts",
+	"Compression":                  "compress traffic, helps on slow links",
+	"ConnectionAttempts":           "number of attempts, one per second, before giving up",
+	"ConnectTimeout":               "seconds to wait for the tcp connection before giving up",
+	"DynamicForward":               "[bind:]port for a local socks proxy through the host",
+	"ForwardX11":                   "forward the local X11 display to the host",
+	"ForwardX11Timeout":            "time untrusted X11 forwarding is accepted, ie 20m",
+	"HostKeyAlias":                 "name used to look up the host key instead of the host name",
+	"KbdInteractiveAuthentication": "allow keyboard interactive authentication such as otp prompts",
+	"LocalForward":                 "[bind:]port:host:hostport, forwards a local port to host:hostport",
+	"PasswordAuthentication":       "allow password authentication",
+	"ProxyJump":                    "[user@]host[:port][,...] jump hosts connected through before the target",
+	"RemoteForward":                "[bind:]port:host:hostport, forwards a port on the server back to host:hostport",
+}
+
+// OptionHint returns a short explanation of opt, empty if the option is not known
+func OptionHint(opt string) string {
+	return optionHints[opt]
+}
+
+// SuggestionContext holds what value suggestions are built from
+type SuggestionContext struct {
+	Host        string   // alias of the host being edited, excluded from ProxyJump suggestions
+	HostAliases []string // aliases of every managed host
+	KeyStore    string   // directory generated keys are stored in
+}
+
+// ValueSuggestions returns the values that make sense for opt, nil if the option takes free form values
+func ValueSuggestions(opt string, ctx SuggestionContext) []string {
+	switch {
+	case IsOptionYesNo(opt):
+		return []string{"yes", "no"}
+	case opt == "AddressFamily":
+		return GetAllAddressFamily()
+	case opt == "IdentityFile":
+		return keyStoreFiles(ctx.KeyStore, func(name string) bool {
+			return !strings.HasSuffix(name, ".pub")
+		})
+	case opt == "CertificateFile":
+		return keyStoreFiles(ctx.KeyStore, func(name string) bool {
+			return strings.HasSuffix(name, "-cert.pub")
+		})
+	case opt == "ProxyJump":
+		aliases := slices.DeleteFunc(slices.Clone(ctx.HostAliases), func(alias string) bool {
+			return alias == ctx.Host
+		})
+		slices.Sort(aliases)
+		return aliases
+	case opt == "BindInterface":
+		interfaces, err := net.Interfaces()
+		if err != nil {
+			return nil
+		}
+		names := make([]string, 0, len(interfaces))
+		for _, iface := range interfaces {
+			names = append(names, iface.Name)
+		}
+		return names
+	}
+	return nil
+}
+
+// keyStoreFiles lists the full paths of regular files in dir accepted by keep
+func keyStoreFiles(dir string, keep func(name string) bool) []string {
+	if dir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	files := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !keep(entry.Name()) {
+			continue
+		}
+		files = append(files, filepath.Join(dir, entry.Name()))
+	}
+	return files
+}
@@ -0,0 +1,48 @@
+package sshUtils
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestValueSuggestions(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"web_ed25519", "web_ed25519.pub", "web_ed25519-cert.pub"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("key"), 0o600); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "subdir"), 0o700); err != nil {
+		t.Fatalf("failed to create subdir: %v", err)
+	}
+	ctx := SuggestionContext{Host: "web", HostAliases: []string{"web", "db", "bastion"}, KeyStore: dir}
+
+	if got := ValueSuggestions("Compression", ctx); !slices.Equal(got, []string{"yes", "no"}) {
+		t.Fatalf("yes/no option should suggest yes and no, got %v", got)
+	}
+	if got := ValueSuggestions("AddressFamily", ctx); !slices.Equal(got, GetAllAddressFamily()) {
+		t.Fatalf("AddressFamily should suggest every family, got %v", got)
+	}
+	if got := ValueSuggestions("IdentityFile", ctx); !slices.Equal(got, []string{filepath.Join(dir, "web_ed25519")}) {
+		t.Fatalf("IdentityFile should only suggest private keys, got %v", got)
+	}
+	if got := ValueSuggestions("CertificateFile", ctx); !slices.Equal(got, []string{filepath.Join(dir, "web_ed25519-cert.pub")}) {
+		t.Fatalf("CertificateFile should only suggest certificates, got %v", got)
+	}
+	if got := ValueSuggestions("ProxyJump", ctx); !slices.Equal(got, []string{"bastion", "db"}) {
+		t.Fatalf("ProxyJump should suggest other hosts sorted, got %v", got)
+	}
+	if got := ValueSuggestions("BindInterface", ctx); len(got) == 0 {
+		t.Fatalf("BindInterface should suggest local interfaces")
+	}
+	if got := ValueSuggestions("User", ctx); got != nil {
+		t.Fatalf("free form options should not have suggestions, got %v", got)
+	}
+	for _, opt := range GetListOfAcceptableOptions() {
+		if OptionHint(opt) == "" {
+			t.Fatalf("settable option %s has no hint", opt)
+		}
+	}
+}
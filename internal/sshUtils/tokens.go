@@ -0,0 +1,248 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/sqlite"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	allTokens     = "%CdhijkLlnpru"
+	commandTokens = allTokens + "T" // LocalCommand and RemoteCommand also know the tunnel interface
+	// connectTokens are only known once ssh talks to the server, ie the offered host key, they are left as is
+	connectTokens    = "fHIKt"
+	knownHostsTokens = allTokens + connectTokens
+	proxyTokens      = "%hnpr"
+	hostNameTokens   = "%h"
+)
+
+// optionTokens maps options that accept percent tokens to the tokens they accept, see TOKENS in ssh_config(5)
+var optionTokens = map[string]string{
+	"certificatefile":    allTokens,
+	"controlpath":        allTokens,
+	"identityagent":      allTokens,
+	"identityfile":       allTokens,
+	"knownhostscommand":  knownHostsTokens,
+	"localcommand":       commandTokens,
+	"localforward":       allTokens,
+	"remotecommand":      commandTokens,
+	"remoteforward":      allTokens,
+	"revokedhostkeys":    allTokens,
+	"userknownhostsfile": allTokens,
+	"proxycommand":       proxyTokens,
+	"hostname":           hostNameTokens,
+}
+
+// tildeOptions are the options ssh runs through tilde expansion before expanding tokens
+var tildeOptions = map[string]struct{}{
+	"certificatefile":    {},
+	"controlpath":        {},
+	"identityagent":      {},
+	"identityfile":       {},
+	"revokedhostkeys":    {},
+	"userknownhostsfile": {},
+}
+
+// TokenContext holds the values percent tokens expand to
+type TokenContext struct {
+	Alias      string // %n, host as given on the command line
+	HostName   string // %h
+	KeyAlias   string // %k, HostKeyAlias or the alias
+	Port       string // %p
+	RemoteUser string // %r
+	LocalUser  string // %u
+	LocalUID   string // %i
+	LocalHost  string // %l, %L is this up to the first dot
+	Home       string // %d and ~
+	ProxyJump  string // %j
+}
+
+// NewTokenContext builds the token values ssh would use when connecting to host, options that are not
+// set fall back to the ssh defaults
+func NewTokenContext(host sqlite.Host) TokenContext {
+	ctx := TokenContext{Alias: host.Host, HostName: host.Host, KeyAlias: host.Host, Port: "22"}
+	if u, err := user.Current(); err == nil {
+		ctx.LocalUser = u.Username
+		ctx.LocalUID = u.Uid
+	}
+	ctx.RemoteUser = ctx.LocalUser
+	ctx.LocalHost, _ = os.Hostname()
+	ctx.Home, _ = os.UserHomeDir()
+	// ssh uses the first value given for an option
+	seen := make(map[string]struct{})
+	for _, opt := range host.Options {
+		key := strings.ToLower(opt.Key)
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		value := strings.TrimSpace(opt.Value)
+		switch key {
+		case "hostname":
+			ctx.HostName = strings.ReplaceAll(value, "%h", host.Host)
+		case "port":
+			ctx.Port = value
+		case "hostkeyalias":
+			ctx.KeyAlias = value
+		case "user":
+			ctx.RemoteUser = value
+		case "proxyjump":
+			if !strings.EqualFold(value, "none") {
+				ctx.ProxyJump = value
+			}
+		}
+	}
+	return ctx
+}
+
+// connectionHash is %C, the sha1 of %l%h%p%r%j in hex
+func (ctx TokenContext) connectionHash() string {
+	sum := sha1.Sum([]byte(ctx.LocalHost + ctx.HostName + ctx.Port + ctx.RemoteUser + ctx.ProxyJump))
+	return hex.EncodeToString(sum[:])
+}
+
+func (ctx TokenContext) token(t byte) string {
+	switch t {
+	case '%':
+		return "%"
+	case 'C':
+		return ctx.connectionHash()
+	case 'd':
+		return ctx.Home
+	case 'h':
+		return ctx.HostName
+	case 'i':
+		return ctx.LocalUID
+	case 'j':
+		return ctx.ProxyJump
+	case 'k':
+		return ctx.KeyAlias
+	case 'L':
+		short, _, _ := strings.Cut(ctx.LocalHost, ".")
+		return short
+	case 'l':
+		return ctx.LocalHost
+	case 'n':
+		return ctx.Alias
+	case 'p':
+		return ctx.Port
+	case 'r':
+		return ctx.RemoteUser
+	case 'T':
+		return "NONE" // sshman does not request tunnel devices
+	case 'u':
+		return ctx.LocalUser
+	}
+	return ""
+}
+
+// ExpandShellTokens is ExpandTokens for a command line run by sh, every expanded value is single quoted so host
+// values such as a HostName from an imported config can not inject commands
+func ExpandShellTokens(value string, ctx TokenContext) (string, error) {
+	return expandTokensWith(value, ctx, allTokens, shellQuote)
+}
+
+// shellQuote quotes s as a single word for sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// expandTokens replaces the tokens in value, errors on tokens outside of accepted
+func expandTokens(value string, ctx TokenContext, accepted string) (string, error) {
+	return expandTokensWith(value, ctx, accepted, nil)
+}
+
+// expandTokensWith is expandTokens with quote applied to every expanded value but %%, if it is not nil
+func expandTokensWith(value string, ctx TokenContext, accepted string, quote func(string) string) (string, error) {
+	var builder strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '%' {
+			builder.WriteByte(value[i])
+			continue
+		}
+		if i+1 == len(value) {
+			return "", fmt.Errorf("invalid trailing %% in %q", value)
+		}
+		i++
+		if !strings.ContainsRune(accepted, rune(value[i])) {
+			return "", fmt.Errorf("unknown token %%%c in %q", value[i], value)
+		}
+		if strings.ContainsRune(connectTokens, rune(value[i])) {
+			builder.WriteByte('%')
+			builder.WriteByte(value[i])
+			continue
+		}
+		expanded := ctx.token(value[i])
+		if quote != nil && value[i] != '%' {
+			expanded = quote(expanded)
+		}
+		builder.WriteString(expanded)
+	}
+	return builder.String(), nil
+}
+
+// ExpandTokens replaces every percent token in value, errors if value holds a token that is not known
+func ExpandTokens(value string, ctx TokenContext) (string, error) {
+	return expandTokens(value, ctx, allTokens)
+}
+
+// ExpandTilde expands a leading ~ or ~user the way ssh does for file options
+func ExpandTilde(value string, home string) (string, error) {
+	if !strings.HasPrefix(value, "~") {
+		return value, nil
+	}
+	name, rest, _ := strings.Cut(value[1:], "/")
+	if name != "" {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", fmt.Errorf("unknown user in %q: %w", value, err)
+		}
+		home = u.HomeDir
+	}
+	if rest == "" {
+		return home, nil
+	}
+	return filepath.Join(home, rest), nil
+}
+
+// AcceptsTokens reports whether ssh expands percent tokens in the value of opt
+func AcceptsTokens(opt string) bool {
+	_, ok := optionTokens[strings.ToLower(opt)]
+	return ok
+}
+
+// ExpandOptionValue expands value the way ssh would for opt, options that take no tokens are returned as is
+func ExpandOptionValue(opt, value string, ctx TokenContext) (string, error) {
+	key := strings.ToLower(opt)
+	accepted, ok := optionTokens[key]
+	if !ok {
+		return value, nil
+	}
+	value = strings.Trim(strings.TrimSpace(value), "\"")
+	if _, ok := tildeOptions[key]; ok {
+		var err error
+		if value, err = ExpandTilde(value, ctx.Home); err != nil {
+			return "", err
+		}
+	}
+	return expandTokens(value, ctx, accepted)
+}
+
+// ValidateTokens checks that value only uses tokens ssh accepts for opt
+func ValidateTokens(opt, value string) error {
+	_, err := ExpandOptionValue(opt, value, TokenContext{})
+	return err
+}
+
+func IsIdentityFileValid(file string) bool {
+	return ValidateTokens("IdentityFile", file) == nil
+}
+
+func IsCertificateFileValid(file string) bool {
+	return ValidateTokens("CertificateFile", file) == nil
+}
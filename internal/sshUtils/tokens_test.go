@@ -0,0 +1,106 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/sqlite"
+	"crypto/sha1"
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewTokenContext(t *testing.T) {
+	host := sqlite.Host{Host: "web", Options: []sqlite.HostOptions{
+		{Key: "HostName", Value: "%h.example.com"},
+		{Key: "Port", Value: "2222"},
+		{Key: "User", Value: "deploy"},
+		{Key: "User", Value: "ignored"},
+		{Key: "ProxyJump", Value: "bastion"},
+		{Key: "HostKeyAlias", Value: "web-key"},
+	}}
+	ctx := NewTokenContext(host)
+	if ctx.Alias != "web" || ctx.HostName != "web.example.com" || ctx.Port != "2222" || ctx.RemoteUser != "deploy" || ctx.ProxyJump != "bastion" || ctx.KeyAlias != "web-key" {
+		t.Fatalf("unexpected token context %+v", ctx)
+	}
+	ctx = NewTokenContext(sqlite.Host{Host: "db"})
+	if ctx.HostName != "db" || ctx.Port != "22" || ctx.RemoteUser != ctx.LocalUser || ctx.KeyAlias != "db" {
+		t.Fatalf("unset options should fall back to ssh defaults, got %+v", ctx)
+	}
+}
+
+func TestExpandTokens(t *testing.T) {
+	ctx := TokenContext{
+		Alias:      "web",
+		HostName:   "web.example.com",
+		Port:       "2222",
+		RemoteUser: "deploy",
+		LocalUser:  "alice",
+		LocalUID:   "1000",
+		KeyAlias:   "web-key",
+		LocalHost:  "laptop.home.lan",
+		Home:       "/home/alice",
+	}
+	sum := sha1.Sum([]byte("laptop.home.lanweb.example.com2222deploy"))
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"%h_%r", "web.example.com_deploy"},
+		{"%n-%p", "web-2222"},
+		{"%u@%L", "alice@laptop"},
+		{"%l", "laptop.home.lan"},
+		{"%i/%k", "1000/web-key"},
+		{"%d/.ssh/id", "/home/alice/.ssh/id"},
+		{"cm-%C", "cm-" + hex.EncodeToString(sum[:])},
+		{"100%%", "100%"},
+		{"plain", "plain"},
+	}
+	for _, tt := range tests {
+		got, err := ExpandTokens(tt.value, ctx)
+		if err != nil {
+			t.Fatalf("failed to expand %q: %v", tt.value, err)
+		}
+		if got != tt.expected {
+			t.Fatalf("expanding %q gave %q, expected %q", tt.value, got, tt.expected)
+		}
+	}
+	for _, invalid := range []string{"%z", "trailing%"} {
+		if _, err := ExpandTokens(invalid, ctx); err == nil {
+			t.Fatalf("expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestExpandOptionValue(t *testing.T) {
+	ctx := TokenContext{Alias: "web", HostName: "web", RemoteUser: "deploy", Home: "/home/alice"}
+	got, err := ExpandOptionValue("IdentityFile", "~/.ssh/%h_%r", ctx)
+	if err != nil {
+		t.Fatalf("failed to expand IdentityFile: %v", err)
+	}
+	if expected := filepath.Join("/home/alice", ".ssh", "web_deploy"); got != expected {
+		t.Fatalf("got %q, expected %q", got, expected)
+	}
+	if got, _ := ExpandOptionValue("User", "%h", ctx); got != "%h" {
+		t.Fatalf("options without tokens should be returned as is, got %q", got)
+	}
+	if _, err := ExpandOptionValue("ProxyCommand", "nc %h %C", ctx); err == nil {
+		t.Fatalf("ProxyCommand does not accept %%C")
+	}
+	if err := ValidateSpecificOption("IdentityFile", "~/.ssh/%q"); err == nil {
+		t.Fatalf("IdentityFile with unknown token should be invalid")
+	}
+	if err := ValidateSpecificOption("IdentityFile", "~/.ssh/%h_%r"); err != nil {
+		t.Fatalf("IdentityFile with known tokens should be valid: %v", err)
+	}
+	if got, err := ExpandOptionValue("LocalCommand", "echo %T", ctx); err != nil || got != "echo NONE" {
+		t.Fatalf("LocalCommand should accept %%T, got %q %v", got, err)
+	}
+	if err := ValidateTokens("IdentityFile", "~/.ssh/%T"); err == nil {
+		t.Fatalf("IdentityFile does not accept %%T")
+	}
+	if got, err := ExpandOptionValue("KnownHostsCommand", "lookup %H %t %f %K %I %h", ctx); err != nil || got != "lookup %H %t %f %K %I web" {
+		t.Fatalf("KnownHostsCommand should keep the tokens known at connect time, got %q %v", got, err)
+	}
+	if err := ValidateTokens("IdentityFile", "~/.ssh/%H"); err == nil {
+		t.Fatalf("IdentityFile does not accept %%H")
+	}
+}
@@ -0,0 +1,259 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/config"
+	"andrew/sshman/internal/sqlite"
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrTunnelRunning = errors.New("forward profile is already running")
+
+// forwardFlags maps forward options to the ssh flag used to request them on the command line
+var forwardFlags = map[string]string{
+	"LocalForward":   "-L",
+	"RemoteForward":  "-R",
+	"DynamicForward": "-D",
+}
+
+func IsForwardOption(opt string) bool {
+	_, ok := forwardFlags[opt]
+	return ok
+}
+
+// ValidateForward checks the spec of fwd with the validator of its forward type
+func ValidateForward(fwd sqlite.Forward) error {
+	if !IsForwardOption(fwd.Type) {
+		return fmt.Errorf("%s is not a forward option", fwd.Type)
+	}
+	var valid bool
+	switch fwd.Type {
+	case "LocalForward":
+		valid = IsLocalForwardValid(fwd.Spec)
+	case "RemoteForward":
+		valid = IsRemoteForwardValid(fwd.Spec)
+	case "DynamicForward":
+		valid = IsDynamicForwardValid(fwd.Spec)
+	}
+	if !valid {
+		return fmt.Errorf("invalid %s spec %q", fwd.Type, fwd.Spec)
+	}
+	return nil
+}
+
+// LocalListenAddrs returns the local addresses ssh will listen on for profile,
+// remote forwards listen on the server and are not included
+func LocalListenAddrs(profile sqlite.ForwardProfile) []string {
+	addrs := make([]string, 0)
+	for _, fwd := range profile.Forwards {
+		parts := splitForwardSpec(fwd.Spec)
+		var bind, port string
+		switch {
+		case fwd.Type == "LocalForward" && len(parts) == 4, fwd.Type == "DynamicForward" && len(parts) == 2:
+			bind, port = parts[0], parts[1]
+		case fwd.Type == "LocalForward" && len(parts) == 3, fwd.Type == "DynamicForward" && len(parts) == 1:
+			port = parts[0]
+		default:
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(localBindAddress(bind), port))
+	}
+	return addrs
+}
+
+// localBindAddress maps a forward bind address to what ssh binds, with GatewayPorts off an empty
+// bind address means loopback and * means every interface
+func localBindAddress(bind string) string {
+	bind = strings.TrimSuffix(strings.TrimPrefix(bind, "["), "]")
+	switch bind {
+	case "", "localhost":
+		return "127.0.0.1"
+	case "*":
+		return ""
+	}
+	return bind
+}
+
+// PortConflictError is returned when a local port of a profile is already in use
+type PortConflictError struct {
+	Addr string
+	Err  error
+}
+
+func (p *PortConflictError) Error() string {
+	return fmt.Sprintf("local address %s is not available: %v", p.Addr, p.Err)
+}
+
+func (p *PortConflictError) Unwrap() error {
+	return p.Err
+}
+
+// CheckLocalPorts tries to listen on every local address of profile and returns a PortConflictError
+// for the first one that is already taken
+func CheckLocalPorts(profile sqlite.ForwardProfile) error {
+	for _, addr := range LocalListenAddrs(profile) {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			return &PortConflictError{Addr: addr, Err: err}
+		}
+		_ = listener.Close()
+	}
+	return nil
+}
+
+// TunnelCommand returns the cmd that starts the forwards of profile without opening a shell,
+// ExitOnForwardFailure makes ssh exit instead of running without a forward it could not set up
+func TunnelCommand(profile sqlite.ForwardProfile, cfg config.Config, options ...string) *exec.Cmd {
+	args := []string{
+		"-N",
+		"-F", cfg.GetSshConfigFilePath(),
+		"-o", "ExitOnForwardFailure=yes",
+	}
+	for _, fwd := range profile.Forwards {
+		args = append(args, forwardFlags[fwd.Type], fwd.Spec)
+	}
+	args = append(args, options...)
+	args = append(args, profile.Host)
+	return exec.Command(getSshExecutable(cfg), args...)
+}
+
+// Tunnel is a running forward profile
+type Tunnel struct {
+	Host      string
+	Name      string
+	Addrs     []string // local addresses the tunnel listens on
+	StartedAt time.Time
+	cmd       *exec.Cmd
+	stderr    bytes.Buffer
+	done      chan struct{}
+	err       error
+	stopped   bool // set when the tunnel was stopped through the manager so the kill is not reported as an error
+}
+
+// Wait blocks until the tunnel exits and returns why it exited
+func (t *Tunnel) Wait() error {
+	<-t.done
+	return t.err
+}
+
+// TunnelManager starts forward profiles in the background and keeps track of the ones still running
+type TunnelManager struct {
+	mu      sync.Mutex
+	cfg     config.Config
+	tunnels map[string]*Tunnel
+}
+
+func NewTunnelManager(cfg config.Config) *TunnelManager {
+	return &TunnelManager{
+		cfg:     cfg,
+		tunnels: make(map[string]*Tunnel),
+	}
+}
+
+func tunnelKey(host, name string) string {
+	return host + "/" + name
+}
+
+// Start runs profile in the background, local ports are checked first so a conflict is reported
+// before ssh is started. options are passed to ssh and need to be prefixed with -o
+func (m *TunnelManager) Start(profile sqlite.ForwardProfile, options ...string) (*Tunnel, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := tunnelKey(profile.Host, profile.Name)
+	if _, ok := m.tunnels[key]; ok {
+		return nil, fmt.Errorf("%w: %s", ErrTunnelRunning, key)
+	}
+	for _, fwd := range profile.Forwards {
+		if err := ValidateForward(fwd); err != nil {
+			return nil, err
+		}
+	}
+	if err := CheckLocalPorts(profile); err != nil {
+		return nil, err
+	}
+	tunnel := &Tunnel{
+		Host:  profile.Host,
+		Name:  profile.Name,
+		Addrs: LocalListenAddrs(profile),
+		cmd:   TunnelCommand(profile, m.cfg, options...),
+		done:  make(chan struct{}),
+	}
+	tunnel.cmd.Stderr = &tunnel.stderr
+	if err := tunnel.cmd.Start(); err != nil {
+		return nil, err
+	}
+	tunnel.StartedAt = time.Now()
+	m.tunnels[key] = tunnel
+	go func() {
+		err := tunnel.cmd.Wait()
+		if err != nil {
+			if msg := strings.TrimSpace(tunnel.stderr.String()); msg != "" {
+				err = fmt.Errorf("%w: %s", err, msg)
+			}
+		}
+		m.mu.Lock()
+		if tunnel.stopped {
+			err = nil
+		}
+		tunnel.err = err
+		if m.tunnels[key] == tunnel {
+			delete(m.tunnels, key)
+		}
+		m.mu.Unlock()
+		close(tunnel.done)
+	}()
+	return tunnel, nil
+}
+
+// Stop kills the tunnel of host's profile name and waits for it to exit, Wait of a stopped tunnel returns nil
+func (m *TunnelManager) Stop(host, name string) error {
+	m.mu.Lock()
+	tunnel, ok := m.tunnels[tunnelKey(host, name)]
+	if ok {
+		tunnel.stopped = true
+	}
+	m.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("forward profile is not running: %s", tunnelKey(host, name))
+	}
+	if err := tunnel.cmd.Process.Kill(); err != nil {
+		return err
+	}
+	<-tunnel.done
+	return nil
+}
+
+// StopAll stops every running tunnel, used when the program exits
+func (m *TunnelManager) StopAll() {
+	for _, tunnel := range m.Sessions() {
+		_ = m.Stop(tunnel.Host, tunnel.Name)
+	}
+}
+
+func (m *TunnelManager) IsRunning(host, name string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.tunnels[tunnelKey(host, name)]
+	return ok
+}
+
+// Sessions returns the running tunnels ordered by host then profile name
+func (m *TunnelManager) Sessions() []*Tunnel {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sessions := make([]*Tunnel, 0, len(m.tunnels))
+	for _, tunnel := range m.tunnels {
+		sessions = append(sessions, tunnel)
+	}
+	slices.SortFunc(sessions, func(a, b *Tunnel) int {
+		return strings.Compare(tunnelKey(a.Host, a.Name), tunnelKey(b.Host, b.Name))
+	})
+	return sessions
+}
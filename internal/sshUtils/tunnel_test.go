@@ -0,0 +1,133 @@
+package sshUtils
+
+import (
+	"andrew/sshman/internal/config"
+	"andrew/sshman/internal/sqlite"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestLocalListenAddrs(t *testing.T) {
+	profile := sqlite.ForwardProfile{
+		Host: "tunnel-test",
+		Name: "mixed",
+		Forwards: []sqlite.Forward{
+			{Type: "LocalForward", Spec: "8080:localhost:80"},
+			{Type: "LocalForward", Spec: "[::1]:8443:web:443"},
+			{Type: "RemoteForward", Spec: "9000:localhost:9000"},
+			{Type: "DynamicForward", Spec: "1080"},
+			{Type: "DynamicForward", Spec: "*:1081"},
+		},
+	}
+	expected := []string{"127.0.0.1:8080", "[::1]:8443", "127.0.0.1:1080", ":1081"}
+	if addrs := LocalListenAddrs(profile); !slices.Equal(addrs, expected) {
+		t.Fatalf("expected local addresses %v but got %v", expected, addrs)
+	}
+}
+
+func TestValidateForward(t *testing.T) {
+	valid := []sqlite.Forward{
+		{Type: "LocalForward", Spec: "8080:localhost:80"},
+		{Type: "DynamicForward", Spec: "1080"},
+		{Type: "DynamicForward", Spec: "127.0.0.1:1080"},
+	}
+	for _, fwd := range valid {
+		if err := ValidateForward(fwd); err != nil {
+			t.Fatalf("expected %+v to be valid: %v", fwd, err)
+		}
+	}
+	invalid := []sqlite.Forward{
+		{Type: "LocalForward", Spec: "8080"},
+		{Type: "DynamicForward", Spec: "a:b:c"},
+		{Type: "User", Spec: "root"},
+	}
+	for _, fwd := range invalid {
+		if err := ValidateForward(fwd); err == nil {
+			t.Fatalf("expected %+v to be invalid", fwd)
+		}
+	}
+}
+
+func TestCheckLocalPorts(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+	profile := sqlite.ForwardProfile{Forwards: []sqlite.Forward{{Type: "LocalForward", Spec: port + ":localhost:80"}}}
+	var conflict *PortConflictError
+	if err = CheckLocalPorts(profile); !errors.As(err, &conflict) {
+		t.Fatalf("expected a port conflict error but got %v", err)
+	}
+	if conflict.Addr != "127.0.0.1:"+port {
+		t.Fatalf("conflict reported wrong address %s", conflict.Addr)
+	}
+}
+
+// fakeSsh writes a script in place of ssh so tunnels can be started without a server
+func fakeSsh(t *testing.T, body string) config.Config {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ssh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+		t.Fatalf("failed to write fake ssh: %v", err)
+	}
+	return config.Config{DevMode: true, Ssh: config.SSH{ExcPath: path}}
+}
+
+func TestTunnelManager(t *testing.T) {
+	manager := NewTunnelManager(fakeSsh(t, "exec sleep 30"))
+	profile := sqlite.ForwardProfile{
+		Host:     "tunnel-test",
+		Name:     "socks",
+		Forwards: []sqlite.Forward{{Type: "DynamicForward", Spec: "0"}},
+	}
+	if _, err := manager.Start(profile); err == nil {
+		t.Fatalf("starting a profile with an invalid forward should fail")
+	}
+	profile.Forwards = []sqlite.Forward{{Type: "RemoteForward", Spec: "9000:localhost:9000"}}
+	if _, err := manager.Start(profile); err != nil {
+		t.Fatalf("failed to start tunnel: %v", err)
+	}
+	if !manager.IsRunning("tunnel-test", "socks") || len(manager.Sessions()) != 1 {
+		t.Fatalf("tunnel should be running after start")
+	}
+	if _, err := manager.Start(profile); !errors.Is(err, ErrTunnelRunning) {
+		t.Fatalf("starting a running profile should fail with ErrTunnelRunning, got %v", err)
+	}
+	tunnel := manager.Sessions()[0]
+	if err := manager.Stop("tunnel-test", "socks"); err != nil {
+		t.Fatalf("failed to stop tunnel: %v", err)
+	}
+	if err := tunnel.Wait(); err != nil {
+		t.Fatalf("a stopped tunnel should not report an error, got %v", err)
+	}
+	if manager.IsRunning("tunnel-test", "socks") {
+		t.Fatalf("tunnel should not be running after stop")
+	}
+}
+
+func TestTunnelExitError(t *testing.T) {
+	manager := NewTunnelManager(fakeSsh(t, "echo 'remote port forwarding failed' >&2; exit 255"))
+	profile := sqlite.ForwardProfile{
+		Host:     "tunnel-test",
+		Name:     "broken",
+		Forwards: []sqlite.Forward{{Type: "RemoteForward", Spec: "9000:localhost:9000"}},
+	}
+	tunnel, err := manager.Start(profile)
+	if err != nil {
+		t.Fatalf("failed to start tunnel: %v", err)
+	}
+	err = tunnel.Wait()
+	if err == nil || !strings.Contains(err.Error(), "remote port forwarding failed") {
+		t.Fatalf("exit error should include ssh stderr, got %v", err)
+	}
+	if manager.IsRunning("tunnel-test", "broken") {
+		t.Fatalf("exited tunnel should be removed from the manager")
+	}
+}
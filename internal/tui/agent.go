@@ -0,0 +1,343 @@
+package tui
+
+import (
+	"andrew/sshman/internal/sqlite"
+	"andrew/sshman/internal/sshUtils"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// startAgentView opens the agent modal, host is the highlighted host whose keys can be loaded from it
+type startAgentView struct {
+	host *sqlite.Host
+}
+
+// loadAgentKeys loads the keys of host that are not in the agent yet, asking for passphrases as needed.
+// When connect is set ssh is started once the keys are loaded, even if loading failed
+type loadAgentKeys struct {
+	host    sqlite.Host
+	connect bool
+}
+
+// agentKeysLoaded is sent once every key of a loadAgentKeys request was handled
+type agentKeysLoaded struct {
+	host    sqlite.Host
+	connect bool
+	loaded  int
+	err     error
+}
+
+// agentKeysPrepared is sent once the unencrypted keys of a loadAgentKeys request were added, pending are the
+// encrypted keys a passphrase is still needed for
+type agentKeysPrepared struct {
+	req     loadAgentKeys
+	pending []string
+	loaded  int
+	err     error
+}
+
+// agentKeysListed is sent with the keys of the agent once they were read
+type agentKeysListed struct {
+	keys []sshUtils.AgentKey
+	err  error
+}
+
+// agentPassphraseAdded is sent once the key being asked for was added with the given passphrase
+type agentPassphraseAdded struct {
+	err error
+}
+
+// agentKeyRemoved is sent once key was removed from the agent
+type agentKeyRemoved struct {
+	key sshUtils.AgentKey
+	err error
+}
+
+type agentModalState struct {
+	visible  bool
+	host     *sqlite.Host
+	keys     []sshUtils.AgentKey
+	selected int
+	loading  bool // the keys of the agent are being read
+	message  string
+	err      error
+}
+
+type agentPassphraseModalState struct {
+	visible bool
+	host    sqlite.Host
+	connect bool
+	pending []string // encrypted keys left to load, the first is being asked for
+	loaded  int
+	adding  bool // the passphrase was submitted and the key is being added
+	input   textinput.Model
+	err     error
+}
+
+// withAgent runs fn against the running ssh agent
+func withAgent(fn func(ag agent.Agent) error) error {
+	ag, err := sshUtils.DialAgent()
+	if err != nil {
+		return err
+	}
+	defer ag.Close()
+	return fn(ag)
+}
+
+func (a AppModel) openAgentView(host *sqlite.Host) (AppModel, tea.Cmd) {
+	a.agentModal = agentModalState{visible: true, host: host}
+	return a.refreshAgentView(nil)
+}
+
+// refreshAgentView reads the keys of the agent in the background, keep is an error to show once they are listed
+func (a AppModel) refreshAgentView(keep error) (AppModel, tea.Cmd) {
+	hosts, err := a.db.GetAll()
+	if err != nil {
+		a.agentModal.err = err
+		return a, nil
+	}
+	a.agentModal.loading = true
+	return a, func() tea.Msg {
+		var keys []sshUtils.AgentKey
+		err := withAgent(func(ag agent.Agent) error {
+			var err error
+			keys, err = sshUtils.ListAgentKeys(ag, hosts)
+			return err
+		})
+		return agentKeysListed{keys: keys, err: errors.Join(err, keep)}
+	}
+}
+
+func (a AppModel) handleAgentKeysListed(msg agentKeysListed) AppModel {
+	a.agentModal.loading = false
+	a.agentModal.keys = msg.keys
+	a.agentModal.err = msg.err
+	a.agentModal.selected = min(a.agentModal.selected, max(0, len(a.agentModal.keys)-1))
+	return a
+}
+
+// loadAgentKeys adds the unencrypted keys of the request in the background, see handleAgentKeysPrepared
+func (a AppModel) loadAgentKeys(req loadAgentKeys) (AppModel, tea.Cmd) {
+	lifetime := a.cfg.Ssh.Agent.GetLifetime()
+	return a, func() tea.Msg {
+		pending := make([]string, 0)
+		loaded := 0
+		err := withAgent(func(ag agent.Agent) error {
+			missing, err := sshUtils.MissingAgentKeys(ag, req.host)
+			if err != nil {
+				return err
+			}
+			errs := make([]error, 0)
+			for _, path := range missing {
+				if sshUtils.KeyNeedsPassphrase(path) {
+					pending = append(pending, path)
+					continue
+				}
+				if err = sshUtils.AddKeyToAgent(ag, path, "", lifetime); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				loaded++
+			}
+			return errors.Join(errs...)
+		})
+		return agentKeysPrepared{req: req, pending: pending, loaded: loaded, err: err}
+	}
+}
+
+// handleAgentKeysPrepared asks for the passphrase of the encrypted keys of the request, if there are any
+func (a AppModel) handleAgentKeysPrepared(msg agentKeysPrepared) (AppModel, tea.Cmd) {
+	req := msg.req
+	if msg.err != nil || len(msg.pending) == 0 {
+		return a.handleAgentKeysLoaded(agentKeysLoaded{host: req.host, connect: req.connect, loaded: msg.loaded, err: msg.err})
+	}
+	input := textinput.New()
+	input.EchoMode = textinput.EchoPassword
+	input.Placeholder = "passphrase"
+	input.Focus()
+	a.agentPassphraseModal = agentPassphraseModalState{
+		visible: true,
+		host:    req.host,
+		connect: req.connect,
+		pending: msg.pending,
+		loaded:  msg.loaded,
+		input:   input,
+	}
+	return a, textinput.Blink
+}
+
+// submitAgentPassphrase loads the key being asked for in the background, see handleAgentPassphraseAdded
+func (a AppModel) submitAgentPassphrase() (AppModel, tea.Cmd) {
+	modal := &a.agentPassphraseModal
+	path, passphrase := modal.pending[0], modal.input.Value()
+	lifetime := a.cfg.Ssh.Agent.GetLifetime()
+	modal.input.SetValue("")
+	modal.adding = true
+	return a, func() tea.Msg {
+		err := withAgent(func(ag agent.Agent) error {
+			return sshUtils.AddKeyToAgent(ag, path, passphrase, lifetime)
+		})
+		return agentPassphraseAdded{err: err}
+	}
+}
+
+// handleAgentPassphraseAdded moves on to the next key, a wrong passphrase asks again
+func (a AppModel) handleAgentPassphraseAdded(msg agentPassphraseAdded) (AppModel, tea.Cmd) {
+	modal := &a.agentPassphraseModal
+	modal.adding = false
+	if msg.err != nil {
+		modal.err = msg.err
+		return a, nil
+	}
+	modal.err = nil
+	modal.loaded++
+	return a.nextAgentPassphrase()
+}
+
+// nextAgentPassphrase moves on to the next encrypted key or finishes the request
+func (a AppModel) nextAgentPassphrase() (AppModel, tea.Cmd) {
+	modal := &a.agentPassphraseModal
+	modal.pending = modal.pending[1:]
+	if len(modal.pending) > 0 {
+		return a, nil
+	}
+	modal.visible = false
+	done := agentKeysLoaded{host: modal.host, connect: modal.connect, loaded: modal.loaded}
+	return a, func() tea.Msg { return done }
+}
+
+// removeSelectedAgentKey unloads the highlighted key of the agent modal in the background
+func (a AppModel) removeSelectedAgentKey() (AppModel, tea.Cmd) {
+	if len(a.agentModal.keys) == 0 {
+		return a, nil
+	}
+	key := a.agentModal.keys[a.agentModal.selected]
+	return a, func() tea.Msg {
+		err := withAgent(func(ag agent.Agent) error {
+			return sshUtils.RemoveAgentKey(ag, key.Fingerprint)
+		})
+		return agentKeyRemoved{key: key, err: err}
+	}
+}
+
+func (a AppModel) handleAgentKeyRemoved(msg agentKeyRemoved) (AppModel, tea.Cmd) {
+	if msg.err != nil {
+		a.agentModal.err = msg.err
+		return a, nil
+	}
+	a.agentModal.message = "Removed " + agentKeyName(msg.key) + " from the agent"
+	return a.refreshAgentView(nil)
+}
+
+func (a AppModel) handleAgentKeysLoaded(msg agentKeysLoaded) (AppModel, tea.Cmd) {
+	if msg.err != nil {
+		slog.Warn("Failed to load keys into the ssh agent", "host", msg.host.Host, "error", msg.err)
+	}
+	if msg.connect {
+		// ssh falls back to reading the keys itself so a failed load should not block connecting
+		return a, runSSHProgram(msg.host, a.cfg.Ssh.ExcPath, a.cfg.GetSshConfigFilePath())
+	}
+	if a.agentModal.visible {
+		a.agentModal.message = fmt.Sprintf("Loaded %d keys of %s", msg.loaded, msg.host.Host)
+		return a.refreshAgentView(msg.err)
+	}
+	return a, nil
+}
+
+func agentKeyName(key sshUtils.AgentKey) string {
+	switch {
+	case key.Path != "":
+		return filepath.Base(key.Path)
+	case key.Comment != "":
+		return key.Comment
+	}
+	return key.Fingerprint
+}
+
+func (a AppModel) agentModalView() string {
+	width := max(80, a.width*2/3)
+	title := lipgloss.NewStyle().Bold(true).Render("SSH Agent")
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#9CA3AF"))
+	selectedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#7D56F4")).Bold(true)
+	var content string
+	switch {
+	case a.agentModal.loading && len(a.agentModal.keys) == 0:
+		content = "Reading the agent..."
+	case errors.Is(a.agentModal.err, sshUtils.ErrNoAgent):
+		content = "No ssh agent is running, start one with eval $(ssh-agent) before launching ssh_man"
+	case a.agentModal.err != nil && len(a.agentModal.keys) == 0:
+		content = "Failed to read the agent. Error: " + a.agentModal.err.Error()
+	case len(a.agentModal.keys) == 0:
+		content = "The agent has no keys loaded"
+	default:
+		lines := make([]string, 0, len(a.agentModal.keys)*2)
+		for i, key := range a.agentModal.keys {
+			name := agentKeyName(key)
+			if key.Certificate {
+				name += " (certificate)"
+			}
+			if i == a.agentModal.selected {
+				name = selectedStyle.Render("> " + name)
+			} else {
+				name = "  " + name
+			}
+			unlocks := "not used by any host"
+			if len(key.Hosts) > 0 {
+				unlocks = "unlocks " + strings.Join(key.Hosts, ", ")
+			}
+			lines = append(lines, name, dimStyle.Render(fmt.Sprintf("    %s %s, %s", key.Type, key.Fingerprint, unlocks)))
+		}
+		content = strings.Join(lines, "\n")
+	}
+	if a.agentModal.err != nil && len(a.agentModal.keys) > 0 {
+		content += "\n\nError: " + a.agentModal.err.Error()
+	} else if a.agentModal.message != "" {
+		content += "\n\n" + a.agentModal.message
+	}
+	tail := fmt.Sprintf("\n%s to select, %s to remove the selected key, %s to close", a.keys.nav(), keyName(a.keys.AgentRemove), keyName(a.keys.Close))
+	if a.agentModal.host != nil {
+		tail = fmt.Sprintf("\n%s to select, %s to load the keys of %s, %s to remove the selected key, %s to close",
+			a.keys.nav(), keyName(a.keys.AgentLoad), a.agentModal.host.Host, keyName(a.keys.AgentRemove), keyName(a.keys.Close))
+	}
+	return lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder()).
+		Width(width).
+		Padding(1, 2).
+		Render(lipgloss.JoinVertical(lipgloss.Left, title, "", lipgloss.NewStyle().Width(width-6).Render(content), tail))
+}
+
+func (a AppModel) agentPassphraseModalView() string {
+	modal := a.agentPassphraseModal
+	width := max(60, a.width/2)
+	title := lipgloss.NewStyle().Bold(true).Render("Load key into the ssh agent")
+	lines := []string{
+		title,
+		"",
+		fmt.Sprintf("Passphrase for %s", modal.pending[0]),
+		modal.input.View(),
+	}
+	if modal.adding {
+		lines = append(lines, "", "Loading the key...")
+	}
+	if modal.err != nil {
+		lines = append(lines, "", lipgloss.NewStyle().Foreground(lipgloss.Color("#EF4444")).Render(modal.err.Error()))
+	}
+	tail := fmt.Sprintf("\n%s to load, %s to skip this key", keyName(a.keys.Confirm), keyName(a.keys.Close))
+	if left := len(modal.pending) - 1; left > 0 {
+		tail += fmt.Sprintf(", %d more after this one", left)
+	}
+	lines = append(lines, tail)
+	return lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder()).
+		Width(width).
+		Padding(1, 2).
+		Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+}
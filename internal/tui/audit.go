@@ -0,0 +1,267 @@
+package tui
+
+import (
+	"andrew/sshman/internal/sqlite"
+	"andrew/sshman/internal/sshUtils"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// startAuditView fetches and audits the authorized_keys of hosts
+type startAuditView struct {
+	hosts []string
+}
+
+// auditFinished is sent once every host of an audit was fetched
+type auditFinished struct {
+	audits []sshUtils.HostAudit
+}
+
+// auditKeysRemoved is sent once the marked entries were removed, errs holds the hosts that failed
+type auditKeysRemoved struct {
+	hosts   []string
+	removed int
+	errs    []error
+}
+
+// auditRow is an entry of the audit modal, entry is -1 for hosts that could not be audited or have no keys
+type auditRow struct {
+	audit int
+	entry int
+}
+
+type auditModalState struct {
+	visible    bool
+	running    bool
+	hosts      []string
+	audits     []sshUtils.HostAudit
+	rows       []auditRow
+	selected   int
+	marked     map[auditRow]bool // entries to remove
+	confirming bool
+	message    string
+	err        error
+}
+
+// openAuditView shows the audit modal and starts fetching the authorized_keys of hosts outside the ui thread
+func (a AppModel) openAuditView(hosts []string) (AppModel, tea.Cmd) {
+	a.auditModal = auditModalState{visible: true, running: true, hosts: hosts, marked: make(map[auditRow]bool)}
+	return a, a.runAudit(hosts)
+}
+
+func (a AppModel) runAudit(hosts []string) tea.Cmd {
+	var inventory []sqlite.Key
+	if a.keyDb != nil {
+		var err error
+		if inventory, err = a.keyDb.GetAll(); err != nil {
+			slog.Warn("Failed to read key inventory for the audit", "error", err)
+		}
+	}
+	local := sshUtils.LocalPublicKeys(a.cfg.GetKeyStorePath(), inventory)
+	ctx, cfg := a.ctx, a.cfg
+	sshOpts := a.sshOpts
+	return func() tea.Msg {
+		return auditFinished{audits: sshUtils.AuditHosts(ctx, hosts, local, cfg, sshUtils.AuditConcurrency, sshOpts...)}
+	}
+}
+
+// handleAuditFinished replaces the audits of the fetched hosts, hosts audited again after a removal keep their place
+func (a AppModel) handleAuditFinished(msg auditFinished) AppModel {
+	modal := &a.auditModal
+	modal.running = false
+	for _, audit := range msg.audits {
+		i := slices.IndexFunc(modal.audits, func(h sshUtils.HostAudit) bool { return h.Host == audit.Host })
+		if i == -1 {
+			modal.audits = append(modal.audits, audit)
+			continue
+		}
+		modal.audits[i] = audit
+	}
+	slices.SortStableFunc(modal.audits, func(x, y sshUtils.HostAudit) int {
+		return slices.Index(modal.hosts, x.Host) - slices.Index(modal.hosts, y.Host)
+	})
+	modal.rows = modal.rows[:0]
+	for i, audit := range modal.audits {
+		if audit.Err != nil || len(audit.Entries) == 0 {
+			modal.rows = append(modal.rows, auditRow{audit: i, entry: -1})
+			continue
+		}
+		for j := range audit.Entries {
+			modal.rows = append(modal.rows, auditRow{audit: i, entry: j})
+		}
+	}
+	modal.marked = make(map[auditRow]bool)
+	modal.selected = min(modal.selected, max(0, len(modal.rows)-1))
+	return a
+}
+
+// toggleAuditMark marks the highlighted entry for removal or clears its mark
+func (a AppModel) toggleAuditMark() AppModel {
+	modal := &a.auditModal
+	if len(modal.rows) == 0 {
+		return a
+	}
+	row := modal.rows[modal.selected]
+	if row.entry == -1 {
+		return a
+	}
+	if modal.marked[row] {
+		delete(modal.marked, row)
+	} else {
+		modal.marked[row] = true
+	}
+	return a
+}
+
+// markFlaggedAuditEntries marks every entry the audit flagged. Unknown keys are left out, they may well be the key
+// the user logs in with, so they have to be marked one by one
+func (a AppModel) markFlaggedAuditEntries() AppModel {
+	modal := &a.auditModal
+	for _, row := range modal.rows {
+		if row.entry == -1 {
+			continue
+		}
+		entry := modal.audits[row.audit].Entries[row.entry]
+		if len(entry.Flags()) > 0 && !entry.Unknown() {
+			modal.marked[row] = true
+		}
+	}
+	return a
+}
+
+// removeMarkedAuditEntries removes the marked entries from the authorized_keys of their hosts outside the ui thread
+func (a AppModel) removeMarkedAuditEntries() (AppModel, tea.Cmd) {
+	modal := &a.auditModal
+	modal.confirming = false
+	byHost := make(map[string][]sshUtils.AuditedKey)
+	hosts := make([]string, 0)
+	for _, row := range modal.rows {
+		if !modal.marked[row] {
+			continue
+		}
+		host := modal.audits[row.audit].Host
+		if _, ok := byHost[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		byHost[host] = append(byHost[host], modal.audits[row.audit].Entries[row.entry])
+	}
+	if len(hosts) == 0 {
+		return a, nil
+	}
+	modal.running = true
+	modal.message = ""
+	ctx, cfg := a.ctx, a.cfg
+	sshOpts := a.sshOpts
+	return a, func() tea.Msg {
+		result := auditKeysRemoved{hosts: hosts}
+		for _, host := range hosts {
+			removed, err := sshUtils.RemoveAuditedKeys(ctx, host, byHost[host], cfg, sshOpts...)
+			if err != nil {
+				result.errs = append(result.errs, fmt.Errorf("%s: %w", host, err))
+				continue
+			}
+			result.removed += removed
+		}
+		return result
+	}
+}
+
+// handleAuditKeysRemoved audits the hosts that were edited again so the modal shows what is left
+func (a AppModel) handleAuditKeysRemoved(msg auditKeysRemoved) (AppModel, tea.Cmd) {
+	a.auditModal.err = errors.Join(msg.errs...)
+	a.auditModal.message = fmt.Sprintf("Removed %d entries, the previous files are kept as ~/.ssh/authorized_keys.bak", msg.removed)
+	if !a.auditModal.visible {
+		return a, nil
+	}
+	return a, a.runAudit(msg.hosts)
+}
+
+func (a AppModel) auditModalView() string {
+	modal := a.auditModal
+	width := max(90, a.width*3/4)
+	title := lipgloss.NewStyle().Bold(true).Render("authorized_keys Audit")
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#9CA3AF"))
+	selectedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#7D56F4")).Bold(true)
+	flagStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#EF4444"))
+	okStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#10B981"))
+	var content string
+	switch {
+	case modal.running && len(modal.rows) == 0:
+		content = fmt.Sprintf("Fetching authorized_keys of %d hosts...", len(modal.hosts))
+	case len(modal.rows) == 0:
+		content = "No hosts to audit"
+	default:
+		visible := max(5, a.height-16)
+		start := max(0, min(modal.selected-visible/2, len(modal.rows)-visible))
+		end := min(len(modal.rows), start+visible)
+		lines := make([]string, 0, end-start+2)
+		for i := start; i < end; i++ {
+			row := modal.rows[i]
+			audit := modal.audits[row.audit]
+			var line string
+			switch {
+			case audit.Err != nil:
+				line = audit.Host + "  " + flagStyle.Render(audit.Err.Error())
+			case row.entry == -1:
+				line = audit.Host + "  " + dimStyle.Render("no authorized keys")
+			default:
+				entry := audit.Entries[row.entry]
+				mark := "[ ]"
+				if modal.marked[row] {
+					mark = "[x]"
+				}
+				comment := entry.Comment
+				if entry.Invalid {
+					comment = strings.TrimSpace(entry.Raw)
+				}
+				status := okStyle.Render("ok")
+				if flags := entry.Flags(); len(flags) > 0 {
+					status = flagStyle.Render(strings.Join(flags, ", "))
+				}
+				line = fmt.Sprintf("%s %s:%d %s %s  %s", mark, audit.Host, entry.Line, entry.Type, comment, status)
+			}
+			if i == modal.selected {
+				line = selectedStyle.Render("> ") + line
+			} else {
+				line = "  " + line
+			}
+			lines = append(lines, line)
+		}
+		if row := modal.rows[modal.selected]; row.entry != -1 {
+			entry := modal.audits[row.audit].Entries[row.entry]
+			detail := entry.Fingerprint
+			if entry.LocalKey != "" {
+				detail += ", local key " + entry.LocalKey
+			}
+			if len(entry.Options) > 0 {
+				detail += ", options " + strings.Join(entry.Options, ",")
+			}
+			lines = append(lines, "", dimStyle.Render(detail))
+		}
+		content = strings.Join(lines, "\n")
+	}
+	if modal.running && len(modal.rows) > 0 {
+		content += "\n\nWorking..."
+	}
+	if modal.err != nil {
+		content += "\n\nError: " + modal.err.Error()
+	} else if modal.message != "" {
+		content += "\n\n" + modal.message
+	}
+	tail := fmt.Sprintf("\n%s to select, %s to mark, %s to mark flagged entries, %s to remove marked entries, %s to close",
+		a.keys.nav(), keyName(a.keys.AuditMark), keyName(a.keys.AuditMarkFlagged), keyName(a.keys.AuditRemove), keyName(a.keys.Close))
+	if modal.confirming {
+		tail = fmt.Sprintf("\nRemove %d marked entries from authorized_keys? %s to remove, %s to cancel", len(modal.marked), keyName(a.keys.Yes), keyName(a.keys.No))
+	}
+	return lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder()).
+		Width(width).
+		Padding(1, 2).
+		Render(lipgloss.JoinVertical(lipgloss.Left, title, "", lipgloss.NewStyle().Width(width-6).Render(content), tail))
+}
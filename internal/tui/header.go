@@ -9,15 +9,20 @@ import (
 )
 
 type HeaderModel struct {
-	height, width int
-	numberOfHost  uint
-	buildMajor    int
-	buildMinor    int
-	buildPatch    int
-	buildDate     string
-	buildOS       string
-	buildArch     string
-	programName   string
+	height, width       int
+	numberOfHost        uint
+	tunnels             int // running forward profiles
+	rotationsDue        int // hosts with keys breaking the key policy
+	unfinishedRotations int // rotations in the ledger left to resume or roll back
+	hostsUp             int // hosts whose ssh port answered the last ping
+	hostsPinged         int // hosts pinged at least once
+	buildMajor          int
+	buildMinor          int
+	buildPatch          int
+	buildDate           string
+	buildOS             string
+	buildArch           string
+	programName         string
 }
 
 func NewHeaderModel(numberOfHost uint) HeaderModel {
@@ -50,6 +55,18 @@ func (h HeaderModel) View() string {
 	//TODO implement me, render build info, program name, total host, etc
 	separator := "⏺"
 	numHostString := strconv.Itoa(int(h.numberOfHost))
+	if h.hostsPinged > 0 {
+		numHostString += " " + separator + " Up: " + strconv.Itoa(h.hostsUp) + "/" + strconv.Itoa(h.hostsPinged)
+	}
+	if h.tunnels > 0 {
+		numHostString += " " + separator + " Tunnels: " + strconv.Itoa(h.tunnels)
+	}
+	if h.rotationsDue > 0 {
+		numHostString += " " + separator + " Rotation Due: " + strconv.Itoa(h.rotationsDue)
+	}
+	if h.unfinishedRotations > 0 {
+		numHostString += " " + separator + " Unfinished Rotations: " + strconv.Itoa(h.unfinishedRotations)
+	}
 	majorStyle := lipgloss.NewStyle().Background(lipgloss.Color("46")).Foreground(lipgloss.Color("#000"))
 	minorVersion := lipgloss.NewStyle().Background(lipgloss.Color("51")).Foreground(lipgloss.Color("#000"))
 	patchVersion := lipgloss.NewStyle().Background(lipgloss.Color("39")).Foreground(lipgloss.Color("#000"))
@@ -0,0 +1,157 @@
+package tui
+
+import (
+	"andrew/sshman/internal/sqlite"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// sparklineSamples is the number of most recent samples the sparkline of a host shows
+const sparklineSamples = 24
+
+var sparkBars = []rune("▁▂▃▄▅▆▇█")
+
+// pingStatusOf maps the result of a ping to the status its sample is stored with
+func pingStatusOf(p pingResult) sqlite.PingStatus {
+	switch {
+	case p.err != nil:
+		return sqlite.PingDown
+	case p.notSSH:
+		return sqlite.PingNotSSH
+	case p.hostReachable:
+		return sqlite.PingUp
+	default:
+		return sqlite.PingRefused
+	}
+}
+
+// loadPingHistory drops the samples older than the configured history and loads the rest
+func (a *AppModel) loadPingHistory() {
+	history := a.cfg.PingScan.GetHistory()
+	if a.db == nil || history == 0 {
+		return
+	}
+	since := time.Now().Add(-history)
+	if err := a.db.PrunePingSamples(since); err != nil {
+		slog.Warn("Failed to prune ping samples", "error", err)
+	}
+	samples, err := a.db.GetPingSamples(since)
+	if err != nil {
+		slog.Warn("Failed to load ping samples, history will start empty", "error", err)
+		return
+	}
+	a.hostsModel.pingHistory = samples
+}
+
+// recordPing stores a sample of p and adds it to the history of its host, nothing is kept when history is off
+func (a AppModel) recordPing(p pingResult) AppModel {
+	history := a.cfg.PingScan.GetHistory()
+	if history == 0 || errors.Is(p.err, context.Canceled) {
+		// a canceled ping says nothing about the host
+		return a
+	}
+	sample := sqlite.PingSample{Host: p.host, TakenAt: time.Now(), Status: pingStatusOf(p)}
+	if sample.Status == sqlite.PingUp {
+		sample.Latency = p.ping
+	}
+	if a.db != nil {
+		// hosts not written yet when write through is off have nowhere to store samples
+		if err := a.db.InsertPingSample(sample); err != nil {
+			slog.Warn("Failed to store ping sample", "host", p.host, "error", err)
+		}
+	}
+	a.hostsModel.addPingSample(sample, history)
+	return a
+}
+
+// pruneStoredPingSamples drops the stored samples that fell out of the history
+func (a AppModel) pruneStoredPingSamples() {
+	history := a.cfg.PingScan.GetHistory()
+	if a.db == nil || history == 0 {
+		return
+	}
+	if err := a.db.PrunePingSamples(time.Now().Add(-history)); err != nil {
+		slog.Warn("Failed to prune ping samples", "error", err)
+	}
+}
+
+// addPingSample appends sample to the history of its host and drops the samples older than history
+func (h *HostsPanelModel) addPingSample(sample sqlite.PingSample, history time.Duration) {
+	if h.pingHistory == nil {
+		h.pingHistory = make(map[string][]sqlite.PingSample)
+	}
+	samples := append(h.pingHistory[sample.Host], sample)
+	cutoff := sample.TakenAt.Add(-history)
+	for len(samples) > 0 && samples[0].TakenAt.Before(cutoff) {
+		samples = samples[1:]
+	}
+	h.pingHistory[sample.Host] = samples
+}
+
+// uptime returns the share of samples taken since since that found the host up, false if there are none
+func uptime(samples []sqlite.PingSample, since time.Time) (float64, bool) {
+	taken, up := 0, 0
+	for _, sample := range samples {
+		if sample.TakenAt.Before(since) {
+			continue
+		}
+		taken++
+		if sample.Status == sqlite.PingUp {
+			up++
+		}
+	}
+	if taken == 0 {
+		return 0, false
+	}
+	return float64(up) / float64(taken) * 100, true
+}
+
+func formatUptime(samples []sqlite.PingSample, since time.Time) string {
+	percent, ok := uptime(samples, since)
+	if !ok {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.1f%%", percent)
+}
+
+// sparkline renders the latency of the most recent samples scaled to the slowest of them, samples that did not
+// find the host up are marked with a red ✕
+func sparkline(samples []sqlite.PingSample) string {
+	recent := samples[max(0, len(samples)-sparklineSamples):]
+	slowest := time.Duration(0)
+	for _, sample := range recent {
+		if sample.Status == sqlite.PingUp {
+			slowest = max(slowest, sample.Latency)
+		}
+	}
+	failed := lipgloss.NewStyle().Foreground(lipgloss.Color("#EF4444")).Render("✕")
+	builder := strings.Builder{}
+	for _, sample := range recent {
+		if sample.Status != sqlite.PingUp {
+			builder.WriteString(failed)
+			continue
+		}
+		bar := 0
+		if slowest > 0 {
+			bar = int(sample.Latency * time.Duration(len(sparkBars)-1) / slowest)
+		}
+		builder.WriteRune(sparkBars[bar])
+	}
+	return builder.String()
+}
+
+// formatPingHistory renders the sparkline of a host along with its uptime over the last day and week, empty if
+// the host has no samples
+func formatPingHistory(samples []sqlite.PingSample, now time.Time) string {
+	if len(samples) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s  24h %s · 7d %s", sparkline(samples),
+		formatUptime(samples, now.Add(-24*time.Hour)), formatUptime(samples, now.Add(-7*24*time.Hour)))
+}
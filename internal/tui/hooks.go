@@ -0,0 +1,240 @@
+package tui
+
+import (
+	"andrew/sshman/internal/sqlite"
+	"andrew/sshman/internal/sshUtils"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// hookOutput carries a line written by the hooks being run
+type hookOutput struct {
+	line string
+}
+
+// hooksFinished is sent once the hooks of a stage ran, err is set by the hook that failed
+type hooksFinished struct {
+	err error
+}
+
+type hooksModalState struct {
+	visible  bool
+	running  bool
+	aborting bool
+	host     sqlite.Host
+	stage    sqlite.HookStage
+	lines    []string
+	updates  chan string
+	result   chan error
+	cancel   context.CancelFunc
+	err      error
+	view     viewport.Model
+}
+
+// lineWriter sends every complete line written to it to lines
+type lineWriter struct {
+	lines chan<- string
+	buf   []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i == -1 {
+			return len(p), nil
+		}
+		w.lines <- strings.TrimRight(string(w.buf[:i]), "\r")
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// flush sends what is left of a line that did not end with a newline
+func (w *lineWriter) flush() {
+	if len(w.buf) > 0 {
+		w.lines <- string(w.buf)
+		w.buf = nil
+	}
+}
+
+// hostHooks returns the hooks of stage of host, none if they could not be read
+func (a AppModel) hostHooks(host sqlite.Host, stage sqlite.HookStage) []sqlite.Hook {
+	if a.db == nil {
+		return nil
+	}
+	hooks, err := a.db.GetHooks(host.Host)
+	if err != nil {
+		slog.Warn("Failed to get hooks of host, running ssh without them", "host", host.Host, "error", err)
+		return nil
+	}
+	return sshUtils.HooksOf(hooks, stage)
+}
+
+// connect starts ssh for host once its pre connect hooks ran
+func (a AppModel) connect(host sqlite.Host) (AppModel, tea.Cmd) {
+	if hooks := a.hostHooks(host, sqlite.HookPreConnect); len(hooks) > 0 {
+		return a.runHooks(host, sqlite.HookPreConnect, hooks)
+	}
+	return a.startSSH(host)
+}
+
+// startSSH hands the terminal to ssh, loading the keys of host into the agent first if configured
+func (a AppModel) startSSH(host sqlite.Host) (AppModel, tea.Cmd) {
+	if a.cfg.Ssh.Agent.AutoLoad {
+		return a.loadAgentKeys(loadAgentKeys{host: host, connect: true})
+	}
+	// todo in the future we also want to pass options given from the command line
+	return a, runSSHProgram(host, a.cfg.Ssh.ExcPath, a.cfg.GetSshConfigFilePath())
+}
+
+// disconnected runs the post disconnect hooks of host once ssh exited
+func (a AppModel) disconnected(host sqlite.Host) (AppModel, tea.Cmd) {
+	if hooks := a.hostHooks(host, sqlite.HookPostDisconnect); len(hooks) > 0 {
+		return a.runHooks(host, sqlite.HookPostDisconnect, hooks)
+	}
+	return a, nil
+}
+
+// runHooks runs hooks outside the ui thread, their output streams into the hooks modal through waitForHookOutput
+func (a AppModel) runHooks(host sqlite.Host, stage sqlite.HookStage, hooks []sqlite.Hook) (AppModel, tea.Cmd) {
+	ctx, cancel := context.WithCancel(a.ctx)
+	a.hooksModal = hooksModalState{
+		visible: true,
+		running: true,
+		host:    host,
+		stage:   stage,
+		updates: make(chan string),
+		result:  make(chan error, 1),
+		cancel:  cancel,
+		view:    viewport.New(60, 15),
+	}
+	a.fillHooksView()
+	updates, result := a.hooksModal.updates, a.hooksModal.result
+	hosts, cfg := slices.Clone(a.hostsModel.data), a.cfg
+	a.work.goTracked(func() {
+		defer cancel()
+		out := &lineWriter{lines: updates}
+		err := sshUtils.RunHooks(ctx, hooks, stage, host, hosts, cfg, out)
+		out.flush()
+		result <- err
+		close(updates)
+	})
+	return a, waitForHookOutput(updates, result)
+}
+
+// waitForHookOutput waits for the next line of the hooks being run
+func waitForHookOutput(updates chan string, result chan error) tea.Cmd {
+	return func() tea.Msg {
+		line, ok := <-updates
+		if !ok {
+			return hooksFinished{err: <-result}
+		}
+		return hookOutput{line: line}
+	}
+}
+
+// handleHooksFinished connects once the pre connect hooks succeeded, the modal stays open otherwise so the
+// output can be read
+func (a AppModel) handleHooksFinished(msg hooksFinished) (AppModel, tea.Cmd) {
+	modal := &a.hooksModal
+	modal.running = false
+	modal.err = msg.err
+	a.fillHooksView()
+	if msg.err != nil {
+		slog.Warn("Hook failed", "host", modal.host.Host, "stage", modal.stage, "error", msg.err)
+		return a, nil
+	}
+	if modal.stage == sqlite.HookPreConnect {
+		modal.visible = false
+		return a.startSSH(modal.host)
+	}
+	return a, nil
+}
+
+func (a AppModel) handleHooksModalKey(msg tea.KeyMsg) (AppModel, tea.Cmd) {
+	modal := &a.hooksModal
+	switch {
+	case key.Matches(msg, a.keys.Close):
+		if modal.running {
+			if !modal.aborting {
+				modal.aborting = true
+				modal.cancel()
+			}
+			return a, nil
+		}
+		modal.visible = false
+		a.focusState = mainViewMode
+	case key.Matches(msg, a.keys.Confirm):
+		if modal.running {
+			return a, nil
+		}
+		modal.visible = false
+		a.focusState = mainViewMode
+		if modal.stage == sqlite.HookPreConnect && modal.err != nil {
+			return a.startSSH(modal.host) // connect anyway
+		}
+	case key.Matches(msg, a.keys.Up):
+		modal.view.ScrollUp(1)
+	case key.Matches(msg, a.keys.Down):
+		modal.view.ScrollDown(1)
+	}
+	return a, nil
+}
+
+// fillHooksView sizes the viewport of the hooks modal and sets its content in Update so it can be scrolled. The
+// view keeps following the output while it is scrolled to the bottom
+func (a *AppModel) fillHooksView() {
+	modal := &a.hooksModal
+	width := max(80, a.width*2/3)
+	follow := modal.view.AtBottom()
+	lines := slices.Clone(modal.lines)
+	if modal.err != nil {
+		message := modal.err.Error()
+		if errors.Is(modal.err, context.Canceled) {
+			message = "Aborted"
+		}
+		lines = append(lines, "", lipgloss.NewStyle().Foreground(lipgloss.Color("#EF4444")).Render(message))
+	}
+	modal.view.Width = width - 4
+	modal.view.Height = max(6, min(24, a.height/2))
+	modal.view.SetContent(lipgloss.NewStyle().Width(width - 6).Render(strings.Join(lines, "\n")))
+	if follow {
+		modal.view.GotoBottom()
+	}
+}
+
+func (a AppModel) hooksModalView() string {
+	modal := a.hooksModal
+	width := max(80, a.width*2/3)
+	stage := "Pre connect"
+	if modal.stage == sqlite.HookPostDisconnect {
+		stage = "Post disconnect"
+	}
+	title := lipgloss.NewStyle().Bold(true).Render(fmt.Sprintf("%s hooks of %s", stage, modal.host.Host))
+	var tail string
+	switch {
+	case modal.aborting && modal.running:
+		tail = "\naborting..."
+	case modal.running:
+		tail = fmt.Sprintf("\nrunning..., %s to abort", keyName(a.keys.Close))
+	case modal.stage == sqlite.HookPreConnect && modal.err != nil:
+		tail = fmt.Sprintf("\n%s to scroll, %s to connect anyway, %s to close", a.keys.nav(), keyName(a.keys.Confirm), keyName(a.keys.Close))
+	default:
+		tail = fmt.Sprintf("\n%s to scroll, %s", a.keys.nav(), a.keys.closeHint())
+	}
+	return lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder()).
+		Width(width).
+		Padding(1, 2).
+		Render(lipgloss.JoinVertical(lipgloss.Left, title, "", modal.view.View(), tail))
+}
@@ -4,12 +4,14 @@ import (
 	"andrew/sshman/internal/config"
 	"andrew/sshman/internal/ping"
 	"andrew/sshman/internal/sqlite"
+	"andrew/sshman/internal/sshUtils"
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"math"
 	"slices"
 	"sort"
-	"strconv"
 	"strings"
 	"time"
 
@@ -29,6 +31,7 @@ const (
 	hostTagColumnKey           = "tags"
 	hostPingColumnKey          = "ping"
 	hostStatusColumnKey        = "status"
+	hostCertColumnKey          = "cert"
 	hostRowPayloadKey          = "__host_payload"
 )
 
@@ -50,6 +53,9 @@ type TableKeyBinds struct {
 	Down        key.Binding
 	Left        key.Binding
 	Right       key.Binding
+	FirstPage   key.Binding
+	LastPage    key.Binding
+	Filter      key.Binding
 	Edit        key.Binding
 	Add         key.Binding
 	Delete      key.Binding
@@ -57,18 +63,29 @@ type TableKeyBinds struct {
 	Ping        key.Binding
 	GenerateKey key.Binding
 	RotateKey   key.Binding
+	BulkRotate  key.Binding
 	CycleView   key.Binding
+	Effective   key.Binding
+	Forwards    key.Binding
+	Tunnels     key.Binding
+	Lint        key.Binding
+	Keys        key.Binding
+	Agent       key.Binding
+	Rotations   key.Binding
+	Audit       key.Binding
+	AuditShown  key.Binding
 }
 
 func (t TableKeyBinds) ShortHelp() []key.Binding {
-	return []key.Binding{t.Up, t.Down, t.Left, t.Right, t.Edit, t.Add, t.Delete, t.Select, t.CycleView, t.Ping, t.GenerateKey, t.RotateKey}
+	return []key.Binding{t.Up, t.Down, t.Left, t.Right, t.Filter, t.Edit, t.Add, t.Delete, t.Select, t.CycleView, t.Ping, t.GenerateKey, t.RotateKey, t.BulkRotate, t.Effective, t.Forwards, t.Tunnels, t.Lint, t.Keys, t.Agent, t.Rotations, t.Audit, t.AuditShown}
 }
 
 func (t TableKeyBinds) FullHelp() [][]key.Binding {
 	binds := make([][]key.Binding, 0)
-	binds = append(binds, []key.Binding{t.Up, t.Down, t.Left, t.Right})
+	binds = append(binds, []key.Binding{t.Up, t.Down, t.Left, t.Right, t.FirstPage, t.LastPage, t.Filter})
 	binds = append(binds, []key.Binding{t.Edit, t.Add, t.Delete})
-	binds = append(binds, []key.Binding{t.Select, t.CycleView, t.Ping, t.GenerateKey, t.RotateKey})
+	binds = append(binds, []key.Binding{t.Select, t.CycleView, t.Ping, t.GenerateKey, t.RotateKey, t.BulkRotate})
+	binds = append(binds, []key.Binding{t.Effective, t.Forwards, t.Tunnels, t.Lint, t.Keys, t.Agent, t.Rotations, t.Audit, t.AuditShown})
 	return binds
 }
 
@@ -99,97 +116,87 @@ func (i InfoViewKeyBinds) FullHelp() [][]key.Binding {
 	return binds
 }
 
-var tableKeyMap TableKeyBinds = TableKeyBinds{
-	Up: key.NewBinding(
-		key.WithKeys("k", "up"),
-		key.WithHelp("k/↑", "up")),
-	Down: key.NewBinding(
-		key.WithKeys("j", "down"),
-		key.WithHelp("j/↓", "down")),
-	Left: key.NewBinding(
-		key.WithKeys("h", "left"),
-		key.WithHelp("h/←", "left"),
-	),
-	Right: key.NewBinding(
-		key.WithKeys("l", "right"),
-		key.WithHelp("l/→", "right")),
-	Edit: key.NewBinding(
-		key.WithKeys("e"),
-		key.WithHelp("e", "edit")),
-	Add: key.NewBinding(
-		key.WithKeys("a"),
-		key.WithHelp("a", "add")),
-	Delete: key.NewBinding(
-		key.WithKeys("d"),
-		key.WithHelp("d", "delete")),
-	Select: key.NewBinding(key.WithKeys("enter"),
-		key.WithHelp("enter", "connect to host")),
-	Ping: key.NewBinding(
-		key.WithKeys("p"),
-		key.WithHelp("p", "ping host"),
-	),
-	GenerateKey: key.NewBinding(
-		key.WithKeys("g"),
-		key.WithHelp("g", "generate key"),
-	),
-	RotateKey: key.NewBinding(
-		key.WithKeys("r"),
-		key.WithHelp("r", "rotate keys"),
-	),
-	CycleView: key.NewBinding(
-		key.WithKeys("ctrl+w"),
-		key.WithHelp("ctrl+w", "cycle views")),
-}
-
-var infoPanelKeyMap InfoViewKeyBinds = InfoViewKeyBinds{
-	Up: key.NewBinding(
-		key.WithKeys("k", "up"),
-		key.WithHelp("k/↑", " up ")),
-	Down: key.NewBinding(
-		key.WithKeys("j", "down"),
-		key.WithHelp("j/↓", " down ")),
-	Next: key.NewBinding(
-		key.WithKeys("tab"),
-		key.WithHelp("tab", " next ")),
-	Prev: key.NewBinding(
-		key.WithKeys("shift+tab"),
-		key.WithHelp("shift+tab", " prev ")),
-	CollapseToggle: key.NewBinding(
-		key.WithKeys("shift+c"),
-		key.WithHelp("shift+c", " collapse ")),
-	Save: key.NewBinding(
-		key.WithKeys("ctrl+s"),
-		key.WithHelp("ctrl+s", " save ")),
-	AddOption: key.NewBinding(
-		key.WithKeys("ctrl+a"),
-		key.WithHelp("ctrl+a", " add option ")),
-	DeleteOption: key.NewBinding(
-		key.WithKeys("ctrl+d"),
-		key.WithHelp("ctrl+d", " delete option ")),
-	ChangeView: key.NewBinding(
-		key.WithKeys("ctrl+w"),
-		key.WithHelp("ctrl+w", " change view ")),
-	CancelView: key.NewBinding(
-		key.WithKeys("esc"),
-		key.WithHelp("esc", " exit/cancel ")),
-	ScrollUpPreview: key.NewBinding(
-		key.WithKeys("ctrl+k"),
-		key.WithHelp("ctrl+k", "scroll preview up"),
-	),
-	ScrollDownPreview: key.NewBinding(
-		key.WithKeys("ctrl+j"),
-		key.WithHelp("ctrl+j", "scroll preview down"),
-	),
+// newTableKeyBinds builds the table bindings with the configured keys applied
+func newTableKeyBinds(bindings config.KeyBindings) TableKeyBinds {
+	binds := keyBindings(bindings, config.KeyScopeTable)
+	return TableKeyBinds{
+		Up:          binds["up"],
+		Down:        binds["down"],
+		Left:        binds["left"],
+		Right:       binds["right"],
+		FirstPage:   binds["first_page"],
+		LastPage:    binds["last_page"],
+		Filter:      binds["filter"],
+		Edit:        binds["edit"],
+		Add:         binds["add"],
+		Delete:      binds["delete"],
+		Select:      binds["connect"],
+		Ping:        binds["ping"],
+		GenerateKey: binds["generate_key"],
+		RotateKey:   binds["rotate_key"],
+		BulkRotate:  binds["bulk_rotate"],
+		CycleView:   binds["cycle_view"],
+		Effective:   binds["effective_config"],
+		Forwards:    binds["forwards"],
+		Tunnels:     binds["tunnels"],
+		Lint:        binds["lint"],
+		Keys:        binds["keys"],
+		Agent:       binds["agent"],
+		Rotations:   binds["rotations"],
+		Audit:       binds["audit"],
+		AuditShown:  binds["audit_shown"],
+	}
+}
+
+// tableKeyMap moves the navigation of the host table onto the table bindings, rows are never selected
+func tableKeyMap(t TableKeyBinds) table.KeyMap {
+	keyMap := table.DefaultKeyMap()
+	keyMap.RowUp = t.Up
+	keyMap.RowDown = t.Down
+	keyMap.PageUp = t.Left
+	keyMap.PageDown = t.Right
+	keyMap.PageFirst = t.FirstPage
+	keyMap.PageLast = t.LastPage
+	keyMap.Filter = t.Filter
+	keyMap.RowSelectToggle.SetEnabled(false)
+	return keyMap
+}
+
+// newInfoViewKeyBinds builds the info panel bindings with the configured keys applied
+func newInfoViewKeyBinds(bindings config.KeyBindings) InfoViewKeyBinds {
+	binds := keyBindings(bindings, config.KeyScopeInfoPanel)
+	return InfoViewKeyBinds{
+		Up:                binds["up"],
+		Down:              binds["down"],
+		Next:              binds["next"],
+		Prev:              binds["prev"],
+		CollapseToggle:    binds["collapse"],
+		Save:              binds["save"],
+		AddOption:         binds["add_option"],
+		DeleteOption:      binds["delete_option"],
+		ChangeView:        binds["change_view"],
+		CancelView:        binds["cancel"],
+		ScrollUpPreview:   binds["scroll_preview_up"],
+		ScrollDownPreview: binds["scroll_preview_down"],
+	}
 }
 
 type hostPingInfo struct {
-	reachable string // 🔴 down, 🟡 ip can be reached port ssh not responding, 🟢 up
-	ping      string // __xunit time part can only have 3 digits and unit must be 2 digits
+	reachable string            // 🔴 down, 🟣 name does not resolve, 🟡 ip can be reached port ssh not responding, 🟠 port open but not ssh, 🟢 up
+	ping      string            // __xunit time part can only have 3 digits and unit must be 2 digits
+	hops      map[string]string // jump host alias -> reachable symbol, ⚪ if not pinged, only set for hosts behind a ProxyJump
+	failedHop string            // jump host the route broke at
+	notSSH    bool
+	version   string // ssh server software of the banner, empty with the tcp probe
+	hostKey   string // host key fingerprint, only set by the hostkey probe
+	dnsFailed bool   // the name of the host did not resolve
+	addresses []ping.AddressResult
 }
 
 type HostsModel struct {
 	table         table.Model
 	cfg           config.Config
+	keys          TableKeyBinds
 	width, height int
 }
 
@@ -199,6 +206,7 @@ func NewHostsModel(cfg config.Config) HostsModel {
 		table.NewFlexColumn(hostHostnameColumnKey, "Hostname", 1).WithStyle(lipgloss.NewStyle().Align(lipgloss.Left).Foreground(lipgloss.Color("#0c97edff"))).WithFiltered(true),
 		table.NewFlexColumn(hostTagColumnKey, "Tags", 1).WithStyle(lipgloss.NewStyle().Align(lipgloss.Left).Foreground(lipgloss.Color("#0c97edff"))).WithFiltered(true),
 		table.NewFlexColumn(hostLastConnectedColumnKey, "Last Connected", 1).WithStyle(lipgloss.NewStyle().Align(lipgloss.Left).Foreground(lipgloss.Color("#0c97edff"))),
+		table.NewColumn(hostCertColumnKey, "Cert", 7).WithStyle(lipgloss.NewStyle().Align(lipgloss.Left).Foreground(lipgloss.Color("#0c97edff"))),
 		table.NewColumn(hostPingColumnKey, "Ping", 5).WithStyle(lipgloss.NewStyle().Align(lipgloss.Left).Foreground(lipgloss.Color("#0c97edff"))),
 		table.NewColumn(hostStatusColumnKey, "Status", 6).WithStyle(lipgloss.NewStyle().Align(lipgloss.Left).Foreground(lipgloss.Color("#0c97edff"))),
 	}
@@ -216,9 +224,11 @@ func NewHostsModel(cfg config.Config) HostsModel {
 		}).
 		Filtered(true).
 		BorderRounded()
+	keys := newTableKeyBinds(cfg.KeyBindings)
 	return HostsModel{
-		table: tbl,
+		table: tbl.WithKeyMap(tableKeyMap(keys)),
 		cfg:   cfg,
+		keys:  keys,
 	}
 }
 
@@ -266,6 +276,17 @@ func (h HostsModel) highlightedHost() *sqlite.Host {
 	return nil
 }
 
+// visibleHosts returns the hosts left after the table filter is applied
+func (h HostsModel) visibleHosts() []sqlite.Host {
+	hosts := make([]sqlite.Host, 0)
+	for _, row := range h.table.GetVisibleRows() {
+		if host, ok := row.Data[hostRowPayloadKey].(*sqlite.Host); ok {
+			hosts = append(hosts, *host)
+		}
+	}
+	return hosts
+}
+
 const (
 	optionFieldKey = iota
 	optionFieldValue
@@ -289,6 +310,8 @@ func newKvInputModel(key string, val string, initState bool, neverEdit bool) kvI
 	}
 	kv.key.Placeholder = "key"
 	kv.val.Placeholder = "value"
+	kv.key.ShowSuggestions = true
+	kv.key.SetSuggestions(sshUtils.GetListOfAcceptableOptions())
 	kv.key.SetValue(key)
 	kv.val.SetValue(val)
 	return kv
@@ -316,6 +339,29 @@ func (k *kvInputModel) focusField(field int) tea.Cmd {
 	}
 }
 
+// setValueSuggestions rebuilds the value suggestions from the option key currently entered
+func (k *kvInputModel) setValueSuggestions(ctx sshUtils.SuggestionContext) {
+	values := sshUtils.ValueSuggestions(strings.TrimSpace(k.key.Value()), ctx)
+	k.val.ShowSuggestions = len(values) > 0
+	k.val.SetSuggestions(values)
+}
+
+// acceptSuggestion completes the focused field with its current suggestion, reports false
+// if there was nothing to complete
+func (k *kvInputModel) acceptSuggestion() bool {
+	input := &k.val
+	if k.focusedField == optionFieldKey {
+		input = &k.key
+	}
+	suggestion := input.CurrentSuggestion()
+	if !input.ShowSuggestions || input.Value() == "" || suggestion == "" || suggestion == input.Value() {
+		return false
+	}
+	input.SetValue(suggestion)
+	input.CursorEnd()
+	return true
+}
+
 func (k *kvInputModel) focusCurrentField() tea.Cmd {
 	return k.focusField(k.focusedField)
 }
@@ -340,9 +386,17 @@ type HostsInfoModel struct {
 	selected                int
 	previewCollapsed        bool
 	pendingSave             bool
+	route                   string // rendered ProxyJump route, empty when the host is reached directly
+	server                  string // ssh server found by the last ping, empty until pinged
+	addresses               string // result per address of the last ping, empty for hosts with a single address
+	history                 string // latency sparkline and uptime, empty until pinged
+	suggestion              sshUtils.SuggestionContext
+	keys                    InfoViewKeyBinds
+	hosts                   []sqlite.Host // stored hosts the ProxyJump chain is validated against before saving
+	saveErr                 error         // why the last save was rejected, cleared once editing starts again
 }
 
-func NewHostsInfoModel() HostsInfoModel {
+func NewHostsInfoModel(keys InfoViewKeyBinds) HostsInfoModel {
 	optionsViewport := viewport.New(0, 0)
 	previewViewport := viewport.New(0, 0)
 	notes := textarea.New()
@@ -359,6 +413,7 @@ func NewHostsInfoModel() HostsInfoModel {
 		tagsInput:               tags,
 		mode:                    infoViewMode,
 		selected:                0,
+		keys:                    keys,
 	}
 }
 
@@ -373,16 +428,16 @@ func (h HostsInfoModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return h, nil
 		}
 		switch {
-		case key.Matches(msg, infoPanelKeyMap.CollapseToggle):
+		case key.Matches(msg, h.keys.CollapseToggle):
 			h.previewCollapsed = !h.previewCollapsed
 			return h, nil
-		case key.Matches(msg, infoPanelKeyMap.Up):
+		case key.Matches(msg, h.keys.Up):
 			cmd := h.moveSelection(-1)
 			return h, cmd
-		case key.Matches(msg, infoPanelKeyMap.Down):
+		case key.Matches(msg, h.keys.Down):
 			cmd := h.moveSelection(1)
 			return h, cmd
-		case key.Matches(msg, infoPanelKeyMap.Prev):
+		case key.Matches(msg, h.keys.Prev):
 			if h.mode == infoEditMode {
 				if handled, cmd := h.handleOptionFieldPrev(); handled {
 					return h, cmd
@@ -390,7 +445,7 @@ func (h HostsInfoModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			cmd := h.moveSelection(-1)
 			return h, cmd
-		case key.Matches(msg, infoPanelKeyMap.Next):
+		case key.Matches(msg, h.keys.Next):
 			if h.mode == infoEditMode {
 				if handled, cmd := h.handleOptionFieldNext(); handled {
 					return h, cmd
@@ -398,8 +453,14 @@ func (h HostsInfoModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			cmd := h.moveSelection(1)
 			return h, cmd
-		case key.Matches(msg, infoPanelKeyMap.Save) && h.mode == infoEditMode:
+		case key.Matches(msg, h.keys.Save) && h.mode == infoEditMode:
 			updated := h.buildUpdatedHost()
+			// the cli refuses the same chain, saving it would leave ssh unable to reach the host
+			if err := sshUtils.ValidateJumpChain(updated, h.hosts); err != nil {
+				h.saveErr = fmt.Errorf("invalid ProxyJump chain: %w", err)
+				return h, nil
+			}
+			h.saveErr = nil
 			h.currentEditHost = updated
 			h.HostPreviewString = buildHostPreview(updated)
 			h.pendingSave = true
@@ -408,10 +469,10 @@ func (h HostsInfoModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return h, func() tea.Msg {
 				return updateHostsMessage{host: updated}
 			}
-		case key.Matches(msg, infoPanelKeyMap.AddOption) && h.mode == infoEditMode:
+		case key.Matches(msg, h.keys.AddOption) && h.mode == infoEditMode:
 			cmd := h.addHostOption()
 			return h, cmd
-		case key.Matches(msg, infoPanelKeyMap.DeleteOption) && h.mode == infoEditMode:
+		case key.Matches(msg, h.keys.DeleteOption) && h.mode == infoEditMode:
 			cmd := h.deleteSelectedHostOption()
 			return h, cmd
 		}
@@ -450,6 +511,9 @@ func (h HostsInfoModel) View() string {
 	title := lipgloss.NewStyle().Bold(true).Render(fmt.Sprintf("Host %s", h.host))
 	var sections []string
 	sections = append(sections, title)
+	if h.saveErr != nil {
+		sections = append(sections, lipgloss.NewStyle().Foreground(lipgloss.Color("#EF4444")).Render("Not saved: "+h.saveErr.Error()))
+	}
 	createdAtString := h.currentEditHost.CreatedAt.Format("2006-01-02 15:04")
 	var updatedAtString string
 	if h.currentEditHost.UpdatedAt == nil {
@@ -460,8 +524,24 @@ func (h HostsInfoModel) View() string {
 	createdAtStringLine := lipgloss.NewStyle().Bold(true).Render("Created At: ") + lipgloss.NewStyle().Foreground(lipgloss.Color("#4cbef3ff")).Render(createdAtString)
 	updatedAtStringLine := lipgloss.NewStyle().Bold(true).Render("Updated At: ") + lipgloss.NewStyle().Foreground(lipgloss.Color("#4cbef3ff")).Render(updatedAtString)
 	sections = append(sections, createdAtStringLine, updatedAtStringLine)
+	if h.route != "" {
+		sections = append(sections, lipgloss.NewStyle().Bold(true).Render("Route: ")+h.route)
+	}
+	if h.server != "" {
+		sections = append(sections, lipgloss.NewStyle().Bold(true).Render("Server: ")+h.server)
+	}
+	if h.addresses != "" {
+		sections = append(sections, lipgloss.NewStyle().Bold(true).Render("Addresses: ")+h.addresses)
+	}
+	if h.history != "" {
+		sections = append(sections, lipgloss.NewStyle().Bold(true).Render("History: ")+h.history)
+	}
 	h.optionsScrollPane.SetContent(h.renderOptions())
-	sections = append(sections, lipgloss.NewStyle().Bold(true).Render("Options"))
+	optionsLabel := lipgloss.NewStyle().Bold(true).Render("Options")
+	if hint := h.selectedOptionHint(); hint != "" {
+		optionsLabel += clampTextWidth(lipgloss.NewStyle().Faint(true).Render(" · "+hint), h.width-lipgloss.Width(optionsLabel))
+	}
+	sections = append(sections, optionsLabel)
 	sections = append(sections, h.optionsScrollPane.View())
 
 	tagsLabel := "Tags"
@@ -521,6 +601,9 @@ func (h *HostsInfoModel) loadHost(host sqlite.Host) {
 	if h.mode == infoEditMode {
 		h.ExitEditMode()
 	}
+	if host.Host != h.host {
+		h.saveErr = nil
+	}
 	h.host = host.Host
 	h.currentEditHost = host
 	h.hostNotes.SetValue(host.Notes)
@@ -541,10 +624,12 @@ func (h *HostsInfoModel) clearHost() {
 	h.currentEditHost = sqlite.Host{}
 	h.hostOptions = nil
 	h.HostPreviewString = ""
+	h.route = ""
 	h.hostNotes.SetValue("")
 	h.tagsInput.SetValue("")
 	h.selected = 0
 	h.pendingSave = false
+	h.saveErr = nil
 }
 
 func (h *HostsInfoModel) EnterEditMode() tea.Cmd {
@@ -552,6 +637,7 @@ func (h *HostsInfoModel) EnterEditMode() tea.Cmd {
 		return nil
 	}
 	h.mode = infoEditMode
+	h.saveErr = nil
 	if !h.isIndexEditable(h.selected) {
 		h.selected = h.firstEditableIndex()
 	}
@@ -665,11 +751,22 @@ func (h *HostsInfoModel) handleOptionFieldNext() (bool, tea.Cmd) {
 	if !h.optionSelectionEditable() {
 		return false, nil
 	}
+	// tab completes the focused field first, the key field moves on right away since keys are single words
+	completed := h.hostOptions[h.selected].acceptSuggestion()
 	if h.hostOptions[h.selected].focusedField == optionFieldKey {
+		h.hostOptions[h.selected].setValueSuggestions(h.suggestion)
 		cmd := h.hostOptions[h.selected].focusField(optionFieldValue)
 		return true, cmd
 	}
-	return false, nil
+	return completed, nil
+}
+
+// selectedOptionHint explains the option being edited, empty outside of edit mode
+func (h HostsInfoModel) selectedOptionHint() string {
+	if h.mode != infoEditMode || !h.selectionIsOption() || !h.optionSelectionEditable() {
+		return ""
+	}
+	return sshUtils.OptionHint(strings.TrimSpace(h.hostOptions[h.selected].key.Value()))
 }
 
 func (h HostsInfoModel) tagSelectionIndex() int {
@@ -697,6 +794,7 @@ func (h *HostsInfoModel) focusCurrentInput() tea.Cmd {
 	if !h.selectionIsOption() || !h.optionSelectionEditable() {
 		return nil
 	}
+	h.hostOptions[h.selected].setValueSuggestions(h.suggestion)
 	return h.hostOptions[h.selected].focusCurrentField()
 }
 
@@ -896,11 +994,14 @@ type HostsPanelModel struct {
 	width, height   int
 	verticalLayout  bool
 	pingMap         map[string]hostPingInfo
+	pingHistory     map[string][]sqlite.PingSample // host -> ping samples oldest first, see recordPing
+	certExpiry      map[string]time.Time           // host -> earliest certificate expiry
+	ctx             context.Context                // pings in flight are canceled with it
 }
 
 func NewHostsPanelModel(cfg config.Config, hosts []sqlite.Host) HostsPanelModel {
 	tableModel := NewHostsModel(cfg)
-	infoModel := NewHostsInfoModel()
+	infoModel := NewHostsInfoModel(newInfoViewKeyBinds(cfg.KeyBindings))
 	panel := HostsPanelModel{
 		table:           tableModel,
 		infoPanel:       infoModel,
@@ -908,6 +1009,9 @@ func NewHostsPanelModel(cfg config.Config, hosts []sqlite.Host) HostsPanelModel
 		data:            make([]sqlite.Host, len(hosts)),
 		tableGrowthBias: defaultTableBias,
 		pingMap:         make(map[string]hostPingInfo),
+		pingHistory:     make(map[string][]sqlite.PingSample),
+		certExpiry:      make(map[string]time.Time),
+		ctx:             context.Background(),
 	}
 	copy(panel.data, hosts)
 	panel.table.setFocused(true)
@@ -918,17 +1022,17 @@ func NewHostsPanelModel(cfg config.Config, hosts []sqlite.Host) HostsPanelModel
 
 func (h HostsPanelModel) ShortHelp() []key.Binding {
 	if h.focus == focusTable {
-		return tableKeyMap.ShortHelp()
+		return h.table.keys.ShortHelp()
 	} else {
-		return infoPanelKeyMap.ShortHelp()
+		return h.infoPanel.keys.ShortHelp()
 	}
 }
 
 func (h HostsPanelModel) FullHelp() [][]key.Binding {
 	if h.focus == focusTable {
-		return tableKeyMap.FullHelp()
+		return h.table.keys.FullHelp()
 	} else {
-		return infoPanelKeyMap.FullHelp()
+		return h.infoPanel.keys.FullHelp()
 	}
 }
 
@@ -985,9 +1089,9 @@ func (h HostsPanelModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	if keyMsg, ok := msg.(tea.KeyMsg); ok {
 		if h.focus == focusTable {
 			switch {
-			case key.Matches(keyMsg, tableKeyMap.Add) && !h.table.table.GetIsFilterInputFocused():
+			case key.Matches(keyMsg, h.table.keys.Add) && !h.table.table.GetIsFilterInputFocused():
 				cmds = append(cmds, func() tea.Msg { return userAddHostMessage{} })
-			case key.Matches(keyMsg, tableKeyMap.Delete) && !h.table.table.GetIsFilterInputFocused():
+			case key.Matches(keyMsg, h.table.keys.Delete) && !h.table.table.GetIsFilterInputFocused():
 				data := h.table.highlightedHost()
 				if data == nil {
 					break
@@ -995,51 +1099,28 @@ func (h HostsPanelModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				// todo remove host from row
 				cmd := func() tea.Msg { return deleteHostMessage{host: data.Host} }
 				cmds = append(cmds, cmd)
-			case key.Matches(keyMsg, tableKeyMap.Edit) && !h.table.table.GetIsFilterInputFocused():
+			case key.Matches(keyMsg, h.table.keys.Edit) && !h.table.table.GetIsFilterInputFocused():
 				if cmd := h.beginEditSelectedHost(); cmd != nil {
 					h.focus = focusInfoPanel
 					h.table.setFocused(false)
 					cmds = append(cmds, cmd)
 				}
-			case key.Matches(keyMsg, tableKeyMap.CycleView):
+			case key.Matches(keyMsg, h.table.keys.CycleView):
 				if !(h.verticalLayout && h.infoPanel.mode != infoEditMode) {
 					h.focus = focusInfoPanel
 					h.table.setFocused(false)
 				}
-			case key.Matches(keyMsg, tableKeyMap.Select):
+			case key.Matches(keyMsg, h.table.keys.Select):
 				if host := h.table.highlightedHost(); host != nil {
 					cmds = append(cmds, startConnectCmd(*host))
 				}
-			case key.Matches(keyMsg, tableKeyMap.Ping) && !h.table.table.GetIsFilterInputFocused() && h.table.cfg.EnablePing:
+			case key.Matches(keyMsg, h.table.keys.Ping) && !h.table.table.GetIsFilterInputFocused() && h.table.cfg.EnablePing:
 				host := h.table.highlightedHost()
 				if host == nil {
 					break
 				}
-				cmds = append(cmds, func() tea.Msg {
-					hostname := hostOptionValue(host, "HostName")
-					if hostname == "" {
-						hostname = host.Host
-					}
-					port := hostOptionValue(host, "Port")
-					if port == "" {
-						port = "22"
-					}
-					portNum, err := strconv.Atoi(port)
-					if err != nil {
-						return pingResult{
-							host: host.Host,
-							err:  err,
-						}
-					}
-					res := ping.PingRemoteHost(hostname, uint(portNum), 2*time.Second)
-					return pingResult{
-						host:          host.Host,
-						err:           res.Err,
-						hostReachable: res.Reachable,
-						ping:          res.Latency,
-					}
-				})
-			case key.Matches(keyMsg, tableKeyMap.GenerateKey) && !h.table.table.GetIsFilterInputFocused():
+				cmds = append(cmds, pingHostCmd(h.ctx, *host, slices.Clone(h.data), h.table.cfg))
+			case key.Matches(keyMsg, h.table.keys.GenerateKey) && !h.table.table.GetIsFilterInputFocused():
 				host := h.table.highlightedHost()
 				if host == nil {
 					break
@@ -1047,7 +1128,7 @@ func (h HostsPanelModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				cmds = append(cmds, func() tea.Msg {
 					return startKeyGenerationForm{host.Host}
 				})
-			case key.Matches(keyMsg, tableKeyMap.RotateKey) && !h.table.table.GetIsFilterInputFocused():
+			case key.Matches(keyMsg, h.table.keys.RotateKey) && !h.table.table.GetIsFilterInputFocused():
 				host := h.table.highlightedHost()
 				if host == nil {
 					break
@@ -1057,19 +1138,86 @@ func (h HostsPanelModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						host: host.Host,
 					}
 				})
+			case key.Matches(keyMsg, h.table.keys.Effective) && !h.table.table.GetIsFilterInputFocused():
+				host := h.table.highlightedHost()
+				if host == nil {
+					break
+				}
+				selected := *host
+				cmds = append(cmds, func() tea.Msg {
+					return startEffectiveConfigView{host: selected}
+				})
+			case key.Matches(keyMsg, h.table.keys.Forwards) && !h.table.table.GetIsFilterInputFocused():
+				host := h.table.highlightedHost()
+				if host == nil {
+					break
+				}
+				alias := host.Host
+				cmds = append(cmds, func() tea.Msg {
+					return startForwardView{host: alias}
+				})
+			case key.Matches(keyMsg, h.table.keys.Tunnels) && !h.table.table.GetIsFilterInputFocused():
+				cmds = append(cmds, func() tea.Msg {
+					return startForwardView{}
+				})
+			case key.Matches(keyMsg, h.table.keys.Lint) && !h.table.table.GetIsFilterInputFocused():
+				cmds = append(cmds, func() tea.Msg {
+					return startLintView{}
+				})
+			case key.Matches(keyMsg, h.table.keys.Keys) && !h.table.table.GetIsFilterInputFocused():
+				cmds = append(cmds, func() tea.Msg {
+					return startKeysView{}
+				})
+			case key.Matches(keyMsg, h.table.keys.Agent) && !h.table.table.GetIsFilterInputFocused():
+				host := h.table.highlightedHost()
+				cmds = append(cmds, func() tea.Msg {
+					return startAgentView{host: host}
+				})
+			case key.Matches(keyMsg, h.table.keys.Rotations) && !h.table.table.GetIsFilterInputFocused():
+				cmds = append(cmds, func() tea.Msg {
+					return startRotationsView{}
+				})
+			case key.Matches(keyMsg, h.table.keys.Audit) && !h.table.table.GetIsFilterInputFocused():
+				host := h.table.highlightedHost()
+				if host == nil {
+					break
+				}
+				cmds = append(cmds, func() tea.Msg {
+					return startAuditView{hosts: []string{host.Host}}
+				})
+			case key.Matches(keyMsg, h.table.keys.AuditShown) && !h.table.table.GetIsFilterInputFocused():
+				hosts := h.table.visibleHosts()
+				if len(hosts) == 0 {
+					break
+				}
+				aliases := make([]string, 0, len(hosts))
+				for _, host := range hosts {
+					aliases = append(aliases, host.Host)
+				}
+				cmds = append(cmds, func() tea.Msg {
+					return startAuditView{hosts: aliases}
+				})
+			case key.Matches(keyMsg, h.table.keys.BulkRotate) && !h.table.table.GetIsFilterInputFocused():
+				hosts := h.table.visibleHosts()
+				if len(hosts) == 0 {
+					break
+				}
+				cmds = append(cmds, func() tea.Msg {
+					return startBulkRotateForm{hosts: hosts}
+				})
 			}
 		} else {
 			switch {
-			case key.Matches(keyMsg, infoPanelKeyMap.ChangeView), key.Matches(keyMsg, infoPanelKeyMap.CancelView):
+			case key.Matches(keyMsg, h.infoPanel.keys.ChangeView), key.Matches(keyMsg, h.infoPanel.keys.CancelView):
 				h.focus = focusTable
 				h.table.setFocused(true)
 				h.infoPanel.ExitEditMode()
-			case key.Matches(keyMsg, infoPanelKeyMap.ScrollUpPreview):
+			case key.Matches(keyMsg, h.infoPanel.keys.ScrollUpPreview):
 				h.infoPanel.previewOptionScrollPane.YOffset--
 				if h.infoPanel.previewOptionScrollPane.YOffset < 0 {
 					h.infoPanel.previewOptionScrollPane.YOffset = 0
 				}
-			case key.Matches(keyMsg, infoPanelKeyMap.ScrollDownPreview):
+			case key.Matches(keyMsg, h.infoPanel.keys.ScrollDownPreview):
 				h.infoPanel.previewOptionScrollPane.YOffset = min(len(strings.Split(h.infoPanel.HostPreviewString, "\n"))+1-h.infoPanel.previewOptionScrollPane.Height, h.infoPanel.previewOptionScrollPane.YOffset+1)
 			}
 		}
@@ -1134,7 +1282,7 @@ func (h *HostsPanelModel) beginEditSelectedHost() tea.Cmd {
 func (h *HostsPanelModel) refreshTableRows() {
 	rows := make([]table.Row, 0, len(h.data))
 	for i := range h.data {
-		rows = append(rows, hostToRow(&h.data[i], h.table.cfg, h.pingMap))
+		rows = append(rows, hostToRow(&h.data[i], h.table.cfg, h.pingMap, h.certExpiry))
 	}
 	h.table.setRows(rows)
 }
@@ -1154,6 +1302,21 @@ func (h *HostsPanelModel) syncInfoWithSelectionForced(forced bool) {
 	} else if forced {
 		h.infoPanel.loadHost(*host)
 	}
+	h.infoPanel.route = formatRoute(*host, h.data, h.pingMap)
+	h.infoPanel.server = formatServer(h.pingMap[host.Host])
+	h.infoPanel.addresses = formatAddresses(h.pingMap[host.Host])
+	h.infoPanel.history = formatPingHistory(h.pingHistory[host.Host], time.Now())
+	h.infoPanel.suggestion = newSuggestionContext(h.table.cfg, host.Host, h.data)
+	h.infoPanel.hosts = h.data
+}
+
+// newSuggestionContext builds the option value suggestion context for editing host, host is empty for new hosts
+func newSuggestionContext(cfg config.Config, host string, hosts []sqlite.Host) sshUtils.SuggestionContext {
+	aliases := make([]string, 0, len(hosts))
+	for _, h := range hosts {
+		aliases = append(aliases, h.Host)
+	}
+	return sshUtils.SuggestionContext{Host: host, HostAliases: aliases, KeyStore: cfg.GetKeyStorePath()}
 }
 
 func (h HostsPanelModel) upsertHost(host sqlite.Host) HostsPanelModel {
@@ -1171,7 +1334,36 @@ func (h HostsPanelModel) upsertHost(host sqlite.Host) HostsPanelModel {
 }
 
 func (h *HostsPanelModel) updatePingMap(p pingResult) {
-	info := hostPingInfo{}
+	info := hostPingInfo{failedHop: p.failedHop}
+	if len(p.hops) > 0 {
+		info.hops = make(map[string]string, len(p.hops))
+		for _, hop := range p.hops {
+			switch {
+			case !hop.probed:
+				info.hops[hop.host] = "⚪"
+			case errors.Is(hop.err, ping.ErrResolve):
+				info.hops[hop.host] = "🟣"
+			case hop.err != nil:
+				slog.Error("Error Pinging Jump Host", "Host", p.host, "Jump Host", hop.host, "Error", hop.err)
+				info.hops[hop.host] = "🔴"
+			case hop.notSSH:
+				info.hops[hop.host] = "🟠"
+			case hop.reachable:
+				info.hops[hop.host] = "🟢"
+			default:
+				info.hops[hop.host] = "🟡"
+			}
+		}
+	}
+	info.addresses = p.addresses
+	if errors.Is(p.err, ping.ErrResolve) {
+		slog.Error("Failed to resolve Remote Host", "Host", p.host, "Error", p.err)
+		info.ping = "dns"
+		info.reachable = "🟣"
+		info.dnsFailed = true
+		h.pingMap[p.host] = info
+		return
+	}
 	if p.err != nil {
 		slog.Error("Error Pinging Remote Host", "Host", p.host, "Error", p.err)
 		info.ping = "n/a"
@@ -1179,15 +1371,36 @@ func (h *HostsPanelModel) updatePingMap(p pingResult) {
 		h.pingMap[p.host] = info
 		return
 	}
-	if p.hostReachable {
+	switch {
+	case p.notSSH:
+		info.reachable = "🟠"
+	case p.hostReachable:
 		info.reachable = "🟢"
-	} else {
+	default:
 		info.reachable = "🟡"
 	}
+	info.notSSH = p.notSSH
+	info.version = p.serverVersion
+	info.hostKey = p.hostKey
 	info.ping = formatDurationCompact(p.ping)
 	h.pingMap[p.host] = info
 }
 
+// reachability counts the hosts of the table that answered their last ping and the hosts pinged so far
+func (h HostsPanelModel) reachability() (up, pinged int) {
+	for _, host := range h.data {
+		info, ok := h.pingMap[host.Host]
+		if !ok {
+			continue
+		}
+		pinged++
+		if info.reachable == "🟢" {
+			up++
+		}
+	}
+	return up, pinged
+}
+
 func (h *HostsPanelModel) updateLastConnection(host string, connectionTimeStamp time.Time) {
 	idx := slices.IndexFunc(h.data, func(existing sqlite.Host) bool {
 		return existing.Host == host
@@ -1201,16 +1414,145 @@ func (h *HostsPanelModel) updateLastConnection(host string, connectionTimeStamp
 	h.refreshTableRows()
 }
 
+// pingHostCmd pings host along the route ssh takes to it, see pingHostWithHops,
+// hosts is used to resolve the route and should not be shared with the ui thread
+func pingHostCmd(ctx context.Context, host sqlite.Host, hosts []sqlite.Host, cfg config.Config) tea.Cmd {
+	return func() tea.Msg {
+		return pingHostWithHops(ctx, host, hosts, cfg)
+	}
+}
+
+// pingHostWithHops probes every jump host of the ProxyJump chain of host, each through the one before it, and then
+// host itself with the ping probe of cfg, see sshUtils.ProbeRoute. This is blocking until done or ctx is canceled
+func pingHostWithHops(ctx context.Context, host sqlite.Host, hosts []sqlite.Host, cfg config.Config) pingResult {
+	probe := cfg.GetPingProbe()
+	route, err := sshUtils.ProbeRoute(ctx, host, hosts, probe, 2*time.Second, cfg)
+	if err != nil {
+		slog.Warn("Failed to resolve ProxyJump chain, only pinging target", "host", host.Host, "error", err)
+	}
+	result := pingResult{host: host.Host, failedHop: route.FailedHop}
+	for _, hop := range route.Hops[:len(route.Hops)-1] {
+		result.hops = append(result.hops, hopPingResult{
+			host:      hop.Hop.Name(),
+			probed:    hop.Probed,
+			reachable: hop.Result.Reachable,
+			notSSH:    isNotSSH(hop.Result, probe),
+			err:       hop.Result.Err,
+		})
+	}
+	target := route.Target()
+	if !target.Probed && route.FailedHop == "" {
+		result.err = route.Err // canceled before the target was reached
+		return result
+	}
+	if !target.Probed {
+		result.err = fmt.Errorf("route failed at %s: %w", route.FailedHop, route.Err)
+		return result
+	}
+	res := target.Result
+	result.hostReachable, result.ping, result.err = res.Reachable, res.Latency, res.Err
+	result.notSSH = isNotSSH(res, probe)
+	result.addresses = res.Addresses
+	result.serverVersion = res.ServerVersion
+	result.hostKey = res.HostKey
+	if res.HostKeyErr != nil {
+		slog.Warn("Failed to fetch host key", "host", host.Host, "error", res.HostKeyErr)
+	}
+	return result
+}
+
+// isNotSSH reports whether res is an open port that did not answer like an ssh server, the tcp probe can not tell
+func isNotSSH(res ping.PingResult, probe string) bool {
+	return probe != config.PingProbeTCP && res.Reachable && !res.SSH
+}
+
+// formatRoute renders the path ssh takes to reach host, e.g. local → bastion → target,
+// hops are marked with their last ping status. Returns an empty string for hosts without a ProxyJump
+func formatRoute(host sqlite.Host, hosts []sqlite.Host, pingMap map[string]hostPingInfo) string {
+	chain, err := sshUtils.ResolveJumpChain(host.Host, sshUtils.HostJumpLookup(hosts))
+	if err != nil {
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("#EF4444")).Render(err.Error())
+	}
+	if len(chain) == 0 {
+		return ""
+	}
+	info, pinged := pingMap[host.Host]
+	parts := []string{"local"}
+	for _, hop := range chain {
+		part := hop.String()
+		if status, ok := info.hops[hop.Host]; ok {
+			part += " " + status
+		}
+		parts = append(parts, part)
+	}
+	target := host.Host
+	if pinged {
+		target += " " + info.reachable
+	}
+	parts = append(parts, target)
+	route := strings.Join(parts, " → ")
+	if pinged && info.failedHop != "" {
+		route += lipgloss.NewStyle().Foreground(lipgloss.Color("#EF4444")).Render(" (broke at " + info.failedHop + ")")
+	}
+	return route
+}
+
+// formatServer renders the ssh server found by the last ping of a host, empty if the ping did not identify one
+func formatServer(info hostPingInfo) string {
+	if info.notSSH {
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("#F59E0B")).Render("port open but not ssh")
+	}
+	if info.version == "" {
+		return ""
+	}
+	if info.hostKey != "" {
+		return info.version + " · " + info.hostKey
+	}
+	return info.version
+}
+
+// formatAddresses renders how each address of a host did on the last ping, empty unless the name did not resolve or
+// resolved to more than one address
+func formatAddresses(info hostPingInfo) string {
+	if info.dnsFailed {
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("#A855F7")).Render("name does not resolve")
+	}
+	if len(info.addresses) < 2 {
+		return ""
+	}
+	parts := make([]string, 0, len(info.addresses))
+	for _, address := range info.addresses {
+		switch {
+		case !address.Tried:
+			parts = append(parts, address.Addr+" ⚪")
+		case address.Reachable:
+			parts = append(parts, address.Addr+" 🟢 "+formatDurationCompact(address.Latency))
+		case address.Refused:
+			parts = append(parts, address.Addr+" 🟡")
+		default:
+			parts = append(parts, address.Addr+" 🔴")
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
 type connectHostMessage struct {
 	host sqlite.Host
 }
 
-func hostToRow(host *sqlite.Host, cfg config.Config, pingMap map[string]hostPingInfo) table.Row {
+// setCertExpiry replaces the certificate expiry shown per host
+func (h *HostsPanelModel) setCertExpiry(expiry map[string]time.Time) {
+	h.certExpiry = expiry
+	h.refreshTableRows()
+}
+
+func hostToRow(host *sqlite.Host, cfg config.Config, pingMap map[string]hostPingInfo, certExpiry map[string]time.Time) table.Row {
 	row := table.NewRow(table.RowData{
 		hostColumnKey:              host.Host,
 		hostHostnameColumnKey:      hostOptionValue(host, "HostName"),
 		hostTagColumnKey:           strings.Join(host.Tags, ","),
 		hostLastConnectedColumnKey: formatLastConnected(host.LastConnection),
+		hostCertColumnKey:          formatCertExpiry(cfg, host.Host, certExpiry),
 		hostPingColumnKey:          formatPing(cfg.EnablePing, host.Host, pingMap),
 		hostStatusColumnKey:        formatHostStatus(cfg.EnablePing, host.Host, pingMap),
 		hostRowPayloadKey:          host,
@@ -1234,6 +1576,20 @@ func formatLastConnected(ts *time.Time) string {
 	return ts.Format("2006-01-02 15:04")
 }
 
+// formatCertExpiry counts down to the certificate expiry of host, certificates due for renewal are shown in red
+func formatCertExpiry(cfg config.Config, host string, certExpiry map[string]time.Time) any {
+	expiry, ok := certExpiry[host]
+	if !ok {
+		return "-"
+	}
+	now := time.Now()
+	text := sshUtils.FormatCertExpiry(expiry, now)
+	if expiry.Sub(now) <= cfg.Ssh.Certificates.GetRenewBefore() {
+		return table.NewStyledCell(text, lipgloss.NewStyle().Foreground(lipgloss.Color("#EF4444")))
+	}
+	return text
+}
+
 // todo add ping ability
 func formatPing(enabled bool, host string, pingMap map[string]hostPingInfo) string {
 	if !enabled {
@@ -1315,8 +1671,18 @@ func buildHostPreview(host sqlite.Host) string {
 		}
 		return opts[i].Key < opts[j].Key
 	})
+	tokens := sshUtils.NewTokenContext(host)
 	for _, opt := range opts {
 		builder.WriteString(fmt.Sprintf("  %s %s\n", opt.Key, opt.Value))
+		// show what ssh will actually use for values holding ~ or tokens
+		if sshUtils.AcceptsTokens(opt.Key) {
+			expanded, err := sshUtils.ExpandOptionValue(opt.Key, opt.Value, tokens)
+			if err != nil {
+				builder.WriteString(fmt.Sprintf("    # %s\n", err))
+			} else if expanded != strings.Trim(strings.TrimSpace(opt.Value), "\"") {
+				builder.WriteString(fmt.Sprintf("    # expands to %s\n", expanded))
+			}
+		}
 	}
 	// do not include tags when constructing preview string
 	// if len(host.Tags) > 0 {
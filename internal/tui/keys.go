@@ -0,0 +1,78 @@
+package tui
+
+import (
+	"andrew/sshman/internal/config"
+	"fmt"
+
+	"github.com/charmbracelet/bubbles/key"
+)
+
+// keyBindings returns the bindings of the actions of scope by action name, with the configured keys applied
+func keyBindings(bindings config.KeyBindings, scope string) map[string]key.Binding {
+	binds := make(map[string]key.Binding)
+	for _, action := range bindings.Actions(scope) {
+		binds[action.Name] = key.NewBinding(key.WithKeys(action.Keys...), key.WithHelp(config.KeyHelp(action.Keys), action.Help))
+	}
+	return binds
+}
+
+type ModalKeyBinds struct {
+	Up               key.Binding
+	Down             key.Binding
+	Left             key.Binding
+	Right            key.Binding
+	Confirm          key.Binding
+	Close            key.Binding
+	AgentRemove      key.Binding
+	AgentLoad        key.Binding
+	LedgerAll        key.Binding
+	LedgerResume     key.Binding
+	LedgerRollback   key.Binding
+	AuditMark        key.Binding
+	AuditMarkFlagged key.Binding
+	AuditRemove      key.Binding
+	Yes              key.Binding
+	No               key.Binding
+}
+
+// newModalKeyBinds builds the modal bindings with the configured keys applied
+func newModalKeyBinds(bindings config.KeyBindings) ModalKeyBinds {
+	binds := keyBindings(bindings, config.KeyScopeModals)
+	return ModalKeyBinds{
+		Up:               binds["up"],
+		Down:             binds["down"],
+		Left:             binds["left"],
+		Right:            binds["right"],
+		Confirm:          binds["confirm"],
+		Close:            binds["close"],
+		AgentRemove:      binds["agent_remove"],
+		AgentLoad:        binds["agent_load"],
+		LedgerAll:        binds["ledger_all"],
+		LedgerResume:     binds["ledger_resume"],
+		LedgerRollback:   binds["ledger_rollback"],
+		AuditMark:        binds["audit_mark"],
+		AuditMarkFlagged: binds["audit_mark_flagged"],
+		AuditRemove:      binds["audit_remove"],
+		Yes:              binds["yes"],
+		No:               binds["no"],
+	}
+}
+
+// keyName returns the first key of b the way modal hints name it, ie j
+func keyName(b key.Binding) string {
+	keys := b.Keys()
+	if len(keys) == 0 {
+		return ""
+	}
+	return config.KeyHelp(keys[:1])
+}
+
+// nav names the keys moving through a modal, ie j/k
+func (m ModalKeyBinds) nav() string {
+	return keyName(m.Down) + "/" + keyName(m.Up)
+}
+
+// closeHint tells how a modal that only shows something is closed
+func (m ModalKeyBinds) closeHint() string {
+	return fmt.Sprintf("%s or %s to close", keyName(m.Confirm), keyName(m.Close))
+}
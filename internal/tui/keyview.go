@@ -2,14 +2,16 @@ package tui
 
 import (
 	"andrew/sshman/internal/config"
+	"andrew/sshman/internal/sqlite"
 	"andrew/sshman/internal/sshUtils"
+	"context"
 	"fmt"
 	"log/slog"
-	"os"
-	"path/filepath"
+	"slices"
 	"strings"
 	"time"
 
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/huh"
@@ -20,12 +22,39 @@ type KeyGenModel struct {
 	form          *huh.Form
 	width, height int
 	spinner       spinner.Model
+	cancel        context.CancelFunc // aborts the key generation started by the form
+	aborting      bool
+	keys          FormKeyBinds
 }
 
 type KeyRotateModel struct {
 	form          *huh.Form
 	width, height int
 	spinner       spinner.Model
+	cancel        context.CancelFunc // aborts the key generation started by the form, nil if it can not be aborted
+	aborting      bool
+	keys          FormKeyBinds
+}
+
+type FormKeyBinds struct {
+	Quit  key.Binding // quits the form without submitting it
+	Abort key.Binding // aborts the work of a submitted form while its spinner is shown
+}
+
+// newFormKeyBinds builds the form bindings with the configured keys applied
+func newFormKeyBinds(bindings config.KeyBindings) FormKeyBinds {
+	binds := keyBindings(bindings, config.KeyScopeForms)
+	return FormKeyBinds{
+		Quit:  binds["quit"],
+		Abort: binds["abort"],
+	}
+}
+
+// huhKeyMap returns the keys of huh forms with quit moved off ctrl+c, which quits the program
+func (f FormKeyBinds) huhKeyMap() *huh.KeyMap {
+	keyMap := huh.NewDefaultKeyMap()
+	keyMap.Quit = f.Quit
+	return keyMap
 }
 
 type abortedKeyGenForm struct{} // tells parent model that the form has been aborted by the user, so it can close the form
@@ -38,10 +67,25 @@ type keyGenResult struct { // this informs the parent model not form that the fo
 
 type abortedRotatedKeyForm struct{}
 
+// securityKeyRequest asks the parent model to hand the terminal to ssh-keygen, the authenticator has to be
+// touched and may ask for its PIN. done turns the outcome into the result of the form
+type securityKeyRequest struct {
+	session *sshUtils.SecurityKeySession
+	done    func(err error) tea.Msg
+}
+
+type bulkRotateRequest struct {
+	targets []sshUtils.BulkRotateTarget
+	skipped []string // hosts that could not be rotated and why
+	opts    sshUtils.BulkRotateOptions
+}
+
 type keyRotateRequest struct {
 	host       string
 	oldKeyPath string           // path to the old key to be replaced, if empty user wants to just add new key to server
+	oldKeyOpt  string           // IdentityFile value the old key is stored under, can hold ~ and tokens unlike oldKeyPath
 	newKeySet  sshUtils.KeyPair // new key set
+	rotation   int64            // ledger entry when an unfinished rotation is resumed
 	err        error
 }
 
@@ -53,8 +97,44 @@ const (
 	OLD_KEY_GEN_TO_REPLACE    = "OLD_KEY_REPLACE"
 	AFFIRM_BOOL_KEY           = "CONFIRM_OPERATION"
 	ROTATE_KEY_STR_KEY        = "SELECTED_KEY_TO_ROTATE"
+	BULK_KEY_MODE_STR_KEY     = "BULK_KEY_MODE"
+	SECURITY_KEY_OPTIONS_KEY  = "SECURITY_KEY_OPTIONS"
+)
+
+const (
+	bulkSharedKey  = "shared"
+	bulkPerHostKey = "per-host"
 )
 
+const (
+	securityKeyResident       = "resident"
+	securityKeyVerifyRequired = "verify-required"
+)
+
+// securityKeyGroup asks for the options of security keys, it is only shown when keyGenType is one of the -SK types
+func securityKeyGroup(keyGenType *string) *huh.Group {
+	return huh.NewGroup(
+		huh.NewMultiSelect[string]().
+			Key(SECURITY_KEY_OPTIONS_KEY).
+			Options(
+				huh.NewOption("Resident, keep the key on the authenticator", securityKeyResident),
+				huh.NewOption("Verify required, ask for the PIN on every login", securityKeyVerifyRequired),
+			).
+			Title("Security Key Options").
+			Description("The authenticator has to be plugged in and touched once the form is done"),
+	).WithHideFunc(func() bool {
+		return !config.IsSecurityKeyType(*keyGenType)
+	})
+}
+
+func securityKeyOptions(form *huh.Form) sshUtils.KeyGenOptions {
+	selected, _ := form.Get(SECURITY_KEY_OPTIONS_KEY).([]string)
+	return sshUtils.KeyGenOptions{
+		Resident:       slices.Contains(selected, securityKeyResident),
+		VerifyRequired: slices.Contains(selected, securityKeyVerifyRequired),
+	}
+}
+
 func getTheme() *huh.Theme {
 	theme := huh.ThemeBase()
 	purple := lipgloss.Color("#7D56F4")
@@ -66,7 +146,9 @@ func getTheme() *huh.Theme {
 	return theme
 }
 
-func NewKeyGenModel(host string, cfg config.Config) KeyGenModel {
+// NewKeyGenModel builds the key generation form for host, the generation is aborted once ctx is canceled and
+// quitting waits for it through work
+func NewKeyGenModel(ctx context.Context, work *inflightWork, host string, cfg config.Config) KeyGenModel {
 	// todo create key-gen form
 	// the form title should be the host followed by key gen
 	// should have just a single option which is a selector of acceptable key gen options from the config file
@@ -77,42 +159,49 @@ func NewKeyGenModel(host string, cfg config.Config) KeyGenModel {
 			keyGenOptions = append(keyGenOptions, strings.ToUpper(keyAlg))
 		}
 	} else {
-		keyGenOptions = append(keyGenOptions, config.RSA, config.ECDSA, config.ED25519)
+		keyGenOptions = append(keyGenOptions, config.RSA, config.ECDSA, config.ED25519, config.ECDSA_SK, config.ED25519_SK)
 	}
 	var password string
-	form := huh.NewForm(huh.NewGroup(
-		huh.NewSelect[string]().
-			Key(KEY_GEN_ALGO_STR_KEY).
-			Options(huh.NewOptions(keyGenOptions...)...).
-			Title("Key Generation Method").Validate(func(s string) error {
-			if len(s) == 0 {
-				return fmt.Errorf("A option must be selected")
-			}
-			return nil
-		}),
-		huh.NewInput().
-			Title("Password").
-			EchoMode(huh.EchoModePassword).
-			Key(KEY_GEN_PASSWORD).Value(&password),
-		huh.NewInput().
-			Title("Retype Password").
-			Key(KEY_GEN_PASSWORD_VALIDATE).
-			EchoMode(huh.EchoModePassword).Validate(func(s string) error {
-			if len(password) == 0 && len(s) != 0 {
-				return fmt.Errorf("Password field is empty")
-			}
-			if password != s {
-				return fmt.Errorf("Passwords do not match")
-			}
-			return nil
-		}),
-		huh.NewConfirm().
-			Key(AFFIRM_BOOL_KEY).
-			Title("Generate Key").
-			Affirmative("Yes").
-			Negative("No"),
+	keyGenType := keyGenOptions[0]
+	ctx, cancel := context.WithCancel(ctx)
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewSelect[string]().
+				Key(KEY_GEN_ALGO_STR_KEY).
+				Options(huh.NewOptions(keyGenOptions...)...).
+				Value(&keyGenType).
+				Title("Key Generation Method").Validate(func(s string) error {
+				if len(s) == 0 {
+					return fmt.Errorf("A option must be selected")
+				}
+				return nil
+			}),
+		).Title(host+" Key Generation form"),
+		securityKeyGroup(&keyGenType),
+		huh.NewGroup(
+			huh.NewInput().
+				Title("Password").
+				EchoMode(huh.EchoModePassword).
+				Key(KEY_GEN_PASSWORD).Value(&password),
+			huh.NewInput().
+				Title("Retype Password").
+				Key(KEY_GEN_PASSWORD_VALIDATE).
+				EchoMode(huh.EchoModePassword).Validate(func(s string) error {
+				if len(password) == 0 && len(s) != 0 {
+					return fmt.Errorf("Password field is empty")
+				}
+				if password != s {
+					return fmt.Errorf("Passwords do not match")
+				}
+				return nil
+			}),
+			huh.NewConfirm().
+				Key(AFFIRM_BOOL_KEY).
+				Title("Generate Key").
+				Affirmative("Yes").
+				Negative("No"),
+		),
 	).
-		Title(host + " Key Generation form")).
 		WithShowHelp(true).
 		WithWidth(45).
 		WithShowErrors(true).
@@ -121,29 +210,48 @@ func NewKeyGenModel(host string, cfg config.Config) KeyGenModel {
 	form.CancelCmd = func() tea.Msg {
 		return abortedKeyGenForm{}
 	}
-	form.SubmitCmd = func() tea.Msg {
+	form.SubmitCmd = work.track(func() tea.Msg {
 		if !form.GetBool(AFFIRM_BOOL_KEY) {
 			return abortedKeyGenForm{}
 		}
 		keyGenType := form.GetString(KEY_GEN_ALGO_STR_KEY)
 		password := form.GetString(KEY_GEN_PASSWORD)
 		hostString := host
-		keyPair, err := sshUtils.GenKey(hostString, keyGenType, password, cfg)
+		if config.IsSecurityKeyType(keyGenType) {
+			session, err := sshUtils.NewSecurityKeySession(hostString, keyGenType, password, securityKeyOptions(form), cfg)
+			if err != nil {
+				return keyGenResult{host: hostString, err: err}
+			}
+			return securityKeyRequest{session: session, done: func(err error) tea.Msg {
+				if err != nil {
+					return keyGenResult{host: hostString, err: err}
+				}
+				return keyGenResult{host: hostString, keyPair: session.Keys}
+			}}
+		}
+		keyPair, err := sshUtils.GenKey(ctx, hostString, keyGenType, password, sshUtils.KeyGenOptions{}, cfg)
 		return keyGenResult{
 			host:    hostString,
 			err:     err,
 			keyPair: keyPair,
 		}
-	}
-	keyMap := huh.NewDefaultKeyMap()
-	keyMap.Quit.SetKeys("ctrl+q")
-	keyMap.Quit.SetHelp("ctrl+q", "quit form")
-	form.WithKeyMap(keyMap)
+	})
+	formKeys := newFormKeyBinds(cfg.KeyBindings)
+	form.WithKeyMap(formKeys.huhKeyMap())
 	return KeyGenModel{
 		width:   45,
 		height:  20,
 		spinner: spinner.New(spinner.WithSpinner(spinner.Dot)),
 		form:    form,
+		cancel:  cancel,
+		keys:    formKeys,
+	}
+}
+
+// release frees the context of the form once its work is done or aborted
+func (km KeyGenModel) release() {
+	if km.cancel != nil {
+		km.cancel()
 	}
 }
 
@@ -165,6 +273,13 @@ func (km KeyGenModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		spin, cmd := km.spinner.Update(msg)
 		km.spinner = spin
 		return km, cmd
+	case tea.KeyMsg:
+		if km.form.State == huh.StateCompleted && key.Matches(msg, km.keys.Abort) && !km.aborting {
+			slog.Info("User aborted key generation", "time", time.Now())
+			km.aborting = true
+			km.cancel()
+			return km, nil
+		}
 	}
 	if km.form.State != huh.StateNormal { // dont send messages to the form if its done processing
 		return km, nil
@@ -192,7 +307,10 @@ func (km KeyGenModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 func (km KeyGenModel) View() string {
 	switch km.form.State {
 	case huh.StateCompleted:
-		return fmt.Sprintf("%s Generating keys...", km.spinner.View())
+		if km.aborting {
+			return fmt.Sprintf("%s Aborting key generation...", km.spinner.View())
+		}
+		return fmt.Sprintf("%s Generating keys... %s to abort", km.spinner.View(), km.keys.Abort.Help().Key)
 	case huh.StateAborted:
 		return ""
 	default:
@@ -200,23 +318,27 @@ func (km KeyGenModel) View() string {
 	}
 }
 
-func NewKeyRotateModel(host string, keys []string, cfg config.Config) KeyRotateModel {
+// NewKeyRotateModel builds the rotate form for host, selected is the IdentityFile value of the key to preselect.
+// The generation of the new key is aborted once ctx is canceled, quitting waits for it through work
+func NewKeyRotateModel(ctx context.Context, work *inflightWork, host sqlite.Host, keys []string, selected string, cfg config.Config) KeyRotateModel {
 	// todo create a form have name host followed key rotation, similar to the key gen one except
 	// file selector (could use a list here filter beforehand on form creation looking over keystore directory finding valid keys to look for)
 	// then again ask for a key gen algorithm from config passed in
 	// and then a confirm button
-	ownedKeys := make([]string, 0)
-	ownedKeys = append(ownedKeys, "None")
+	ownedKeys := make([]huh.Option[string], 0)
+	ownedKeys = append(ownedKeys, huh.NewOption("None", ""))
+	keyPaths := make(map[string]string) // stored value -> expanded path
+	tokens := sshUtils.NewTokenContext(host)
 	for _, key := range keys {
-		if strings.HasPrefix(key, "~/") { // in case user has keys added manually using ~
-			home, err := os.UserHomeDir()
-			if err == nil {
-				key = strings.TrimPrefix(key, "~/")
-				key = filepath.Join(home, key)
-			}
+		// keys added manually can use ~ or tokens such as %h
+		path, err := sshUtils.ExpandOptionValue("IdentityFile", key, tokens)
+		if err != nil {
+			slog.Warn("Failed to expand identity file of host", "host", host.Host, "key", key, "error", err)
+			continue
 		}
-		if strings.HasPrefix(key, cfg.Ssh.KeyPath) {
-			ownedKeys = append(ownedKeys, key)
+		if strings.HasPrefix(path, cfg.GetKeyStorePath()) {
+			keyPaths[key] = path
+			ownedKeys = append(ownedKeys, huh.NewOption(path, key))
 		}
 	}
 	keyGenOptions := make([]string, 0)
@@ -225,15 +347,18 @@ func NewKeyRotateModel(host string, keys []string, cfg config.Config) KeyRotateM
 			keyGenOptions = append(keyGenOptions, strings.ToUpper(keyAlg))
 		}
 	} else {
-		keyGenOptions = append(keyGenOptions, config.RSA, config.ECDSA, config.ED25519)
+		keyGenOptions = append(keyGenOptions, config.RSA, config.ECDSA, config.ED25519, config.ECDSA_SK, config.ED25519_SK)
 	}
 	var password string
+	keyGenType := keyGenOptions[0]
+	ctx, cancel := context.WithCancel(ctx)
 	form := huh.NewForm(
 		// replace old key step
 		huh.NewGroup(
 			huh.NewSelect[string]().
 				Key(ROTATE_KEY_STR_KEY).
-				Options(huh.NewOptions(ownedKeys...)...).
+				Options(ownedKeys...).
+				Value(&selected).
 				Title("key to rotate"),
 		),
 		// key gen step
@@ -241,6 +366,7 @@ func NewKeyRotateModel(host string, keys []string, cfg config.Config) KeyRotateM
 			huh.NewSelect[string]().
 				Key(KEY_GEN_ALGO_STR_KEY).
 				Options(huh.NewOptions(keyGenOptions...)...).
+				Value(&keyGenType).
 				Title("Key Generation Method").
 				Validate(func(s string) error {
 					if len(s) == 0 {
@@ -248,6 +374,9 @@ func NewKeyRotateModel(host string, keys []string, cfg config.Config) KeyRotateM
 					}
 					return nil
 				}),
+		),
+		securityKeyGroup(&keyGenType),
+		huh.NewGroup(
 			huh.NewInput().
 				Key(KEY_GEN_PASSWORD).
 				Title("Password").
@@ -280,34 +409,135 @@ func NewKeyRotateModel(host string, keys []string, cfg config.Config) KeyRotateM
 	form.CancelCmd = func() tea.Msg {
 		return abortedRotatedKeyForm{}
 	}
-	form.SubmitCmd = func() tea.Msg {
+	form.SubmitCmd = work.track(func() tea.Msg {
 		if !form.GetBool(AFFIRM_BOOL_KEY) {
 			return abortedRotatedKeyForm{}
 		}
 		keyGenType := form.GetString(KEY_GEN_ALGO_STR_KEY)
 		password := form.GetString(KEY_GEN_PASSWORD)
-		hostString := host
+		hostString := host.Host
 		keyToRotate := form.GetString(ROTATE_KEY_STR_KEY)
-		if keyToRotate == "None" {
-			keyToRotate = ""
+		if config.IsSecurityKeyType(keyGenType) {
+			session, err := sshUtils.NewSecurityKeySession(hostString, keyGenType, password, securityKeyOptions(form), cfg)
+			if err != nil {
+				return keyRotateRequest{host: hostString, err: err}
+			}
+			return securityKeyRequest{session: session, done: func(err error) tea.Msg {
+				req := keyRotateRequest{host: hostString, oldKeyPath: keyPaths[keyToRotate], oldKeyOpt: keyToRotate, err: err}
+				if err == nil {
+					req.newKeySet = session.Keys
+				}
+				return req
+			}}
 		}
-		keyPair, err := sshUtils.GenKey(hostString, keyGenType, password, cfg)
+		keyPair, err := sshUtils.GenKey(ctx, hostString, keyGenType, password, sshUtils.KeyGenOptions{}, cfg)
 		return keyRotateRequest{
 			host:       hostString,
 			newKeySet:  keyPair,
-			oldKeyPath: keyToRotate,
+			oldKeyPath: keyPaths[keyToRotate],
+			oldKeyOpt:  keyToRotate,
 			err:        err,
 		}
-	}
-	keyMap := huh.NewDefaultKeyMap()
-	keyMap.Quit.SetKeys("ctrl+q")
-	keyMap.Quit.SetHelp("ctrl+q", "quit form")
-	form.WithKeyMap(keyMap)
+	})
+	formKeys := newFormKeyBinds(cfg.KeyBindings)
+	form.WithKeyMap(formKeys.huhKeyMap())
 	return KeyRotateModel{
 		width:   45,
 		height:  20,
 		form:    form,
 		spinner: spinner.New(spinner.WithSpinner(spinner.Dot)),
+		cancel:  cancel,
+		keys:    formKeys,
+	}
+}
+
+// NewBulkRotateModel builds the form for rotating the keys of hosts at once, the form lives in a
+// KeyRotateModel as it behaves the same way
+func NewBulkRotateModel(hosts []sqlite.Host, cfg config.Config) KeyRotateModel {
+	oldKeys := []huh.Option[string]{huh.NewOption("Each host's key in the key store", "")}
+	usage := sshUtils.KeyUsage(hosts)
+	paths := make([]string, 0, len(usage))
+	for path := range usage {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+	for _, path := range paths {
+		oldKeys = append(oldKeys, huh.NewOption(fmt.Sprintf("%s (%d hosts)", path, len(usage[path])), path))
+	}
+	keyGenOptions := make([]string, 0)
+	if len(cfg.Ssh.AcceptableKeyGenAlgorithms) > 0 {
+		for _, keyAlg := range cfg.Ssh.AcceptableKeyGenAlgorithms {
+			keyGenOptions = append(keyGenOptions, strings.ToUpper(keyAlg))
+		}
+	} else {
+		keyGenOptions = append(keyGenOptions, config.RSA, config.ECDSA, config.ED25519)
+	}
+	// security keys need a touch for every verifying login, see sshUtils.ErrBulkSecurityKey
+	keyGenOptions = slices.DeleteFunc(keyGenOptions, config.IsSecurityKeyType)
+	form := huh.NewForm(
+		huh.NewGroup(
+			huh.NewSelect[string]().
+				Key(ROTATE_KEY_STR_KEY).
+				Options(oldKeys...).
+				Title("key to rotate"),
+			huh.NewSelect[string]().
+				Key(BULK_KEY_MODE_STR_KEY).
+				Options(
+					huh.NewOption("One key shared by every host", bulkSharedKey),
+					huh.NewOption("One key per host", bulkPerHostKey),
+				).
+				Title("New keys"),
+		),
+		huh.NewGroup(
+			huh.NewSelect[string]().
+				Key(KEY_GEN_ALGO_STR_KEY).
+				Options(huh.NewOptions(keyGenOptions...)...).
+				Title("Key Generation Method"),
+			huh.NewConfirm().
+				Key(AFFIRM_BOOL_KEY).
+				Title(fmt.Sprintf("Rotate keys of %d hosts", len(hosts))).
+				Description("New keys have no passphrase so logins can be verified unattended").
+				Affirmative("Yes").
+				Negative("No"),
+		),
+	).
+		WithShowErrors(true).
+		WithWidth(60).
+		WithTheme(getTheme()).
+		WithShowHelp(true)
+	form.CancelCmd = func() tea.Msg {
+		return abortedRotatedKeyForm{}
+	}
+	form.SubmitCmd = func() tea.Msg {
+		if !form.GetBool(AFFIRM_BOOL_KEY) {
+			return abortedRotatedKeyForm{}
+		}
+		targets, skipped := sshUtils.SelectBulkTargets(hosts, "", "", form.GetString(ROTATE_KEY_STR_KEY), cfg.GetKeyStorePath())
+		return bulkRotateRequest{
+			targets: targets,
+			skipped: skipped,
+			opts: sshUtils.BulkRotateOptions{
+				Algorithm:  form.GetString(KEY_GEN_ALGO_STR_KEY),
+				PerHost:    form.GetString(BULK_KEY_MODE_STR_KEY) == bulkPerHostKey,
+				SharedName: "bulk",
+			},
+		}
+	}
+	formKeys := newFormKeyBinds(cfg.KeyBindings)
+	form.WithKeyMap(formKeys.huhKeyMap())
+	return KeyRotateModel{
+		width:   60,
+		height:  20,
+		form:    form,
+		spinner: spinner.New(spinner.WithSpinner(spinner.Dot)),
+		keys:    formKeys,
+	}
+}
+
+// release frees the context of the form once its work is done or aborted
+func (rkm KeyRotateModel) release() {
+	if rkm.cancel != nil {
+		rkm.cancel()
 	}
 }
 
@@ -329,6 +559,13 @@ func (rkm KeyRotateModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		spin, cmd := rkm.spinner.Update(msg)
 		rkm.spinner = spin
 		return rkm, cmd
+	case tea.KeyMsg:
+		if rkm.form.State == huh.StateCompleted && key.Matches(msg, rkm.keys.Abort) && rkm.cancel != nil && !rkm.aborting {
+			slog.Info("User aborted the rotate key generation", "time", time.Now())
+			rkm.aborting = true
+			rkm.cancel()
+			return rkm, nil
+		}
 	}
 
 	if rkm.form.State != huh.StateNormal {
@@ -355,6 +592,12 @@ func (rkm KeyRotateModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 func (rkm KeyRotateModel) View() string {
 	switch rkm.form.State {
 	case huh.StateCompleted:
+		switch {
+		case rkm.aborting:
+			return fmt.Sprintf("%s Aborting key generation...", rkm.spinner.View())
+		case rkm.cancel != nil:
+			return fmt.Sprintf("%s Generating keys... %s to abort", rkm.spinner.View(), rkm.keys.Abort.Help().Key)
+		}
 		return fmt.Sprintf("%s Generating keys...", rkm.spinner.View())
 	case huh.StateAborted:
 		return ""
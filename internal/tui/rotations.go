@@ -0,0 +1,248 @@
+package tui
+
+import (
+	"andrew/sshman/internal/sqlite"
+	"andrew/sshman/internal/sshParser"
+	"andrew/sshman/internal/sshUtils"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// startRotationsView opens the rotation ledger
+type startRotationsView struct{}
+
+type rotationsModalState struct {
+	visible   bool
+	all       bool // show finished and rolled back rotations too
+	rotations []sqlite.Rotation
+	selected  int
+	message   string
+	err       error
+}
+
+// startRotation records the rotation of req in the ledger once its new key is registered, 0 is returned if it
+// could not be recorded
+func (a AppModel) startRotation(req keyRotateRequest) int64 {
+	if a.keyDb == nil {
+		return 0
+	}
+	rotation := sqlite.Rotation{
+		Host:      req.host,
+		OldKey:    req.oldKeyPath,
+		OldKeyOpt: req.oldKeyOpt,
+		NewKey:    req.newKeySet.PrivateKey,
+		NewPubKey: req.newKeySet.PubKey,
+		StartedAt: time.Now(),
+	}
+	if req.oldKeyPath == "" {
+		rotation.Remove = sqlite.RotationSkipped
+	}
+	id, err := a.keyDb.InsertRotation(rotation)
+	if err != nil {
+		slog.Warn("Failed to record rotation in the ledger", "host", req.host, "error", err)
+		return 0
+	}
+	return id
+}
+
+// updateRotation applies fn to the ledger entry of a rotation, id 0 is a rotation that was not recorded
+func (a AppModel) updateRotation(id int64, fn func(r *sqlite.Rotation)) {
+	if id == 0 || a.keyDb == nil {
+		return
+	}
+	rotation, err := a.keyDb.GetRotation(id)
+	if err != nil {
+		slog.Warn("Failed to read rotation from the ledger", "rotation", id, "error", err)
+		return
+	}
+	fn(&rotation)
+	rotation.UpdatedAt = time.Now()
+	if err = a.keyDb.UpdateRotation(rotation); err != nil {
+		slog.Warn("Failed to update rotation in the ledger", "rotation", id, "error", err)
+	}
+}
+
+// refreshUnfinishedRotations recounts the rotations left to resume or roll back for the header
+func (a *AppModel) refreshUnfinishedRotations() {
+	if a.keyDb == nil {
+		return
+	}
+	rotations, err := a.keyDb.GetUnfinishedRotations()
+	if err != nil {
+		slog.Warn("Failed to read unfinished rotations", "error", err)
+		return
+	}
+	a.header.unfinishedRotations = len(rotations)
+}
+
+func (a AppModel) openRotationsView() AppModel {
+	a.rotationsModal = rotationsModalState{visible: true}
+	return a.refreshRotationsView()
+}
+
+func (a AppModel) refreshRotationsView() AppModel {
+	a.refreshUnfinishedRotations()
+	if a.keyDb == nil {
+		return a
+	}
+	if a.rotationsModal.all {
+		a.rotationsModal.rotations, a.rotationsModal.err = a.keyDb.GetRotations()
+	} else {
+		a.rotationsModal.rotations, a.rotationsModal.err = a.keyDb.GetUnfinishedRotations()
+	}
+	a.rotationsModal.selected = min(a.rotationsModal.selected, max(0, len(a.rotationsModal.rotations)-1))
+	return a
+}
+
+// resumeSelectedRotation continues the highlighted rotation from its first step that did not complete
+func (a AppModel) resumeSelectedRotation() (AppModel, tea.Cmd) {
+	if len(a.rotationsModal.rotations) == 0 {
+		return a, nil
+	}
+	rotation := a.rotationsModal.rotations[a.rotationsModal.selected]
+	step := sshUtils.NextRotationStep(rotation)
+	if step == sshUtils.RotationStepNone {
+		a.rotationsModal.message = "The rotation of " + rotation.Host + " is already finished"
+		return a, nil
+	}
+	if err := sshUtils.EnsureRotationKey(a.db, rotation); err != nil {
+		a.rotationsModal.err = fmt.Errorf("failed to register the new key of %s: %w", rotation.Host, err)
+		return a, nil
+	}
+	a.rotationsModal.visible = false
+	newKeys := sshUtils.KeyPair{PrivateKey: rotation.NewKey, PubKey: rotation.NewPubKey}
+	req := removeOldKeyRequest{
+		host:       rotation.Host,
+		oldKey:     rotation.OldKey,
+		oldKeyOpt:  rotation.OldKeyOpt,
+		newKeyPair: newKeys,
+		rotation:   rotation.ID,
+	}
+	switch step {
+	case sshUtils.RotationStepCopy:
+		resume := keyRotateRequest{
+			host:       rotation.Host,
+			oldKeyPath: rotation.OldKey,
+			oldKeyOpt:  rotation.OldKeyOpt,
+			newKeySet:  newKeys,
+			rotation:   rotation.ID,
+		}
+		return a, func() tea.Msg { return resume }
+	case sshUtils.RotationStepVerify:
+		return a, func() tea.Msg { return verifyNewKeyRequest{req: req} }
+	}
+	req.verified = true
+	return a, func() tea.Msg { return req }
+}
+
+// rollbackSelectedRotation restores the IdentityFile registration the host of the highlighted rotation had before it
+func (a AppModel) rollbackSelectedRotation() AppModel {
+	if len(a.rotationsModal.rotations) == 0 {
+		return a
+	}
+	rotation := a.rotationsModal.rotations[a.rotationsModal.selected]
+	if _, err := sshUtils.RollbackRotation(a.db, a.keyDb, rotation, time.Now()); err != nil {
+		a.rotationsModal.err = err
+		return a
+	}
+	a.rotationsModal.err = nil
+	a.rotationsModal.message = fmt.Sprintf("Rolled back %s, the new key %s is still on the server", rotation.Host, filepath.Base(rotation.NewKey))
+	if rotation.OldKeyOpt != "" {
+		a.rotationsModal.message = fmt.Sprintf("Rolled back %s to %s, the new key %s is still on the server", rotation.Host, rotation.OldKeyOpt, filepath.Base(rotation.NewKey))
+	}
+	hosts, err := a.db.GetAll()
+	if err != nil {
+		slog.Warn("Failed to get all hosts from database", "error", err)
+		a.pendingWrite = true
+		return a.refreshRotationsView()
+	}
+	a.hostsModel.data = hosts
+	a.hostsModel.refreshTableRows()
+	if err = sshParser.SerializeHostToFile(a.cfg.GetSshConfigFilePath(), hosts); err != nil {
+		slog.Warn("Failed to serialize hosts into ssh config file", "error", err)
+		a.pendingWrite = true
+	}
+	return a.refreshRotationsView()
+}
+
+func rotationStatusStyle(status sqlite.RotationStatus) lipgloss.Style {
+	switch status {
+	case sqlite.RotationDone, sqlite.RotationSkipped:
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("#10B981"))
+	case sqlite.RotationFailed:
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("#EF4444"))
+	case sqlite.RotationCanceled:
+		return lipgloss.NewStyle().Foreground(lipgloss.Color("#F59E0B"))
+	}
+	return lipgloss.NewStyle().Foreground(lipgloss.Color("#9CA3AF"))
+}
+
+func (a AppModel) rotationsModalView() string {
+	modal := a.rotationsModal
+	width := max(80, a.width*2/3)
+	heading := "Unfinished Rotations"
+	if modal.all {
+		heading = "Rotation Ledger"
+	}
+	title := lipgloss.NewStyle().Bold(true).Render(heading)
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#9CA3AF"))
+	selectedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#7D56F4")).Bold(true)
+	var content string
+	switch {
+	case modal.err != nil && len(modal.rotations) == 0:
+		content = "Failed to read the rotation ledger. Error: " + modal.err.Error()
+	case len(modal.rotations) == 0 && modal.all:
+		content = "No rotations have been recorded yet"
+	case len(modal.rotations) == 0:
+		content = fmt.Sprintf("Every rotation finished, press %s to show the whole ledger", keyName(a.keys.LedgerAll))
+	default:
+		lines := make([]string, 0, len(modal.rotations)*3)
+		for i, rotation := range modal.rotations {
+			oldKey := "no old key"
+			if rotation.OldKey != "" {
+				oldKey = filepath.Base(rotation.OldKey)
+			}
+			name := fmt.Sprintf("%s  %s -> %s", rotation.Host, oldKey, filepath.Base(rotation.NewKey))
+			if i == modal.selected {
+				name = selectedStyle.Render("> " + name)
+			} else {
+				name = "  " + name
+			}
+			steps := []string{
+				"copy " + rotationStatusStyle(rotation.Copy).Render(string(rotation.Copy)),
+				"verify " + rotationStatusStyle(rotation.Verify).Render(string(rotation.Verify)),
+				"remove " + rotationStatusStyle(rotation.Remove).Render(string(rotation.Remove)),
+			}
+			if rotation.RolledBack {
+				steps = append(steps, rotationStatusStyle(sqlite.RotationCanceled).Render("rolled back"))
+			}
+			lines = append(lines, name, "    "+strings.Join(steps, ", ")+dimStyle.Render(", started "+rotation.StartedAt.Format(time.DateTime)))
+			if rotation.Error != "" && !rotation.Finished() {
+				lines = append(lines, dimStyle.Render("    "+rotation.Error))
+			}
+		}
+		content = strings.Join(lines, "\n")
+	}
+	if modal.err != nil && len(modal.rotations) > 0 {
+		content += "\n\nError: " + modal.err.Error()
+	} else if modal.message != "" {
+		content += "\n\n" + modal.message
+	}
+	show := "all rotations"
+	if modal.all {
+		show = "unfinished rotations"
+	}
+	tail := fmt.Sprintf("\n%s to select, %s to resume, %s to roll back, %s to show %s, %s to close", a.keys.nav(),
+		keyName(a.keys.LedgerResume), keyName(a.keys.LedgerRollback), keyName(a.keys.LedgerAll), show, keyName(a.keys.Close))
+	return lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder()).
+		Width(width).
+		Padding(1, 2).
+		Render(lipgloss.JoinVertical(lipgloss.Left, title, "", lipgloss.NewStyle().Width(width-6).Render(content), tail))
+}
@@ -0,0 +1,82 @@
+package tui
+
+import (
+	"andrew/sshman/internal/ping"
+	"andrew/sshman/internal/sqlite"
+	"slices"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// startPingScan pings every host, or the hosts the table filter shows, in the background
+type startPingScan struct{}
+
+// pingScanProgress carries the result of a host as soon as the scan pinged it
+type pingScanProgress struct {
+	result pingResult
+}
+
+// pingScanFinished is sent once every host of a scan was pinged
+type pingScanFinished struct{}
+
+type pingScanState struct {
+	running bool
+	updates chan pingResult
+}
+
+// scanOnStart returns the command starting the first scan, nil if scanning is turned off
+func (a AppModel) scanOnStart() tea.Cmd {
+	if !a.cfg.EnablePing || !a.cfg.PingScan.IsEnabled() {
+		return nil
+	}
+	return func() tea.Msg { return startPingScan{} }
+}
+
+// startPingScan pings the hosts with a worker pool outside the ui thread, results stream in through
+// waitForPingScan. A scan still running when the next one is due is left to finish, quitting cancels it
+func (a AppModel) startPingScan() (AppModel, tea.Cmd) {
+	if a.pingScan.running {
+		return a, nil
+	}
+	all := slices.Clone(a.hostsModel.data)
+	targets := all
+	if a.cfg.PingScan.FilteredOnly {
+		targets = a.hostsModel.table.visibleHosts()
+	}
+	if len(targets) == 0 {
+		return a, a.nextPingScan()
+	}
+	a.pingScan.running = true
+	a.pingScan.updates = make(chan pingResult)
+	updates := a.pingScan.updates
+	concurrency := a.cfg.PingScan.GetConcurrency()
+	cfg, ctx := a.cfg, a.ctx
+	go func() {
+		ping.Scan(ctx, targets, concurrency, func(host sqlite.Host) pingResult {
+			return pingHostWithHops(ctx, host, all, cfg)
+		}, updates)
+		close(updates)
+	}()
+	return a, waitForPingScan(updates)
+}
+
+// nextPingScan schedules the next scan, nil if only the scan at start runs
+func (a AppModel) nextPingScan() tea.Cmd {
+	interval := a.cfg.PingScan.GetInterval()
+	if interval <= 0 {
+		return nil
+	}
+	return tea.Tick(interval, func(time.Time) tea.Msg { return startPingScan{} })
+}
+
+// waitForPingScan waits for the next result of a scan
+func waitForPingScan(updates chan pingResult) tea.Cmd {
+	return func() tea.Msg {
+		result, ok := <-updates
+		if !ok {
+			return pingScanFinished{}
+		}
+		return pingScanProgress{result: result}
+	}
+}
@@ -1,6 +1,7 @@
 package tui
 
 import (
+	"andrew/sshman/internal/config"
 	"andrew/sshman/internal/sqlite"
 	"andrew/sshman/internal/sshUtils"
 	"errors"
@@ -8,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/textarea"
 	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
@@ -61,9 +63,11 @@ type kvRowInput struct {
 	inputFocus int
 	width      int
 	height     int
+	suggestion sshUtils.SuggestionContext // used to build value suggestions once a key is entered
+	keys       WizardKeyBinds
 }
 
-func newKVRowInput() kvRowInput {
+func newKVRowInput(keys WizardKeyBinds) kvRowInput {
 	key := textinput.New()
 	key.Placeholder = "Option key"
 	val := textinput.New()
@@ -78,6 +82,7 @@ func newKVRowInput() kvRowInput {
 		key:    key,
 		val:    val,
 		height: kvRowHeight,
+		keys:   keys,
 	}
 }
 
@@ -122,7 +127,7 @@ func setPromptStyleKvRowInput(input *textinput.Model, focused bool) {
 func (k kvRowInput) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
-		if msg.Type == tea.KeyEscape {
+		if key.Matches(msg, k.keys.Exit) {
 			if k.mode == formNavigateMode {
 				k.focus = false
 				setPromptStyleKvRowInput(&k.key, false)
@@ -140,21 +145,21 @@ func (k kvRowInput) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return k, nil
 			}
 		}
-		if msg.Type == tea.KeyEnter {
+		if key.Matches(msg, k.keys.Select) {
 			if k.mode == formEditMode {
 				k.mode = formNavigateMode
 				if k.inputFocus == keyInputFocusState {
 					k.key.Blur()
+					k.val.Placeholder = "Option value"
 					if sshUtils.IsAcceptableOption(k.key.Value()) {
-						if sshUtils.IsOptionYesNo(k.key.Value()) {
-							k.val.SetSuggestions([]string{"yes", "no"})
-							k.val.ShowSuggestions = true
-						} else if k.key.Value() == "AddressFamily" {
-							k.val.SetSuggestions(sshUtils.GetAllAddressFamily())
+						values := sshUtils.ValueSuggestions(k.key.Value(), k.suggestion)
+						k.val.ShowSuggestions = len(values) > 0
+						k.val.SetSuggestions(values)
+						if hint := sshUtils.OptionHint(k.key.Value()); hint != "" {
+							k.val.Placeholder = hint
 						}
 					}
 				} else {
-					k.val.ShowSuggestions = false
 					k.val.Blur()
 				}
 				return k, nil
@@ -168,7 +173,7 @@ func (k kvRowInput) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return k, cmd
 			}
 		}
-		if (msg.Type == tea.KeyTab || msg.Type == tea.KeyRight) && k.mode == formNavigateMode {
+		if key.Matches(msg, k.keys.OptionValue) && k.mode == formNavigateMode {
 			if k.inputFocus == keyInputFocusState {
 				k.inputFocus = valueInputFocusState
 				setPromptStyleKvRowInput(&k.key, false)
@@ -176,7 +181,7 @@ func (k kvRowInput) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			return k, nil
 		}
-		if (msg.Type == tea.KeyShiftTab || msg.Type == tea.KeyLeft) && k.mode == formNavigateMode {
+		if key.Matches(msg, k.keys.OptionKey) && k.mode == formNavigateMode {
 			if k.inputFocus == valueInputFocusState {
 				k.inputFocus = keyInputFocusState
 				setPromptStyleKvRowInput(&k.key, true)
@@ -235,6 +240,61 @@ type WizardViewModel struct {
 	kvViewport    viewport.Model
 	formWidth     int
 	width, height int
+	suggestion    sshUtils.SuggestionContext
+	keys          WizardKeyBinds
+	saveErr       error // why the host was not added, the wizard stays open so it can be fixed
+}
+
+type WizardKeyBinds struct {
+	Next         key.Binding
+	Prev         key.Binding
+	OptionKey    key.Binding // focuses the key input of the selected option row
+	OptionValue  key.Binding // focuses the value input of the selected option row
+	First        key.Binding
+	Last         key.Binding
+	Select       key.Binding // edits the selected row or submits the wizard on confirm
+	DeleteOption key.Binding
+	Exit         key.Binding
+}
+
+func (w WizardKeyBinds) ShortHelp() []key.Binding {
+	return []key.Binding{w.Next, w.Prev, w.First, w.Last, w.Select, w.DeleteOption, w.Exit}
+}
+
+func (w WizardKeyBinds) FullHelp() [][]key.Binding {
+	return [][]key.Binding{{w.Next, w.Prev, w.First, w.Last}, {w.OptionKey, w.OptionValue}, {w.Select, w.DeleteOption, w.Exit}}
+}
+
+// newWizardKeyBinds builds the wizard bindings with the configured keys applied
+func newWizardKeyBinds(bindings config.KeyBindings) WizardKeyBinds {
+	binds := keyBindings(bindings, config.KeyScopeWizard)
+	return WizardKeyBinds{
+		Next:         binds["next"],
+		Prev:         binds["prev"],
+		OptionKey:    binds["option_key"],
+		OptionValue:  binds["option_value"],
+		First:        binds["first"],
+		Last:         binds["last"],
+		Select:       binds["select"],
+		DeleteOption: binds["delete_option"],
+		Exit:         binds["exit"],
+	}
+}
+
+// SetKeyBindings applies the configured wizard keys
+func (w *WizardViewModel) SetKeyBindings(bindings config.KeyBindings) {
+	w.keys = newWizardKeyBinds(bindings)
+	for i := range w.hostOptions {
+		w.hostOptions[i].keys = w.keys
+	}
+}
+
+// SetSuggestionContext sets what option value suggestions are built from for every row
+func (w *WizardViewModel) SetSuggestionContext(ctx sshUtils.SuggestionContext) {
+	w.suggestion = ctx
+	for i := range w.hostOptions {
+		w.hostOptions[i].suggestion = ctx
+	}
 }
 
 func (w WizardViewModel) innerWidth() int {
@@ -335,8 +395,8 @@ func (w WizardViewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if w.selectedRow < 3 {
 			switch w.selectedRow {
 			case 0:
-				if key, ok := msg.(tea.KeyMsg); ok {
-					if key.Type == tea.KeyEnter || key.Type == tea.KeyEsc {
+				if keyMsg, ok := msg.(tea.KeyMsg); ok {
+					if key.Matches(keyMsg, w.keys.Select, w.keys.Exit) {
 						w.mode = formNavigateMode
 						w.hostInput.Blur()
 						return w, nil
@@ -348,8 +408,8 @@ func (w WizardViewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					return w, nil
 				}
 			case 1:
-				if key, ok := msg.(tea.KeyMsg); ok {
-					if key.Type == tea.KeyEnter || key.Type == tea.KeyEsc {
+				if keyMsg, ok := msg.(tea.KeyMsg); ok {
+					if key.Matches(keyMsg, w.keys.Select, w.keys.Exit) {
 						w.mode = formNavigateMode
 						w.hostnameInput.Blur()
 						return w, nil
@@ -361,8 +421,8 @@ func (w WizardViewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					return w, nil
 				}
 			default:
-				if key, ok := msg.(tea.KeyMsg); ok {
-					if key.Type == tea.KeyEnter || key.Type == tea.KeyEsc {
+				if keyMsg, ok := msg.(tea.KeyMsg); ok {
+					if key.Matches(keyMsg, w.keys.Select, w.keys.Exit) {
 						w.mode = formNavigateMode
 						w.tags.Blur()
 						return w, nil
@@ -386,7 +446,7 @@ func (w WizardViewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		} else {
 			if w.selectedRow == len(w.hostOptions)+3 && w.notes.Focused() {
 				if msg, ok := msg.(tea.KeyMsg); ok {
-					if msg.Type == tea.KeyEsc || msg.Type == tea.KeyCtrlS {
+					if key.Matches(msg, w.keys.Exit) || msg.Type == tea.KeyCtrlS {
 						w.notes.Blur()
 						return w, nil
 					}
@@ -401,10 +461,10 @@ func (w WizardViewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	}
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
-		if msg.Type == tea.KeyEsc {
+		if key.Matches(msg, w.keys.Exit) {
 			return w, func() tea.Msg { return userExitWizard{} }
 		}
-		if w.selectedRow == len(w.hostOptions)+4 && msg.String() == "enter" {
+		if w.selectedRow == len(w.hostOptions)+4 && key.Matches(msg, w.keys.Select) {
 			cmd := func() tea.Msg {
 				host := w.hostInput.Value()
 				options := make([]sqlite.HostOptions, 0)
@@ -440,17 +500,17 @@ func (w WizardViewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			return w, cmd
 		}
-		if msg.Type == tea.KeyEnd {
+		if key.Matches(msg, w.keys.Last) {
 			w.selectedRow = len(w.hostOptions) + 4
 			w.ensureKVSelectionVisible()
 			return w, nil
 		}
-		if msg.Type == tea.KeyHome {
+		if key.Matches(msg, w.keys.First) {
 			w.selectedRow = 0
 			w.ensureKVSelectionVisible()
 			return w, nil
 		}
-		if msg.String() == "down" || msg.String() == "k" || msg.String() == "tab" {
+		if key.Matches(msg, w.keys.Next) {
 			if w.selectedRow == len(w.hostOptions)+4 {
 				return w, nil
 			}
@@ -458,7 +518,7 @@ func (w WizardViewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			w.ensureKVSelectionVisible()
 			return w, nil
 		}
-		if msg.String() == "up" || msg.String() == "j" || msg.Type == tea.KeyShiftTab {
+		if key.Matches(msg, w.keys.Prev) {
 			if w.selectedRow == 0 {
 				return w, nil
 			}
@@ -466,7 +526,7 @@ func (w WizardViewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			w.ensureKVSelectionVisible()
 			return w, nil
 		}
-		if msg.String() == "enter" {
+		if key.Matches(msg, w.keys.Select) {
 			w.mode = formEditMode
 			// get selected row and then toggle that element as focused
 			if w.selectedRow < 3 {
@@ -484,7 +544,8 @@ func (w WizardViewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			index := w.selectedRow - 3
 			if index == len(w.hostOptions)-1 {
-				newRow := newKVRowInput()
+				newRow := newKVRowInput(w.keys)
+				newRow.suggestion = w.suggestion
 				newRow.SetWidth(w.innerWidth())
 				w.hostOptions = append(w.hostOptions, newRow) // as a user adds entries we
 				// want to keep adding options so they can continue to add more
@@ -500,7 +561,7 @@ func (w WizardViewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return w, cmd
 			}
 		}
-		if msg.String() == "d" {
+		if key.Matches(msg, w.keys.DeleteOption) {
 			index := w.selectedRow - 3
 			if index > 0 && index < len(w.hostOptions) {
 				// delete that option
@@ -510,7 +571,8 @@ func (w WizardViewModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						w.selectedRow--
 					}
 				} else { // clear the option if 2 or less rows exist
-					w.hostOptions[index] = newKVRowInput()
+					w.hostOptions[index] = newKVRowInput(w.keys)
+					w.hostOptions[index].suggestion = w.suggestion
 					w.hostOptions[index].SetWidth(w.innerWidth())
 				}
 				w.ensureKVSelectionVisible()
@@ -595,6 +657,9 @@ func (w WizardViewModel) View() string {
 	)
 
 	form := []string{host, hostname, tags, w.kvViewport.View(), notes, confirm}
+	if w.saveErr != nil {
+		form = append(form, lipgloss.NewStyle().Foreground(lipgloss.Color("#EF4444")).Render("Not added: "+w.saveErr.Error()))
+	}
 	return lipgloss.JoinVertical(lipgloss.Left, form...)
 }
 
@@ -619,9 +684,10 @@ func NewWizardViewModel() WizardViewModel {
 	notes.Placeholder = "Notes"
 	notes.SetHeight(wizardNotesHeight)
 
+	keys := newWizardKeyBinds(config.KeyBindings{})
 	hostOptions := make([]kvRowInput, 2)
 	for i := range hostOptions {
-		hostOptions[i] = newKVRowInput()
+		hostOptions[i] = newKVRowInput(keys)
 	}
 
 	formWidth := wizardDefaultFormWidth
@@ -639,6 +705,7 @@ func NewWizardViewModel() WizardViewModel {
 		formWidth:     formWidth,
 		width:         formWidth,
 		height:        defaultHeight,
+		keys:          keys,
 	}
 	wiz.recalcLayout()
 	return wiz
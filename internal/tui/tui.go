@@ -2,17 +2,25 @@ package tui
 
 import (
 	"andrew/sshman/internal/config"
+	"andrew/sshman/internal/lint"
+	"andrew/sshman/internal/ping"
 	"andrew/sshman/internal/sqlite"
 	"andrew/sshman/internal/sshParser"
 	"andrew/sshman/internal/sshUtils"
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
@@ -44,22 +52,64 @@ type userAddHostMessage struct{}
 type userExitWizard struct{}
 
 type deleteHostMessage struct {
-	host string
+	host  string
+	force bool // skips the confirmation
 }
 
 type sshProcFinished struct {
-	err error
+	host sqlite.Host
+	err  error
 }
 
 type pingResult struct {
 	host          string
 	hostReachable bool
+	notSSH        bool // the port is open but did not answer with an ssh banner
+	serverVersion string
+	hostKey       string // host key fingerprint, only fetched with the hostkey ping probe
+	addresses     []ping.AddressResult
 	ping          time.Duration
 	err           error
+	hops          []hopPingResult // results for each jump host in the ProxyJump chain, in connection order
+	failedHop     string          // jump host the route broke at, host and the hops after it were not pinged
+}
+
+type hopPingResult struct {
+	host      string
+	probed    bool // false if the route broke at an earlier hop
+	reachable bool
+	notSSH    bool
+	err       error
 }
 
 type startKeyRotateForm struct {
 	host string
+	key  string // IdentityFile value to preselect, set when walking through due rotations
+}
+
+type nextDueRotation struct{}
+
+type startBulkRotateForm struct {
+	hosts []sqlite.Host
+}
+
+type bulkRotateProgress struct {
+	status sshUtils.BulkRotateStatus
+}
+
+type bulkRotateFinished struct{}
+
+type bulkModalState struct {
+	visible  bool
+	running  bool
+	statuses []sshUtils.BulkRotateStatus
+	skipped  []string
+	updates  chan sshUtils.BulkRotateStatus
+	cancel   context.CancelFunc // aborts the rotation, hosts done so far are still recorded
+	ledger   *sshUtils.BulkLedgerRecorder
+	aborting bool
+	err      error
+	view     viewport.Model
 }
 
 type startKeyGenerationForm struct {
@@ -69,22 +119,38 @@ type startKeyGenerationForm struct {
 type removeOldKeyRequest struct {
 	host       string
 	oldKey     string
+	oldKeyOpt  string // IdentityFile value oldKey is stored under
 	newKeyPair sshUtils.KeyPair
+	verified   bool  // login with the new key succeeded, the old key is only removed if set
+	rotation   int64 // ledger entry of the rotation, 0 if it was not recorded
 	err        error
 }
 
+// verifyNewKeyRequest is sent once the new key is copied, req is sent on if logging in with the new key works
+type verifyNewKeyRequest struct {
+	req removeOldKeyRequest
+}
+
+type verifyNewKeyResult struct {
+	req removeOldKeyRequest
+	err error
+}
+
 type removeOldKeyResult struct {
 	host          string
 	oldKey        string
+	oldKeyOpt     string
 	newKeyPair    sshUtils.KeyPair
 	err           error
 	keyWasRemoved bool // shows wether the script ran or not
 	statusMsg     string
+	rotation      int64
 }
 
 type failedToCopyKey struct {
-	pair sshUtils.KeyPair
-	err  error
+	pair     sshUtils.KeyPair
+	err      error
+	rotation int64
 }
 
 type keyModalState struct {
@@ -95,8 +161,15 @@ type keyModalState struct {
 
 // todo hook up modal to show when rotating keys to get user consent to script usage
 
+type rotateVerifyModalState struct {
+	visible bool
+	host    string
+	key     string
+}
+
 type rotateKeyRemoveModalState struct {
 	visible     bool // focus state
+	verified    bool // login with the new key was checked before the removal was offered
 	err         error
 	host        string
 	keyPair     sshUtils.KeyPair
@@ -104,15 +177,17 @@ type rotateKeyRemoveModalState struct {
 	scriptView  viewport.Model
 	script      string
 	cmd         tea.Cmd
+	rotation    int64
 }
 
 type rotateKeyCopyModalState struct {
-	visible bool // focus state
-	err     error
-	host    string
-	keys    sshUtils.KeyPair
-	oldKey  string
-	cmd     tea.Cmd
+	visible  bool // focus state
+	err      error
+	host     string
+	keys     sshUtils.KeyPair
+	oldKey   string
+	cmd      tea.Cmd
+	rotation int64
 }
 
 type rotateKeyResultModal struct {
@@ -127,6 +202,68 @@ type failedToCopyModal struct {
 	err     error
 }
 
+type startEffectiveConfigView struct {
+	host sqlite.Host
+}
+
+type effectiveConfigResult struct {
+	host string
+	opts []sshUtils.EffectiveOption
+	err  error
+}
+
+type effectiveConfigModalState struct {
+	visible bool
+	loading bool
+	host    string
+	opts    []sshUtils.EffectiveOption
+	err     error
+	view    viewport.Model
+}
+
+type deleteWarningModalState struct {
+	visible    bool
+	host       string
+	dependents []string
+}
+
+// startForwardView opens the forward profile modal for host, an empty host lists the running tunnels of every host
+type startForwardView struct {
+	host string
+}
+
+type tunnelExited struct {
+	host string
+	name string
+	err  error
+}
+
+type forwardModalState struct {
+	visible  bool
+	host     string
+	profiles []sqlite.ForwardProfile
+	selected int
+	err      error
+}
+
+type startLintView struct{}
+
+type lintModalState struct {
+	visible  bool
+	findings []lint.Finding
+	err      error
+	view     viewport.Model
+}
+
+type startKeysView struct{}
+
+type keysModalState struct {
+	visible bool
+	entries []sshUtils.InventoryEntry
+	err     error
+	view    viewport.Model
+}
+
 type AppModel struct {
 	width, height int // this constitutes the entire terminal size
 	// app components
@@ -141,17 +278,113 @@ type AppModel struct {
 	pendingWrite          bool          // used to detect if write is needed before calling ssh process (only useful when writeThrough is disabled)
 	cfg                   config.Config // used to check if writeThrough is enabled if so forces
 	sshOpts               []string
+	keys                  ModalKeyBinds
 	keyModal              keyModalState
 	rotateCopyModal       rotateKeyCopyModalState
 	rotateRemoveKeyModal  rotateKeyRemoveModalState
 	rotateResultModal     rotateKeyResultModal
 	rotateCopyFailedModal failedToCopyModal
+	rotateVerifyModal     rotateVerifyModalState
+	effectiveModal        effectiveConfigModalState
+	deleteWarningModal    deleteWarningModalState
+	forwardModal          forwardModalState
+	lintModal             lintModalState
+	keysModal             keysModalState
+	bulkModal             bulkModalState
+	agentModal            agentModalState
+	hooksModal            hooksModalState
+	agentPassphraseModal  agentPassphraseModalState
+	rotationsModal        rotationsModalState
+	auditModal            auditModalState
+	pingScan              pingScanState
+	forwardDb             *sqlite.ForwardDao
+	keyDb                 *sqlite.KeyDao
+	tunnels               *sshUtils.TunnelManager
+	rotationQueue         []sshUtils.DueRotation // due rotations left to walk through
+	rotatingDue           bool                   // quit once rotationQueue is drained
+	ctx                   context.Context        // parent of all background work, canceled on quit
+	cancel                context.CancelFunc
+	work                  *inflightWork
+}
+
+// quitWaitTimeout bounds how long quitting waits for the background work that was canceled to clean up
+const quitWaitTimeout = 10 * time.Second
+
+// inflightWork tracks background work that leaves files behind when it is cut short, like key generation. Quitting
+// cancels it through the context of the app and waits for it so it gets to remove what it left
+type inflightWork struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	closing bool
+}
+
+// start registers work about to run, false once quitting started and the work should not run at all. Work is not
+// tracked by a nil inflightWork
+func (w *inflightWork) start() bool {
+	if w == nil {
+		return true
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closing {
+		return false
+	}
+	w.wg.Add(1)
+	return true
+}
+
+func (w *inflightWork) done() {
+	if w != nil {
+		w.wg.Done()
+	}
+}
+
+// track wraps cmd so quitting waits for it to return
+func (w *inflightWork) track(cmd tea.Cmd) tea.Cmd {
+	return func() tea.Msg {
+		if !w.start() {
+			return nil
+		}
+		defer w.done()
+		return cmd()
+	}
+}
+
+// goTracked runs fn on its own goroutine, quitting waits for it to return
+func (w *inflightWork) goTracked(fn func()) {
+	if !w.start() {
+		return
+	}
+	go func() {
+		defer w.done()
+		fn()
+	}()
+}
+
+// wait stops new work from starting and waits at most timeout for the work running
+func (w *inflightWork) wait(timeout time.Duration) {
+	w.mu.Lock()
+	w.closing = true
+	w.mu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		slog.Warn("Background work did not finish before quitting", "timeout", timeout)
+	}
 }
 
 // todo implement model func
 
 func (a AppModel) Init() tea.Cmd {
-	return nil
+	if a.rotatingDue {
+		return func() tea.Msg { return nextDueRotation{} }
+	}
+	return a.scanOnStart()
 }
 
 func getWriteThroughOption(configuration *bool) bool {
@@ -174,7 +407,7 @@ func runSSHProgram(host sqlite.Host, sshPath string, configPath string, options
 		c = exec.Command(sshPath, args...)
 	}
 	return tea.ExecProcess(c, func(err error) tea.Msg {
-		return sshProcFinished{err: err}
+		return sshProcFinished{host: host, err: err}
 	})
 }
 
@@ -195,17 +428,43 @@ func (a AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		a.wizard = wiz.(WizardViewModel)
 		a.keyForm.width = msg.Width
 		a.keyForm.height = newDim.Height
+		if a.effectiveModal.visible {
+			a.fillEffectiveView()
+		}
+		if a.lintModal.visible {
+			a.fillLintView()
+		}
+		if a.keysModal.visible {
+			a.fillKeysView()
+		}
+		if a.bulkModal.visible {
+			a.fillBulkView()
+		}
+		if a.hooksModal.visible {
+			a.fillHooksView()
+		}
 		return a, cmd
 	case userAddHostMessage:
 		// Show wizard state, and create a new wizard with current dimensions of viewport
 		a.focusState = wizardMode
-		newWiz, _ := NewWizardViewModel().Update(tea.WindowSizeMsg{Height: a.wizard.height, Width: a.wizard.width})
+		wiz := NewWizardViewModel()
+		wiz.SetKeyBindings(a.cfg.KeyBindings)
+		wiz.SetSuggestionContext(newSuggestionContext(a.cfg, "", a.hostsModel.data))
+		newWiz, _ := wiz.Update(tea.WindowSizeMsg{Height: a.wizard.height, Width: a.wizard.width})
 		a.wizard = newWiz.(WizardViewModel)
 		return a, nil
 	case userExitWizard: // leave sshWizard view
 		a.focusState = mainViewMode
 		return a, nil
 	case deleteHostMessage:
+		if !msg.force {
+			a.deleteWarningModal = deleteWarningModalState{
+				visible:    true,
+				host:       msg.host,
+				dependents: sshUtils.JumpDependents(msg.host, a.hostsModel.data),
+			}
+			return a, nil
+		}
 		err := a.db.Delete(sqlite.Host{Host: msg.host})
 		if err != nil {
 			slog.Error("Failed to delete host from db", "Host", msg.host)
@@ -228,8 +487,12 @@ func (a AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return a, nil
 	case newHostsMessage:
 		// todo insert new host and then get updated table
-		a.focusState = mainViewMode
 		newHost := msg.host
+		if err := sshUtils.ValidateJumpChain(newHost, a.hostsModel.data); err != nil {
+			a.wizard.saveErr = fmt.Errorf("invalid ProxyJump chain: %w", err)
+			return a, nil
+		}
+		a.focusState = mainViewMode
 		err := a.db.Insert(newHost)
 		if err != nil {
 			slog.Error("Failed to insert new host into the db", "error", err)
@@ -293,53 +556,76 @@ func (a AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 			a.pendingWrite = !a.pendingWrite
 		}
-		// todo in the future we also want to pass options given from the command line
-		exeCommand := runSSHProgram(msg.host, a.cfg.Ssh.ExcPath, a.cfg.GetSshConfigFilePath())
-		return a, exeCommand
+		return a.connect(msg.host)
+	case startAgentView:
+		return a.openAgentView(msg.host)
+	case startRotationsView:
+		return a.openRotationsView(), nil
+	case startAuditView:
+		return a.openAuditView(msg.hosts)
+	case auditFinished:
+		return a.handleAuditFinished(msg), nil
+	case auditKeysRemoved:
+		return a.handleAuditKeysRemoved(msg)
+	case loadAgentKeys:
+		return a.loadAgentKeys(msg)
+	case agentKeysPrepared:
+		return a.handleAgentKeysPrepared(msg)
+	case agentKeysLoaded:
+		return a.handleAgentKeysLoaded(msg)
+	case agentKeysListed:
+		return a.handleAgentKeysListed(msg), nil
+	case agentPassphraseAdded:
+		return a.handleAgentPassphraseAdded(msg)
+	case agentKeyRemoved:
+		return a.handleAgentKeyRemoved(msg)
+	case hookOutput:
+		a.hooksModal.lines = append(a.hooksModal.lines, msg.line)
+		a.fillHooksView()
+		return a, waitForHookOutput(a.hooksModal.updates, a.hooksModal.result)
+	case hooksFinished:
+		return a.handleHooksFinished(msg)
 
 	case tea.KeyMsg:
 		if msg.Type == tea.KeyCtrlC {
-			if a.pendingWrite {
-				// todo serialize host config file
-				hosts, err := a.db.GetAll()
-				if err != nil {
-					slog.Error("Failed to write config file after database change, recommend to run a serialization dump")
-				} else {
-					err := sshParser.SerializeHostToFile(a.cfg.GetSshConfigFilePath(), hosts)
-					if err != nil {
-						slog.Error("Failed to write config file after database change, recommend to run a serialization dump")
-					} else {
-						a.pendingWrite = false
-					}
-				}
-			}
-			return a, tea.Quit
+			return a, a.quit()
 		}
 		// check if any modal is visible
 		// going to be honest i think resetting the focus state is not needed since its adjusted in the modal
 		// creation but im going to leave it in case something changes in future and that isn't true
+		if a.hooksModal.visible {
+			return a.handleHooksModalKey(msg)
+		}
 		if a.keyModal.visible {
-			if msg.String() == "esc" || msg.String() == "enter" {
+			if key.Matches(msg, a.keys.Close, a.keys.Confirm) {
 				a.keyModal.visible = false
 				a.focusState = mainViewMode
 			}
 			return a, nil
+		} else if a.rotateVerifyModal.visible {
+			return a, nil // closes itself once the login check returns
 		} else if a.rotateCopyModal.visible { // todo implement these blocks that handle modal views
-			if msg.String() == "esc" {
+			if key.Matches(msg, a.keys.Close) {
 				a.rotateCopyModal.visible = false
 				a.focusState = mainViewMode
 				if a.rotateCopyModal.err == nil {
+					a.updateRotation(a.rotateCopyModal.rotation, func(r *sqlite.Rotation) {
+						r.Copy = sqlite.RotationCanceled
+					})
+					a.refreshUnfinishedRotations()
 					// show results screen with pub and private key loc
 					status := "User canceled copy request\n"
 					newKeys := "New keys can be found: " + a.rotateCopyModal.keys.PrivateKey + "(.pub)\n"
-					oldKeys := "To remove the old key from remote server look for the comment that matches the private key"
+					oldKeys := "The rotation can be resumed or rolled back from the rotation ledger (H)"
 					a.rotateResultModal = rotateKeyResultModal{
 						err:     nil,
 						message: fmt.Sprintf("%s%s%s", status, newKeys, oldKeys),
 						visible: true,
 					}
+				} else {
+					return a, a.finishRotation()
 				}
-			} else if msg.String() == "enter" {
+			} else if key.Matches(msg, a.keys.Confirm) {
 				if a.rotateCopyModal.err == nil {
 					a.rotateCopyModal.visible = false
 					a.focusState = mainViewMode
@@ -347,31 +633,37 @@ func (a AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				} else { // if error is not nil accept enter as esc, this was because an error was generated producing the keys
 					a.rotateCopyModal.visible = false
 					a.focusState = mainViewMode
+					return a, a.finishRotation()
 				}
 			}
 			return a, nil
 		} else if a.rotateCopyFailedModal.visible {
-			if msg.String() == "esc" || msg.String() == "enter" {
+			if key.Matches(msg, a.keys.Close, a.keys.Confirm) {
 				a.rotateCopyFailedModal.visible = false
 				a.focusState = mainViewMode
+				return a, a.finishRotation()
 			}
 
 		} else if a.rotateRemoveKeyModal.visible { // this will need extra work for handling viewport navigation
-			if a.rotateRemoveKeyModal.err != nil && (msg.String() == "enter" || msg.String() == "esc") {
+			if a.rotateRemoveKeyModal.err != nil && key.Matches(msg, a.keys.Confirm, a.keys.Close) {
 				a.rotateRemoveKeyModal.visible = false
 				a.focusState = mainViewMode
-				return a, nil
+				return a, a.finishRotation()
 			}
-			if msg.String() == "enter" {
+			if key.Matches(msg, a.keys.Confirm) {
 				a.rotateRemoveKeyModal.visible = false
 				a.focusState = mainViewMode
 				return a, a.rotateRemoveKeyModal.cmd
 			}
-			if msg.String() == "esc" {
+			if key.Matches(msg, a.keys.Close) {
 				// here we should show the results modal
 				// should say that the key has been copied but the old key has not been removed
 				a.rotateRemoveKeyModal.visible = false
-				statusMsg := "New Key %s was copied to remote.\nOld key %s was not removed from remote and should be removed"
+				a.updateRotation(a.rotateRemoveKeyModal.rotation, func(r *sqlite.Rotation) {
+					r.Remove = sqlite.RotationCanceled
+				})
+				a.refreshUnfinishedRotations()
+				statusMsg := "New Key %s was copied to remote.\nOld key %s was not removed from remote, resume the rotation from the rotation ledger (H) to remove it"
 				statusMsg = fmt.Sprintf(statusMsg, filepath.Base(a.rotateRemoveKeyModal.keyPair.PubKey), filepath.Base(a.rotateRemoveKeyModal.keyToRemove))
 				a.rotateResultModal = rotateKeyResultModal{
 					visible: true,
@@ -380,27 +672,179 @@ func (a AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 			}
 			// view port handling
-			if msg.String() == "left" || msg.String() == "h" || msg.String() == "right" || msg.String() == "l" {
-				m, cmd := a.rotateRemoveKeyModal.scriptView.Update(msg)
-				a.rotateRemoveKeyModal.scriptView = m
-				return a, cmd
-			}
-			if msg.String() == "k" || msg.String() == "up" {
-				if a.rotateRemoveKeyModal.scriptView.YOffset >= 1 {
-					a.rotateRemoveKeyModal.scriptView.YOffset--
-				}
-				return a, nil
-			}
-			if msg.String() == "i" || msg.String() == "down" {
-				a.rotateRemoveKeyModal.scriptView.YOffset++
-				return a, nil
+			switch {
+			case key.Matches(msg, a.keys.Left):
+				a.rotateRemoveKeyModal.scriptView.ScrollLeft(1)
+			case key.Matches(msg, a.keys.Right):
+				a.rotateRemoveKeyModal.scriptView.ScrollRight(1)
+			case key.Matches(msg, a.keys.Up):
+				a.rotateRemoveKeyModal.scriptView.ScrollUp(1)
+			case key.Matches(msg, a.keys.Down):
+				a.rotateRemoveKeyModal.scriptView.ScrollDown(1)
 			}
 			return a, nil
 
 		} else if a.rotateResultModal.visible { // enter and esc being only options here
-			if msg.String() == "esc" || msg.String() == "enter" {
+			if key.Matches(msg, a.keys.Close, a.keys.Confirm) {
 				a.rotateResultModal.visible = false
 				a.focusState = mainViewMode
+				return a, a.finishRotation()
+			}
+			return a, nil
+		} else if a.effectiveModal.visible {
+			switch {
+			case key.Matches(msg, a.keys.Close, a.keys.Confirm):
+				a.effectiveModal.visible = false
+				a.focusState = mainViewMode
+			case key.Matches(msg, a.keys.Up):
+				a.effectiveModal.view.ScrollUp(1)
+			case key.Matches(msg, a.keys.Down):
+				a.effectiveModal.view.ScrollDown(1)
+			}
+			return a, nil
+		} else if a.lintModal.visible {
+			switch {
+			case key.Matches(msg, a.keys.Close, a.keys.Confirm):
+				a.lintModal.visible = false
+				a.focusState = mainViewMode
+			case key.Matches(msg, a.keys.Up):
+				a.lintModal.view.ScrollUp(1)
+			case key.Matches(msg, a.keys.Down):
+				a.lintModal.view.ScrollDown(1)
+			}
+			return a, nil
+		} else if a.bulkModal.visible {
+			switch {
+			case key.Matches(msg, a.keys.Close, a.keys.Confirm):
+				if a.bulkModal.running {
+					if key.Matches(msg, a.keys.Close) && !a.bulkModal.aborting {
+						// hosts mid rotation are stopped, the report is shown once they finish
+						a.bulkModal.cancel()
+						a.bulkModal.aborting = true
+					}
+					break
+				}
+				a.bulkModal.visible = false
+				a.focusState = mainViewMode
+			case key.Matches(msg, a.keys.Up):
+				a.bulkModal.view.ScrollUp(1)
+			case key.Matches(msg, a.keys.Down):
+				a.bulkModal.view.ScrollDown(1)
+			}
+			return a, nil
+		} else if a.agentPassphraseModal.visible {
+			if a.agentPassphraseModal.adding {
+				return a, nil // the submitted passphrase is still being checked
+			}
+			switch {
+			case key.Matches(msg, a.keys.Close):
+				return a.nextAgentPassphrase()
+			case key.Matches(msg, a.keys.Confirm):
+				return a.submitAgentPassphrase()
+			}
+			var cmd tea.Cmd
+			a.agentPassphraseModal.input, cmd = a.agentPassphraseModal.input.Update(msg)
+			return a, cmd
+		} else if a.agentModal.visible {
+			switch {
+			case key.Matches(msg, a.keys.Close, a.keys.Confirm):
+				a.agentModal.visible = false
+				a.focusState = mainViewMode
+			case key.Matches(msg, a.keys.Up):
+				a.agentModal.selected = max(0, a.agentModal.selected-1)
+			case key.Matches(msg, a.keys.Down):
+				a.agentModal.selected = min(max(0, len(a.agentModal.keys)-1), a.agentModal.selected+1)
+			case key.Matches(msg, a.keys.AgentRemove):
+				return a.removeSelectedAgentKey()
+			case key.Matches(msg, a.keys.AgentLoad):
+				if a.agentModal.host != nil {
+					a.agentModal.message = ""
+					return a.loadAgentKeys(loadAgentKeys{host: *a.agentModal.host})
+				}
+			}
+			return a, nil
+		} else if a.rotationsModal.visible {
+			switch {
+			case key.Matches(msg, a.keys.Close, a.keys.Confirm):
+				a.rotationsModal.visible = false
+				a.focusState = mainViewMode
+			case key.Matches(msg, a.keys.Up):
+				a.rotationsModal.selected = max(0, a.rotationsModal.selected-1)
+			case key.Matches(msg, a.keys.Down):
+				a.rotationsModal.selected = min(max(0, len(a.rotationsModal.rotations)-1), a.rotationsModal.selected+1)
+			case key.Matches(msg, a.keys.LedgerAll):
+				a.rotationsModal.all = !a.rotationsModal.all
+				a.rotationsModal.selected = 0
+				a.rotationsModal.message = ""
+				a = a.refreshRotationsView()
+			case key.Matches(msg, a.keys.LedgerResume):
+				a.rotationsModal.message = ""
+				return a.resumeSelectedRotation()
+			case key.Matches(msg, a.keys.LedgerRollback):
+				a.rotationsModal.message = ""
+				a = a.rollbackSelectedRotation()
+			}
+			return a, nil
+		} else if a.auditModal.visible {
+			if a.auditModal.confirming {
+				switch {
+				case key.Matches(msg, a.keys.Yes):
+					return a.removeMarkedAuditEntries()
+				case key.Matches(msg, a.keys.No, a.keys.Close):
+					a.auditModal.confirming = false
+				}
+				return a, nil
+			}
+			switch {
+			case key.Matches(msg, a.keys.Close, a.keys.Confirm):
+				a.auditModal.visible = false
+				a.focusState = mainViewMode
+			case key.Matches(msg, a.keys.Up):
+				a.auditModal.selected = max(0, a.auditModal.selected-1)
+			case key.Matches(msg, a.keys.Down):
+				a.auditModal.selected = min(max(0, len(a.auditModal.rows)-1), a.auditModal.selected+1)
+			case key.Matches(msg, a.keys.AuditMark):
+				a = a.toggleAuditMark()
+			case key.Matches(msg, a.keys.AuditMarkFlagged):
+				a = a.markFlaggedAuditEntries()
+			case key.Matches(msg, a.keys.AuditRemove):
+				a.auditModal.confirming = len(a.auditModal.marked) > 0 && !a.auditModal.running
+			}
+			return a, nil
+		} else if a.keysModal.visible {
+			switch {
+			case key.Matches(msg, a.keys.Close, a.keys.Confirm):
+				a.keysModal.visible = false
+				a.focusState = mainViewMode
+			case key.Matches(msg, a.keys.Up):
+				a.keysModal.view.ScrollUp(1)
+			case key.Matches(msg, a.keys.Down):
+				a.keysModal.view.ScrollDown(1)
+			}
+			return a, nil
+		} else if a.forwardModal.visible {
+			switch {
+			case key.Matches(msg, a.keys.Close):
+				a.forwardModal.visible = false
+				a.focusState = mainViewMode
+			case key.Matches(msg, a.keys.Up):
+				a.forwardModal.selected = max(0, a.forwardModal.selected-1)
+			case key.Matches(msg, a.keys.Down):
+				a.forwardModal.selected = min(max(0, len(a.forwardModal.profiles)-1), a.forwardModal.selected+1)
+			case key.Matches(msg, a.keys.Confirm):
+				return a, a.toggleSelectedTunnel()
+			}
+			return a, nil
+		} else if a.deleteWarningModal.visible {
+			switch {
+			case key.Matches(msg, a.keys.Close):
+				a.deleteWarningModal.visible = false
+				a.focusState = mainViewMode
+			case key.Matches(msg, a.keys.Confirm):
+				a.deleteWarningModal.visible = false
+				a.focusState = mainViewMode
+				host := a.deleteWarningModal.host
+				return a, func() tea.Msg { return deleteHostMessage{host: host, force: true} }
 			}
 			return a, nil
 		}
@@ -429,33 +873,140 @@ func (a AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if msg.err != nil {
 			slog.Error("ssh ran into an error", "error", msg.err)
 		}
-		return a, nil
+		return a.disconnected(msg.host)
 	case pingResult:
+		a = a.recordPing(msg)
 		update, cmd := a.hostsModel.Update(msg)
 		a.hostsModel = update.(HostsPanelModel)
+		a.header.hostsUp, a.header.hostsPinged = a.hostsModel.reachability()
 		return a, cmd
+	case startPingScan:
+		return a.startPingScan()
+	case pingScanProgress:
+		a = a.recordPing(msg.result)
+		update, cmd := a.hostsModel.Update(msg.result)
+		a.hostsModel = update.(HostsPanelModel)
+		a.header.hostsUp, a.header.hostsPinged = a.hostsModel.reachability()
+		return a, tea.Batch(cmd, waitForPingScan(a.pingScan.updates))
+	case pingScanFinished:
+		a.pingScan.running = false
+		a.pruneStoredPingSamples()
+		return a, a.nextPingScan()
 	case abortedKeyGenForm:
+		a.keyForm.release()
 		a.focusState = mainViewMode
 		return a, nil
 	case abortedRotatedKeyForm:
+		a.keyRotateForm.release()
 		a.focusState = mainViewMode
-		return a, nil
+		return a, a.finishRotation()
+	case nextDueRotation:
+		if len(a.rotationQueue) == 0 {
+			return a, a.quit()
+		}
+		next := a.rotationQueue[0]
+		a.rotationQueue = a.rotationQueue[1:]
+		return a, func() tea.Msg {
+			return startKeyRotateForm{host: next.Host, key: next.Key}
+		}
 	// todo set focus state and initialize forms according to the host given
 	case startKeyGenerationForm:
 		a.focusState = keyGenForm
-		a.keyForm = NewKeyGenModel(msg.host, a.cfg)
+		a.keyForm = NewKeyGenModel(a.ctx, a.work, msg.host, a.cfg)
 		return a, a.keyForm.Init()
 	case startKeyRotateForm:
 		a.focusState = rotateKeyGenForm
 		hostsKeys, err := a.db.GetAllHostsIdentityKeys(msg.host)
 		if err != nil {
 			slog.Warn("Failed to get hosts keys due to error", "Host", msg.host, "error", err)
-			return a, nil
+			a.focusState = mainViewMode
+			return a, a.finishRotation()
+		}
+		// the rest of the host is needed to expand tokens in the key paths
+		host, err := a.db.Get(msg.host)
+		if err != nil {
+			slog.Warn("Failed to get host due to error", "Host", msg.host, "error", err)
+			a.focusState = mainViewMode
+			return a, a.finishRotation()
 		}
-		a.keyRotateForm = NewKeyRotateModel(msg.host, hostsKeys, a.cfg)
+		a.keyRotateForm = NewKeyRotateModel(a.ctx, a.work, host, hostsKeys, msg.key, a.cfg)
 		return a, a.keyRotateForm.Init()
+	case startBulkRotateForm:
+		// copies and logins are run against the generated file so any buffered changes need to be flushed first
+		a.flushPendingWrite()
+		a.focusState = rotateKeyGenForm
+		a.keyRotateForm = NewBulkRotateModel(msg.hosts, a.cfg)
+		return a, a.keyRotateForm.Init()
+	case bulkRotateRequest:
+		a.focusState = mainViewMode
+		a.bulkModal = bulkModalState{
+			visible: true,
+			skipped: msg.skipped,
+			view:    viewport.New(60, 15),
+		}
+		if len(msg.targets) == 0 {
+			a.bulkModal.err = fmt.Errorf("none of the hosts have a key to rotate")
+			a.fillBulkView()
+			return a, nil
+		}
+		for _, target := range msg.targets {
+			a.bulkModal.statuses = append(a.bulkModal.statuses, sshUtils.BulkRotateStatus{BulkRotateTarget: target})
+		}
+		a.fillBulkView()
+		a.bulkModal.running = true
+		a.bulkModal.ledger = sshUtils.NewBulkLedgerRecorder(a.keyDb, time.Now())
+		a.bulkModal.updates = make(chan sshUtils.BulkRotateStatus)
+		ctx, cancel := context.WithCancel(a.ctx)
+		a.bulkModal.cancel = cancel
+		opts := msg.opts
+		opts.SSHOptions = a.sshOpts
+		updates, cfg := a.bulkModal.updates, a.cfg
+		a.work.goTracked(func() {
+			defer cancel()
+			sshUtils.BulkRotate(ctx, msg.targets, opts, cfg, updates)
+			close(updates)
+		})
+		return a, waitForBulkRotate(updates)
+	case bulkRotateProgress:
+		if err := a.bulkModal.ledger.Record(msg.status); err != nil {
+			slog.Warn("Failed to record bulk rotation progress", "error", err)
+		}
+		for i := range a.bulkModal.statuses {
+			if a.bulkModal.statuses[i].Host == msg.status.Host {
+				a.bulkModal.statuses[i] = msg.status
+			}
+		}
+		a.fillBulkView()
+		return a, waitForBulkRotate(a.bulkModal.updates)
+	case bulkRotateFinished:
+		a.bulkModal.running = false
+		a.bulkModal.err = sshUtils.ApplyBulkRotation(a.db, a.keyDb, a.bulkModal.ledger, a.bulkModal.statuses, a.cfg)
+		if a.bulkModal.err != nil {
+			slog.Warn("Failed to record bulk rotation", "error", a.bulkModal.err)
+		}
+		a.fillBulkView()
+		hosts, err := a.db.GetAll()
+		if err != nil {
+			slog.Warn("Failed to get all hosts from database", "error", err)
+			return a, nil
+		}
+		a.hostsModel.data = hosts
+		a.hostsModel.refreshTableRows()
+		if err = sshParser.SerializeHostToFile(a.cfg.GetSshConfigFilePath(), hosts); err != nil {
+			slog.Warn("Failed to serialize hosts into ssh config file", "error", err)
+			a.pendingWrite = true
+		}
+		a.refreshRotationsDue()
+		return a, nil
+	case securityKeyRequest:
+		return a, tea.Exec(msg.session, msg.done)
 	case keyGenResult:
+		a.keyForm.release()
 		a.focusState = mainViewMode
+		if errors.Is(msg.err, context.Canceled) {
+			slog.Info("Key generation aborted", "host", msg.host)
+			return a, nil
+		}
 		a.keyModal = keyModalState{
 			visible: true,
 			pubKey:  msg.keyPair.PubKey,
@@ -464,6 +1015,8 @@ func (a AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		if msg.err == nil {
 			err := a.db.RegisterNewIdentityKeyForHost(msg.host, msg.keyPair.PrivateKey)
 			if err == nil {
+				a.recordKey(msg.keyPair.PrivateKey)
+				a.refreshRotationsDue()
 				if getWriteThroughOption(a.cfg.StorageConf.WriteThrough) {
 					hosts, err := a.db.GetAll()
 					if err != nil {
@@ -503,12 +1056,24 @@ func (a AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		// enter to accept
 		// esc to cancel
 		// if the user exit here don't remove the old key from the host
+		a.keyRotateForm.release()
+		if errors.Is(msg.err, context.Canceled) {
+			slog.Info("Key rotation aborted while generating the new key", "host", msg.host)
+			a.focusState = mainViewMode
+			return a, a.finishRotation()
+		}
 		a.rotateCopyModal = newRotateCopyModal(msg)
 		if msg.err == nil {
-			err := a.db.RegisterNewIdentityKeyForHost(msg.host, msg.newKeySet.PrivateKey)
-			if err != nil {
-				a.rotateCopyModal.err = err
-				return a, nil
+			// a resumed rotation already registered its new key, see resumeSelectedRotation
+			if msg.rotation == 0 {
+				err := a.db.RegisterNewIdentityKeyForHost(msg.host, msg.newKeySet.PrivateKey)
+				if err != nil {
+					a.rotateCopyModal.err = err
+					return a, nil
+				}
+				a.recordKey(msg.newKeySet.PrivateKey)
+				msg.rotation = a.startRotation(msg)
+				a.rotateCopyModal.rotation = msg.rotation
 			}
 			hosts, err := a.db.GetAll()
 			if err != nil {
@@ -522,30 +1087,47 @@ func (a AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					a.hostsModel.refreshTableRows()
 				}
 			}
-			cmd := tea.ExecProcess(sshUtils.CopyKey(msg.newKeySet.PubKey, msg.host, a.cfg, a.sshOpts...),
-				func(err error) tea.Msg {
-					if err != nil {
-						slog.Warn("Copy program failed to upload new key", "error", err, "host", msg.host, "public key", msg.newKeySet.PubKey)
-						return failedToCopyKey{
-							err:  err,
-							pair: msg.newKeySet,
-						}
-					}
-					if a.cfg.Ssh.RemovePubKeyAfterGen {
-						err := os.Remove(msg.newKeySet.PubKey)
-						if err != nil {
-							slog.Warn(
-								"Failed to remove pub key from keystore after copy", "PubKey", msg.newKeySet.PubKey,
-								"err", err,
-							)
-						}
+			copyKey := func(err error) tea.Msg {
+				if err != nil {
+					slog.Warn("Copy program failed to upload new key", "error", err, "host", msg.host, "public key", msg.newKeySet.PubKey)
+					return failedToCopyKey{
+						err:      err,
+						pair:     msg.newKeySet,
+						rotation: msg.rotation,
 					}
-					return removeOldKeyRequest{
-						host:       msg.host,
-						oldKey:     msg.oldKeyPath,
-						newKeyPair: msg.newKeySet,
+				}
+				if a.cfg.Ssh.RemovePubKeyAfterGen {
+					err := os.Remove(msg.newKeySet.PubKey)
+					if err != nil {
+						slog.Warn(
+							"Failed to remove pub key from keystore after copy", "PubKey", msg.newKeySet.PubKey,
+							"err", err,
+						)
 					}
-				})
+				}
+				return verifyNewKeyRequest{req: removeOldKeyRequest{
+					host:       msg.host,
+					oldKey:     msg.oldKeyPath,
+					oldKeyOpt:  msg.oldKeyOpt,
+					newKeyPair: msg.newKeySet,
+					rotation:   msg.rotation,
+				}}
+			}
+			var cmd tea.Cmd
+			if a.cfg.Ssh.Native {
+				session, err := sshUtils.InstallKeySession(a.ctx, msg.newKeySet.PubKey, msg.host, a.cfg, a.sshOpts...)
+				if err != nil {
+					a.rotateCopyModal.err = err
+					a.updateRotation(msg.rotation, func(r *sqlite.Rotation) {
+						r.Copy, r.Error = sqlite.RotationFailed, err.Error()
+					})
+					return a, nil
+				}
+				// the native transport never prompts so it runs in the background instead of taking over the terminal
+				cmd = a.work.track(func() tea.Msg { return copyKey(session.Run()) })
+			} else {
+				cmd = tea.ExecProcess(sshUtils.CopyKey(a.ctx, msg.newKeySet.PubKey, msg.host, a.cfg, a.sshOpts...), copyKey)
+			}
 			updHost, err := a.db.Get(msg.host)
 			if err != nil {
 				slog.Warn("Failed to fetch updated host after key addition in key rotation")
@@ -561,7 +1143,132 @@ func (a AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return a, nil
 	case failedToCopyKey:
 		a.rotateCopyFailedModal = newFailedToCopyModal(msg)
+		a.updateRotation(msg.rotation, func(r *sqlite.Rotation) {
+			r.Copy, r.Error = sqlite.RotationFailed, msg.err.Error()
+		})
+		return a, nil
+	case startEffectiveConfigView:
+		// ssh -G reads the generated file so any buffered changes need to be flushed first
+		a.flushPendingWrite()
+		a.effectiveModal = effectiveConfigModalState{
+			visible: true,
+			loading: true,
+			host:    msg.host.Host,
+			view:    viewport.New(60, 15),
+		}
+		a.fillEffectiveView()
+		stored := make(map[string][]string)
+		for _, opt := range msg.host.Options {
+			stored[opt.Key] = append(stored[opt.Key], opt.Value)
+		}
+		cfg := a.cfg
+		return a, func() tea.Msg {
+			opts, err := sshUtils.GetEffectiveConfig(msg.host.Host, stored, cfg)
+			return effectiveConfigResult{host: msg.host.Host, opts: opts, err: err}
+		}
+	case startForwardView:
+		// tunnels are started against the generated file so any buffered changes need to be flushed first
+		a.flushPendingWrite()
+		a.forwardModal = forwardModalState{
+			visible: true,
+			host:    msg.host,
+		}
+		a.forwardModal.profiles, a.forwardModal.err = a.loadForwardProfiles(msg.host)
+		return a, nil
+	case startKeysView:
+		a.keysModal = keysModalState{
+			visible: true,
+			view:    viewport.New(60, 15),
+		}
+		hosts, err := a.db.GetAll()
+		if err != nil {
+			a.keysModal.err = err
+			a.fillKeysView()
+			return a, nil
+		}
+		a.keysModal.entries, a.keysModal.err = sshUtils.RefreshKeyInventory(a.keyDb, hosts, a.cfg.GetKeyStorePath())
+		a.fillKeysView()
+		return a, nil
+	case startLintView:
+		a.lintModal = lintModalState{
+			visible: true,
+			view:    viewport.New(60, 15),
+		}
+		a.lintModal.findings, a.lintModal.err = a.runLint()
+		a.fillLintView()
 		return a, nil
+	case tunnelExited:
+		a.header.tunnels = len(a.tunnels.Sessions())
+		if msg.err != nil {
+			slog.Warn("Tunnel exited", "host", msg.host, "profile", msg.name, "error", msg.err)
+			if a.forwardModal.visible {
+				a.forwardModal.err = fmt.Errorf("%s/%s exited: %w", msg.host, msg.name, msg.err)
+			}
+		}
+		if a.forwardModal.visible && a.forwardModal.host == "" {
+			a.forwardModal.profiles, _ = a.loadForwardProfiles("")
+			a.forwardModal.selected = min(a.forwardModal.selected, max(0, len(a.forwardModal.profiles)-1))
+		}
+		return a, nil
+	case effectiveConfigResult:
+		if !a.effectiveModal.visible || a.effectiveModal.host != msg.host {
+			return a, nil // user closed the modal before ssh returned
+		}
+		if msg.err != nil {
+			slog.Warn("Failed to get effective config for host", "host", msg.host, "error", msg.err)
+		}
+		a.effectiveModal.loading = false
+		a.effectiveModal.opts = msg.opts
+		a.effectiveModal.err = msg.err
+		a.fillEffectiveView()
+		return a, nil
+	case verifyNewKeyRequest:
+		// the old key is only removed once the new key is proven to log in, a wrong authorized_keys permission
+		// or an AuthorizedKeysFile override would otherwise lock the user out
+		newKey := msg.req.newKeyPair.PrivateKey
+		a.rotateVerifyModal = rotateVerifyModalState{visible: true, host: msg.req.host, key: newKey}
+		a.updateRotation(msg.req.rotation, func(r *sqlite.Rotation) {
+			r.Copy = sqlite.RotationDone
+		})
+		if key, err := sshUtils.InspectKey(newKey); err == nil && (key.HasPassphrase || config.IsSecurityKeyType(sshUtils.KeyGenAlgorithm(key.Algorithm))) {
+			// batch mode would stop ssh asking for the passphrase or authenticator PIN, ssh reads it from the terminal
+			// instead where security keys also ask to be touched
+			cmd := sshUtils.VerifyKeyLoginCommand(a.ctx, newKey, msg.req.host, false, a.cfg, a.sshOpts...)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			return a, tea.ExecProcess(cmd, func(err error) tea.Msg {
+				return verifyNewKeyResult{req: msg.req, err: sshUtils.CheckKeyLogin(stderr.Bytes(), err, newKey, msg.req.host)}
+			})
+		}
+		ctx, cfg, opts := a.ctx, a.cfg, a.sshOpts
+		return a, func() tea.Msg {
+			return verifyNewKeyResult{req: msg.req, err: sshUtils.VerifyKeyLogin(ctx, newKey, msg.req.host, cfg, opts...)}
+		}
+	case verifyNewKeyResult:
+		a.rotateVerifyModal.visible = false
+		a.updateRotation(msg.req.rotation, func(r *sqlite.Rotation) {
+			r.Verify, r.Error = sqlite.RotationDone, ""
+			if msg.err != nil {
+				r.Verify, r.Error = sqlite.RotationFailed, msg.err.Error()
+			}
+		})
+		if msg.err != nil {
+			slog.Warn("Login with new key failed, old key is kept", "host", msg.req.host, "key", msg.req.newKeyPair.PrivateKey, "error", msg.err)
+			a.focusState = mainViewMode
+			kept := "No old key was selected for removal"
+			if msg.req.oldKey != "" {
+				kept = "Old key " + filepath.Base(msg.req.oldKey) + " was kept so access is not lost"
+			}
+			a.rotateResultModal = rotateKeyResultModal{
+				visible: true,
+				err:     msg.err,
+				message: fmt.Sprintf("New key %s was copied to %s but logging in with it failed:\n%s\n%s", filepath.Base(msg.req.newKeyPair.PrivateKey), msg.req.host, msg.err, kept),
+			}
+			return a, nil
+		}
+		req := msg.req
+		req.verified = true
+		return a, func() tea.Msg { return req }
 	case removeOldKeyRequest:
 		// ask user if they want to continue with the request
 		// the modal should show the key name being removed
@@ -569,21 +1276,70 @@ func (a AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		// enter accept
 		// esc cancel --> in this case send a removeOldKeyResult msg so the old key is at least de registered from host file
 
+		if !msg.verified {
+			a.rotateResultModal = rotateKeyResultModal{
+				visible: true,
+				message: "Key uploaded to remote, old key was not removed as login with the new key was not verified",
+			}
+			return a, nil
+		}
 		if msg.oldKey == "" { // case where users does not want to remove old key
+			a.updateRotation(msg.rotation, func(r *sqlite.Rotation) {
+				r.Remove = sqlite.RotationSkipped
+			})
 			a.rotateResultModal = rotateKeyResultModal{
 				visible: true,
 				err:     nil,
-				message: "Key uploaded to remote, no key to remove operation complete",
+				message: "Key uploaded to remote and login with it verified, no key to remove operation complete",
+			}
+			return a, nil
+		}
+		if a.cfg.Ssh.Native {
+			entry, err := sshUtils.AuthorizedKeyEntry(msg.oldKey)
+			if err != nil {
+				slog.Warn("Failed to read the old key for removal", "error", err)
+				a.focusState = mainViewMode
+				a.updateRotation(msg.rotation, func(r *sqlite.Rotation) {
+					r.Remove, r.Error = sqlite.RotationFailed, err.Error()
+				})
+				a.rotateResultModal = newRotateResultModal(removeOldKeyResult{
+					err:        err,
+					oldKey:     msg.oldKey,
+					oldKeyOpt:  msg.oldKeyOpt,
+					newKeyPair: msg.newKeyPair,
+					statusMsg:  "Failed to read the old key, it was not removed from the server",
+				})
+				return a, nil
 			}
+			a.rotateRemoveKeyModal = newRotateRemoveModal(msg)
+			session := sshUtils.RemoveKeySession(a.ctx, entry, msg.host, a.cfg, a.sshOpts...)
+			a.rotateRemoveKeyModal.cmd = a.work.track(func() tea.Msg {
+				err := session.Run()
+				return removeOldKeyResult{
+					host:          msg.host,
+					err:           err,
+					oldKey:        msg.oldKey,
+					oldKeyOpt:     msg.oldKeyOpt,
+					newKeyPair:    msg.newKeyPair,
+					keyWasRemoved: err == nil,
+					rotation:      msg.rotation,
+				}
+			})
+			a.rotateRemoveKeyModal.scriptView.Height = 6
+			a.rotateRemoveKeyModal.script = "remove every line holding\n" + entry + "\nfrom ~/.ssh/authorized_keys over sftp, the previous file is kept as authorized_keys.bak"
 			return a, nil
 		}
-		proc, err := sshUtils.RemoveOldKeyFromRemoteServer(msg.oldKey, msg.host, a.cfg, a.sshOpts...)
+		proc, err := sshUtils.RemoveOldKeyFromRemoteServer(a.ctx, msg.oldKey, msg.host, a.cfg, a.sshOpts...)
 		if err != nil {
 			slog.Warn("Failed to create a remote key removal script", "error", err)
 			a.focusState = mainViewMode
+			a.updateRotation(msg.rotation, func(r *sqlite.Rotation) {
+				r.Remove, r.Error = sqlite.RotationFailed, err.Error()
+			})
 			a.rotateResultModal = newRotateResultModal(removeOldKeyResult{
 				err:           err,
 				oldKey:        msg.oldKey,
+				oldKeyOpt:     msg.oldKeyOpt,
 				newKeyPair:    msg.newKeyPair,
 				keyWasRemoved: false,
 				statusMsg:     "Failed to generate removal script, likely due to key not being managed by ssh_man",
@@ -595,8 +1351,10 @@ func (a AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				host:          msg.host,
 				err:           err,
 				oldKey:        msg.oldKey,
+				oldKeyOpt:     msg.oldKeyOpt,
 				newKeyPair:    msg.newKeyPair,
 				keyWasRemoved: err == nil,
+				rotation:      msg.rotation,
 			}
 		})
 		a.rotateRemoveKeyModal = newRotateRemoveModal(msg)
@@ -608,12 +1366,19 @@ func (a AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case removeOldKeyResult:
 		a.focusState = mainViewMode
 		a.rotateResultModal = newRotateResultModal(msg)
+		a.updateRotation(msg.rotation, func(r *sqlite.Rotation) {
+			r.Remove, r.Error = sqlite.RotationDone, ""
+			if msg.err != nil {
+				r.Remove, r.Error = sqlite.RotationFailed, msg.err.Error()
+			}
+		})
+		a.refreshUnfinishedRotations()
 		if msg.err != nil {
 			slog.Warn("failed to remove old key from remote server", "host", msg.host, "key", msg.oldKey, "error", msg.err)
 			a.rotateResultModal.message = "Failed to remove old key from server due to error: " + msg.err.Error()
 			return a, nil
 		}
-		err := a.db.DeRegisterIdentityKeyFromHost(msg.host, msg.oldKey)
+		err := a.db.DeRegisterIdentityKeyFromHost(msg.host, msg.oldKeyOpt)
 		if err != nil {
 			slog.Warn("failed to delete key registered to host from database", "error", err, "host", msg.host, "key", msg.oldKey)
 			a.rotateResultModal.message = "New key was uploaded to server but failed to remove old one from database, error: " + err.Error()
@@ -635,6 +1400,9 @@ func (a AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				a.rotateResultModal.message = "New key was uploaded but failed to remove old key: " + filepath.Base(msg.oldKey) + "\n from disk"
 			} else {
 				a.rotateResultModal.message = "New Key was uploaded and old key was removed from config and remote server"
+				if err = a.keyDb.Delete(msg.oldKey); err != nil {
+					slog.Warn("failed to remove old key from key inventory", "key", msg.oldKey, "error", err)
+				}
 			}
 		} else {
 			a.rotateResultModal.message = fmt.Sprintf("New Key %s was uploaded\nOld Key %s was not removed from remote", filepath.Base(msg.newKeyPair.PubKey), filepath.Base(msg.oldKey))
@@ -667,6 +1435,9 @@ func (a AppModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 func (a AppModel) View() string {
 	a.footer.currentKeymap = a.hostsModel
+	if a.focusState == wizardMode {
+		a.footer.currentKeymap = a.wizard.keys
+	}
 	// todo render header with a bottom normal border
 	header := lipgloss.NewStyle().Width(a.width).
 		Height(a.header.Height()).
@@ -711,6 +1482,10 @@ func (a AppModel) View() string {
 		dimmed := lipgloss.NewStyle().Foreground(lipgloss.Color("#6B7280")).Render(base)
 		return overlayView(dimmed, a.rotateKeyCopyModalView())
 	}
+	if a.rotateVerifyModal.visible {
+		dimmed := lipgloss.NewStyle().Foreground(lipgloss.Color("#6B7280")).Render(base)
+		return overlayView(dimmed, a.rotateVerifyModalView())
+	}
 	if a.rotateCopyFailedModal.visible {
 		dimmed := lipgloss.NewStyle().Foreground(lipgloss.Color("#6B7280")).Render(base)
 		return overlayView(dimmed, a.rotateFailedToCopyKeyModalView())
@@ -723,29 +1498,122 @@ func (a AppModel) View() string {
 		dimmed := lipgloss.NewStyle().Foreground(lipgloss.Color("#6B7280")).Render(base)
 		return overlayView(dimmed, a.rotateKeyResultView())
 	}
+	if a.effectiveModal.visible {
+		dimmed := lipgloss.NewStyle().Foreground(lipgloss.Color("#6B7280")).Render(base)
+		return overlayView(dimmed, a.effectiveConfigModalView())
+	}
+	if a.lintModal.visible {
+		dimmed := lipgloss.NewStyle().Foreground(lipgloss.Color("#6B7280")).Render(base)
+		return overlayView(dimmed, a.lintModalView())
+	}
+	if a.keysModal.visible {
+		dimmed := lipgloss.NewStyle().Foreground(lipgloss.Color("#6B7280")).Render(base)
+		return overlayView(dimmed, a.keysModalView())
+	}
+	if a.agentPassphraseModal.visible {
+		dimmed := lipgloss.NewStyle().Foreground(lipgloss.Color("#6B7280")).Render(base)
+		return overlayView(dimmed, a.agentPassphraseModalView())
+	}
+	if a.agentModal.visible {
+		dimmed := lipgloss.NewStyle().Foreground(lipgloss.Color("#6B7280")).Render(base)
+		return overlayView(dimmed, a.agentModalView())
+	}
+	if a.rotationsModal.visible {
+		dimmed := lipgloss.NewStyle().Foreground(lipgloss.Color("#6B7280")).Render(base)
+		return overlayView(dimmed, a.rotationsModalView())
+	}
+	if a.auditModal.visible {
+		dimmed := lipgloss.NewStyle().Foreground(lipgloss.Color("#6B7280")).Render(base)
+		return overlayView(dimmed, a.auditModalView())
+	}
+	if a.bulkModal.visible {
+		dimmed := lipgloss.NewStyle().Foreground(lipgloss.Color("#6B7280")).Render(base)
+		return overlayView(dimmed, a.bulkModalView())
+	}
+	if a.forwardModal.visible {
+		dimmed := lipgloss.NewStyle().Foreground(lipgloss.Color("#6B7280")).Render(base)
+		return overlayView(dimmed, a.forwardModalView())
+	}
+	if a.deleteWarningModal.visible {
+		dimmed := lipgloss.NewStyle().Foreground(lipgloss.Color("#6B7280")).Render(base)
+		return overlayView(dimmed, a.deleteWarningModalView())
+	}
+	if a.hooksModal.visible {
+		dimmed := lipgloss.NewStyle().Foreground(lipgloss.Color("#6B7280")).Render(base)
+		return overlayView(dimmed, a.hooksModalView())
+	}
 	return base
 }
 
-func NewAppModel(hosts []sqlite.Host, db *sqlite.HostDao, cfg config.Config, sshOpts ...string) AppModel {
+func NewAppModel(hosts []sqlite.Host, db *sqlite.HostDao, forwardDb *sqlite.ForwardDao, keyDb *sqlite.KeyDao, tunnels *sshUtils.TunnelManager, cfg config.Config, sshOpts ...string) AppModel {
 	options := make([]string, 0)
 	for _, opt := range sshOpts {
 		options = append(options, "-o "+opt)
 	}
+	ctx, cancel := context.WithCancel(context.Background())
 	appModel := AppModel{
+		ctx:        ctx,
+		cancel:     cancel,
+		work:       &inflightWork{},
 		db:         db,
+		forwardDb:  forwardDb,
+		keyDb:      keyDb,
+		tunnels:    tunnels,
 		header:     NewHeaderModel(uint(len(hosts))),
 		footer:     NewFooterModel(),
 		focusState: int(mainViewMode),
 		hostsModel: NewHostsPanelModel(cfg, hosts),
 		wizard:     NewWizardViewModel(),
+		keys:       newModalKeyBinds(cfg.KeyBindings),
 		sshOpts:    options,
 		cfg:        cfg,
 	}
+	appModel.wizard.SetKeyBindings(cfg.KeyBindings)
+	appModel.hostsModel.ctx = ctx
 	appModel.footer.currentKeymap = appModel.hostsModel
 	appModel.rotateRemoveKeyModal.scriptView = viewport.New(60, 15)
+	appModel.header.rotationsDue = len(sshUtils.DueHosts(sshUtils.DueRotations(hosts, cfg, time.Now())))
+	if keyDb != nil {
+		certs, err := keyDb.GetCertificates()
+		if err != nil {
+			slog.Warn("Failed to load certificates, expiry will not be shown", "error", err)
+		} else {
+			appModel.hostsModel.setCertExpiry(sshUtils.CertificateExpiry(certs))
+		}
+	}
+	appModel.refreshUnfinishedRotations()
+	appModel.loadPingHistory()
 	return appModel
 }
 
+// QueueRotations walks the user through the rotate form for each due key in turn, the program quits
+// once every rotation has finished
+func (a *AppModel) QueueRotations(due []sshUtils.DueRotation) {
+	a.rotationQueue = due
+	a.rotatingDue = true
+}
+
+// refreshRotationsDue recounts the hosts with keys breaking the key policy for the header
+func (a *AppModel) refreshRotationsDue() {
+	hosts, err := a.db.GetAll()
+	if err != nil {
+		slog.Warn("Failed to get hosts to check key policy", "error", err)
+		return
+	}
+	a.header.rotationsDue = len(sshUtils.DueHosts(sshUtils.DueRotations(hosts, a.cfg, time.Now())))
+	a.refreshUnfinishedRotations()
+}
+
+// finishRotation is called whenever a rotate flow ends, successfully or not, and moves on to the next
+// queued rotation if there is one
+func (a *AppModel) finishRotation() tea.Cmd {
+	a.refreshRotationsDue()
+	if !a.rotatingDue {
+		return nil
+	}
+	return func() tea.Msg { return nextDueRotation{} }
+}
+
 func (a AppModel) keyModalView() string {
 	title := lipgloss.NewStyle().Bold(true).Render("Key Generated")
 	body := "Public key saved at:\n" + a.keyModal.pubKey
@@ -756,17 +1624,17 @@ func (a AppModel) keyModalView() string {
 		Border(lipgloss.RoundedBorder()).
 		Padding(2, 2).
 		Width(max(a.width/2, 60)).
-		Render(title + "\n\n" + body + "\n\nPress enter or esc to close")
+		Render(title + "\n\n" + body + "\n\nPress " + a.keys.closeHint())
 }
 
 func (a AppModel) rotateKeyCopyModalView() string {
 	width := max(60, a.width/2)
 	title := lipgloss.NewStyle().Bold(true).Render("Copy Key To Remote")
 	msg := fmt.Sprintf("Do you wish to copy key %s to remote %s", filepath.Base(a.rotateCopyModal.keys.PubKey), a.rotateCopyModal.host)
-	tail := lipgloss.NewStyle().Bold(true).Render("Press enter to proceeded or Esc to cancel")
+	tail := lipgloss.NewStyle().Bold(true).Render(fmt.Sprintf("Press %s to proceeded or %s to cancel", keyName(a.keys.Confirm), keyName(a.keys.Close)))
 	if a.rotateCopyModal.err != nil {
 		msg = fmt.Sprintf("Cannot Copy key to remote for reason %s", a.rotateCopyModal.err)
-		tail = "Press enter or esc to close"
+		tail = "Press " + a.keys.closeHint()
 	}
 
 	content := fmt.Sprintf("%s\n%s\n\n%s\n", title, msg, tail)
@@ -780,15 +1648,20 @@ func (a AppModel) rotateKeyRemoveModalView() string {
 	width := max(70, a.width/2)
 	a.rotateRemoveKeyModal.scriptView.Width = width
 	title := lipgloss.NewStyle().Bold(true).Render("Remove Key From Remote")
+	if a.rotateRemoveKeyModal.verified {
+		verified := lipgloss.NewStyle().Foreground(lipgloss.Color("#22C55E")).
+			Render(fmt.Sprintf("✓ logged in to %s with %s", a.rotateRemoveKeyModal.host, filepath.Base(a.rotateRemoveKeyModal.keyPair.PrivateKey)))
+		title = lipgloss.JoinVertical(lipgloss.Left, title, verified)
+	}
 	var content string
 	var tail string
 	if a.rotateRemoveKeyModal.err != nil { //
 		content = "Error encountered. Error: " + a.rotateRemoveKeyModal.err.Error()
-		tail = "\nPress enter or esc to close"
+		tail = "\nPress " + a.keys.closeHint()
 	} else {
 		// content = a.rotateRemoveKeyModal.script
 		content = lipgloss.NewStyle().Width(width - 2).Render(a.rotateRemoveKeyModal.script)
-		tail = "\nPress enter to continue or esc to cancel"
+		tail = fmt.Sprintf("\nPress %s to continue or %s to cancel", keyName(a.keys.Confirm), keyName(a.keys.Close))
 	}
 	a.rotateRemoveKeyModal.scriptView.SetContent(content)
 	return lipgloss.NewStyle().
@@ -798,12 +1671,22 @@ func (a AppModel) rotateKeyRemoveModalView() string {
 		Render(lipgloss.JoinVertical(lipgloss.Left, title, a.rotateRemoveKeyModal.scriptView.View()), tail)
 }
 
+func (a AppModel) rotateVerifyModalView() string {
+	title := lipgloss.NewStyle().Bold(true).Render("Verifying New Key")
+	body := fmt.Sprintf("Logging in to %s with %s\nThe old key is only removed once this succeeds", a.rotateVerifyModal.host, filepath.Base(a.rotateVerifyModal.key))
+	return lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder()).
+		Width(max(60, a.width/2)).
+		Padding(2, 2).
+		Render(title + "\n\n" + body)
+}
+
 func (a AppModel) rotateKeyResultView() string {
 	width := max(60, a.width/2)
 	title := lipgloss.NewStyle().Bold(true).Render("Key Rotation Result")
 	topSeparator := lipgloss.NewStyle().Foreground(lipgloss.Color("240")).Render(strings.Repeat("/", width-4))
 	bottomSeparator := lipgloss.NewStyle().Foreground(lipgloss.Color("240")).Render(strings.Repeat("\\", width-4))
-	content := fmt.Sprintf("%s\n%s\n%s\n%s\n\nPress enter or esc to close", title, topSeparator, a.rotateResultModal.message, bottomSeparator)
+	content := fmt.Sprintf("%s\n%s\n%s\n%s\n\nPress %s", title, topSeparator, a.rotateResultModal.message, bottomSeparator, a.keys.closeHint())
 
 	return lipgloss.NewStyle().Border(lipgloss.NormalBorder()).
 		Width(width).
@@ -816,7 +1699,7 @@ func (a AppModel) rotateFailedToCopyKeyModalView() string {
 	title := lipgloss.NewStyle().Bold(true).Render("Copy Failed")
 	errMsg := "Encountered error: " + a.rotateCopyFailedModal.err.Error()
 	content := "Key(s) Location: " + a.rotateCopyFailedModal.pair.PrivateKey + "(.pub)"
-	tail := "Press enter or esc to close"
+	tail := "Press " + a.keys.closeHint()
 	base := fmt.Sprintf("%s\n%s\n%s\n%s", title, errMsg, content, tail)
 	return lipgloss.NewStyle().
 		Border(lipgloss.NormalBorder()).
@@ -825,6 +1708,384 @@ func (a AppModel) rotateFailedToCopyKeyModalView() string {
 		Render(base)
 }
 
+// fillEffectiveView sizes the viewport of the effective config modal and sets its content. This is done in Update
+// rather than View so the viewport kept in the model has the lines to scroll through
+func (a *AppModel) fillEffectiveView() {
+	width := max(70, a.width/2)
+	var content string
+	switch {
+	case a.effectiveModal.loading:
+		content = "Running ssh -G..."
+	case a.effectiveModal.err != nil:
+		content = "Failed to resolve config. Error: " + a.effectiveModal.err.Error()
+	default:
+		storedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#22C55E")).Bold(true)
+		overriddenStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#EAB308")).Bold(true)
+		defaultStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#9CA3AF"))
+		lines := make([]string, 0, len(a.effectiveModal.opts))
+		for _, opt := range a.effectiveModal.opts {
+			line := opt.Key + " " + opt.Value
+			switch {
+			case opt.Overridden():
+				lines = append(lines, overriddenStyle.Render(line+" (stored: "+strings.Join(opt.StoredValue, ", ")+")"))
+			case opt.Stored():
+				lines = append(lines, storedStyle.Render(line))
+			default:
+				lines = append(lines, defaultStyle.Render(line))
+			}
+		}
+		content = strings.Join(lines, "\n")
+	}
+	a.effectiveModal.view.Width = width - 4
+	a.effectiveModal.view.Height = max(6, min(20, a.height/2))
+	a.effectiveModal.view.SetContent(lipgloss.NewStyle().Width(width - 6).Render(content))
+}
+
+func (a AppModel) effectiveConfigModalView() string {
+	width := max(70, a.width/2)
+	title := lipgloss.NewStyle().Bold(true).Render("Effective Config: " + a.effectiveModal.host)
+	legend := lipgloss.NewStyle().Foreground(lipgloss.Color("#22C55E")).Render("stored") + " " +
+		lipgloss.NewStyle().Foreground(lipgloss.Color("#EAB308")).Render("stored but overridden")
+	tail := fmt.Sprintf("\n%s to scroll, %s", a.keys.nav(), a.keys.closeHint())
+	return lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder()).
+		Width(width).
+		Padding(1, 2).
+		Render(lipgloss.JoinVertical(lipgloss.Left, title, legend, "", a.effectiveModal.view.View(), tail))
+}
+
+// fillLintView sizes the viewport of the lint modal and sets its content, see fillEffectiveView
+func (a *AppModel) fillLintView() {
+	width := max(80, a.width*2/3)
+	severityStyles := map[lint.Severity]lipgloss.Style{
+		lint.SeverityError:   lipgloss.NewStyle().Foreground(lipgloss.Color("#EF4444")).Bold(true),
+		lint.SeverityWarning: lipgloss.NewStyle().Foreground(lipgloss.Color("#EAB308")).Bold(true),
+		lint.SeverityInfo:    lipgloss.NewStyle().Foreground(lipgloss.Color("#4cbef3ff")),
+	}
+	var content string
+	switch {
+	case a.lintModal.err != nil:
+		content = "Failed to lint hosts. Error: " + a.lintModal.err.Error()
+	case len(a.lintModal.findings) == 0:
+		content = lipgloss.NewStyle().Foreground(lipgloss.Color("#22C55E")).Render("No problems found")
+	default:
+		lines := make([]string, 0, len(a.lintModal.findings))
+		for _, f := range a.lintModal.findings {
+			severity := severityStyles[f.Severity].Render(fmt.Sprintf("%-7s", f.Severity))
+			lines = append(lines, fmt.Sprintf("%s %s [%s] %s", severity, lipgloss.NewStyle().Bold(true).Render(f.Host), f.Rule, f.Message))
+		}
+		content = strings.Join(lines, "\n")
+	}
+	a.lintModal.view.Width = width - 4
+	a.lintModal.view.Height = max(6, min(20, a.height/2))
+	a.lintModal.view.SetContent(lipgloss.NewStyle().Width(width - 6).Render(content))
+}
+
+func (a AppModel) lintModalView() string {
+	width := max(80, a.width*2/3)
+	title := lipgloss.NewStyle().Bold(true).Render("Lint")
+	tail := fmt.Sprintf("\n%d findings, %s to scroll, %s", len(a.lintModal.findings), a.keys.nav(), a.keys.closeHint())
+	return lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder()).
+		Width(width).
+		Padding(1, 2).
+		Render(lipgloss.JoinVertical(lipgloss.Left, title, "", a.lintModal.view.View(), tail))
+}
+
+// waitForBulkRotate waits for the next progress update of a bulk rotation
+func waitForBulkRotate(updates chan sshUtils.BulkRotateStatus) tea.Cmd {
+	return func() tea.Msg {
+		status, ok := <-updates
+		if !ok {
+			return bulkRotateFinished{}
+		}
+		return bulkRotateProgress{status: status}
+	}
+}
+
+// fillBulkView sizes the viewport of the bulk rotation modal and sets its content, see fillEffectiveView
+func (a *AppModel) fillBulkView() {
+	width := max(80, a.width*2/3)
+	doneStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#22C55E"))
+	failStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#EF4444"))
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#9CA3AF"))
+	lines := make([]string, 0, len(a.bulkModal.statuses)+len(a.bulkModal.skipped))
+	for _, s := range a.bulkModal.statuses {
+		line := fmt.Sprintf("%-24s %s", s.Host, s.Stage)
+		switch s.Stage {
+		case sshUtils.BulkDone:
+			line = doneStyle.Render(line)
+			if s.OldKey != "" && !s.OldRemoved {
+				line += dimStyle.Render(" old key kept")
+			}
+		case sshUtils.BulkFailed:
+			line = failStyle.Render(fmt.Sprintf("%-24s failed %s", s.Host, s.FailedAt)) + dimStyle.Render(" "+s.Err.Error())
+		}
+		lines = append(lines, line)
+	}
+	for _, reason := range a.bulkModal.skipped {
+		lines = append(lines, dimStyle.Render("skipped "+reason))
+	}
+	if a.bulkModal.err != nil {
+		lines = append(lines, "", failStyle.Render("Error: "+a.bulkModal.err.Error()))
+	}
+	a.bulkModal.view.Width = width - 4
+	a.bulkModal.view.Height = max(6, min(24, a.height/2))
+	a.bulkModal.view.SetContent(lipgloss.NewStyle().Width(width - 6).Render(strings.Join(lines, "\n")))
+}
+
+func (a AppModel) bulkModalView() string {
+	width := max(80, a.width*2/3)
+	title := lipgloss.NewStyle().Bold(true).Render("Bulk Key Rotation")
+	done, failed := 0, 0
+	for _, s := range a.bulkModal.statuses {
+		switch s.Stage {
+		case sshUtils.BulkDone:
+			done++
+		case sshUtils.BulkFailed:
+			failed++
+		}
+	}
+	tail := fmt.Sprintf("\n%d of %d hosts rotated, %d failed, %s to scroll", done, len(a.bulkModal.statuses), failed, a.keys.nav())
+	if a.bulkModal.aborting {
+		tail += ", aborting..."
+	} else if a.bulkModal.running {
+		tail += ", rotating..., " + keyName(a.keys.Close) + " to abort"
+	} else {
+		tail += ", " + a.keys.closeHint()
+	}
+	return lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder()).
+		Width(width).
+		Padding(1, 2).
+		Render(lipgloss.JoinVertical(lipgloss.Left, title, "", a.bulkModal.view.View(), tail))
+}
+
+// fillKeysView sizes the viewport of the key inventory modal and sets its content, see fillEffectiveView
+func (a *AppModel) fillKeysView() {
+	width := max(80, a.width*2/3)
+	flagStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#EAB308")).Bold(true)
+	dimStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#9CA3AF"))
+	var content string
+	switch {
+	case a.keysModal.err != nil:
+		content = "Failed to load key inventory. Error: " + a.keysModal.err.Error()
+	case len(a.keysModal.entries) == 0:
+		content = "No keys found, generate one with g"
+	default:
+		lines := make([]string, 0, len(a.keysModal.entries)*3)
+		for _, e := range a.keysModal.entries {
+			header := lipgloss.NewStyle().Bold(true).Render(e.Path)
+			if flags := e.Flags(); len(flags) > 0 {
+				header += " " + flagStyle.Render(strings.Join(flags, ", "))
+			}
+			passphrase := "no passphrase"
+			if e.HasPassphrase {
+				passphrase = "passphrase"
+			}
+			details := fmt.Sprintf("  %s %d %s, %s, created %s", e.Algorithm, e.Bits, e.Fingerprint, passphrase, e.CreatedAt.Format("2006-01-02"))
+			if e.Comment != "" {
+				details += ", " + e.Comment
+			}
+			hosts := "  used by: none"
+			if len(e.Hosts) > 0 {
+				hosts = "  used by: " + strings.Join(e.Hosts, ", ")
+			}
+			lines = append(lines, header, dimStyle.Render(details), dimStyle.Render(hosts))
+		}
+		content = strings.Join(lines, "\n")
+	}
+	a.keysModal.view.Width = width - 4
+	a.keysModal.view.Height = max(6, min(24, a.height/2))
+	a.keysModal.view.SetContent(lipgloss.NewStyle().Width(width - 6).Render(content))
+}
+
+func (a AppModel) keysModalView() string {
+	width := max(80, a.width*2/3)
+	title := lipgloss.NewStyle().Bold(true).Render("Key Inventory")
+	flagged := 0
+	for _, e := range a.keysModal.entries {
+		if len(e.Flags()) > 0 {
+			flagged++
+		}
+	}
+	tail := fmt.Sprintf("\n%d keys, %d flagged, %s to scroll, %s", len(a.keysModal.entries), flagged, a.keys.nav(), a.keys.closeHint())
+	return lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder()).
+		Width(width).
+		Padding(1, 2).
+		Render(lipgloss.JoinVertical(lipgloss.Left, title, "", a.keysModal.view.View(), tail))
+}
+
+func (a AppModel) forwardModalView() string {
+	width := max(70, a.width/2)
+	titleText := "Forward Profiles: " + a.forwardModal.host
+	if a.forwardModal.host == "" {
+		titleText = "Running Tunnels"
+	}
+	title := lipgloss.NewStyle().Bold(true).Render(titleText)
+	runningStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#22C55E")).Bold(true)
+	stoppedStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("#9CA3AF"))
+	lines := make([]string, 0, len(a.forwardModal.profiles))
+	for i, profile := range a.forwardModal.profiles {
+		cursor := "  "
+		if i == a.forwardModal.selected {
+			cursor = "> "
+		}
+		name := profile.Name
+		if a.forwardModal.host == "" {
+			name = profile.Host + "/" + profile.Name
+		}
+		status := stoppedStyle.Render("stopped")
+		if a.tunnels.IsRunning(profile.Host, profile.Name) {
+			status = runningStyle.Render("running")
+		}
+		lines = append(lines, cursor+name+" "+status)
+		if addrs := sshUtils.LocalListenAddrs(profile); len(addrs) > 0 {
+			lines = append(lines, "    local: "+strings.Join(addrs, ", "))
+		}
+		for _, fwd := range profile.Forwards {
+			lines = append(lines, stoppedStyle.Render("    "+fwd.Type+" "+fwd.Spec))
+		}
+	}
+	content := strings.Join(lines, "\n")
+	if len(lines) == 0 {
+		if a.forwardModal.host == "" {
+			content = "No tunnels are running"
+		} else {
+			content = "No forward profiles, add one with sshman --host " + a.forwardModal.host + " --fp-add <name> -o LocalForward=..."
+		}
+	}
+	if a.forwardModal.err != nil {
+		content += "\n\n" + lipgloss.NewStyle().Foreground(lipgloss.Color("#EF4444")).Render(a.forwardModal.err.Error())
+	}
+	tail := fmt.Sprintf("\n%s to move, %s to start/stop, %s to close", a.keys.nav(), keyName(a.keys.Confirm), keyName(a.keys.Close))
+	return lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder()).
+		Width(width).
+		Padding(1, 2).
+		Render(lipgloss.JoinVertical(lipgloss.Left, title, "", lipgloss.NewStyle().Width(width-6).Render(content), tail))
+}
+
+func (a AppModel) deleteWarningModalView() string {
+	width := max(60, a.width/2)
+	title := lipgloss.NewStyle().Bold(true).Render("Delete Host")
+	content := fmt.Sprintf("Delete %s?", a.deleteWarningModal.host)
+	tail := fmt.Sprintf("Press %s to delete or %s to cancel", keyName(a.keys.Confirm), keyName(a.keys.Close))
+	if len(a.deleteWarningModal.dependents) > 0 {
+		title = lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color("#EAB308")).Render("Jump Host In Use")
+		content = fmt.Sprintf("%s is used as a ProxyJump by:\n  %s\n\nDeleting it will leave their ProxyJump unresolved.",
+			a.deleteWarningModal.host, strings.Join(a.deleteWarningModal.dependents, "\n  "))
+		tail = fmt.Sprintf("Press %s to delete anyway or %s to cancel", keyName(a.keys.Confirm), keyName(a.keys.Close))
+	}
+	return lipgloss.NewStyle().
+		Border(lipgloss.NormalBorder()).
+		Width(width).
+		Padding(2, 2).
+		Render(fmt.Sprintf("%s\n%s\n\n%s", title, content, tail))
+}
+
+// flushPendingWrite writes the ssh config file if changes were buffered because write through is disabled,
+// needed before running anything that reads the generated file
+func (a *AppModel) flushPendingWrite() {
+	if !a.pendingWrite {
+		return
+	}
+	hosts, err := a.db.GetAll()
+	if err != nil {
+		slog.Error("Failed to get hosts from database", "error", err)
+	} else if err = sshParser.SerializeHostToFile(a.cfg.GetSshConfigFilePath(), hosts); err != nil {
+		slog.Error("Failed to serialize host into ssh config file", "file", a.cfg.GetSshConfigFilePath(), "error", err)
+	} else {
+		a.pendingWrite = false
+	}
+}
+
+// quit stops pings, key generation and copies still running so none of them outlives the program. The canceled
+// work gets to clean up, ie removing partial keys, before the pending config write so nothing touches the database
+// after the file was written
+func (a AppModel) quit() tea.Cmd {
+	a.cancel()
+	return func() tea.Msg {
+		a.work.wait(quitWaitTimeout)
+		a.flushPendingWrite()
+		return tea.Quit()
+	}
+}
+
+// recordKey adds a generated key to the key inventory, usage is filled in the next time the inventory is refreshed
+func (a AppModel) recordKey(path string) {
+	key, err := sshUtils.InspectKey(path)
+	if err != nil {
+		slog.Warn("Failed to inspect generated key", "key", path, "error", err)
+		return
+	}
+	if err = a.keyDb.Upsert(key); err != nil {
+		slog.Warn("Failed to add generated key to key inventory", "key", path, "error", err)
+	}
+}
+
+// runLint checks every stored host and forward profile with the rules enabled in the config
+func (a AppModel) runLint() ([]lint.Finding, error) {
+	linter, err := lint.New(a.cfg.Lint)
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := a.db.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	profiles, err := a.forwardDb.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	return linter.Run(lint.Context{Hosts: hosts, Forwards: profiles, Config: a.cfg}), nil
+}
+
+// loadForwardProfiles returns the profiles of host, or the profiles currently running when host is empty
+func (a AppModel) loadForwardProfiles(host string) ([]sqlite.ForwardProfile, error) {
+	if host != "" {
+		return a.forwardDb.GetForHost(host)
+	}
+	profiles, err := a.forwardDb.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	return slices.DeleteFunc(profiles, func(p sqlite.ForwardProfile) bool {
+		return !a.tunnels.IsRunning(p.Host, p.Name)
+	}), nil
+}
+
+// toggleSelectedTunnel stops the selected profile if it is running and starts it otherwise,
+// a started tunnel is watched so the ui is told when it exits
+func (a *AppModel) toggleSelectedTunnel() tea.Cmd {
+	if a.forwardModal.selected >= len(a.forwardModal.profiles) {
+		return nil
+	}
+	profile := a.forwardModal.profiles[a.forwardModal.selected]
+	a.forwardModal.err = nil
+	if a.tunnels.IsRunning(profile.Host, profile.Name) {
+		if err := a.tunnels.Stop(profile.Host, profile.Name); err != nil {
+			slog.Error("Failed to stop tunnel", "host", profile.Host, "profile", profile.Name, "error", err)
+			a.forwardModal.err = err
+		}
+		return nil
+	}
+	// ssh must not prompt on the terminal the ui owns, an auth failure comes back as the exit error of the tunnel
+	options := append(slices.Clone(a.sshOpts), "-o", "BatchMode=yes")
+	tunnel, err := a.tunnels.Start(profile, options...)
+	if err != nil {
+		slog.Error("Failed to start tunnel", "host", profile.Host, "profile", profile.Name, "error", err)
+		a.forwardModal.err = err
+		return nil
+	}
+	a.header.tunnels = len(a.tunnels.Sessions())
+	return func() tea.Msg {
+		err := tunnel.Wait()
+		return tunnelExited{host: tunnel.Host, name: tunnel.Name, err: err}
+	}
+}
+
 func overlayView(base, modal string) string {
 	return overlay.Composite(modal, base, overlay.Center, overlay.Center, 0, 0)
 }
@@ -836,11 +2097,12 @@ func overlayViewWithOffsets(base, modal string, xOff, yOff int) string {
 // modal related code
 func newRotateCopyModal(req keyRotateRequest) rotateKeyCopyModalState {
 	return rotateKeyCopyModalState{
-		visible: true,
-		err:     req.err,
-		host:    req.host,
-		oldKey:  req.oldKeyPath,
-		keys:    req.newKeySet,
+		visible:  true,
+		err:      req.err,
+		host:     req.host,
+		oldKey:   req.oldKeyPath,
+		keys:     req.newKeySet,
+		rotation: req.rotation,
 	}
 }
 
@@ -851,7 +2113,9 @@ func newRotateRemoveModal(req removeOldKeyRequest) rotateKeyRemoveModalState {
 		host:        req.host,
 		keyPair:     req.newKeyPair,
 		keyToRemove: req.oldKey,
+		verified:    req.verified,
 		scriptView:  viewport.New(30, 6),
+		rotation:    req.rotation,
 	}
 }
 